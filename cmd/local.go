@@ -1,8 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"kube-scheduler-practice/internal/client"
-	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -17,13 +20,16 @@ and usage of using your command. For example:
 Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		c, err := client.NewLocalClient()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cmd.SilenceUsage = true
+		c, err := client.NewLocalClient(schedulerConfig)
 		if err != nil {
-			slog.Error(err.Error())
-			return
+			return err
 		}
-		c.Run()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runScheduler(ctx, c)
 	},
 }
 
@@ -1,12 +1,29 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"kube-scheduler-practice/internal/client"
+	"kube-scheduler-practice/internal/config"
 	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
 )
 
+var (
+	// cfgFile はスケジューラの設定ファイルのパス
+	cfgFile string
+	// schedulerName は --config を指定しないときに担当する Pod の spec.schedulerName
+	schedulerName string
+	// metricsBindAddress は指定されていれば設定ファイルの metricsBindAddress より優先する
+	metricsBindAddress string
+	// leaderElect は指定されていれば設定ファイルの leaderElection.leaderElect より優先する
+	leaderElect bool
+	// schedulerConfig は PersistentPreRunE で読み込んだ設定
+	schedulerConfig *config.Config
+)
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "kube-scheduler-practice",
@@ -20,6 +37,39 @@ to quickly create a Cobra application.`,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	// Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if cfgFile == "" {
+			schedulerConfig = config.Default(schedulerName)
+		} else {
+			if cmd.Flags().Changed("scheduler-name") {
+				return errors.New("--scheduler-name cannot be used with --config; set profiles[].schedulerName in the config file instead")
+			}
+			c, err := config.Load(cfgFile)
+			if err != nil {
+				return err
+			}
+			schedulerConfig = c
+		}
+		if cmd.Flags().Changed("leader-elect") {
+			schedulerConfig.LeaderElection.LeaderElect = leaderElect
+		}
+		if cmd.Flags().Changed("metrics-bind-address") {
+			schedulerConfig.MetricsBindAddress = metricsBindAddress
+		}
+		return schedulerConfig.Validate()
+	},
+}
+
+// runScheduler はメトリクスの HTTP サーバを起動し、ctx がキャンセルされるまで Pod をスケジュールし続ける
+// Lease を失ったときなどはエラーを返す。Execute が終了コード 1 で終了するので、Pod は再起動される
+func runScheduler(ctx context.Context, c client.K8sClient) error {
+	go func() {
+		slog.Info("serving metrics", "address", schedulerConfig.MetricsBindAddress)
+		if err := c.Metrics.ListenAndServe(ctx, schedulerConfig.MetricsBindAddress); err != nil {
+			slog.Error(err.Error())
+		}
+	}()
+	return c.Run(ctx)
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -41,7 +91,10 @@ func init() {
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
 
-	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.kube-scheduler-practice.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "scheduler config file with profiles (YAML)")
+	rootCmd.PersistentFlags().StringVar(&schedulerName, "scheduler-name", config.DefaultSchedulerName, "only schedule pods whose spec.schedulerName matches this name (cannot be combined with --config)")
+	rootCmd.PersistentFlags().StringVar(&metricsBindAddress, "metrics-bind-address", config.DefaultMetricsBindAddress, "address to serve Prometheus metrics on /metrics (overrides metricsBindAddress in the config file)")
+	rootCmd.PersistentFlags().BoolVar(&leaderElect, "leader-elect", false, "only schedule pods while holding the leader election lease, so that multiple replicas can run (overrides leaderElection.leaderElect in the config file)")
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
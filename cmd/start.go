@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"context"
 	"kube-scheduler-practice/internal/client"
 	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -17,14 +21,18 @@ and usage of using your command. For example:
 Cobra is a CLI library for Go that empowers applications.
 This application is a tool to generate the needed files
 to quickly create a Cobra application.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// ここから先のエラーは使い方の誤りではないので、usage は表示しない
+		cmd.SilenceUsage = true
 		slog.Info("kube-scheduler-practice start")
-		c, err := client.NewInClusterClient()
+		c, err := client.NewInClusterClient(schedulerConfig)
 		if err != nil {
-			slog.Error(err.Error())
-			return
+			return err
 		}
-		c.Run()
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runScheduler(ctx, c)
 	},
 }
 
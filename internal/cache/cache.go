@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"fmt"
+	"kube-scheduler-practice/internal/logic"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+// 期限の過ぎた仮置きの Pod を取り除く間隔
+const cleanAssumedPeriod = time.Second
+
+// Cache はノードと配置済みの Pod を informer のイベントから組み立てて保持する
+//
+// 配置するノードを決めた Pod は、bind の完了や informer への反映を待たずに仮置き (AssumePod) し、
+// 直後にスケジュールする Pod の判断に反映する
+//   - informer で配置が確認できたら (AddPod / UpdatePod) 確定する
+//   - bind に失敗したら ForgetPod で取り消す
+//   - bind が終わってから ttl が経っても確認できなければ、期限切れとして取り除く
+type Cache struct {
+	// bind が終わってから informer で確認できるまで待つ時間。0 なら期限切れにしない
+	ttl   time.Duration
+	clock clock.Clock
+
+	lock  sync.RWMutex
+	nodes map[string]*nodeItem
+	// Pod のキー (namespace/name) → Pod の状態。仮置きの Pod も含む
+	podStates map[string]*podState
+	// 仮置きで、まだ informer で確認できていない Pod のキー
+	assumedPods map[string]bool
+}
+
+type nodeItem struct {
+	// ノードの削除や追加の前後で、Pod だけが残っている間は nil
+	node *v1.Node
+	pods map[string]*v1.Pod
+	// node と pods から作った NodeInfo。変わったら nil にして、NodeInfos で作り直す
+	// 作った NodeInfo は書き換えないので、Snapshot と共有できる
+	info *logic.NodeInfo
+}
+
+type podState struct {
+	pod *v1.Pod
+	// 仮置きの Pod が期限切れになる時刻。bind が終わるまでは nil
+	deadline *time.Time
+}
+
+// New は空の Cache を作る。ttl は bind が終わってから仮置きの Pod を確認できるまで待つ時間
+func New(ttl time.Duration) *Cache {
+	return newCache(clock.RealClock{}, ttl)
+}
+
+func newCache(clock clock.Clock, ttl time.Duration) *Cache {
+	return &Cache{
+		ttl:         ttl,
+		clock:       clock,
+		nodes:       map[string]*nodeItem{},
+		podStates:   map[string]*podState{},
+		assumedPods: map[string]bool{},
+	}
+}
+
+// Run は stopCh が閉じられるまで、期限の過ぎた仮置きの Pod を定期的に取り除く
+func (c *Cache) Run(stopCh <-chan struct{}) {
+	go wait.Until(c.cleanupExpiredAssumedPods, cleanAssumedPeriod, stopCh)
+}
+
+func podKey(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+// AssumePod は spec.nodeName を設定した pod を、そのノードに配置されたものとして仮置きする
+func (c *Cache) AssumePod(pod *v1.Pod) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := podKey(pod)
+	if _, ok := c.podStates[key]; ok {
+		return fmt.Errorf("pod %s is already in the cache, so can't be assumed", key)
+	}
+	c.addPod(pod)
+	c.assumedPods[key] = true
+	return nil
+}
+
+// FinishBinding は仮置きの Pod の bind が終わったことを伝える。ここから ttl 経つと期限切れになる
+func (c *Cache) FinishBinding(pod *v1.Pod) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := podKey(pod)
+	if !c.assumedPods[key] || c.ttl == 0 {
+		return
+	}
+	deadline := c.clock.Now().Add(c.ttl)
+	c.podStates[key].deadline = &deadline
+}
+
+// ForgetPod は bind に失敗した仮置きの Pod を取り除く。仮置きでなければ何もしない
+func (c *Cache) ForgetPod(pod *v1.Pod) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := podKey(pod)
+	if !c.assumedPods[key] {
+		return
+	}
+	c.removePod(key)
+}
+
+// IsAssumedPod は pod が仮置きで、まだ informer で確認できていないかを返す
+func (c *Cache) IsAssumedPod(pod *v1.Pod) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.assumedPods[podKey(pod)]
+}
+
+// AddPod はノードに配置済みの Pod を追加する
+// 仮置きの Pod なら、informer で確認できた Pod で置き換えて確定する
+func (c *Cache) AddPod(pod *v1.Pod) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	key := podKey(pod)
+	if _, ok := c.podStates[key]; ok {
+		// 仮置きと違うノードに配置されていることもあるので、一度取り除いてから追加する
+		c.removePod(key)
+	}
+	c.addPod(pod)
+}
+
+// UpdatePod はノードに配置済みの Pod を newPod で置き換える
+func (c *Cache) UpdatePod(oldPod, newPod *v1.Pod) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.removePod(podKey(oldPod))
+	c.addPod(newPod)
+}
+
+// RemovePod は Pod を取り除く。キャッシュに無ければ何もしない
+func (c *Cache) RemovePod(pod *v1.Pod) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.removePod(podKey(pod))
+}
+
+func (c *Cache) addPod(pod *v1.Pod) {
+	key := podKey(pod)
+	item := c.nodeItem(pod.Spec.NodeName)
+	item.pods[key] = pod
+	item.info = nil
+	c.podStates[key] = &podState{pod: pod}
+}
+
+func (c *Cache) removePod(key string) {
+	delete(c.assumedPods, key)
+	ps, ok := c.podStates[key]
+	if !ok {
+		return
+	}
+	delete(c.podStates, key)
+	nodeName := ps.pod.Spec.NodeName
+	item := c.nodes[nodeName]
+	delete(item.pods, key)
+	item.info = nil
+	if item.node == nil && len(item.pods) == 0 {
+		delete(c.nodes, nodeName)
+	}
+}
+
+// AddNode はノードを追加する。同じ名前のノードがあれば置き換える
+func (c *Cache) AddNode(node *v1.Node) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	item := c.nodeItem(node.Name)
+	item.node = node
+	item.info = nil
+}
+
+// UpdateNode はノードを newNode で置き換える
+func (c *Cache) UpdateNode(_, newNode *v1.Node) {
+	c.AddNode(newNode)
+}
+
+// RemoveNode はノードを取り除く
+// ノードに配置された Pod は、その Pod の削除が届くまで残しておく
+func (c *Cache) RemoveNode(node *v1.Node) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	item, ok := c.nodes[node.Name]
+	if !ok {
+		return
+	}
+	if len(item.pods) == 0 {
+		delete(c.nodes, node.Name)
+		return
+	}
+	item.node = nil
+	item.info = nil
+}
+
+// ノード名に対応する nodeItem を返す。無ければ作る
+func (c *Cache) nodeItem(nodeName string) *nodeItem {
+	item, ok := c.nodes[nodeName]
+	if !ok {
+		item = &nodeItem{pods: map[string]*v1.Pod{}}
+		c.nodes[nodeName] = item
+	}
+	return item
+}
+
+// NodeInfos はすべてのノードの NodeInfo をノード名の順に返す
+// 仮置きの Pod も配置済みとして含む。変わっていないノードは前回作った NodeInfo を使い回す
+func (c *Cache) NodeInfos() []*logic.NodeInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	nodeInfos := make([]*logic.NodeInfo, 0, len(c.nodes))
+	for _, item := range c.nodes {
+		if item.node == nil {
+			continue
+		}
+		if item.info == nil {
+			// Pod の並びで結果が変わらないよう、キーの順に追加する
+			keys := make([]string, 0, len(item.pods))
+			for key := range item.pods {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			pods := make([]*v1.Pod, 0, len(keys))
+			for _, key := range keys {
+				pods = append(pods, item.pods[key])
+			}
+			item.info = logic.NewNodeInfo(item.node, pods...)
+		}
+		nodeInfos = append(nodeInfos, item.info)
+	}
+	sort.Slice(nodeInfos, func(i, j int) bool {
+		return nodeInfos[i].Node.Name < nodeInfos[j].Node.Name
+	})
+	return nodeInfos
+}
+
+// bind が終わってから ttl が過ぎても informer で確認できない仮置きの Pod を取り除く
+func (c *Cache) cleanupExpiredAssumedPods() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.clock.Now()
+	for key := range c.assumedPods {
+		ps := c.podStates[key]
+		if ps.deadline == nil || now.Before(*ps.deadline) {
+			continue
+		}
+		slog.Warn("assumed pod expired", "pod", ps.pod.Name, "namespace", ps.pod.Namespace, "node", ps.pod.Spec.NodeName)
+		c.removePod(key)
+	}
+}
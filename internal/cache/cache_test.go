@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+const testTTL = 30 * time.Second
+
+func newTestNode(name string) *v1.Node {
+	return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+}
+
+func newTestPod(name, nodeName, cpu string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec: v1.PodSpec{
+			NodeName: nodeName,
+			Containers: []v1.Container{{
+				Name:      "c",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+			}},
+		},
+	}
+}
+
+func newTestCache(nodes ...*v1.Node) (*Cache, *testingclock.FakeClock) {
+	clock := testingclock.NewFakeClock(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := newCache(clock, testTTL)
+	for _, node := range nodes {
+		c.AddNode(node)
+	}
+	return c, clock
+}
+
+// ノード名 → 配置済み Pod の名前
+func podsByNode(c *Cache) map[string][]string {
+	got := map[string][]string{}
+	for _, nodeInfo := range c.NodeInfos() {
+		names := []string{}
+		for _, pod := range nodeInfo.Pods {
+			names = append(names, pod.Name)
+		}
+		got[nodeInfo.Node.Name] = names
+	}
+	return got
+}
+
+func TestCache_AssumePod(t *testing.T) {
+	tests := []struct {
+		name string
+		// pod1 を node1 に仮置きした後に行う操作
+		after        func(c *Cache, clock *testingclock.FakeClock, assumed *v1.Pod)
+		want         map[string][]string
+		wantAssumed  bool
+		wantCPUNode1 int64
+	}{
+		{
+			name:         "assumed pod is reflected",
+			want:         map[string][]string{"node1": {"pod1"}, "node2": {}},
+			wantAssumed:  true,
+			wantCPUNode1: 500,
+		},
+		{
+			// bind が終わるまでは期限切れにしない
+			name: "assumed pod does not expire while binding",
+			after: func(c *Cache, clock *testingclock.FakeClock, _ *v1.Pod) {
+				clock.Step(2 * testTTL)
+				c.cleanupExpiredAssumedPods()
+			},
+			want:         map[string][]string{"node1": {"pod1"}, "node2": {}},
+			wantAssumed:  true,
+			wantCPUNode1: 500,
+		},
+		{
+			name: "assumed pod expires after binding",
+			after: func(c *Cache, clock *testingclock.FakeClock, assumed *v1.Pod) {
+				c.FinishBinding(assumed)
+				clock.Step(testTTL)
+				c.cleanupExpiredAssumedPods()
+			},
+			want: map[string][]string{"node1": {}, "node2": {}},
+		},
+		{
+			name: "assumed pod does not expire before ttl",
+			after: func(c *Cache, clock *testingclock.FakeClock, assumed *v1.Pod) {
+				c.FinishBinding(assumed)
+				clock.Step(testTTL - time.Second)
+				c.cleanupExpiredAssumedPods()
+			},
+			want:         map[string][]string{"node1": {"pod1"}, "node2": {}},
+			wantAssumed:  true,
+			wantCPUNode1: 500,
+		},
+		{
+			name: "bind failure forgets pod",
+			after: func(c *Cache, _ *testingclock.FakeClock, assumed *v1.Pod) {
+				c.ForgetPod(assumed)
+			},
+			want: map[string][]string{"node1": {}, "node2": {}},
+		},
+		{
+			// informer で確認できたら、期限が過ぎても残る
+			name: "added pod confirms assumed pod",
+			after: func(c *Cache, clock *testingclock.FakeClock, assumed *v1.Pod) {
+				c.FinishBinding(assumed)
+				c.AddPod(newTestPod("pod1", "node1", "500m"))
+				clock.Step(2 * testTTL)
+				c.cleanupExpiredAssumedPods()
+				c.ForgetPod(assumed)
+			},
+			want:         map[string][]string{"node1": {"pod1"}, "node2": {}},
+			wantCPUNode1: 500,
+		},
+		{
+			// 仮置きと違うノードに配置されていたら、informer の内容を正とする
+			name: "added pod on another node",
+			after: func(c *Cache, _ *testingclock.FakeClock, _ *v1.Pod) {
+				c.AddPod(newTestPod("pod1", "node2", "500m"))
+			},
+			want: map[string][]string{"node1": {}, "node2": {"pod1"}},
+		},
+		{
+			name: "updated pod confirms assumed pod",
+			after: func(c *Cache, _ *testingclock.FakeClock, assumed *v1.Pod) {
+				c.UpdatePod(newTestPod("pod1", "", "500m"), newTestPod("pod1", "node1", "500m"))
+			},
+			want:         map[string][]string{"node1": {"pod1"}, "node2": {}},
+			wantCPUNode1: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, clock := newTestCache(newTestNode("node1"), newTestNode("node2"))
+			assumed := newTestPod("pod1", "node1", "500m")
+			if err := c.AssumePod(assumed); err != nil {
+				t.Fatalf("Cache.AssumePod() error = %v", err)
+			}
+			if tt.after != nil {
+				tt.after(c, clock, assumed)
+			}
+			if got := podsByNode(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pods by node = %v, want %v", got, tt.want)
+			}
+			if got := c.IsAssumedPod(assumed); got != tt.wantAssumed {
+				t.Errorf("Cache.IsAssumedPod() = %v, want %v", got, tt.wantAssumed)
+			}
+			if got := c.NodeInfos()[0].Requested.MilliCPU; got != tt.wantCPUNode1 {
+				t.Errorf("requested cpu of node1 = %d, want %d", got, tt.wantCPUNode1)
+			}
+		})
+	}
+}
+
+func TestCache_AssumePod_AlreadyInCache(t *testing.T) {
+	c, _ := newTestCache(newTestNode("node1"))
+	c.AddPod(newTestPod("pod1", "node1", "500m"))
+	if err := c.AssumePod(newTestPod("pod1", "node1", "500m")); err == nil {
+		t.Error("Cache.AssumePod() error = nil, want error")
+	}
+}
+
+func TestCache_Nodes(t *testing.T) {
+	c, _ := newTestCache()
+	// ノードより先に Pod が届くこともある
+	c.AddPod(newTestPod("pod1", "node1", "500m"))
+	if got := podsByNode(c); len(got) != 0 {
+		t.Errorf("pods by node = %v, want no nodes before the node is added", got)
+	}
+
+	c.AddNode(newTestNode("node1"))
+	c.AddNode(newTestNode("node2"))
+	if got, want := podsByNode(c), map[string][]string{"node1": {"pod1"}, "node2": {}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pods by node = %v, want %v", got, want)
+	}
+
+	updated := newTestNode("node1")
+	updated.Labels = map[string]string{"tier": "web"}
+	c.UpdateNode(newTestNode("node1"), updated)
+	if got := c.NodeInfos()[0].Node.Labels["tier"]; got != "web" {
+		t.Errorf("node1 label tier = %q, want web", got)
+	}
+
+	c.RemoveNode(updated)
+	c.RemoveNode(newTestNode("node2"))
+	if got := podsByNode(c); len(got) != 0 {
+		t.Errorf("pods by node = %v, want no nodes", got)
+	}
+	// 削除したノードに残っていた Pod は、Pod の削除で取り除かれる
+	c.RemovePod(newTestPod("pod1", "node1", "500m"))
+	if len(c.nodes) != 0 || len(c.podStates) != 0 {
+		t.Errorf("cache has %d nodes and %d pods, want empty", len(c.nodes), len(c.podStates))
+	}
+}
+
+func TestCache_NodeInfos_Reuse(t *testing.T) {
+	c, _ := newTestCache(newTestNode("node1"), newTestNode("node2"))
+	before := c.NodeInfos()
+	c.AddPod(newTestPod("pod1", "node2", "500m"))
+	after := c.NodeInfos()
+
+	// 変わっていないノードの NodeInfo は使い回し、変わったノードだけ作り直す
+	if before[0] != after[0] {
+		t.Error("NodeInfo of node1 was rebuilt though node1 did not change")
+	}
+	if before[1] == after[1] {
+		t.Error("NodeInfo of node2 was not rebuilt after adding a pod")
+	}
+	// 作った NodeInfo は書き換えない
+	if len(before[1].Pods) != 0 {
+		t.Errorf("previous NodeInfo of node2 has %d pods, want 0", len(before[1].Pods))
+	}
+}
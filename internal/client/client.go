@@ -2,32 +2,89 @@ package client
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
+	internalcache "kube-scheduler-practice/internal/cache"
+	"kube-scheduler-practice/internal/config"
 	"kube-scheduler-practice/internal/logic"
+	"kube-scheduler-practice/internal/metrics"
+	"kube-scheduler-practice/internal/queue"
 	"log/slog"
 	"path/filepath"
+	"sync"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	policylisters "k8s.io/client-go/listers/policy/v1"
+	storagelisters "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/homedir"
 )
 
+// bind が終わってから informer で配置を確認できるまで、仮置きの Pod を残しておく時間
+const assumedPodTTL = 30 * time.Second
+
 type K8sClient struct {
-	Clientset     kubernetes.Interface
-	ScheduleLogic ScheduleLogic
+	Clientset kubernetes.Interface
+	// schedulerName → その Profile の ScheduleLogic
+	// spec.schedulerName がいずれかと一致する Pod だけをスケジュールする
+	Profiles map[string]ScheduleLogic
+	// スケジュールに失敗した Pod を再試行するまでの待ち時間
+	// Pod ごとに失敗するたびに倍になり、PodMaxBackoff で頭打ちになる。0 の場合はデフォルト値を使う
+	PodInitialBackoff time.Duration
+	PodMaxBackoff     time.Duration
+	// 同時に行う bind の数の上限。0 の場合はデフォルト値を使う
+	BindParallelism int
+	Preemption      config.Preemption
+	// nil ならメトリクスを記録しない
+	Metrics *metrics.Metrics
+	// nil ならリーダー選出をせず、常にスケジュールする
+	LeaderElection *LeaderElectionConfig
+	// Pod グループのメンバーが揃うのを待つ時間。0 の場合はデフォルト値を使う
+	PodGroupTimeout time.Duration
+
+	// StartInformers で初期化される
+	podLister          corelisters.PodLister
+	nodeLister         corelisters.NodeLister
+	namespaceLister    corelisters.NamespaceLister
+	pvcLister          corelisters.PersistentVolumeClaimLister
+	pvLister           corelisters.PersistentVolumeLister
+	storageClassLister storagelisters.StorageClassLister
+	csiNodeLister      storagelisters.CSINodeLister
+	pdbLister          policylisters.PodDisruptionBudgetLister
+	queue              *queue.PriorityQueue
+	// ノードと配置済みの Pod。配置を決めた Pod も bind の前に仮置きする
+	schedulerCache *internalcache.Cache
+	// 実行中の bind。容量が BindParallelism で、空くまで次の bind を始めない
+	bindingSlots chan struct{}
+	bindings     *sync.WaitGroup
+	// 配置を決めて、Pod グループの他のメンバーを待っている Pod
+	podGroups *podGroupWaiter
+	// schedulerName → その Profile のイベントの recorder
+	recorders map[string]record.EventRecorder
 }
 
+// ScheduleLogic はクラスタの状態 (snapshot) から Pod を配置するノードを選ぶ
 type ScheduleLogic interface {
-	ChooseAvailableNodes(unschedulePod *v1.Pod, vs *v1.NodeList) (*v1.NodeList, error)
-	ChooseSuitableNode(unschedulePod *v1.Pod, vs *v1.NodeList) (v1.Node, error)
+	ChooseAvailableNodes(unschedulePod *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error)
+	ChooseSuitableNode(unschedulePod *v1.Pod, vs *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error)
+	// どのノードにも配置できない Pod のために、退避させる Pod とそのノードを選ぶ。見つからなければ nil を返す
+	Preempt(pod *v1.Pod, snapshot *logic.Snapshot, pdbs []*policyv1.PodDisruptionBudget) (*logic.PreemptionResult, error)
 }
 
-func NewLocalClient() (K8sClient, error) {
+func NewLocalClient(cfg *config.Config) (K8sClient, error) {
 	var kubeconfig *string
 	if home := homedir.HomeDir(); home != "" {
 		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional) absolute path to the kubeconfig file")
@@ -36,62 +93,357 @@ func NewLocalClient() (K8sClient, error) {
 	}
 	flag.Parse()
 
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	restConfig, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
 	if err != nil {
 		return K8sClient{}, fmt.Errorf("error building kubeconfig: %s", err.Error())
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return K8sClient{}, fmt.Errorf("error creating clientset: %s", err.Error())
 	}
 
-	scheduleLogic := &logic.ScheduleLogic{}
-	return K8sClient{Clientset: clientset, ScheduleLogic: scheduleLogic}, nil
+	return newK8sClient(clientset, cfg)
 }
 
-func NewInClusterClient() (K8sClient, error) {
-	config, err := rest.InClusterConfig()
+func NewInClusterClient(cfg *config.Config) (K8sClient, error) {
+	restConfig, err := rest.InClusterConfig()
 	if err != nil {
 		return K8sClient{}, fmt.Errorf("error creating in-cluster config: %s", err.Error())
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	clientset, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return K8sClient{}, fmt.Errorf("error creating clientset: %s", err.Error())
 	}
 
-	scheduleLogic := &logic.ScheduleLogic{}
-	return K8sClient{Clientset: clientset, ScheduleLogic: scheduleLogic}, nil
+	return newK8sClient(clientset, cfg)
+}
+
+func newK8sClient(clientset kubernetes.Interface, cfg *config.Config) (K8sClient, error) {
+	m := metrics.New()
+	profiles, err := NewProfiles(cfg, logic.NewInTreeRegistry(), m)
+	if err != nil {
+		return K8sClient{}, err
+	}
+	var leaderElection *LeaderElectionConfig
+	if cfg.LeaderElection.LeaderElect {
+		leaderElection = &LeaderElectionConfig{
+			Namespace:     cfg.LeaderElection.ResourceNamespace,
+			Name:          cfg.LeaderElection.ResourceName,
+			LeaseDuration: time.Duration(cfg.LeaderElection.LeaseDurationSeconds) * time.Second,
+			RenewDeadline: time.Duration(cfg.LeaderElection.RenewDeadlineSeconds) * time.Second,
+			RetryPeriod:   time.Duration(cfg.LeaderElection.RetryPeriodSeconds) * time.Second,
+		}
+	}
+	return K8sClient{
+		Clientset:         clientset,
+		Profiles:          profiles,
+		PodInitialBackoff: time.Duration(cfg.PodInitialBackoffSeconds) * time.Second,
+		PodMaxBackoff:     time.Duration(cfg.PodMaxBackoffSeconds) * time.Second,
+		BindParallelism:   cfg.BindParallelism,
+		Preemption:        cfg.Preemption,
+		Metrics:           m,
+		LeaderElection:    leaderElection,
+		PodGroupTimeout:   time.Duration(cfg.PodGroupTimeoutSeconds) * time.Second,
+	}, nil
+}
+
+// NewProfiles は設定ファイルの Profile ごとに ScheduleLogic を作る
+// Filter と Score の並行数や、Filter を打ち切る割合はすべての Profile で共通
+// metricsRecorder が nil でなければ、プラグインの実行時間を記録する
+func NewProfiles(cfg *config.Config, registry logic.Registry, metricsRecorder logic.MetricsRecorder) (map[string]ScheduleLogic, error) {
+	profiles := make(map[string]ScheduleLogic, len(cfg.Profiles))
+	for _, profile := range cfg.Profiles {
+		plugins := logic.DefaultPlugins()
+		if profile.Plugins != nil {
+			plugins = logic.PluginSet{}
+			for _, p := range profile.Plugins.Filter {
+				plugins.Filter = append(plugins.Filter, p.Name)
+			}
+			for _, p := range profile.Plugins.Score {
+				plugins.Score = append(plugins.Score, logic.WeightedPlugin{Name: p.Name, Weight: p.Weight})
+			}
+		}
+		plugins.Args = profile.Args()
+
+		scheduleLogic, err := logic.NewScheduleLogic(registry, plugins)
+		if err != nil {
+			return nil, fmt.Errorf("error creating profile %s: %w", profile.SchedulerName, err)
+		}
+		scheduleLogic.SetMetricsRecorder(metricsRecorder)
+		scheduleLogic.SetParallelism(cfg.Parallelism)
+		scheduleLogic.SetPercentageOfNodesToScore(cfg.PercentageOfNodesToScore)
+		profiles[profile.SchedulerName] = scheduleLogic
+	}
+	return profiles, nil
+}
+
+// Pod と Node、Namespace、ボリュームに関するリソースの informer を起動し、キャッシュの同期を待つ
+// スケジュール対象の Pod は informer のイベントを通じてキューに積まれる
+// ctx がキャンセルされるとキューも閉じられる
+func (k *K8sClient) StartInformers(ctx context.Context) error {
+	initialBackoff, maxBackoff := k.PodInitialBackoff, k.PodMaxBackoff
+	if initialBackoff == 0 {
+		initialBackoff = config.DefaultPodInitialBackoffSeconds * time.Second
+	}
+	if maxBackoff == 0 {
+		maxBackoff = config.DefaultPodMaxBackoffSeconds * time.Second
+	}
+	k.queue = queue.NewPriorityQueue(initialBackoff, maxBackoff)
+	bindParallelism := k.BindParallelism
+	if bindParallelism == 0 {
+		bindParallelism = config.DefaultBindParallelism
+	}
+	k.bindingSlots = make(chan struct{}, bindParallelism)
+	k.bindings = &sync.WaitGroup{}
+	k.podGroups = newPodGroupWaiter()
+	k.queue.Run(ctx.Done())
+	k.Metrics.SetQueueDepths(k.queue.Depths)
+	k.schedulerCache = internalcache.New(assumedPodTTL)
+	k.schedulerCache.Run(ctx.Done())
+	k.startEventRecorders(ctx)
+
+	// Namespace は pod affinity の namespaceSelector を評価するために使う
+	// PVC / PV / StorageClass / CSINode はボリュームを考慮したスケジュールに、PDB はプリエンプションに使う
+	nodeInformerFactory := informers.NewSharedInformerFactory(k.Clientset, 0)
+	k.nodeLister = nodeInformerFactory.Core().V1().Nodes().Lister()
+	k.namespaceLister = nodeInformerFactory.Core().V1().Namespaces().Lister()
+	k.pvcLister = nodeInformerFactory.Core().V1().PersistentVolumeClaims().Lister()
+	k.pvLister = nodeInformerFactory.Core().V1().PersistentVolumes().Lister()
+	k.storageClassLister = nodeInformerFactory.Storage().V1().StorageClasses().Lister()
+	k.csiNodeLister = nodeInformerFactory.Storage().V1().CSINodes().Lister()
+	k.pdbLister = nodeInformerFactory.Policy().V1().PodDisruptionBudgets().Lister()
+
+	// ノードの空き容量を計算するため、ノードに配置済みで動作中の Pod を watch する
+	assignedPodInformerFactory := informers.NewSharedInformerFactoryWithOptions(k.Clientset, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = assignedPodSelector().String()
+		}),
+	)
+
+	// 担当する未スケジュールの Pod だけを API サーバ側で絞り込んで watch する
+	podInformerFactory := informers.NewSharedInformerFactoryWithOptions(k.Clientset, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.FieldSelector = k.unscheduledPodSelector().String()
+		}),
+	)
+	podInformer := podInformerFactory.Core().V1().Pods()
+	k.podLister = podInformer.Lister()
+	registration, err := k.addPodEventHandlers(podInformer.Informer())
+	if err != nil {
+		return fmt.Errorf("error adding pod event handler: %w", err)
+	}
+	if err := k.addCacheEventHandlers(nodeInformerFactory, assignedPodInformerFactory); err != nil {
+		return err
+	}
+	if err := k.addClusterEventHandlers(nodeInformerFactory, assignedPodInformerFactory); err != nil {
+		return err
+	}
+	factories := []informers.SharedInformerFactory{nodeInformerFactory, assignedPodInformerFactory, podInformerFactory}
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	for _, factory := range factories {
+		for typ, synced := range factory.WaitForCacheSync(ctx.Done()) {
+			if !synced {
+				return fmt.Errorf("error syncing informer cache for %v", typ)
+			}
+		}
+	}
+	// 既存 Pod の Add イベントがすべてキューに積まれるまで待つ
+	if !cache.WaitForCacheSync(ctx.Done(), registration.HasSynced) {
+		return fmt.Errorf("error waiting for pod event handler to sync")
+	}
+	return nil
+}
+
+// QueueDepths はスケジュールを待つ Pod の数をサブキューごとに返す
+func (k *K8sClient) QueueDepths() queue.Depths {
+	return k.queue.Depths()
 }
 
 func (k *K8sClient) GetNodes() (*v1.NodeList, error) {
-	nodes, err := k.Clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	nodes, err := k.nodeLister.List(labels.Everything())
 	if err != nil {
 		return nil, fmt.Errorf("error getting nodes: %s", err.Error())
 	}
-	return nodes, nil
+	nodeList := &v1.NodeList{Items: make([]v1.Node, 0, len(nodes))}
+	for _, node := range nodes {
+		nodeList.Items = append(nodeList.Items, *node)
+	}
+	return nodeList, nil
 }
 
 func (k *K8sClient) GetUnscheduledPods() (*v1.PodList, error) {
-	// node にアサインされていない Pod の一覧を取得する
-
-	// TODO: この実装はFieldSelectorを使うことで効率化される
-	unscheduledPods := &v1.PodList{}
-	pods, err := k.Clientset.CoreV1().Pods("").List(context.TODO(), metav1.ListOptions{})
+	// このスケジューラが担当し、まだ node にアサインされていない Pod の一覧を取得する
+	pods, err := k.podLister.List(labels.Everything())
 	if err != nil {
 		return nil, fmt.Errorf("error getting pods: %s", err.Error())
 	}
-	for _, pod := range pods.Items {
-		if pod.Spec.NodeName == "" {
-			unscheduledPods.Items = append(unscheduledPods.Items, pod)
-			slog.Info("detect unscheduled pods", "name", pod.Name, "namespace", pod.Namespace)
+	unscheduledPods := &v1.PodList{}
+	// FieldSelector を解釈しない API (fake clientset など) もあるので、手元でも同じ条件で絞り込む
+	for _, pod := range pods {
+		if !k.isPodToSchedule(pod) {
+			continue
 		}
+		unscheduledPods.Items = append(unscheduledPods.Items, *pod)
 	}
 
 	return unscheduledPods, nil
 }
 
+// プリエンプションで nominatedNodeName を設定した、まだ配置していない Pod を返す
+// 配置を決めて bind を待っている Pod はキャッシュで配置済みとして数えるので含めない
+func (k *K8sClient) GetNominatedPods() ([]*v1.Pod, error) {
+	pods, err := k.podLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error getting pods: %s", err.Error())
+	}
+	var nominatedPods []*v1.Pod
+	for _, pod := range pods {
+		if pod.Status.NominatedNodeName == "" || !k.isPodToSchedule(pod) || k.schedulerCache.IsAssumedPod(pod) {
+			continue
+		}
+		nominatedPods = append(nominatedPods, pod)
+	}
+	return nominatedPods, nil
+}
+
+// スケジューラのキャッシュのノードと配置済み Pod、Namespace、ボリュームに関するリソースから、スケジュールに使う Snapshot を作る
+// 配置を決めて bind を待っている Pod も配置済みとして含む
+// ノードを指名された Pod は、そのノードの空きを優先度の低い Pod に使わせないよう Snapshot に渡す
+func (k *K8sClient) GetSnapshot() (*logic.Snapshot, error) {
+	namespaces, err := k.namespaceLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error getting namespaces: %s", err.Error())
+	}
+	storageInfo, err := k.GetStorageInfo()
+	if err != nil {
+		return nil, err
+	}
+	nominatedPods, err := k.GetNominatedPods()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := logic.NewSnapshotFromNodeInfos(k.schedulerCache.NodeInfos(), namespaces)
+	snapshot.SetStorageInfo(storageInfo)
+	snapshot.SetNominatedPods(nominatedPods)
+	return snapshot, nil
+}
+
+func (k *K8sClient) GetStorageInfo() (*logic.StorageInfo, error) {
+	pvcs, err := k.pvcLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error getting persistent volume claims: %s", err.Error())
+	}
+	pvs, err := k.pvLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error getting persistent volumes: %s", err.Error())
+	}
+	classes, err := k.storageClassLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error getting storage classes: %s", err.Error())
+	}
+	csiNodes, err := k.csiNodeLister.List(labels.Everything())
+	if err != nil {
+		return nil, fmt.Errorf("error getting csi nodes: %s", err.Error())
+	}
+	return logic.NewStorageInfo(pvcs, pvs, classes, csiNodes), nil
+}
+
+// ノードに配置済みで動作中の Pod を選ぶための FieldSelector
+func assignedPodSelector() fields.Selector {
+	return fields.AndSelectors(
+		fields.OneTermNotEqualSelector("spec.nodeName", ""),
+		fields.OneTermNotEqualSelector("status.phase", string(v1.PodSucceeded)),
+		fields.OneTermNotEqualSelector("status.phase", string(v1.PodFailed)),
+	)
+}
+
+// 担当する未スケジュールの Pod を選ぶための FieldSelector
+func (k *K8sClient) unscheduledPodSelector() fields.Selector {
+	selectors := []fields.Selector{
+		fields.OneTermEqualSelector("spec.nodeName", ""),
+		fields.OneTermNotEqualSelector("status.phase", string(v1.PodSucceeded)),
+		fields.OneTermNotEqualSelector("status.phase", string(v1.PodFailed)),
+	}
+	// FieldSelector では OR を書けないので、schedulerName で絞り込めるのは Profile が 1 つのときだけ
+	if len(k.Profiles) == 1 {
+		for schedulerName := range k.Profiles {
+			selectors = append(selectors, fields.OneTermEqualSelector("spec.schedulerName", schedulerName))
+		}
+	}
+	return fields.AndSelectors(selectors...)
+}
+
+// Pod がこのスケジューラの担当で、まだスケジュールが必要な状態かどうかを返す
+func (k *K8sClient) isPodToSchedule(pod *v1.Pod) bool {
+	if _, ok := k.Profiles[pod.Spec.SchedulerName]; !ok {
+		return false
+	}
+	if pod.Spec.NodeName != "" {
+		return false
+	}
+	// 削除中の Pod は配置しても意味がない
+	if pod.DeletionTimestamp != nil {
+		return false
+	}
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	return true
+}
+
+// BindPodVolumes は Pod を node に配置する前に、Pod の未束縛の PVC を node で使えるようにする
+//   - 束縛できる PV がある PVC: PV の claimRef に PVC を設定し、PV コントローラに束縛させる
+//   - WaitForFirstConsumer でプロビジョニングする PVC: selected-node アノテーションで node を伝える
+//
+// node では Pod のボリュームを用意できない場合は *logic.FitError を返す
+func (k *K8sClient) BindPodVolumes(pod *v1.Pod, node *v1.Node, storageInfo *logic.StorageInfo) error {
+	podVolumes, reasons := logic.FindPodVolumes(pod, node, storageInfo)
+	if len(reasons) > 0 {
+		return &logic.FitError{Pod: pod, NumAllNodes: 1, NodeToReasons: map[string][]string{node.Name: reasons}}
+	}
+
+	ctx := context.TODO()
+	for _, binding := range podVolumes.StaticBindings {
+		pvc := binding.PVC
+		if ref := binding.PV.Spec.ClaimRef; ref != nil && ref.UID == pvc.UID {
+			// すでに PVC を指している
+			continue
+		}
+		pv := binding.PV.DeepCopy()
+		pv.Spec.ClaimRef = &v1.ObjectReference{
+			APIVersion:      "v1",
+			Kind:            "PersistentVolumeClaim",
+			Namespace:       pvc.Namespace,
+			Name:            pvc.Name,
+			UID:             pvc.UID,
+			ResourceVersion: pvc.ResourceVersion,
+		}
+		metav1.SetMetaDataAnnotation(&pv.ObjectMeta, logic.AnnBoundByController, "yes")
+		slog.Info("binding persistent volume to claim", "pv", pv.Name, "pvc", pvc.Name, "namespace", pvc.Namespace, "node", node.Name)
+		if _, err := k.Clientset.CoreV1().PersistentVolumes().Update(ctx, pv, metav1.UpdateOptions{}); err != nil {
+			k.Metrics.IncAPIError("update_persistent_volume")
+			return fmt.Errorf("failed to bind persistent volume %s to claim %s/%s: %w", pv.Name, pvc.Namespace, pvc.Name, err)
+		}
+	}
+	for _, claim := range podVolumes.DynamicProvisions {
+		if claim.Annotations[logic.AnnSelectedNode] == node.Name {
+			continue
+		}
+		pvc := claim.DeepCopy()
+		metav1.SetMetaDataAnnotation(&pvc.ObjectMeta, logic.AnnSelectedNode, node.Name)
+		slog.Info("requesting volume provisioning", "pvc", pvc.Name, "namespace", pvc.Namespace, "node", node.Name)
+		if _, err := k.Clientset.CoreV1().PersistentVolumeClaims(pvc.Namespace).Update(ctx, pvc, metav1.UpdateOptions{}); err != nil {
+			k.Metrics.IncAPIError("update_persistent_volume_claim")
+			return fmt.Errorf("failed to set selected node on claim %s/%s: %w", pvc.Namespace, pvc.Name, err)
+		}
+	}
+	return nil
+}
+
 func (k *K8sClient) AssignPodToNode(pod *v1.Pod, node *v1.Node) error {
 	binding := &v1.Binding{
 		ObjectMeta: metav1.ObjectMeta{
@@ -107,8 +459,11 @@ func (k *K8sClient) AssignPodToNode(pod *v1.Pod, node *v1.Node) error {
 
 	slog.Info("attempting to bind pod to node", "pod", pod.Name, "node", node.Name)
 
+	start := time.Now()
 	err := k.Clientset.CoreV1().Pods(pod.Namespace).Bind(context.TODO(), binding, metav1.CreateOptions{})
+	k.Metrics.ObserveBinding(err, time.Since(start))
 	if err != nil {
+		k.Metrics.IncAPIError("bind")
 		slog.Error("failed to bind pod to node", "pod", pod.Name, "node", node.Name, "error", err)
 		return fmt.Errorf("failed to bind pod %s/%s to node %s: %w", pod.Namespace, pod.Name, node.Name, err)
 	}
@@ -116,52 +471,378 @@ func (k *K8sClient) AssignPodToNode(pod *v1.Pod, node *v1.Node) error {
 	return nil
 }
 
-// スケジュールされていない pod 取得 → ノード情報取得 → 配置するpodを選択 → 配置指示
-// 一連の処理の一巡を行う
-func (k *K8sClient) ProcessOneLoop() error {
-	unscheduledPods, err := k.GetUnscheduledPods()
+// assumedPod は配置するノードを決めて仮置きし、bind を待っている Pod
+type assumedPod struct {
+	pInfo *queue.QueuedPodInfo
+	// informer から取得した Pod と、spec.nodeName を設定して仮置きした Pod
+	pod        *v1.Pod
+	assumedPod *v1.Pod
+	node       *v1.Node
+	// ボリュームを用意するために使う、スケジュールしたときの PVC / PV など
+	storageInfo *logic.StorageInfo
+	// Pod が属する Pod グループ。nil なら他の Pod を待たずに bind する
+	group *podGroup
+}
+
+// キューから取り出した Pod 1 つについて
+// ノード情報取得 → 配置するnodeを選択 → 仮置き を行う
+// 配置できなかった Pod は、配置できるようになり得るイベントが来るまでキューの unschedulablePods で待つ
+// 配置するノードを決めたら、bindingCycle で bind する Pod を返す
+// そうでなければ試行の結果を返す。Pod がすでにスケジュール不要になっていれば、Result が空の結果を返す
+func (k *K8sClient) schedulePod(pInfo *queue.QueuedPodInfo) (*assumedPod, PodResult, error) {
+	pod, err := k.podLister.Pods(pInfo.Pod.Namespace).Get(pInfo.Pod.Name)
+	if apierrors.IsNotFound(err) {
+		// キューに積まれた後に削除された
+		return nil, PodResult{}, nil
+	} else if err != nil {
+		return nil, PodResult{}, err
+	}
+	if !k.isPodToSchedule(pod) {
+		return nil, PodResult{}, nil
+	}
+	if k.schedulerCache.IsAssumedPod(pod) {
+		// bind 済みで informer に反映されるのを待っている、または Pod グループの他のメンバーを待っている
+		return nil, PodResult{}, nil
+	}
+	slog.Info("detect unscheduled pods", "name", pod.Name, "namespace", pod.Namespace)
+
+	group, err := podGroupOf(pod)
 	if err != nil {
-		return err
+		// Pod が直されるまで配置しない
+		k.recordSchedulingFailure(pod, v1.PodReasonUnschedulable, err.Error(), "")
+		k.queue.AddUnschedulableIfNotPresent(pInfo, true)
+		return nil, PodResult{Result: metrics.ResultUnschedulable, Reason: err.Error()}, nil
+	}
+
+	snapshot, err := k.GetSnapshot()
+	if err != nil {
+		return nil, PodResult{}, err
 	}
 
-	for _, pod := range unscheduledPods.Items {
-		nodes, err := k.GetNodes()
+	scheduleLogic := k.Profiles[pod.Spec.SchedulerName]
+
+	// 配置して良いノードを取得
+	availableNodes, err := scheduleLogic.ChooseAvailableNodes(pod, snapshot)
+	var fitErr *logic.FitError
+	if errors.As(err, &fitErr) {
+		// どのノードにも配置できない。優先度の低い Pod を退避させ、その Pod が削除されたら再試行する
+		slog.Info("pod is unschedulable", "pod", pod.Name, "namespace", pod.Namespace, "reason", fitErr.Error(), "attempts", pInfo.Attempts)
+		nominatedNodeName, err := k.preempt(pod, snapshot, scheduleLogic)
 		if err != nil {
-			return err
+			return nil, PodResult{}, err
 		}
+		k.recordSchedulingFailure(pod, v1.PodReasonUnschedulable, fitErr.Error(), nominatedNodeName)
+		k.queue.AddUnschedulableIfNotPresent(pInfo, true)
+		return nil, PodResult{Result: metrics.ResultUnschedulable, Reason: fitErr.Error()}, nil
+	} else if err != nil {
+		return nil, PodResult{}, err
+	}
 
-		// 配置して良いノードを取得
-		availableNodes, err := k.ScheduleLogic.ChooseAvailableNodes(&pod, nodes)
-		if err != nil {
-			return err
+	// 実際に配置するノードを取得
+	selectNode, err := scheduleLogic.ChooseSuitableNode(pod, availableNodes, snapshot)
+	if err != nil {
+		return nil, PodResult{}, err
+	}
+
+	// もし selectNode が空だったら、クラスタが変わるのを待ってから再試行する
+	if selectNode.Name == "" {
+		slog.Info("no suitable node found for pod", "pod", pod.Name)
+		k.recordSchedulingFailure(pod, v1.PodReasonUnschedulable, "no suitable node found", "")
+		k.queue.AddUnschedulableIfNotPresent(pInfo, true)
+		return nil, PodResult{Result: metrics.ResultUnschedulable, Reason: "no suitable node found"}, nil
+	}
+
+	// bind を待たずに、次の Pod のスケジュールにこの Pod の配置を反映する
+	assumed := pod.DeepCopy()
+	assumed.Spec.NodeName = selectNode.Name
+	if err := k.schedulerCache.AssumePod(assumed); err != nil {
+		return nil, PodResult{}, err
+	}
+	return &assumedPod{pInfo: pInfo, pod: pod, assumedPod: assumed, node: &selectNode, storageInfo: snapshot.StorageInfo(), group: group}, PodResult{}, nil
+}
+
+// bindingCycle は仮置きした Pod のボリュームを用意してから、Pod をノードに bind する
+// 失敗したら仮置きを取り消すので、他の Pod はそのノードの空きを使えるようになる
+func (k *K8sClient) bindingCycle(a *assumedPod) (PodResult, error) {
+	if err := k.BindPodVolumes(a.pod, a.node, a.storageInfo); err != nil {
+		k.forgetAssumedPod(a)
+		var fitErr *logic.FitError
+		if errors.As(err, &fitErr) {
+			slog.Info("pod volumes are not available on the selected node", "pod", a.pod.Name, "namespace", a.pod.Namespace, "node", a.node.Name, "reason", fitErr.Error())
+			k.recordSchedulingFailure(a.pod, v1.PodReasonUnschedulable, fitErr.Error(), "")
+			k.queue.AddUnschedulableIfNotPresent(a.pInfo, true)
+			return PodResult{Result: metrics.ResultUnschedulable, Reason: fitErr.Error()}, nil
 		}
+		return PodResult{}, err
+	}
 
-		// 実際に配置するノードを取得
-		selectNode, err := k.ScheduleLogic.ChooseSuitableNode(&pod, availableNodes)
-		if err != nil {
-			return err
+	if err := k.AssignPodToNode(a.pod, a.node); err != nil {
+		k.forgetAssumedPod(a)
+		return PodResult{}, err
+	}
+	k.schedulerCache.FinishBinding(a.assumedPod)
+
+	slog.Info("assign pod to node successfully", "pod", a.pod.Name, "node", a.node.Name)
+	k.recordScheduled(a.pod, a.node.Name)
+	return PodResult{Result: metrics.ResultScheduled, NodeName: a.node.Name}, nil
+}
+
+// preempt は優先度の低い Pod を退避させれば pod を配置できるノードを探し、退避させる Pod を削除する
+// Pod は terminationGracePeriodSeconds に従って終了し、pod は再試行で配置される
+// pod の nominatedNodeName に設定するノード名を返す。退避させなかった場合は空文字列を返す
+func (k *K8sClient) preempt(pod *v1.Pod, snapshot *logic.Snapshot, scheduleLogic ScheduleLogic) (string, error) {
+	if k.Preemption.Disabled {
+		return "", nil
+	}
+	pdbs, err := k.pdbLister.List(labels.Everything())
+	if err != nil {
+		return "", fmt.Errorf("error getting pod disruption budgets: %s", err.Error())
+	}
+	result, err := scheduleLogic.Preempt(pod, snapshot, pdbs)
+	if err != nil {
+		return "", fmt.Errorf("failed to preempt pods for pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	if result == nil {
+		slog.Info("preemption is not helpful for scheduling", "pod", pod.Name, "namespace", pod.Namespace)
+		return "", nil
+	}
+
+	victims := make([]string, 0, len(result.Victims))
+	for _, victim := range result.Victims {
+		victims = append(victims, victim.Namespace+"/"+victim.Name)
+	}
+	if k.Preemption.DryRun {
+		slog.Info("dry-run: would preempt pods", "pod", pod.Name, "namespace", pod.Namespace, "node", result.NodeName, "victims", victims, "pdbViolations", result.NumPDBViolations)
+		return "", nil
+	}
+	slog.Info("preempting pods", "pod", pod.Name, "namespace", pod.Namespace, "node", result.NodeName, "victims", victims, "pdbViolations", result.NumPDBViolations)
+
+	ctx := context.TODO()
+	for _, victim := range result.Victims {
+		if err := k.deleteVictim(ctx, pod, victim); err != nil {
+			return "", err
 		}
+	}
+	return result.NodeName, nil
+}
 
-		// もし selectNode が空だったら、スケジューリングをスキップ
-		if selectNode.Name == "" {
-			slog.Info("no suitable node found for pod", "pod", pod.Name)
-			continue
+// deleteVictim は退避させる Pod に DisruptionTarget の condition を付けてから削除する
+// 削除の猶予期間は Pod の terminationGracePeriodSeconds に従う
+func (k *K8sClient) deleteVictim(ctx context.Context, preemptor, victim *v1.Pod) error {
+	condition := v1.PodCondition{
+		Type:    v1.DisruptionTarget,
+		Status:  v1.ConditionTrue,
+		Reason:  v1.PodReasonPreemptionByScheduler,
+		Message: fmt.Sprintf("%s: preempting to accommodate a higher priority pod %s/%s", preemptor.Spec.SchedulerName, preemptor.Namespace, preemptor.Name),
+	}
+	if err := k.patchPodStatus(victim, condition, ""); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err := k.Clientset.CoreV1().Pods(victim.Namespace).Delete(ctx, victim.Name, metav1.DeleteOptions{Preconditions: metav1.NewUIDPreconditions(string(victim.UID))})
+	if err != nil && !apierrors.IsNotFound(err) {
+		k.Metrics.IncAPIError("delete_pod")
+		return fmt.Errorf("failed to delete victim pod %s/%s: %w", victim.Namespace, victim.Name, err)
+	}
+	slog.Info("preempted pod", "victim", victim.Name, "namespace", victim.Namespace, "preemptor", preemptor.Name, "node", victim.Spec.NodeName)
+	k.recorder(preemptor).Eventf(victim, v1.EventTypeNormal, eventReasonPreempted, "Preempted by pod %s on node %s", preemptor.UID, victim.Spec.NodeName)
+	return nil
+}
+
+// forgetAssumedPod は仮置きを取り消す
+// 仮置きの分の空きが無いために配置できなかった Pod があるかもしれないので、再試行させる
+func (k *K8sClient) forgetAssumedPod(a *assumedPod) {
+	k.schedulerCache.ForgetPod(a.assumedPod)
+	k.queue.MoveAllToActiveOrBackoffQueue(eventAssumedPodForget, nil)
+}
+
+// PodResult は Pod 1 つのスケジュールの試行の結果
+type PodResult struct {
+	Pod *v1.Pod
+	// metrics.ResultScheduled / ResultUnschedulable / ResultError、または ResultWaiting のいずれか
+	// 取り出した時点でスケジュール不要になっていた Pod (削除済み、仮置き済みなど) は空
+	Result string
+	// 配置したノード。Result が ResultScheduled のときだけ設定する
+	NodeName string
+	// 配置できなかった理由。Result が ResultUnschedulable のときだけ設定する
+	Reason string
+	// Result が ResultError のときのエラー
+	Err error
+}
+
+// CycleResult は ProcessOneLoop で試行した Pod ごとの結果。キューから取り出した順に並ぶ
+// テストなど、ProcessOneLoop を呼んだ側が Pod ごとの結果を確かめるために使う
+// メトリクスとログには、CycleResult を使わなくても processPod が記録する
+type CycleResult struct {
+	Pods []PodResult
+}
+
+// Get は Pod の結果を返す。試行していなければ nil を返す
+func (r CycleResult) Get(namespace, name string) *PodResult {
+	for i := range r.Pods {
+		if r.Pods[i].Pod.Namespace == namespace && r.Pods[i].Pod.Name == name {
+			return &r.Pods[i]
+		}
+	}
+	return nil
+}
+
+// Count は結果が result だった Pod の数を返す
+func (r CycleResult) Count(result string) int {
+	n := 0
+	for _, p := range r.Pods {
+		if p.Result == result {
+			n++
+		}
+	}
+	return n
+}
+
+// Err はエラーになった Pod のエラーをまとめて返す。1 つも無ければ nil を返す
+func (r CycleResult) Err() error {
+	var errs []error
+	for _, p := range r.Pods {
+		if p.Err != nil {
+			errs = append(errs, fmt.Errorf("pod %s/%s: %w", p.Pod.Namespace, p.Pod.Name, p.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// キューから取り出した Pod 1 つをスケジュールし、試行の結果を result に書き込む
+// 結果はメトリクスとログにも記録する
+// 配置するノードが決まったら、bind は別の goroutine で行い、終わるのを待たずに戻る
+// その場合 result は bind が終わってから書き込まれるので、k.bindings を待ってから読む
+// Pod グループのメンバーは、minMember 個の配置が決まるまで bind を待ち、揃ったらまとめて bind する
+// まとめて bind するメンバーのどれかの bind に失敗したら、まだ bind していないメンバーも bind せずに再試行させる
+func (k *K8sClient) processPod(pInfo *queue.QueuedPodInfo, result *PodResult) {
+	start := time.Now()
+	assumed, attempt, err := k.schedulePod(pInfo)
+	if err != nil {
+		*result = k.handleSchedulingError(pInfo, start, err)
+		k.queue.Done(pInfo.Pod)
+		return
+	}
+	if assumed == nil {
+		*result = k.observeSchedulingResult(pInfo, start, attempt)
+		k.queue.Done(pInfo.Pod)
+		return
+	}
+
+	toBind := []*waitingPod{{assumedPod: assumed, start: start, result: result}}
+	var gang *gangBinding
+	if assumed.group != nil {
+		if toBind = k.permitPodGroup(toBind[0]); toBind == nil {
+			return
 		}
+		gang = &gangBinding{}
+	}
+	for _, wp := range toBind {
+		k.startBinding(wp, gang)
+	}
+}
+
+// startBinding は別の goroutine で bindingCycle を実行し、試行の結果を wp.result に書き込む
+// gang は Pod グループのメンバーをまとめて bind するときに渡す。他のメンバーの bind に失敗していたら、wp は bind しない
+func (k *K8sClient) startBinding(wp *waitingPod, gang *gangBinding) {
+	result := wp.result
+	if result == nil {
+		// 前の ProcessOneLoop から待っていた Pod。結果はログとメトリクスにだけ残す
+		result = &PodResult{}
+	}
+	// bind が BindParallelism 個実行中なら、どれかが終わるまで待つ
+	k.bindingSlots <- struct{}{}
+	k.bindings.Add(1)
+	go func() {
+		defer k.bindings.Done()
+		defer func() { <-k.bindingSlots }()
+		// bind が終わるまではスケジュール中として扱い、その間のイベントで再試行できるようにする
+		defer k.queue.Done(wp.pInfo.Pod)
 
-		if err := k.AssignPodToNode(&pod, &selectNode); err != nil {
-			return err
+		if gang != nil {
+			if failed := gang.failedMember(); failed != nil {
+				*result = k.abortGangMember(wp, failed)
+				return
+			}
+		}
+		attempt, err := k.bindingCycle(wp.assumedPod)
+		if gang != nil && (err != nil || attempt.Result != metrics.ResultScheduled) {
+			gang.fail(wp.pod)
+		}
+		if err != nil {
+			// bind に失敗した Pod だけを再試行する
+			*result = k.handleSchedulingError(wp.pInfo, wp.start, err)
+			return
 		}
+		*result = k.observeSchedulingResult(wp.pInfo, wp.start, attempt)
+	}()
+}
 
-		slog.Info("assign pod to node successfully", "pod", pod.Name, "node", selectNode.Name)
+// handleSchedulingError は API のエラーなどで Pod をスケジュールできなかったことを記録する
+// クラスタの変化を待たず、バックオフの後でこの Pod だけを再試行する
+func (k *K8sClient) handleSchedulingError(pInfo *queue.QueuedPodInfo, start time.Time, err error) PodResult {
+	slog.Error("failed to schedule pod", "pod", pInfo.Pod.Name, "namespace", pInfo.Pod.Namespace, "error", err)
+	k.Metrics.ObserveSchedulingAttempt(metrics.ResultError, pInfo.Pod.Spec.SchedulerName, time.Since(start))
+	k.recordSchedulingFailure(pInfo.Pod, v1.PodReasonSchedulerError, err.Error(), "")
+	k.queue.AddUnschedulableIfNotPresent(pInfo, false)
+	return PodResult{Pod: pInfo.Pod, Result: metrics.ResultError, Err: err}
+}
+
+// observeSchedulingResult は試行の結果をメトリクスに記録し、Pod を設定して返す
+func (k *K8sClient) observeSchedulingResult(pInfo *queue.QueuedPodInfo, start time.Time, attempt PodResult) PodResult {
+	profile := pInfo.Pod.Spec.SchedulerName
+	if attempt.Result != "" {
+		k.Metrics.ObserveSchedulingAttempt(attempt.Result, profile, time.Since(start))
+	}
+	if attempt.Result == metrics.ResultScheduled {
+		k.Metrics.ObservePodScheduled(profile, time.Since(pInfo.InitialAttemptTimestamp))
+	}
+	attempt.Pod = pInfo.Pod
+	return attempt
+}
+
+// 現時点で activeQ に積まれている Pod を一巡スケジュールし、bind が終わるまで待ってから Pod ごとの結果を返す
+// ある Pod のスケジュールや bind に失敗しても、その Pod だけを再試行に回し、後ろの Pod のスケジュールは続ける
+// 前回までに Pod グループの他のメンバーを待っていた Pod は、この回に bind しても結果には含めない
+// 一巡の途中で activeQ が空になったら (数えた後に Pod が削除されたなど)、待たずにそこで終える
+func (k *K8sClient) ProcessOneLoop() CycleResult {
+	results := make([]PodResult, k.queue.Depths().Active)
+	n := 0
+	for ; n < len(results); n++ {
+		pInfo, ok := k.queue.TryPop()
+		if !ok {
+			break
+		}
+		k.processPod(pInfo, &results[n])
+	}
+	k.bindings.Wait()
+	// Pod グループの他のメンバーを待っている Pod は ResultWaiting のまま返す
+	k.podGroups.detachResults()
+	return CycleResult{Pods: results[:n]}
+}
+
+// informer を起動し、ctx がキャンセルされるまでキューに積まれた Pod をスケジュールし続ける
+// LeaderElection が設定されていれば、リーダーに選ばれている間だけスケジュールする
+func (k *K8sClient) Run(ctx context.Context) error {
+	if err := k.StartInformers(ctx); err != nil {
+		return err
+	}
+	if k.LeaderElection != nil {
+		return k.runWithLeaderElection(ctx, k.LeaderElection)
 	}
+	k.scheduleLoop()
 	return nil
 }
 
-func (k *K8sClient) Run() {
+// scheduleLoop はキューが閉じられるまで Pod を取り出してスケジュールする
+// 閉じられたら、実行中の bind が終わるのを待ってから戻る
+func (k *K8sClient) scheduleLoop() {
+	defer k.bindings.Wait()
 	for {
-		if err := k.ProcessOneLoop(); err != nil {
-			slog.Error(err.Error())
+		pInfo, ok := k.queue.Pop()
+		if !ok {
+			return
 		}
-		time.Sleep(10 * time.Second)
+		// 結果は processPod がメトリクスとログに記録しているので、ここでは使わない
+		k.processPod(pInfo, &PodResult{})
 	}
 }
@@ -1,17 +1,59 @@
 package client
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"kube-scheduler-practice/internal/config"
+	"kube-scheduler-practice/internal/logic"
+	"kube-scheduler-practice/internal/metrics"
+	"kube-scheduler-practice/internal/queue"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	coretesting "k8s.io/client-go/testing"
 )
 
+const testSchedulerName = "my-custom-scheduler"
+
+// schedulerNames を担当する、何もしない ScheduleLogic の Profile を返す
+func testProfiles(schedulerNames ...string) map[string]ScheduleLogic {
+	profiles := map[string]ScheduleLogic{}
+	for _, name := range schedulerNames {
+		profiles[name] = &mockScheduleLogic{}
+	}
+	return profiles
+}
+
+// informer を起動済みの K8sClient を返す。informer はテスト終了時に停止する
+func newStartedClient(t *testing.T, k *K8sClient) *K8sClient {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := k.StartInformers(ctx); err != nil {
+		t.Fatalf("K8sClient.StartInformers() error = %v", err)
+	}
+	return k
+}
+
 func TestK8sClient_GetNodes(t *testing.T) {
 	type fields struct {
 		K8sClient K8sClient
@@ -68,9 +110,9 @@ func TestK8sClient_GetNodes(t *testing.T) {
 	// --- テストの実行ループ ---
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := &K8sClient{
+			k := newStartedClient(t, &K8sClient{
 				Clientset: tt.fields.K8sClient.Clientset,
-			}
+			})
 			got, err := k.GetNodes()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("K8sClient.GetNodes() error = %v, wantErr %v", err, tt.wantErr)
@@ -108,7 +150,8 @@ func TestK8sClient_GetPodsNotScheduled(t *testing.T) {
 			Namespace: "default",
 		},
 		Spec: v1.PodSpec{
-			NodeName: "", // NodeNameが空
+			NodeName:      "", // NodeNameが空
+			SchedulerName: testSchedulerName,
 		},
 	}
 
@@ -118,11 +161,60 @@ func TestK8sClient_GetPodsNotScheduled(t *testing.T) {
 			Namespace: "default",
 		},
 		Spec: v1.PodSpec{
-			NodeName: "kind-worker", // NodeNameが設定済み
+			NodeName:      "kind-worker", // NodeNameが設定済み
+			SchedulerName: testSchedulerName,
 		},
 	}
 
-	fakeClientset := fake.NewSimpleClientset(unscheduledPod, scheduledPod)
+	// 別のスケジューラが担当する Pod
+	defaultSchedulerPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "default-scheduler-pod",
+			Namespace: "default",
+		},
+		Spec: v1.PodSpec{
+			SchedulerName: "default-scheduler",
+		},
+	}
+	noSchedulerNamePod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "no-scheduler-name-pod",
+			Namespace: "kube-system",
+		},
+	}
+
+	// 削除中、または終了済みの Pod
+	terminatingPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "terminating-pod",
+			Namespace:         "default",
+			DeletionTimestamp: &metav1.Time{Time: time.Now()},
+			Finalizers:        []string{"example.com/keep"},
+		},
+		Spec: v1.PodSpec{
+			SchedulerName: testSchedulerName,
+		},
+	}
+	succeededPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "succeeded-pod",
+			Namespace: "default",
+		},
+		Spec: v1.PodSpec{
+			SchedulerName: testSchedulerName,
+		},
+		Status: v1.PodStatus{Phase: v1.PodSucceeded},
+	}
+	failedPod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "failed-pod",
+			Namespace: "default",
+		},
+		Spec: v1.PodSpec{
+			SchedulerName: testSchedulerName,
+		},
+		Status: v1.PodStatus{Phase: v1.PodFailed},
+	}
 
 	tests := []struct {
 		name    string
@@ -133,7 +225,7 @@ func TestK8sClient_GetPodsNotScheduled(t *testing.T) {
 		{
 			name: "success",
 			fields: fields{
-				K8sClient: K8sClient{Clientset: fakeClientset},
+				K8sClient: K8sClient{Clientset: fake.NewSimpleClientset(unscheduledPod, scheduledPod), Profiles: testProfiles(testSchedulerName)},
 			},
 			want: &v1.PodList{
 				Items: []v1.Pod{*unscheduledPod},
@@ -143,19 +235,59 @@ func TestK8sClient_GetPodsNotScheduled(t *testing.T) {
 		{
 			name: "none",
 			fields: fields{
-				K8sClient: K8sClient{Clientset: fake.NewSimpleClientset()},
+				K8sClient: K8sClient{Clientset: fake.NewSimpleClientset(), Profiles: testProfiles(testSchedulerName)},
 			},
 			want: &v1.PodList{
 				Items: []v1.Pod{},
 			},
 			wantErr: false,
 		},
+		{
+			name: "success: mixed scheduler names",
+			fields: fields{
+				K8sClient: K8sClient{
+					Clientset: fake.NewSimpleClientset(unscheduledPod, scheduledPod, defaultSchedulerPod, noSchedulerNamePod),
+					Profiles:  testProfiles(testSchedulerName),
+				},
+			},
+			want: &v1.PodList{
+				Items: []v1.Pod{*unscheduledPod},
+			},
+			wantErr: false,
+		},
+		{
+			name: "success: another scheduler name picks only its own pods",
+			fields: fields{
+				K8sClient: K8sClient{
+					Clientset: fake.NewSimpleClientset(unscheduledPod, scheduledPod, defaultSchedulerPod, noSchedulerNamePod),
+					Profiles:  testProfiles("default-scheduler"),
+				},
+			},
+			want: &v1.PodList{
+				Items: []v1.Pod{*defaultSchedulerPod},
+			},
+			wantErr: false,
+		},
+		{
+			name: "success: terminating and finished pods are excluded",
+			fields: fields{
+				K8sClient: K8sClient{
+					Clientset: fake.NewSimpleClientset(unscheduledPod, terminatingPod, succeededPod, failedPod),
+					Profiles:  testProfiles(testSchedulerName),
+				},
+			},
+			want: &v1.PodList{
+				Items: []v1.Pod{*unscheduledPod},
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := &K8sClient{
+			k := newStartedClient(t, &K8sClient{
 				Clientset: tt.fields.K8sClient.Clientset,
-			}
+				Profiles:  tt.fields.K8sClient.Profiles,
+			})
 			got, err := k.GetUnscheduledPods()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("K8sClient.GetUnscheduledPods() error = %v, wantErr %v", err, tt.wantErr)
@@ -181,6 +313,83 @@ func TestK8sClient_GetPodsNotScheduled(t *testing.T) {
 	}
 }
 
+func TestK8sClient_GetPodsNotScheduled_FieldSelector(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	newStartedClient(t, &K8sClient{Clientset: clientset, Profiles: testProfiles(testSchedulerName)})
+
+	// API サーバ側で絞り込めるよう、informer が FieldSelector 付きで List していることを確認する
+	// 配置済み Pod の informer も pods を List するので、spec.nodeName="" で絞り込んでいるものを探す
+	var listAction coretesting.ListAction
+	for _, action := range clientset.Actions() {
+		a, ok := action.(coretesting.ListAction)
+		if !ok || action.GetResource().Resource != "pods" {
+			continue
+		}
+		if nodeName, ok := a.GetListRestrictions().Fields.RequiresExactMatch("spec.nodeName"); ok && nodeName == "" {
+			listAction = a
+		}
+	}
+	if listAction == nil {
+		t.Fatal("expected a list action for pods")
+	}
+	got := listAction.GetListRestrictions().Fields
+	want := map[string]string{
+		"spec.schedulerName": testSchedulerName,
+		"spec.nodeName":      "",
+	}
+	for field, value := range want {
+		if v, ok := got.RequiresExactMatch(field); !ok || v != value {
+			t.Errorf("field selector %q: want %s=%q", got.String(), field, value)
+		}
+	}
+}
+
+func TestK8sClient_GetNominatedPods(t *testing.T) {
+	newPod := func(name, nodeName, nominatedNodeName string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+			Spec:       v1.PodSpec{NodeName: nodeName, SchedulerName: testSchedulerName},
+			Status:     v1.PodStatus{NominatedNodeName: nominatedNodeName},
+		}
+	}
+	nominated := newPod("nominated", "", "node1")
+	assumed := newPod("assumed", "", "node1")
+	clientset := fake.NewSimpleClientset(
+		nominated,
+		assumed,
+		newPod("not-nominated", "", ""),
+		// 配置済みの Pod は nominatedNodeName が残っていても含めない
+		newPod("scheduled", "node1", "node1"),
+	)
+	k := newStartedClient(t, &K8sClient{Clientset: clientset, Profiles: testProfiles(testSchedulerName)})
+	// 仮置きした Pod はキャッシュで配置済みとして数えるので含めない
+	assumedCopy := assumed.DeepCopy()
+	assumedCopy.Spec.NodeName = "node1"
+	if err := k.schedulerCache.AssumePod(assumedCopy); err != nil {
+		t.Fatalf("Cache.AssumePod() error = %v", err)
+	}
+
+	got, err := k.GetNominatedPods()
+	if err != nil {
+		t.Fatalf("K8sClient.GetNominatedPods() error = %v", err)
+	}
+	var names []string
+	for _, pod := range got {
+		names = append(names, pod.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"nominated"}) {
+		t.Errorf("K8sClient.GetNominatedPods() = %v, want [nominated]", names)
+	}
+
+	snapshot, err := k.GetSnapshot()
+	if err != nil {
+		t.Fatalf("K8sClient.GetSnapshot() error = %v", err)
+	}
+	if pods := snapshot.NominatedPodsForNode("node1"); len(pods) != 1 || pods[0].Name != "nominated" {
+		t.Errorf("Snapshot.NominatedPodsForNode() = %v, want [nominated]", pods)
+	}
+}
+
 func TestK8sClient_AssignPodToNode(t *testing.T) {
 	type fields struct {
 		Clientset kubernetes.Interface
@@ -261,60 +470,143 @@ func TestK8sClient_AssignPodToNode(t *testing.T) {
 	}
 }
 
+func TestK8sClient_BindPodVolumes(t *testing.T) {
+	wffc := storagev1.VolumeBindingWaitForFirstConsumer
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}}
+	classes := []*storagev1.StorageClass{
+		{ObjectMeta: metav1.ObjectMeta{Name: "local"}, Provisioner: "kubernetes.io/no-provisioner", VolumeBindingMode: &wffc},
+		{ObjectMeta: metav1.ObjectMeta{Name: "csi"}, Provisioner: "ebs.csi.aws.com", VolumeBindingMode: &wffc},
+	}
+	newPVC := func(name, class string) *v1.PersistentVolumeClaim {
+		return &v1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+			Spec:       v1.PersistentVolumeClaimSpec{StorageClassName: &class},
+		}
+	}
+	staticPVC := newPVC("static", "local")
+	provisionPVC := newPVC("provision", "csi")
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: "local-pv"},
+		Spec:       v1.PersistentVolumeSpec{StorageClassName: "local"},
+		Status:     v1.PersistentVolumeStatus{Phase: v1.VolumeAvailable},
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"},
+		Spec: v1.PodSpec{Volumes: []v1.Volume{
+			{Name: "data", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "static"}}},
+			{Name: "cache", VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: "provision"}}},
+		}},
+	}
+
+	t.Run("success: bind static volume and request provisioning", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(staticPVC, provisionPVC, pv)
+		k := &K8sClient{Clientset: clientset}
+		storageInfo := logic.NewStorageInfo([]*v1.PersistentVolumeClaim{staticPVC, provisionPVC}, []*v1.PersistentVolume{pv}, classes, nil)
+		if err := k.BindPodVolumes(pod, node, storageInfo); err != nil {
+			t.Fatalf("K8sClient.BindPodVolumes() error = %v", err)
+		}
+
+		gotPV, err := clientset.CoreV1().PersistentVolumes().Get(context.Background(), pv.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get pv: %v", err)
+		}
+		if ref := gotPV.Spec.ClaimRef; ref == nil || ref.Name != staticPVC.Name || ref.UID != staticPVC.UID {
+			t.Errorf("pv claimRef = %+v, want %s", ref, staticPVC.Name)
+		}
+		gotPVC, err := clientset.CoreV1().PersistentVolumeClaims("default").Get(context.Background(), provisionPVC.Name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get pvc: %v", err)
+		}
+		if got := gotPVC.Annotations[logic.AnnSelectedNode]; got != node.Name {
+			t.Errorf("pvc %s annotation = %q, want %q", logic.AnnSelectedNode, got, node.Name)
+		}
+	})
+
+	t.Run("failure: no volume available on the node", func(t *testing.T) {
+		k := &K8sClient{Clientset: fake.NewSimpleClientset(staticPVC, provisionPVC)}
+		storageInfo := logic.NewStorageInfo([]*v1.PersistentVolumeClaim{staticPVC, provisionPVC}, nil, classes, nil)
+		err := k.BindPodVolumes(pod, node, storageInfo)
+		var fitErr *logic.FitError
+		if !errors.As(err, &fitErr) {
+			t.Errorf("K8sClient.BindPodVolumes() error = %v, want *logic.FitError", err)
+		}
+	})
+
+	t.Run("failure: API server returns error", func(t *testing.T) {
+		clientset := fake.NewSimpleClientset(staticPVC, provisionPVC, pv)
+		clientset.PrependReactor("update", "persistentvolumes", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+			return true, nil, errors.New("simulated API error")
+		})
+		k := &K8sClient{Clientset: clientset}
+		storageInfo := logic.NewStorageInfo([]*v1.PersistentVolumeClaim{staticPVC, provisionPVC}, []*v1.PersistentVolume{pv}, classes, nil)
+		if err := k.BindPodVolumes(pod, node, storageInfo); err == nil {
+			t.Errorf("K8sClient.BindPodVolumes() error = nil, want error")
+		}
+	})
+}
+
 func TestK8sClient_ProcessOneLoop(t *testing.T) {
 	type fields struct {
 		Clientset     kubernetes.Interface
 		ScheduleLogic ScheduleLogic
 	}
 	// --- test cases ---
-	unscheduledPod1 := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod-1", Namespace: "default"}}
-	unscheduledPod2 := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod-2", Namespace: "default"}}
+	unscheduledPod1 := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod-1", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	unscheduledPod2 := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod-2", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	assignedPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "assigned-pod", Namespace: "default"}, Spec: v1.PodSpec{NodeName: "available-node"}}
+	finishedPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "finished-pod", Namespace: "default"}, Spec: v1.PodSpec{NodeName: "available-node"}, Status: v1.PodStatus{Phase: v1.PodSucceeded}}
 	availableNode := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "available-node"}}
+	// 各ケースの Pod より後に作られ、最後にスケジュールされる Pod
+	laterPod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "later-pod", Namespace: "default", CreationTimestamp: metav1.NewTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
 	tests := []struct {
 		name          string
 		fields        fields
 		wantErr       bool
 		podExists     bool // to check if a pod exists before the test
 		expectedError string
+		// test-pod-1 の試行の結果
+		wantResult string
 	}{
 		{
 			name: "Success: schedule a pod to a node with one unscheduled pod",
 			fields: fields{
 				Clientset: fake.NewSimpleClientset(unscheduledPod1),
 				ScheduleLogic: &mockScheduleLogic{
-					funcChooseAvailableNodes: func(p *v1.Pod, nl *v1.NodeList) (*v1.NodeList, error) {
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
 						return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
 					},
-					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList) (v1.Node, error) {
+					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
 						return availableNode, nil
 					},
 				},
 			},
-			wantErr:   false,
-			podExists: true,
+			wantErr:    false,
+			podExists:  true,
+			wantResult: metrics.ResultScheduled,
 		},
 		{
 			name: "Success: schedule a pod to a node with two unscheduled pods",
 			fields: fields{
 				Clientset: fake.NewSimpleClientset(unscheduledPod1, unscheduledPod2),
 				ScheduleLogic: &mockScheduleLogic{
-					funcChooseAvailableNodes: func(p *v1.Pod, nl *v1.NodeList) (*v1.NodeList, error) {
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
 						return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
 					},
-					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList) (v1.Node, error) {
+					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
 						return availableNode, nil
 					},
 				},
 			},
-			wantErr:   false,
-			podExists: true,
+			wantErr:    false,
+			podExists:  true,
+			wantResult: metrics.ResultScheduled,
 		},
 		{
 			name: "Error: no available nodes",
 			fields: fields{
 				Clientset: fake.NewSimpleClientset(unscheduledPod1),
 				ScheduleLogic: &mockScheduleLogic{
-					funcChooseAvailableNodes: func(p *v1.Pod, nl *v1.NodeList) (*v1.NodeList, error) {
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
 						return nil, errors.New("no available nodes")
 					},
 				},
@@ -322,16 +614,52 @@ func TestK8sClient_ProcessOneLoop(t *testing.T) {
 			wantErr:       true,
 			podExists:     true,
 			expectedError: "no available nodes",
+			wantResult:    metrics.ResultError,
+		},
+		{
+			name: "Success: pod fits nowhere",
+			fields: fields{
+				Clientset: fake.NewSimpleClientset(unscheduledPod1),
+				ScheduleLogic: &mockScheduleLogic{
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+						return &v1.NodeList{}, &logic.FitError{Pod: p, NumAllNodes: 1, NodeToReasons: map[string][]string{"node": {"Insufficient cpu"}}}
+					},
+				},
+			},
+			wantErr:    false,
+			podExists:  true,
+			wantResult: metrics.ResultUnschedulable,
+		},
+		{
+			name: "Success: assigned pods are passed to the logic via snapshot",
+			fields: fields{
+				Clientset: fake.NewSimpleClientset(unscheduledPod1, assignedPod, finishedPod, &availableNode),
+				ScheduleLogic: &mockScheduleLogic{
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+						nodeInfo := snapshot.Get(availableNode.Name)
+						if nodeInfo == nil || len(nodeInfo.Pods) != 1 || nodeInfo.Pods[0].Name != assignedPod.Name {
+							return nil, fmt.Errorf("unexpected node info in snapshot: %v", nodeInfo)
+						}
+						return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
+					},
+					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+						return availableNode, nil
+					},
+				},
+			},
+			wantErr:    false,
+			podExists:  true,
+			wantResult: metrics.ResultScheduled,
 		},
 		{
 			name: "Error: choosing suitable node fails",
 			fields: fields{
 				Clientset: fake.NewSimpleClientset(unscheduledPod1),
 				ScheduleLogic: &mockScheduleLogic{
-					funcChooseAvailableNodes: func(p *v1.Pod, nl *v1.NodeList) (*v1.NodeList, error) {
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
 						return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
 					},
-					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList) (v1.Node, error) {
+					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
 						return v1.Node{}, errors.New("suitable node selection failed")
 					},
 				},
@@ -339,22 +667,27 @@ func TestK8sClient_ProcessOneLoop(t *testing.T) {
 			wantErr:       true,
 			podExists:     true,
 			expectedError: "suitable node selection failed",
+			wantResult:    metrics.ResultError,
 		},
 		{
+			// bind は Pod ごとに非同期で行い、失敗した Pod だけを再試行する
 			name: "Error: assigning pod to node fails",
 			fields: fields{
 				Clientset: func() *fake.Clientset {
 					clientset := fake.NewSimpleClientset(unscheduledPod1)
 					clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+						if binding, ok := action.(coretesting.CreateAction).GetObject().(*v1.Binding); !ok || binding.Name != unscheduledPod1.Name {
+							return false, nil, nil
+						}
 						return true, nil, errors.New("assigning pod failed")
 					})
 					return clientset
 				}(),
 				ScheduleLogic: &mockScheduleLogic{
-					funcChooseAvailableNodes: func(p *v1.Pod, nl *v1.NodeList) (*v1.NodeList, error) {
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
 						return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
 					},
-					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList) (v1.Node, error) {
+					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
 						return availableNode, nil
 					},
 				},
@@ -362,22 +695,24 @@ func TestK8sClient_ProcessOneLoop(t *testing.T) {
 			wantErr:       true,
 			podExists:     true,
 			expectedError: "assigning pod failed",
+			wantResult:    metrics.ResultError,
 		},
 		{
 			name: "Success: No suitable node found",
 			fields: fields{
 				Clientset: fake.NewSimpleClientset(unscheduledPod1),
 				ScheduleLogic: &mockScheduleLogic{
-					funcChooseAvailableNodes: func(p *v1.Pod, nl *v1.NodeList) (*v1.NodeList, error) {
+					funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
 						return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
 					},
-					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList) (v1.Node, error) {
+					funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
 						return v1.Node{}, nil
 					},
 				},
 			},
-			wantErr:   false,
-			podExists: true,
+			wantErr:    false,
+			podExists:  true,
+			wantResult: metrics.ResultUnschedulable,
 		},
 		{
 			name: "Success: No unscheduled pods",
@@ -391,13 +726,723 @@ func TestK8sClient_ProcessOneLoop(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			k := &K8sClient{
-				Clientset:     tt.fields.Clientset,
-				ScheduleLogic: tt.fields.ScheduleLogic,
+			clientset := tt.fields.Clientset.(*fake.Clientset)
+			if tt.podExists {
+				if err := clientset.Tracker().Add(laterPod); err != nil {
+					t.Fatalf("failed to add pod: %v", err)
+				}
 			}
-			if err := k.ProcessOneLoop(); (err != nil) != tt.wantErr {
+			// later-pod は、前の Pod の結果に関わらず available-node に配置できる
+			scheduleLogic := &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					if p.Name == laterPod.Name {
+						return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
+					}
+					return tt.fields.ScheduleLogic.ChooseAvailableNodes(p, snapshot)
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					if p.Name == laterPod.Name {
+						return availableNode, nil
+					}
+					return tt.fields.ScheduleLogic.ChooseSuitableNode(p, nl, snapshot)
+				},
+			}
+			k := newStartedClient(t, &K8sClient{
+				Clientset: clientset,
+				Profiles:  map[string]ScheduleLogic{testSchedulerName: scheduleLogic},
+			})
+			result := k.ProcessOneLoop()
+			err := result.Err()
+			if (err != nil) != tt.wantErr {
 				t.Errorf("K8sClient.ProcessOneLoop() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if err != nil && !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("K8sClient.ProcessOneLoop() error = %v, want %q", err, tt.expectedError)
+			}
+			if !tt.podExists {
+				if len(result.Pods) != 0 {
+					t.Errorf("CycleResult.Pods = %v, want empty", result.Pods)
+				}
+				return
+			}
+
+			if got := result.Get(unscheduledPod1.Namespace, unscheduledPod1.Name); got == nil || got.Result != tt.wantResult {
+				t.Errorf("result of %s = %+v, want %s", unscheduledPod1.Name, got, tt.wantResult)
+			} else if got.Result == metrics.ResultUnschedulable && got.Reason == "" {
+				t.Errorf("result of %s has no reason", unscheduledPod1.Name)
+			}
+			// 前の Pod が失敗しても、後ろの Pod はスケジュールされる
+			if got := result.Get(laterPod.Namespace, laterPod.Name); got == nil || got.Result != metrics.ResultScheduled || got.NodeName != availableNode.Name {
+				t.Errorf("result of %s = %+v, want scheduled on %s", laterPod.Name, got, availableNode.Name)
+			}
+			if got := result.Pods[len(result.Pods)-1].Pod.Name; got != laterPod.Name {
+				t.Errorf("last scheduled pod = %s, want %s", got, laterPod.Name)
+			}
+			bound := false
+			for _, action := range clientset.Actions() {
+				if createAction, ok := action.(coretesting.CreateAction); ok && action.GetSubresource() == "binding" {
+					if createAction.GetObject().(*v1.Binding).Name == laterPod.Name {
+						bound = true
+					}
+				}
+			}
+			if !bound {
+				t.Errorf("%s was not bound", laterPod.Name)
+			}
+		})
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_Preemption(t *testing.T) {
+	preemptor := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "high", Namespace: "default"},
+		Spec:       v1.PodSpec{SchedulerName: testSchedulerName, PriorityClassName: "high"},
+	}
+	victim := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "low", Namespace: "default", UID: types.UID("uid-low")},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+	}
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+
+	tests := []struct {
+		name              string
+		preemption        config.Preemption
+		wantNominatedNode string
+		wantVictimDeleted bool
+	}{
+		{
+			name:              "victims are deleted and node is nominated",
+			wantNominatedNode: "node1",
+			wantVictimDeleted: true,
+		},
+		{
+			// dry-run ではログに出すだけで何も変更しない
+			name:       "dry-run",
+			preemption: config.Preemption{DryRun: true},
+		},
+		{
+			name:       "disabled",
+			preemption: config.Preemption{Disabled: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(preemptor, victim, node)
+			k := newStartedClient(t, &K8sClient{
+				Clientset:  clientset,
+				Preemption: tt.preemption,
+				Profiles: map[string]ScheduleLogic{
+					testSchedulerName: &mockScheduleLogic{
+						funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+							return &v1.NodeList{}, &logic.FitError{Pod: p, NumAllNodes: 1, NodeToReasons: map[string][]string{"node1": {"Insufficient cpu"}}}
+						},
+						funcPreempt: func(p *v1.Pod, snapshot *logic.Snapshot, pdbs []*policyv1.PodDisruptionBudget) (*logic.PreemptionResult, error) {
+							return &logic.PreemptionResult{NodeName: "node1", Victims: []*v1.Pod{victim}}, nil
+						},
+					},
+				},
+			})
+			if err := k.ProcessOneLoop().Err(); err != nil {
+				t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+			}
+
+			ctx := context.Background()
+			got, err := clientset.CoreV1().Pods("default").Get(ctx, preemptor.Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get preemptor: %v", err)
+			}
+			if got.Status.NominatedNodeName != tt.wantNominatedNode {
+				t.Errorf("NominatedNodeName = %q, want %q", got.Status.NominatedNodeName, tt.wantNominatedNode)
+			}
+			_, err = clientset.CoreV1().Pods("default").Get(ctx, victim.Name, metav1.GetOptions{})
+			if deleted := apierrors.IsNotFound(err); deleted != tt.wantVictimDeleted {
+				t.Errorf("victim deleted = %v (err: %v), want %v", deleted, err, tt.wantVictimDeleted)
+			}
+		})
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_PodEvents(t *testing.T) {
+	availableNode := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "available-node"}}
+	clientset := fake.NewSimpleClientset()
+	k := newStartedClient(t, &K8sClient{
+		Clientset: clientset,
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					return &v1.NodeList{Items: []v1.Node{availableNode}}, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return availableNode, nil
+				},
+			},
+		},
+	})
+
+	var bound []string
+	clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		createAction := action.(coretesting.CreateAction)
+		if createAction.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		binding := createAction.GetObject().(*v1.Binding)
+		bound = append(bound, binding.Name)
+		return true, binding, nil
+	})
+
+	// informer 起動後に作成された Pod も、イベント経由でキューに積まれる
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "new-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "other-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: "default-scheduler"}},
+	}
+	for _, pod := range pods {
+		if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+			t.Fatalf("failed to create pod: %v", err)
+		}
+	}
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return k.queue.Depths().Active > 0, nil
+	}); err != nil {
+		t.Fatalf("pod was not enqueued: %v", err)
+	}
+
+	if err := k.ProcessOneLoop().Err(); err != nil {
+		t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+	}
+	if len(bound) != 1 || bound[0] != "new-pod" {
+		t.Errorf("bound pods = %v, want [new-pod]", bound)
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_RetryOnClusterEvent(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	clientset := fake.NewSimpleClientset(pod)
+	var bound []string
+	clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		createAction := action.(coretesting.CreateAction)
+		if createAction.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		binding := createAction.GetObject().(*v1.Binding)
+		bound = append(bound, binding.Target.Name)
+		return true, binding, nil
+	})
+	k := newStartedClient(t, &K8sClient{
+		Clientset:         clientset,
+		PodInitialBackoff: time.Millisecond,
+		PodMaxBackoff:     time.Millisecond,
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					nl := &v1.NodeList{}
+					for _, nodeInfo := range snapshot.NodeInfos() {
+						nl.Items = append(nl.Items, *nodeInfo.Node)
+					}
+					if len(nl.Items) == 0 {
+						return nl, &logic.FitError{Pod: p, NumAllNodes: 0, NodeToReasons: map[string][]string{}}
+					}
+					return nl, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return nl.Items[0], nil
+				},
+			},
+		},
+	})
+
+	// ノードが無いので配置できず、unschedulablePods で待つ
+	if err := k.ProcessOneLoop().Err(); err != nil {
+		t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+	}
+	if got := k.QueueDepths(); got != (queue.Depths{Unschedulable: 1}) {
+		t.Fatalf("QueueDepths() = %+v, want 1 unschedulable pod", got)
+	}
+	if err := k.ProcessOneLoop().Err(); err != nil {
+		t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+	}
+	if len(bound) != 0 {
+		t.Fatalf("bound pods = %v before any cluster event", bound)
+	}
+
+	// ノードが追加されると再試行される
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "new-node"}}
+	if _, err := clientset.CoreV1().Nodes().Create(context.Background(), node, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create node: %v", err)
+	}
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return k.QueueDepths().Active > 0, nil
+	}); err != nil {
+		t.Fatalf("pod was not moved to activeQ: %v (depths: %+v)", err, k.QueueDepths())
+	}
+	if err := k.ProcessOneLoop().Err(); err != nil {
+		t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+	}
+	if len(bound) != 1 || bound[0] != "new-node" {
+		t.Errorf("bound nodes = %v, want [new-node]", bound)
+	}
+}
+
+func TestK8sClient_Run(t *testing.T) {
+	availableNode := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "available-node"}}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	clientset := fake.NewSimpleClientset(availableNode, pod)
+
+	boundCh := make(chan string, 1)
+	clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		createAction := action.(coretesting.CreateAction)
+		if createAction.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		binding := createAction.GetObject().(*v1.Binding)
+		boundCh <- binding.Target.Name
+		return true, binding, nil
+	})
+
+	k := &K8sClient{
+		Clientset: clientset,
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					nl := &v1.NodeList{}
+					for _, nodeInfo := range snapshot.NodeInfos() {
+						nl.Items = append(nl.Items, *nodeInfo.Node)
+					}
+					return nl, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return nl.Items[0], nil
+				},
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- k.Run(ctx) }()
+
+	select {
+	case node := <-boundCh:
+		if node != availableNode.Name {
+			t.Errorf("pod bound to %s, want %s", node, availableNode.Name)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("pod was not bound")
+	}
+
+	// ctx をキャンセルすると Run は終了する
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("K8sClient.Run() error = %v", err)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("K8sClient.Run() did not return after cancel")
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_Profiles(t *testing.T) {
+	batchNode := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "batch-node"}}
+	webNode := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "web-node"}}
+	profileFor := func(node v1.Node) ScheduleLogic {
+		return &mockScheduleLogic{
+			funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+				return &v1.NodeList{Items: []v1.Node{node}}, nil
+			},
+			funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+				return nl.Items[0], nil
+			},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "batch-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: "batch-scheduler"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: "web-scheduler"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: "default-scheduler"}},
+	)
+	bound := map[string]string{}
+	clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		createAction := action.(coretesting.CreateAction)
+		if createAction.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		binding := createAction.GetObject().(*v1.Binding)
+		bound[binding.Name] = binding.Target.Name
+		return true, binding, nil
+	})
+
+	k := newStartedClient(t, &K8sClient{
+		Clientset: clientset,
+		Profiles: map[string]ScheduleLogic{
+			"batch-scheduler": profileFor(batchNode),
+			"web-scheduler":   profileFor(webNode),
+		},
+	})
+	if err := k.ProcessOneLoop().Err(); err != nil {
+		t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+	}
+
+	// spec.schedulerName に対応する Profile でスケジュールされる
+	want := map[string]string{"batch-pod": "batch-node", "web-pod": "web-node"}
+	if !reflect.DeepEqual(bound, want) {
+		t.Errorf("bound pods = %v, want %v", bound, want)
+	}
+
+	// Profile が複数ある場合は schedulerName を FieldSelector に含められない
+	if _, ok := k.unscheduledPodSelector().RequiresExactMatch("spec.schedulerName"); ok {
+		t.Errorf("field selector %q should not restrict spec.schedulerName", k.unscheduledPodSelector())
+	}
+}
+
+func TestNewProfiles(t *testing.T) {
+	tests := []struct {
+		name         string
+		cfg          *config.Config
+		wantProfiles []string
+		wantErr      bool
+	}{
+		{
+			name:         "success: default config",
+			cfg:          config.Default("my-custom-scheduler"),
+			wantProfiles: []string{"my-custom-scheduler"},
+		},
+		{
+			name: "success: multiple profiles",
+			cfg: &config.Config{Profiles: []config.Profile{
+				{SchedulerName: "batch-scheduler"},
+				{
+					SchedulerName: "web-scheduler",
+					Plugins: &config.Plugins{
+						Filter: []config.Plugin{{Name: logic.TierIsolationName}},
+						Score:  []config.Plugin{{Name: logic.RandomName, Weight: 2}},
+					},
+					PluginConfig: []config.PluginConfig{
+						{Name: logic.TierIsolationName, Args: json.RawMessage(`{"labelKey":"pool","excludedValues":["system"]}`)},
+					},
+				},
+			}},
+			wantProfiles: []string{"batch-scheduler", "web-scheduler"},
+		},
+		{
+			name: "failure: unknown plugin",
+			cfg: &config.Config{Profiles: []config.Profile{{
+				SchedulerName: "my-custom-scheduler",
+				Plugins:       &config.Plugins{Filter: []config.Plugin{{Name: "Unknown"}}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "failure: invalid plugin args",
+			cfg: &config.Config{Profiles: []config.Profile{{
+				SchedulerName: "my-custom-scheduler",
+				PluginConfig: []config.PluginConfig{
+					{Name: logic.TierIsolationName, Args: json.RawMessage(`{"unknownField":true}`)},
+				},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewProfiles(tt.cfg, logic.NewInTreeRegistry(), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewProfiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			gotNames := []string{}
+			for name := range got {
+				gotNames = append(gotNames, name)
+			}
+			sort.Strings(gotNames)
+			if !reflect.DeepEqual(gotNames, tt.wantProfiles) {
+				t.Errorf("NewProfiles() = %v, want %v", gotNames, tt.wantProfiles)
+			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestK8sClient_ProcessOneLoop_Metrics(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	schedulablePod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "schedulable", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	unschedulablePod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unschedulable", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	m := metrics.New()
+	k := newStartedClient(t, &K8sClient{
+		Clientset: fake.NewSimpleClientset(node, schedulablePod, unschedulablePod),
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					if p.Name == unschedulablePod.Name {
+						return &v1.NodeList{}, &logic.FitError{Pod: p, NumAllNodes: 1, NodeToReasons: map[string][]string{"node1": {"Insufficient cpu"}}}
+					}
+					return &v1.NodeList{Items: []v1.Node{*node}}, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return *node, nil
+				},
+			},
+		},
+		Metrics: m,
+	})
+	if err := k.ProcessOneLoop().Err(); err != nil {
+		t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	m.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	got := recorder.Body.String()
+	for _, want := range []string{
+		`scheduler_schedule_attempts_total{profile="my-custom-scheduler",result="scheduled"} 1`,
+		`scheduler_schedule_attempts_total{profile="my-custom-scheduler",result="unschedulable"} 1`,
+		`scheduler_pod_scheduling_duration_seconds_count{profile="my-custom-scheduler"} 1`,
+		`scheduler_binding_duration_seconds_count{result="success"} 1`,
+		// 配置できなかった Pod はイベントを待つ
+		`scheduler_pending_pods{queue="unschedulable"} 1`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_PodRemovedDuringLoop(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	pods := []*v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}},
+	}
+	var k *K8sClient
+	k = newStartedClient(t, &K8sClient{
+		Clientset: fake.NewSimpleClientset(node, pods[0], pods[1]),
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					// 一巡の Pod を数えた後で、もう一方の Pod が削除されてキューから抜ける
+					for _, pod := range pods {
+						if pod.Name != p.Name {
+							k.queue.Delete(pod)
+						}
+					}
+					return &v1.NodeList{Items: []v1.Node{*node}}, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return *node, nil
+				},
+			},
+		},
+	})
+
+	done := make(chan CycleResult, 1)
+	go func() { done <- k.ProcessOneLoop() }()
+	select {
+	case result := <-done:
+		if err := result.Err(); err != nil {
+			t.Errorf("K8sClient.ProcessOneLoop() error = %v", err)
+		}
+		if len(result.Pods) != 1 || result.Pods[0].Result != metrics.ResultScheduled {
+			t.Errorf("K8sClient.ProcessOneLoop() = %+v, want 1 scheduled pod", result.Pods)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("K8sClient.ProcessOneLoop() did not return")
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_AssumedPods(t *testing.T) {
+	// 2 つ目の Pod が入る空きは無いノード
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1")}},
+	}
+	newPod := func(name string, created time.Time) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+			Spec: v1.PodSpec{
+				SchedulerName: testSchedulerName,
+				Containers: []v1.Container{{
+					Name:      "c",
+					Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("600m")}},
+				}},
+			},
+		}
+	}
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// 最初の Pod の bind を失敗させるか
+		firstBindFails bool
+		// bind に成功した Pod
+		want []string
+		// 最初の Pod の試行の結果
+		wantFirstResult string
+	}{
+		{
+			// bind が informer に反映される前でも、最初の Pod の分の空きは使えない
+			name:            "assumed pod occupies the node",
+			want:            []string{"first"},
+			wantFirstResult: metrics.ResultScheduled,
+		},
+		{
+			name:            "failed bind is forgotten",
+			firstBindFails:  true,
+			wantFirstResult: metrics.ResultError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clientset := fake.NewSimpleClientset(node, newPod("first", created), newPod("second", created.Add(time.Second)))
+			var bound []string
+			clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+				createAction := action.(coretesting.CreateAction)
+				if createAction.GetSubresource() != "binding" {
+					return false, nil, nil
+				}
+				binding := createAction.GetObject().(*v1.Binding)
+				if tt.firstBindFails && binding.Name == "first" {
+					return true, nil, errors.New("bind failed")
+				}
+				// Pod の nodeName は更新しないので、informer には反映されない
+				bound = append(bound, binding.Name)
+				return true, binding, nil
+			})
+			scheduleLogic, err := logic.NewScheduleLogic(logic.NewInTreeRegistry(), logic.PluginSet{Filter: []string{logic.NodeResourcesFitName}})
+			if err != nil {
+				t.Fatalf("NewScheduleLogic() error = %v", err)
+			}
+			k := newStartedClient(t, &K8sClient{
+				Clientset:         clientset,
+				Profiles:          map[string]ScheduleLogic{testSchedulerName: scheduleLogic},
+				PodInitialBackoff: time.Millisecond,
+				PodMaxBackoff:     time.Millisecond,
+			})
+
+			result := k.ProcessOneLoop()
+			if got := result.Get("default", "first"); got == nil || got.Result != tt.wantFirstResult {
+				t.Fatalf("result of first = %+v, want %s", got, tt.wantFirstResult)
+			}
+			// 2 つ目の Pod は、最初の Pod の bind の成否に関わらず仮置きの分の空きが無い
+			if got := result.Get("default", "second"); got == nil || got.Result != metrics.ResultUnschedulable {
+				t.Errorf("result of second = %+v, want %s", got, metrics.ResultUnschedulable)
+			}
+			if tt.firstBindFails {
+				// bind に失敗した Pod の仮置きは取り消される
+				if nodeInfo := k.schedulerCache.NodeInfos()[0]; len(nodeInfo.Pods) != 0 {
+					t.Errorf("pods on node1 = %d, want 0 after the failed bind", len(nodeInfo.Pods))
+				}
+				// bind に失敗した Pod と、bind が終わる前に配置できなかった次の Pod が再試行される
+				if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+					return k.queue.Depths().Active == 2, nil
+				}); err != nil {
+					t.Fatalf("pods were not retried: %v (queue: %+v)", err, k.queue.Depths())
+				}
+			}
+			if !reflect.DeepEqual(bound, tt.want) {
+				t.Errorf("bound pods = %v, want %v", bound, tt.want)
+			}
+		})
+	}
+}
+
+// Bind の前に beforeBind を呼ぶ clientset
+// fake clientset の reactor はロックを取って 1 つずつ呼ばれるので、並行に実行される bind はその外側で捕まえる
+type beforeBindClientset struct {
+	*fake.Clientset
+	beforeBind func()
+}
+
+func (c *beforeBindClientset) CoreV1() typedcorev1.CoreV1Interface {
+	return &beforeBindCoreV1{CoreV1Interface: c.Clientset.CoreV1(), beforeBind: c.beforeBind}
+}
+
+type beforeBindCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	beforeBind func()
+}
+
+func (c *beforeBindCoreV1) Pods(namespace string) typedcorev1.PodInterface {
+	return &beforeBindPods{PodInterface: c.CoreV1Interface.Pods(namespace), beforeBind: c.beforeBind}
+}
+
+type beforeBindPods struct {
+	typedcorev1.PodInterface
+	beforeBind func()
+}
+
+func (p *beforeBindPods) Bind(ctx context.Context, binding *v1.Binding, opts metav1.CreateOptions) error {
+	p.beforeBind()
+	return p.PodInterface.Bind(ctx, binding, opts)
+}
+
+func TestK8sClient_ProcessOneLoop_BindParallelism(t *testing.T) {
+	const bindParallelism = 2
+	node := v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	var objects []runtime.Object
+	for i := range 5 {
+		objects = append(objects, &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("pod%d", i), Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}})
+	}
+
+	var lock sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	release := make(chan struct{})
+	clientset := &beforeBindClientset{
+		Clientset: fake.NewSimpleClientset(objects...),
+		beforeBind: func() {
+			lock.Lock()
+			inFlight++
+			maxInFlight = max(maxInFlight, inFlight)
+			lock.Unlock()
+			<-release
+			lock.Lock()
+			inFlight--
+			lock.Unlock()
+		},
+	}
+	k := newStartedClient(t, &K8sClient{
+		Clientset: clientset,
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					return &v1.NodeList{Items: []v1.Node{node}}, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return node, nil
+				},
+			},
+		},
+		BindParallelism: bindParallelism,
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- k.ProcessOneLoop().Err() }()
+
+	// bind が上限まで並行に実行されるのを待ってから、すべての bind を終わらせる
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return inFlight == bindParallelism, nil
+	}); err != nil {
+		t.Fatalf("bindings did not run concurrently: %v", err)
+	}
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("K8sClient.ProcessOneLoop() error = %v", err)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("K8sClient.ProcessOneLoop() did not return")
+	}
+
+	if maxInFlight != bindParallelism {
+		t.Errorf("max concurrent bindings = %d, want %d", maxInFlight, bindParallelism)
+	}
+	bindings := 0
+	for _, action := range clientset.Actions() {
+		if action.Matches("create", "pods") && action.GetSubresource() == "binding" {
+			bindings++
+		}
+	}
+	if bindings != len(objects) {
+		t.Errorf("bindings = %d, want %d", bindings, len(objects))
+	}
+}
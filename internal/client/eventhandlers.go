@@ -0,0 +1,218 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// クラスタのイベント名。unschedulablePods を再試行するときにログに出す
+const (
+	eventNodeAdd           = "NodeAdd"
+	eventNodeUpdate        = "NodeUpdate"
+	eventAssignedPodAdd    = "AssignedPodAdd"
+	eventAssignedPodUpdate = "AssignedPodUpdate"
+	eventAssignedPodDelete = "AssignedPodDelete"
+	eventStorageChange     = "StorageChange"
+	// bind に失敗して仮置きを取り消した。仮置きの分の空きが使えるようになる
+	eventAssumedPodForget = "AssumedPodForget"
+)
+
+// addPodEventHandlers は担当する未スケジュールの Pod のイベントをキューに反映する
+// Pod がノードに配置される、削除中になるなどで担当外になった場合は Delete として届く
+func (k *K8sClient) addPodEventHandlers(podInformer cache.SharedIndexInformer) (cache.ResourceEventHandlerRegistration, error) {
+	return podInformer.AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: func(obj interface{}) bool {
+			pod, ok := obj.(*v1.Pod)
+			return ok && k.isPodToSchedule(pod)
+		},
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				k.queue.Add(obj.(*v1.Pod))
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				k.queue.Update(oldObj.(*v1.Pod), newObj.(*v1.Pod))
+			},
+			DeleteFunc: func(obj interface{}) {
+				if pod := podFromDeleteEvent(obj); pod != nil {
+					k.queue.Delete(pod)
+					k.removeWaitingPod(pod)
+				}
+			},
+		},
+	})
+}
+
+// addCacheEventHandlers はノードと配置済みの Pod のイベントをスケジューラのキャッシュに反映する
+func (k *K8sClient) addCacheEventHandlers(nodeInformerFactory, assignedPodInformerFactory informers.SharedInformerFactory) error {
+	nodeHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			k.schedulerCache.AddNode(obj.(*v1.Node))
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			k.schedulerCache.UpdateNode(oldObj.(*v1.Node), newObj.(*v1.Node))
+		},
+		DeleteFunc: func(obj interface{}) {
+			if node := nodeFromDeleteEvent(obj); node != nil {
+				k.schedulerCache.RemoveNode(node)
+			}
+		},
+	}
+	if _, err := nodeInformerFactory.Core().V1().Nodes().Informer().AddEventHandler(nodeHandler); err != nil {
+		return fmt.Errorf("error adding node cache event handler: %w", err)
+	}
+
+	// 終了した Pod はリソースを消費しないので取り除く
+	// FieldSelector を解釈しない API (fake clientset など) もあるので、手元でも同じ条件で絞り込む
+	assignedPodHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod := obj.(*v1.Pod); isAssignedAndRunning(pod) {
+				k.schedulerCache.AddPod(pod)
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, newPod := oldObj.(*v1.Pod), newObj.(*v1.Pod)
+			switch {
+			case isAssignedAndRunning(oldPod) && isAssignedAndRunning(newPod):
+				k.schedulerCache.UpdatePod(oldPod, newPod)
+			case isAssignedAndRunning(newPod):
+				k.schedulerCache.AddPod(newPod)
+			case isAssignedAndRunning(oldPod):
+				k.schedulerCache.RemovePod(oldPod)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if pod := podFromDeleteEvent(obj); pod != nil && pod.Spec.NodeName != "" {
+				k.schedulerCache.RemovePod(pod)
+			}
+		},
+	}
+	if _, err := assignedPodInformerFactory.Core().V1().Pods().Informer().AddEventHandler(assignedPodHandler); err != nil {
+		return fmt.Errorf("error adding assigned pod cache event handler: %w", err)
+	}
+	return nil
+}
+
+// addClusterEventHandlers は、配置できなかった Pod が配置できるようになり得るイベントで
+// unschedulablePods の Pod を再試行させる
+func (k *K8sClient) addClusterEventHandlers(nodeInformerFactory, assignedPodInformerFactory informers.SharedInformerFactory) error {
+	moveAll := func(event string) func(interface{}) {
+		return func(interface{}) { k.queue.MoveAllToActiveOrBackoffQueue(event, nil) }
+	}
+
+	// ノードが増えた、またはスケジュールに関わる部分が変わった
+	nodeHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: moveAll(eventNodeAdd),
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldNode, newNode := oldObj.(*v1.Node), newObj.(*v1.Node)
+			if nodeSchedulingPropertiesChanged(oldNode, newNode) {
+				k.queue.MoveAllToActiveOrBackoffQueue(eventNodeUpdate, nil)
+			}
+		},
+	}
+	if _, err := nodeInformerFactory.Core().V1().Nodes().Informer().AddEventHandler(nodeHandler); err != nil {
+		return fmt.Errorf("error adding node event handler: %w", err)
+	}
+
+	// Pod が減ればリソースやポートが空く。Pod が増えたときは pod affinity を満たせるようになり得る
+	assignedPodHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			k.queue.MoveAllToActiveOrBackoffQueue(eventAssignedPodAdd, hasPodAffinity)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldPod, newPod := oldObj.(*v1.Pod), newObj.(*v1.Pod)
+			if !reflect.DeepEqual(oldPod.Labels, newPod.Labels) || isTerminated(newPod) {
+				k.queue.MoveAllToActiveOrBackoffQueue(eventAssignedPodUpdate, nil)
+			}
+		},
+		DeleteFunc: moveAll(eventAssignedPodDelete),
+	}
+	if _, err := assignedPodInformerFactory.Core().V1().Pods().Informer().AddEventHandler(assignedPodHandler); err != nil {
+		return fmt.Errorf("error adding assigned pod event handler: %w", err)
+	}
+
+	// PV のプロビジョニングや束縛が進めば、ボリュームを用意できるようになり得る
+	storageHandler := cache.ResourceEventHandlerFuncs{
+		AddFunc:    moveAll(eventStorageChange),
+		UpdateFunc: func(_, newObj interface{}) { k.queue.MoveAllToActiveOrBackoffQueue(eventStorageChange, nil) },
+	}
+	for _, informer := range []cache.SharedIndexInformer{
+		nodeInformerFactory.Core().V1().PersistentVolumeClaims().Informer(),
+		nodeInformerFactory.Core().V1().PersistentVolumes().Informer(),
+		nodeInformerFactory.Storage().V1().StorageClasses().Informer(),
+		nodeInformerFactory.Storage().V1().CSINodes().Informer(),
+	} {
+		if _, err := informer.AddEventHandler(storageHandler); err != nil {
+			return fmt.Errorf("error adding storage event handler: %w", err)
+		}
+	}
+	return nil
+}
+
+// ノードのうち、Filter や Score の結果に関わる部分が変わったかを返す
+// 定期的に更新される heartbeat の時刻などの変化では再試行しない
+func nodeSchedulingPropertiesChanged(oldNode, newNode *v1.Node) bool {
+	if oldNode.Spec.Unschedulable != newNode.Spec.Unschedulable {
+		return true
+	}
+	if !reflect.DeepEqual(oldNode.Status.Allocatable, newNode.Status.Allocatable) {
+		return true
+	}
+	if !reflect.DeepEqual(oldNode.Labels, newNode.Labels) {
+		return true
+	}
+	if !reflect.DeepEqual(oldNode.Spec.Taints, newNode.Spec.Taints) {
+		return true
+	}
+	return !reflect.DeepEqual(nodeConditionStatuses(oldNode), nodeConditionStatuses(newNode))
+}
+
+func nodeConditionStatuses(node *v1.Node) map[v1.NodeConditionType]v1.ConditionStatus {
+	statuses := make(map[v1.NodeConditionType]v1.ConditionStatus, len(node.Status.Conditions))
+	for _, cond := range node.Status.Conditions {
+		statuses[cond.Type] = cond.Status
+	}
+	return statuses
+}
+
+func hasPodAffinity(pod *v1.Pod) bool {
+	return pod.Spec.Affinity != nil && pod.Spec.Affinity.PodAffinity != nil
+}
+
+func isTerminated(pod *v1.Pod) bool {
+	return pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed
+}
+
+// ノードに配置済みで、終了していない Pod か
+func isAssignedAndRunning(pod *v1.Pod) bool {
+	return pod.Spec.NodeName != "" && !isTerminated(pod)
+}
+
+// Delete イベントの obj は、watch が切れていた間に消えた場合 DeletedFinalStateUnknown になる
+// nodeFromDeleteEvent と podFromDeleteEvent は、どちらの場合もノードや Pod を取り出して返す
+func nodeFromDeleteEvent(obj interface{}) *v1.Node {
+	switch t := obj.(type) {
+	case *v1.Node:
+		return t
+	case cache.DeletedFinalStateUnknown:
+		if node, ok := t.Obj.(*v1.Node); ok {
+			return node
+		}
+	}
+	return nil
+}
+
+func podFromDeleteEvent(obj interface{}) *v1.Pod {
+	switch t := obj.(type) {
+	case *v1.Pod:
+		return t
+	case cache.DeletedFinalStateUnknown:
+		if pod, ok := t.Obj.(*v1.Pod); ok {
+			return pod
+		}
+	}
+	return nil
+}
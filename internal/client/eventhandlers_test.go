@@ -0,0 +1,70 @@
+package client
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeSchedulingPropertiesChanged(t *testing.T) {
+	base := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"zone": "a"}, ResourceVersion: "1"},
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")},
+			Conditions:  []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+	tests := []struct {
+		name   string
+		update func(node *v1.Node)
+		want   bool
+	}{
+		{
+			// kubelet が定期的に更新する heartbeat では再試行しない
+			name: "heartbeat only",
+			update: func(node *v1.Node) {
+				node.ResourceVersion = "2"
+				node.Status.Conditions[0].LastHeartbeatTime = metav1.Now()
+			},
+			want: false,
+		},
+		{
+			name:   "became not ready",
+			update: func(node *v1.Node) { node.Status.Conditions[0].Status = v1.ConditionFalse },
+			want:   true,
+		},
+		{
+			name:   "cordoned",
+			update: func(node *v1.Node) { node.Spec.Unschedulable = true },
+			want:   true,
+		},
+		{
+			name:   "allocatable changed",
+			update: func(node *v1.Node) { node.Status.Allocatable[v1.ResourceCPU] = resource.MustParse("8") },
+			want:   true,
+		},
+		{
+			name:   "labels changed",
+			update: func(node *v1.Node) { node.Labels["zone"] = "b" },
+			want:   true,
+		},
+		{
+			name: "taints changed",
+			update: func(node *v1.Node) {
+				node.Spec.Taints = []v1.Taint{{Key: "dedicated", Value: "batch", Effect: v1.TaintEffectNoSchedule}}
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := base.DeepCopy()
+			tt.update(updated)
+			if got := nodeSchedulingPropertiesChanged(base, updated); got != tt.want {
+				t.Errorf("nodeSchedulingPropertiesChanged() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
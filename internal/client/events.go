@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// kube-scheduler と同じイベントの reason
+const (
+	eventReasonScheduled        = "Scheduled"
+	eventReasonFailedScheduling = "FailedScheduling"
+	eventReasonPreempted        = "Preempted"
+)
+
+// startEventRecorders は Profile ごとに、schedulerName を送信元とするイベントの recorder を作る
+// イベントは ctx がキャンセルされるまで API サーバに送られる
+func (k *K8sClient) startEventRecorders(ctx context.Context) {
+	broadcaster := record.NewBroadcaster(record.WithContext(ctx))
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: k.Clientset.CoreV1().Events("")})
+	k.recorders = make(map[string]record.EventRecorder, len(k.Profiles))
+	for schedulerName := range k.Profiles {
+		k.recorders[schedulerName] = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: schedulerName})
+	}
+}
+
+// pod を担当する Profile の recorder を返す
+func (k *K8sClient) recorder(pod *v1.Pod) record.EventRecorder {
+	if r, ok := k.recorders[pod.Spec.SchedulerName]; ok {
+		return r
+	}
+	return &record.FakeRecorder{}
+}
+
+// recordScheduled は Pod をノードに配置したことをイベントで伝える
+func (k *K8sClient) recordScheduled(pod *v1.Pod, nodeName string) {
+	k.recorder(pod).Eventf(pod, v1.EventTypeNormal, eventReasonScheduled, "Successfully assigned %s/%s to %s", pod.Namespace, pod.Name, nodeName)
+}
+
+// recordSchedulingFailure は Pod を配置できなかったことを FailedScheduling イベントと
+// PodScheduled condition で伝える。nominatedNodeName が空でなければ Pod の nominatedNodeName も設定する
+//   - reason が Unschedulable: どのノードにも配置できなかった。message は配置できなかった理由の要約
+//   - reason が SchedulerError: API のエラーなど、スケジューラの内部エラー
+//
+// condition の更新に失敗してもスケジュールは続けられるので、ログに出すだけにする
+func (k *K8sClient) recordSchedulingFailure(pod *v1.Pod, reason, message, nominatedNodeName string) {
+	k.recorder(pod).Event(pod, v1.EventTypeWarning, eventReasonFailedScheduling, message)
+
+	condition := v1.PodCondition{
+		Type:    v1.PodScheduled,
+		Status:  v1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	}
+	if err := k.patchPodStatus(pod, condition, nominatedNodeName); err != nil {
+		slog.Error("failed to update pod scheduling status", "pod", pod.Name, "namespace", pod.Namespace, "error", err)
+	}
+}
+
+// patchPodStatus は Pod の condition と、nominatedNodeName が空でなければ nominatedNodeName を更新する
+// 変わっていなければ API を呼ばない
+func (k *K8sClient) patchPodStatus(pod *v1.Pod, condition v1.PodCondition, nominatedNodeName string) error {
+	newStatus := pod.Status.DeepCopy()
+	if nominatedNodeName != "" {
+		newStatus.NominatedNodeName = nominatedNodeName
+	}
+	if !setPodCondition(newStatus, condition) && newStatus.NominatedNodeName == pod.Status.NominatedNodeName {
+		return nil
+	}
+
+	// informer のキャッシュが古くても衝突しないよう、resourceVersion を含まない差分を patch する
+	oldData, err := json.Marshal(v1.Pod{Status: pod.Status})
+	if err != nil {
+		return err
+	}
+	newData, err := json.Marshal(v1.Pod{Status: *newStatus})
+	if err != nil {
+		return err
+	}
+	patch, err := strategicpatch.CreateTwoWayMergePatch(oldData, newData, &v1.Pod{})
+	if err != nil {
+		return fmt.Errorf("failed to create status patch of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	if _, err := k.Clientset.CoreV1().Pods(pod.Namespace).Patch(context.TODO(), pod.Name, types.StrategicMergePatchType, patch, metav1.PatchOptions{}, "status"); err != nil {
+		k.Metrics.IncAPIError("patch_pod_status")
+		return fmt.Errorf("failed to patch status of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+	return nil
+}
+
+// setPodCondition は status の同じ種類の condition を condition で置き換える
+// 状態が変わったときだけ lastTransitionTime を更新し、何か変わったかを返す
+func setPodCondition(status *v1.PodStatus, condition v1.PodCondition) bool {
+	condition.LastProbeTime = metav1.Time{}
+	for i := range status.Conditions {
+		existing := &status.Conditions[i]
+		if existing.Type != condition.Type {
+			continue
+		}
+		if existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+			return false
+		}
+		condition.LastTransitionTime = existing.LastTransitionTime
+		if existing.Status != condition.Status {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		*existing = condition
+		return true
+	}
+	condition.LastTransitionTime = metav1.Now()
+	status.Conditions = append(status.Conditions, condition)
+	return true
+}
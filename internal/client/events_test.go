@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"kube-scheduler-practice/internal/logic"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSetPodCondition(t *testing.T) {
+	lastTransition := metav1.NewTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	unschedulable := v1.PodCondition{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable, Message: "0/1 nodes are available: 1 Insufficient cpu."}
+
+	tests := []struct {
+		name       string
+		conditions []v1.PodCondition
+		condition  v1.PodCondition
+		want       bool
+		// lastTransitionTime を引き継ぐか
+		wantKeepTransition bool
+	}{
+		{
+			name:      "new condition",
+			condition: unschedulable,
+			want:      true,
+		},
+		{
+			name: "same condition",
+			conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable, Message: unschedulable.Message, LastTransitionTime: lastTransition},
+			},
+			condition:          unschedulable,
+			want:               false,
+			wantKeepTransition: true,
+		},
+		{
+			// 理由が変わっただけなら lastTransitionTime は変えない
+			name: "message changed",
+			conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable, Message: "0/1 nodes are available: 1 Insufficient memory.", LastTransitionTime: lastTransition},
+			},
+			condition:          unschedulable,
+			want:               true,
+			wantKeepTransition: true,
+		},
+		{
+			name: "status changed",
+			conditions: []v1.PodCondition{
+				{Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: lastTransition},
+			},
+			condition: unschedulable,
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &v1.PodStatus{Conditions: append([]v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionFalse}}, tt.conditions...)}
+			if got := setPodCondition(status, tt.condition); got != tt.want {
+				t.Errorf("setPodCondition() = %v, want %v", got, tt.want)
+			}
+			if len(status.Conditions) != 2 {
+				t.Fatalf("conditions = %+v, want PodReady and PodScheduled", status.Conditions)
+			}
+			got := status.Conditions[1]
+			if got.Status != tt.condition.Status || got.Reason != tt.condition.Reason || got.Message != tt.condition.Message {
+				t.Errorf("PodScheduled condition = %+v, want %+v", got, tt.condition)
+			}
+			if keep := got.LastTransitionTime.Equal(&lastTransition); keep != tt.wantKeepTransition {
+				t.Errorf("lastTransitionTime = %v, want kept = %v", got.LastTransitionTime, tt.wantKeepTransition)
+			}
+		})
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_Events(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	schedulablePod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "schedulable", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	unschedulablePod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unschedulable", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	fitErr := &logic.FitError{
+		Pod:         unschedulablePod,
+		NumAllNodes: 4,
+		NodeToReasons: map[string][]string{
+			"control": {"node(s) had tier=control"},
+			"node1":   {"Insufficient cpu"},
+			"node2":   {"Insufficient cpu"},
+			"node3":   {"Insufficient cpu"},
+		},
+	}
+
+	clientset := fake.NewSimpleClientset(node, schedulablePod, unschedulablePod)
+	k := newStartedClient(t, &K8sClient{
+		Clientset: clientset,
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					if p.Name == unschedulablePod.Name {
+						return &v1.NodeList{}, fitErr
+					}
+					return &v1.NodeList{Items: []v1.Node{*node}}, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return *node, nil
+				},
+			},
+		},
+	})
+	if err := k.ProcessOneLoop().Err(); err != nil {
+		t.Fatalf("K8sClient.ProcessOneLoop() error = %v", err)
+	}
+
+	// 配置できなかった Pod は PodScheduled=False になる
+	ctx := context.Background()
+	got, err := clientset.CoreV1().Pods("default").Get(ctx, unschedulablePod.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	wantMessage := "0/4 nodes are available: 1 node(s) had tier=control, 3 Insufficient cpu."
+	var condition *v1.PodCondition
+	for i := range got.Status.Conditions {
+		if got.Status.Conditions[i].Type == v1.PodScheduled {
+			condition = &got.Status.Conditions[i]
+		}
+	}
+	if condition == nil || condition.Status != v1.ConditionFalse || condition.Reason != v1.PodReasonUnschedulable || condition.Message != wantMessage {
+		t.Errorf("PodScheduled condition = %+v, want False/%s with message %q", condition, v1.PodReasonUnschedulable, wantMessage)
+	}
+
+	// イベントは非同期に送られる
+	want := map[string]struct {
+		eventType string
+		reason    string
+		message   string
+	}{
+		unschedulablePod.Name: {v1.EventTypeWarning, eventReasonFailedScheduling, wantMessage},
+		schedulablePod.Name:   {v1.EventTypeNormal, eventReasonScheduled, "Successfully assigned default/schedulable to node1"},
+	}
+	var events []v1.Event
+	if err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+		list, err := clientset.CoreV1().Events("default").List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, err
+		}
+		events = list.Items
+		return len(events) >= len(want), nil
+	}); err != nil {
+		t.Fatalf("events were not recorded: %v (events: %+v)", err, events)
+	}
+	for _, event := range events {
+		w, ok := want[event.InvolvedObject.Name]
+		if !ok {
+			t.Errorf("unexpected event for %s: %+v", event.InvolvedObject.Name, event)
+			continue
+		}
+		if event.Type != w.eventType || event.Reason != w.reason || event.Message != w.message {
+			t.Errorf("event for %s = %s/%s %q, want %s/%s %q", event.InvolvedObject.Name, event.Type, event.Reason, event.Message, w.eventType, w.reason, w.message)
+		}
+		// イベントは Profile の schedulerName から送られる
+		if event.Source.Component != testSchedulerName {
+			t.Errorf("event source = %q, want %q", event.Source.Component, testSchedulerName)
+		}
+	}
+}
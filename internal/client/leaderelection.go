@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionConfig は Lease を使ったリーダー選出の設定
+// 同じ Lease を使うレプリカのうち、リーダーに選ばれた 1 つだけがスケジュールする
+type LeaderElectionConfig struct {
+	// リーダー選出に使う Lease の namespace と名前
+	Namespace string
+	Name      string
+	// リーダー以外のレプリカが、更新の止まった Lease を奪うまでに待つ時間
+	LeaseDuration time.Duration
+	// リーダーが Lease の更新をこの時間続けて失敗したら、スケジュールをやめる
+	RenewDeadline time.Duration
+	// Lease の取得や更新を試みる間隔
+	RetryPeriod time.Duration
+}
+
+// errLeaderElectionLost は Lease を更新できずにリーダーでなくなったことを表す
+// 他のレプリカがすでにスケジュールしているかもしれないので、プロセスを終了させる
+var errLeaderElectionLost = errors.New("leader election lost")
+
+// runWithLeaderElection はリーダーに選ばれてから、ctx がキャンセルされるかリーダーでなくなるまで Pod をスケジュールする
+// informer はリーダーになる前から起動しておき、引き継いだらすぐにスケジュールできるようにする
+// ctx がキャンセルされたら、処理中の Pod を終えてから Lease を手放し、他のレプリカがすぐに引き継げるようにする
+func (k *K8sClient) runWithLeaderElection(ctx context.Context, cfg *LeaderElectionConfig) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("error getting hostname: %w", err)
+	}
+	// 同じノードで複数のプロセスが動いても区別できるよう、UUID を付ける
+	identity := hostname + "_" + string(uuid.NewUUID())
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, cfg.Namespace, cfg.Name,
+		k.Clientset.CoreV1(), k.Clientset.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return fmt.Errorf("error creating leader election lock: %w", err)
+	}
+
+	// Lease の解放はスケジュールのループが止まってからにしたいので、ctx とは別に止める
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	defer stopElection()
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration,
+		RenewDeadline:   cfg.RenewDeadline,
+		RetryPeriod:     cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            cfg.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				defer stopElection()
+				slog.Info("started leading", "lease", cfg.Namespace+"/"+cfg.Name, "identity", identity)
+				// Lease を更新できなくなったら、次の Pod を取り出さずにループを止める
+				go func() {
+					<-leaderCtx.Done()
+					k.queue.Close()
+				}()
+				k.scheduleLoop()
+			},
+			// リーダーにならずに止めた場合も呼ばれる
+			OnStoppedLeading: func() {
+				slog.Info("leader election stopped", "lease", cfg.Namespace+"/"+cfg.Name, "identity", identity)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					slog.Info("new leader elected", "lease", cfg.Namespace+"/"+cfg.Name, "leader", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating leader elector: %w", err)
+	}
+
+	// リーダーならキューが閉じてループが止まったときに OnStartedLeading が止めるので、
+	// ここではリーダーでないときだけ Lease の取得をやめる
+	go func() {
+		<-ctx.Done()
+		if !elector.IsLeader() {
+			stopElection()
+		}
+	}()
+	elector.Run(electionCtx)
+
+	if ctx.Err() == nil {
+		return errLeaderElectionLost
+	}
+	return nil
+}
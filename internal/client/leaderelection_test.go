@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"kube-scheduler-practice/internal/logic"
+	"sync"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+)
+
+// 他のレプリカに奪われないよう、リース期間はテストより十分長くし、再試行の間隔だけ短くする
+var testLeaderElectionConfig = LeaderElectionConfig{
+	Namespace:     "kube-system",
+	Name:          "kube-scheduler-practice",
+	LeaseDuration: time.Minute,
+	RenewDeadline: 30 * time.Second,
+	RetryPeriod:   10 * time.Millisecond,
+}
+
+// bind を記録する fake clientset を返す
+// bind された Pod には nodeName を設定し、他のレプリカがもう一度スケジュールしないようにする
+func newLeaderElectionClientset(t *testing.T, objects ...runtime.Object) (*fake.Clientset, func() []string) {
+	t.Helper()
+	clientset := fake.NewSimpleClientset(objects...)
+	var lock sync.Mutex
+	var bound []string
+	clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		createAction := action.(coretesting.CreateAction)
+		if createAction.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		binding := createAction.GetObject().(*v1.Binding)
+		obj, err := clientset.Tracker().Get(v1.SchemeGroupVersion.WithResource("pods"), binding.Namespace, binding.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		pod := obj.(*v1.Pod).DeepCopy()
+		pod.Spec.NodeName = binding.Target.Name
+		if err := clientset.Tracker().Update(v1.SchemeGroupVersion.WithResource("pods"), pod, pod.Namespace); err != nil {
+			return true, nil, err
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		bound = append(bound, binding.Name)
+		return true, binding, nil
+	})
+	return clientset, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), bound...)
+	}
+}
+
+// 最初のノードに配置する K8sClient を返す
+func newLeaderElectionClient(clientset *fake.Clientset) *K8sClient {
+	cfg := testLeaderElectionConfig
+	return &K8sClient{
+		Clientset: clientset,
+		Profiles: map[string]ScheduleLogic{
+			testSchedulerName: &mockScheduleLogic{
+				funcChooseAvailableNodes: func(p *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+					nl := &v1.NodeList{}
+					for _, nodeInfo := range snapshot.NodeInfos() {
+						nl.Items = append(nl.Items, *nodeInfo.Node)
+					}
+					return nl, nil
+				},
+				funcChooseSuitableNode: func(p *v1.Pod, nl *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+					return nl.Items[0], nil
+				},
+			},
+		},
+		LeaderElection: &cfg,
+	}
+}
+
+// Run を別の goroutine で動かし、止める関数を返す。止める関数は Run の戻り値を返す
+func startRun(t *testing.T, k *K8sClient) func() error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- k.Run(ctx) }()
+	var once sync.Once
+	var err error
+	stop := func() error {
+		once.Do(func() {
+			cancel()
+			select {
+			case err = <-done:
+			case <-time.After(wait.ForeverTestTimeout):
+				t.Fatal("K8sClient.Run() did not return after cancel")
+			}
+		})
+		return err
+	}
+	t.Cleanup(func() { stop() })
+	return stop
+}
+
+func getLease(t *testing.T, clientset *fake.Clientset) *coordinationv1.Lease {
+	t.Helper()
+	lease, err := clientset.CoordinationV1().Leases(testLeaderElectionConfig.Namespace).Get(context.Background(), testLeaderElectionConfig.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get lease: %v", err)
+	}
+	return lease
+}
+
+func TestK8sClient_Run_NotLeader(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	// 他のレプリカが更新し続けている Lease
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: testLeaderElectionConfig.Name, Namespace: testLeaderElectionConfig.Namespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("other-replica"),
+			LeaseDurationSeconds: ptr.To(int32(3600)),
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+		},
+	}
+	clientset, bound := newLeaderElectionClientset(t, node, pod, lease)
+	stop := startRun(t, newLeaderElectionClient(clientset))
+
+	// Lease の取得を何度か試みるまで待つ
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		gets := 0
+		for _, action := range clientset.Actions() {
+			if action.Matches("get", "leases") {
+				gets++
+			}
+		}
+		return gets >= 5, nil
+	}); err != nil {
+		t.Fatalf("lease was not observed: %v", err)
+	}
+	if err := stop(); err != nil {
+		t.Errorf("K8sClient.Run() error = %v", err)
+	}
+
+	// リーダーでなければ bind しない
+	if got := bound(); len(got) != 0 {
+		t.Errorf("bound pods = %v, want none", got)
+	}
+	if got := getLease(t, clientset); ptr.Deref(got.Spec.HolderIdentity, "") != "other-replica" {
+		t.Errorf("lease holder = %q, want other-replica", ptr.Deref(got.Spec.HolderIdentity, ""))
+	}
+}
+
+func TestK8sClient_Run_LeaderHandover(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+	pod1 := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	clientset, bound := newLeaderElectionClientset(t, node, pod1)
+
+	waitBound := func(want []string) {
+		t.Helper()
+		if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+			return len(bound()) >= len(want), nil
+		}); err != nil {
+			t.Fatalf("pods were not bound: %v (bound: %v)", err, bound())
+		}
+		if got := bound(); len(got) != len(want) || got[len(got)-1] != want[len(want)-1] {
+			t.Fatalf("bound pods = %v, want %v", got, want)
+		}
+	}
+
+	// 先に起動したレプリカがリーダーになり、スケジュールする
+	stopLeader := startRun(t, newLeaderElectionClient(clientset))
+	waitBound([]string{"pod1"})
+	leader := ptr.Deref(getLease(t, clientset).Spec.HolderIdentity, "")
+	if leader == "" {
+		t.Fatal("lease has no holder after the leader bound a pod")
+	}
+	startRun(t, newLeaderElectionClient(clientset))
+	// fake clientset は watch を始める前のイベントを送らないので、
+	// 待機しているレプリカの informer が Pod を watch し始めるまで待つ
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		watches := 0
+		for _, action := range clientset.Actions() {
+			if action.Matches("watch", "pods") {
+				watches++
+			}
+		}
+		// 配置済みの Pod と未スケジュールの Pod の informer がレプリカごとに 1 つずつある
+		return watches >= 4, nil
+	}); err != nil {
+		t.Fatalf("standby replica did not start watching pods: %v", err)
+	}
+
+	// SIGTERM などで止めると Lease を手放し、待機していたレプリカがすぐに引き継ぐ
+	if err := stopLeader(); err != nil {
+		t.Errorf("K8sClient.Run() error = %v", err)
+	}
+	pod2 := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod2", Namespace: "default"}, Spec: v1.PodSpec{SchedulerName: testSchedulerName}}
+	if _, err := clientset.CoreV1().Pods(pod2.Namespace).Create(context.Background(), pod2, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create pod: %v", err)
+	}
+	waitBound([]string{"pod1", "pod2"})
+	if got := ptr.Deref(getLease(t, clientset).Spec.HolderIdentity, ""); got == "" || got == leader {
+		t.Errorf("lease holder = %q, want the standby replica", got)
+	}
+}
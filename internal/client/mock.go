@@ -1,16 +1,30 @@
 package client
 
-import v1 "k8s.io/api/core/v1"
+import (
+	"kube-scheduler-practice/internal/logic"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+)
 
 type mockScheduleLogic struct {
-	funcChooseAvailableNodes func(unschedulePod *v1.Pod, vs *v1.NodeList) (*v1.NodeList, error)
-	funcChooseSuitableNode   func(unschedulePod *v1.Pod, vs *v1.NodeList) (v1.Node, error)
+	funcChooseAvailableNodes func(unschedulePod *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error)
+	funcChooseSuitableNode   func(unschedulePod *v1.Pod, vs *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error)
+	funcPreempt              func(pod *v1.Pod, snapshot *logic.Snapshot, pdbs []*policyv1.PodDisruptionBudget) (*logic.PreemptionResult, error)
+}
+
+func (m *mockScheduleLogic) ChooseAvailableNodes(unschedulePod *v1.Pod, snapshot *logic.Snapshot) (*v1.NodeList, error) {
+	return m.funcChooseAvailableNodes(unschedulePod, snapshot)
 }
 
-func (m *mockScheduleLogic) ChooseAvailableNodes(unschedulePod *v1.Pod, vs *v1.NodeList) (*v1.NodeList, error) {
-	return m.funcChooseAvailableNodes(unschedulePod, vs)
+func (m *mockScheduleLogic) ChooseSuitableNode(unschedulePod *v1.Pod, vs *v1.NodeList, snapshot *logic.Snapshot) (v1.Node, error) {
+	return m.funcChooseSuitableNode(unschedulePod, vs, snapshot)
 }
 
-func (m *mockScheduleLogic) ChooseSuitableNode(unschedulePod *v1.Pod, vs *v1.NodeList) (v1.Node, error) {
-	return m.funcChooseSuitableNode(unschedulePod, vs)
+// funcPreempt が設定されていなければ、プリエンプションできないものとして nil を返す
+func (m *mockScheduleLogic) Preempt(pod *v1.Pod, snapshot *logic.Snapshot, pdbs []*policyv1.PodDisruptionBudget) (*logic.PreemptionResult, error) {
+	if m.funcPreempt == nil {
+		return nil, nil
+	}
+	return m.funcPreempt(pod, snapshot, pdbs)
 }
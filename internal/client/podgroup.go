@@ -0,0 +1,254 @@
+package client
+
+import (
+	"fmt"
+	"kube-scheduler-practice/internal/config"
+	"kube-scheduler-practice/internal/logic"
+	"kube-scheduler-practice/internal/metrics"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// Pod グループ (gang) を表すラベルとアノテーション
+// 分散学習のワーカーのように、一部だけが動いても意味の無い Pod をまとめて配置する
+const (
+	// PodGroupLabel は Pod が属する Pod グループの名前。同じ namespace で同じ名前の Pod が 1 つのグループになる
+	PodGroupLabel = "scheduling.x-k8s.io/pod-group"
+	// PodGroupMinMemberAnnotation は、配置が決まるまで bind を待つメンバーの数
+	// 省略した場合や 1 の場合は、グループに属していても 1 つずつ bind する
+	PodGroupMinMemberAnnotation = "scheduling.x-k8s.io/min-member"
+)
+
+// ResultWaiting は、Pod グループの他のメンバーの配置が決まるのを待っていることを表す PodResult.Result
+const ResultWaiting = "waiting"
+
+// podGroup は Pod が属する Pod グループ
+type podGroup struct {
+	namespace string
+	name      string
+	minMember int
+}
+
+// podGroupOf は pod が属する Pod グループを返す
+// グループに属していない、または minMember が 1 なら nil を返す
+func podGroupOf(pod *v1.Pod) (*podGroup, error) {
+	name := pod.Labels[PodGroupLabel]
+	value, ok := pod.Annotations[PodGroupMinMemberAnnotation]
+	if name == "" || !ok {
+		return nil, nil
+	}
+	minMember, err := strconv.Atoi(value)
+	if err != nil || minMember < 1 {
+		return nil, fmt.Errorf("invalid %s annotation %q of pod group %s: must be a positive integer", PodGroupMinMemberAnnotation, value, name)
+	}
+	if minMember == 1 {
+		return nil, nil
+	}
+	return &podGroup{namespace: pod.Namespace, name: name, minMember: minMember}, nil
+}
+
+func podKey(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+func (g *podGroup) key() string {
+	return g.namespace + "/" + g.name
+}
+
+// countPlaced はノードに配置済み (仮置きを含む) のメンバーの数を返す
+func (g *podGroup) countPlaced(nodeInfos []*logic.NodeInfo) int {
+	n := 0
+	for _, nodeInfo := range nodeInfos {
+		for _, pod := range nodeInfo.Pods {
+			if pod.Namespace == g.namespace && pod.Labels[PodGroupLabel] == g.name {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// waitingPod は仮置きしたまま、Pod グループの他のメンバーの配置が決まるのを待っている Pod
+// キューからは取り出したままにしておき、bind するか仮置きを取り消すまで Done を呼ばない
+type waitingPod struct {
+	*assumedPod
+	// スケジュールを始めた時刻
+	start time.Time
+	// 試行の結果を書き込む先。ProcessOneLoop が戻った後は nil にして、返した結果を書き換えない
+	result *PodResult
+}
+
+// waitingGroup は minMember に達していない Pod グループ
+type waitingGroup struct {
+	group *podGroup
+	// 待っているメンバー。待ち始めた順に並べ、その順に bind する
+	pods []*waitingPod
+	// 期限が過ぎたら rejectPodGroup を呼ぶ
+	timer *time.Timer
+}
+
+// gangBinding は、minMember に達してまとめて bind する Pod グループのメンバーが共有する状態
+// どれかのメンバーの bind に失敗したら、まだ bind を始めていないメンバーは bind せずに仮置きを取り消し、
+// 失敗したメンバーと一緒に再試行させる
+// bind 済みや bind 中のメンバーは取り消せない。再試行ではそれらを配置済みのメンバーとして数え、
+// 残りのメンバーが揃ったところで bind する
+type gangBinding struct {
+	lock sync.Mutex
+	// 最初に bind に失敗したメンバー。nil なら失敗していない
+	failed *v1.Pod
+}
+
+// fail はメンバー pod の bind に失敗したことを記録する
+func (g *gangBinding) fail(pod *v1.Pod) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.failed == nil {
+		g.failed = pod
+	}
+}
+
+// failedMember は bind に失敗したメンバーを返す。失敗していなければ nil を返す
+func (g *gangBinding) failedMember() *v1.Pod {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.failed
+}
+
+// podGroupWaiter は minMember に達していない Pod グループを保持する
+type podGroupWaiter struct {
+	lock sync.Mutex
+	// Pod グループのキー (namespace/name) → 待っているメンバー
+	groups map[string]*waitingGroup
+}
+
+func newPodGroupWaiter() *podGroupWaiter {
+	return &podGroupWaiter{groups: map[string]*waitingGroup{}}
+}
+
+// permitPodGroup は仮置きした Pod グループのメンバー wp を bind してよいかを決める
+// 配置済み・仮置きのメンバーと合わせて minMember に達したら、待っていたメンバーと wp を bind する順に返す
+// 達していなければ wp を待たせて nil を返す。PodGroupTimeout が過ぎても達しなければ rejectPodGroup で取り消す
+func (k *K8sClient) permitPodGroup(wp *waitingPod) []*waitingPod {
+	group := wp.group
+	key := group.key()
+	k.podGroups.lock.Lock()
+	defer k.podGroups.lock.Unlock()
+
+	wg, ok := k.podGroups.groups[key]
+	if !ok {
+		wg = &waitingGroup{group: group}
+	}
+	// wp と待っているメンバーも仮置きしているので、キャッシュから数える
+	// 期限切れで取り消したメンバーの仮置きは、このロックを取っている間に取り消している
+	placed := group.countPlaced(k.schedulerCache.NodeInfos())
+
+	if placed >= group.minMember {
+		if ok {
+			wg.timer.Stop()
+			delete(k.podGroups.groups, key)
+		}
+		slog.Info("pod group reached min member", "podGroup", key, "minMember", group.minMember, "placed", placed)
+		return append(wg.pods, wp)
+	}
+
+	if !ok {
+		timeout := k.PodGroupTimeout
+		if timeout == 0 {
+			timeout = config.DefaultPodGroupTimeoutSeconds * time.Second
+		}
+		wg.timer = time.AfterFunc(timeout, func() { k.rejectPodGroup(key, wg) })
+		k.podGroups.groups[key] = wg
+	}
+	wg.pods = append(wg.pods, wp)
+	reason := fmt.Sprintf("waiting for pod group %s: %d of %d members are placed", key, placed, group.minMember)
+	slog.Info("pod is waiting for pod group", "pod", wp.pod.Name, "namespace", wp.pod.Namespace, "node", wp.node.Name, "podGroup", key, "placed", placed, "minMember", group.minMember)
+	*wp.result = PodResult{Pod: wp.pod, Result: ResultWaiting, Reason: reason}
+	return nil
+}
+
+// rejectPodGroup は PodGroupTimeout が過ぎても minMember に達しなかった Pod グループの、
+// 待っているメンバーの仮置きを取り消し、バックオフの後で再試行させる
+func (k *K8sClient) rejectPodGroup(key string, wg *waitingGroup) {
+	k.podGroups.lock.Lock()
+	if k.podGroups.groups[key] != wg {
+		// 期限と同時に minMember に達して bind を始めた
+		k.podGroups.lock.Unlock()
+		return
+	}
+	delete(k.podGroups.groups, key)
+	reason := fmt.Sprintf("pod group %s did not reach min member %d in time", key, wg.group.minMember)
+	for _, wp := range wg.pods {
+		k.schedulerCache.ForgetPod(wp.assumedPod.assumedPod)
+		if wp.result != nil {
+			*wp.result = PodResult{Pod: wp.pod, Result: metrics.ResultUnschedulable, Reason: reason}
+		}
+	}
+	k.podGroups.lock.Unlock()
+
+	slog.Info("released pods of pod group", "podGroup", key, "minMember", wg.group.minMember, "waiting", len(wg.pods))
+	for _, wp := range wg.pods {
+		k.Metrics.ObserveSchedulingAttempt(metrics.ResultUnschedulable, wp.pod.Spec.SchedulerName, time.Since(wp.start))
+		k.recordSchedulingFailure(wp.pod, v1.PodReasonUnschedulable, reason, "")
+		// 他のメンバーが増えれば揃うかもしれないので、クラスタのイベントを待たずに再試行する
+		k.queue.AddUnschedulableIfNotPresent(wp.pInfo, false)
+	}
+	// 仮置きの分の空きが無いために配置できなかった Pod を再試行させる
+	k.queue.MoveAllToActiveOrBackoffQueue(eventAssumedPodForget, nil)
+}
+
+// abortGangMember は Pod グループの他のメンバー failed の bind に失敗したので、wp を bind せずに仮置きを取り消す
+// failed と一緒に揃えて bind できるよう、クラスタのイベントを待たずにバックオフの後で再試行させる
+func (k *K8sClient) abortGangMember(wp *waitingPod, failed *v1.Pod) PodResult {
+	key := wp.group.key()
+	reason := fmt.Sprintf("pod group %s: member %s failed to bind", key, failed.Name)
+	slog.Info("pod group member is not bound because another member failed to bind", "pod", wp.pod.Name, "namespace", wp.pod.Namespace, "podGroup", key, "failed", failed.Name)
+	k.forgetAssumedPod(wp.assumedPod)
+	k.recordSchedulingFailure(wp.pod, v1.PodReasonUnschedulable, reason, "")
+	k.queue.AddUnschedulableIfNotPresent(wp.pInfo, false)
+	return k.observeSchedulingResult(wp.pInfo, wp.start, PodResult{Result: metrics.ResultUnschedulable, Reason: reason})
+}
+
+// removeWaitingPod は削除された Pod が Pod グループの他のメンバーを待っていれば、仮置きを取り消す
+func (k *K8sClient) removeWaitingPod(pod *v1.Pod) {
+	key := podKey(pod)
+	k.podGroups.lock.Lock()
+	var removed *waitingPod
+	for groupKey, wg := range k.podGroups.groups {
+		i := slices.IndexFunc(wg.pods, func(wp *waitingPod) bool { return podKey(wp.pod) == key })
+		if i < 0 {
+			continue
+		}
+		wp := wg.pods[i]
+		removed = wp
+		wg.pods = slices.Delete(wg.pods, i, i+1)
+		k.schedulerCache.ForgetPod(wp.assumedPod.assumedPod)
+		if len(wg.pods) == 0 {
+			wg.timer.Stop()
+			delete(k.podGroups.groups, groupKey)
+		}
+		break
+	}
+	k.podGroups.lock.Unlock()
+
+	if removed != nil {
+		slog.Info("pod waiting for pod group was deleted", "pod", pod.Name, "namespace", pod.Namespace)
+		k.queue.MoveAllToActiveOrBackoffQueue(eventAssumedPodForget, nil)
+	}
+}
+
+// detachResults は待っている Pod の結果の書き込み先を外す
+// ProcessOneLoop が返した結果を、後から bind や期限切れで書き換えないようにする
+func (w *podGroupWaiter) detachResults() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	for _, wg := range w.groups {
+		for _, wp := range wg.pods {
+			wp.result = nil
+		}
+	}
+}
@@ -0,0 +1,419 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"kube-scheduler-practice/internal/logic"
+	"kube-scheduler-practice/internal/metrics"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	coretesting "k8s.io/client-go/testing"
+)
+
+var podGroupCreated = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Pod グループ group に属する、CPU を 600m 要求する Pod を返す。minMember が空ならアノテーションを付けない
+// i 番目の Pod ほど後に作られたことにして、スケジュールの順番を決める
+func newPodGroupPod(name, group, minMember string, i int) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(podGroupCreated.Add(time.Duration(i) * time.Second)),
+			Labels:            map[string]string{PodGroupLabel: group},
+		},
+		Spec: v1.PodSpec{
+			SchedulerName: testSchedulerName,
+			Containers: []v1.Container{{
+				Name:      "c",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("600m")}},
+			}},
+		},
+	}
+	if minMember != "" {
+		pod.Annotations = map[string]string{PodGroupMinMemberAnnotation: minMember}
+	}
+	return pod
+}
+
+// bind された Pod の名前を記録する fake clientset を返す
+// Pod の nodeName は更新しないので、bind した Pod は仮置きのまま残る
+func newPodGroupClientset(objects ...runtime.Object) (*fake.Clientset, func() []string) {
+	clientset := fake.NewSimpleClientset(objects...)
+	var lock sync.Mutex
+	var bound []string
+	clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		createAction := action.(coretesting.CreateAction)
+		if createAction.GetSubresource() != "binding" {
+			return false, nil, nil
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		bound = append(bound, createAction.GetObject().(*v1.Binding).Name)
+		return true, createAction.GetObject(), nil
+	})
+	return clientset, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		got := append([]string{}, bound...)
+		sort.Strings(got)
+		return got
+	}
+}
+
+// CPU が cpu のノード 1 つに、空きがあれば配置する K8sClient を返す
+func newPodGroupClient(t *testing.T, clientset *fake.Clientset, podGroupTimeout time.Duration) *K8sClient {
+	t.Helper()
+	scheduleLogic, err := logic.NewScheduleLogic(logic.NewInTreeRegistry(), logic.PluginSet{Filter: []string{logic.NodeResourcesFitName}})
+	if err != nil {
+		t.Fatalf("NewScheduleLogic() error = %v", err)
+	}
+	return newStartedClient(t, &K8sClient{
+		Clientset:         clientset,
+		Profiles:          map[string]ScheduleLogic{testSchedulerName: scheduleLogic},
+		PodInitialBackoff: time.Millisecond,
+		PodMaxBackoff:     time.Millisecond,
+		PodGroupTimeout:   podGroupTimeout,
+	})
+}
+
+func newPodGroupNode(cpu string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Status:     v1.NodeStatus{Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)}},
+	}
+}
+
+func TestPodGroupOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     *v1.Pod
+		want    *podGroup
+		wantErr bool
+	}{
+		{
+			name: "pod group",
+			pod:  newPodGroupPod("worker-0", "training", "3", 0),
+			want: &podGroup{namespace: "default", name: "training", minMember: 3},
+		},
+		{
+			name: "no label",
+			pod:  &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "worker-0", Namespace: "default", Annotations: map[string]string{PodGroupMinMemberAnnotation: "3"}}},
+		},
+		{
+			name: "no min member",
+			pod:  newPodGroupPod("worker-0", "training", "", 0),
+		},
+		{
+			// 1 つずつ bind しても同じ
+			name: "min member 1",
+			pod:  newPodGroupPod("worker-0", "training", "1", 0),
+		},
+		{
+			name:    "invalid min member",
+			pod:     newPodGroupPod("worker-0", "training", "three", 0),
+			wantErr: true,
+		},
+		{
+			name:    "non-positive min member",
+			pod:     newPodGroupPod("worker-0", "training", "0", 0),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := podGroupOf(tt.pod)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("podGroupOf() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("podGroupOf() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_PodGroup(t *testing.T) {
+	tests := []struct {
+		name string
+		// ノードの CPU。Pod は 600m ずつ要求する
+		nodeCPU string
+		pods    []*v1.Pod
+		// 1 回目の ProcessOneLoop の後に作る Pod
+		laterPods []*v1.Pod
+		// 最後の ProcessOneLoop の結果
+		wantResults map[string]string
+		wantBound   []string
+	}{
+		{
+			name:    "members are bound together",
+			nodeCPU: "4",
+			pods: []*v1.Pod{
+				newPodGroupPod("worker-0", "training", "3", 0),
+				newPodGroupPod("worker-1", "training", "3", 1),
+				newPodGroupPod("worker-2", "training", "3", 2),
+			},
+			wantResults: map[string]string{"worker-0": metrics.ResultScheduled, "worker-1": metrics.ResultScheduled, "worker-2": metrics.ResultScheduled},
+			wantBound:   []string{"worker-0", "worker-1", "worker-2"},
+		},
+		{
+			// minMember を超えたメンバーは待たずに bind する
+			name:    "extra members are bound without waiting",
+			nodeCPU: "4",
+			pods: []*v1.Pod{
+				newPodGroupPod("worker-0", "training", "2", 0),
+				newPodGroupPod("worker-1", "training", "2", 1),
+				newPodGroupPod("worker-2", "training", "2", 2),
+			},
+			wantResults: map[string]string{"worker-0": metrics.ResultScheduled, "worker-1": metrics.ResultScheduled, "worker-2": metrics.ResultScheduled},
+			wantBound:   []string{"worker-0", "worker-1", "worker-2"},
+		},
+		{
+			name:    "members wait for min member",
+			nodeCPU: "4",
+			pods: []*v1.Pod{
+				newPodGroupPod("worker-0", "training", "3", 0),
+				newPodGroupPod("worker-1", "training", "3", 1),
+			},
+			wantResults: map[string]string{"worker-0": ResultWaiting, "worker-1": ResultWaiting},
+		},
+		{
+			// 前の回から待っていたメンバーは、揃った回にまとめて bind する
+			name:    "late member releases waiting members",
+			nodeCPU: "4",
+			pods: []*v1.Pod{
+				newPodGroupPod("worker-0", "training", "3", 0),
+				newPodGroupPod("worker-1", "training", "3", 1),
+			},
+			laterPods:   []*v1.Pod{newPodGroupPod("worker-2", "training", "3", 2)},
+			wantResults: map[string]string{"worker-2": metrics.ResultScheduled},
+			wantBound:   []string{"worker-0", "worker-1", "worker-2"},
+		},
+		{
+			// 他のグループのメンバーは数えない
+			name:    "pod groups are counted separately",
+			nodeCPU: "4",
+			pods: []*v1.Pod{
+				newPodGroupPod("a-0", "a", "2", 0),
+				newPodGroupPod("b-0", "b", "2", 1),
+				newPodGroupPod("a-1", "a", "2", 2),
+			},
+			wantResults: map[string]string{"a-0": metrics.ResultScheduled, "b-0": ResultWaiting, "a-1": metrics.ResultScheduled},
+			wantBound:   []string{"a-0", "a-1"},
+		},
+		{
+			// 空きが足りないメンバーは配置できず、他のメンバーは待ち続ける
+			name:    "member does not fit",
+			nodeCPU: "1500m",
+			pods: []*v1.Pod{
+				newPodGroupPod("worker-0", "training", "3", 0),
+				newPodGroupPod("worker-1", "training", "3", 1),
+				newPodGroupPod("worker-2", "training", "3", 2),
+			},
+			wantResults: map[string]string{"worker-0": ResultWaiting, "worker-1": ResultWaiting, "worker-2": metrics.ResultUnschedulable},
+		},
+		{
+			name:        "invalid min member",
+			nodeCPU:     "4",
+			pods:        []*v1.Pod{newPodGroupPod("worker-0", "training", "three", 0)},
+			wantResults: map[string]string{"worker-0": metrics.ResultUnschedulable},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects := []runtime.Object{newPodGroupNode(tt.nodeCPU)}
+			for _, pod := range tt.pods {
+				objects = append(objects, pod)
+			}
+			clientset, bound := newPodGroupClientset(objects...)
+			k := newPodGroupClient(t, clientset, time.Minute)
+
+			result := k.ProcessOneLoop()
+			if len(tt.laterPods) > 0 {
+				for _, pod := range tt.laterPods {
+					if _, err := clientset.CoreV1().Pods(pod.Namespace).Create(context.Background(), pod, metav1.CreateOptions{}); err != nil {
+						t.Fatalf("failed to create pod: %v", err)
+					}
+				}
+				if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+					return k.queue.Depths().Active == len(tt.laterPods), nil
+				}); err != nil {
+					t.Fatalf("later pods were not queued: %v", err)
+				}
+				result = k.ProcessOneLoop()
+			}
+
+			if err := result.Err(); err != nil {
+				t.Errorf("K8sClient.ProcessOneLoop() error = %v", err)
+			}
+			got := map[string]string{}
+			for _, p := range result.Pods {
+				got[p.Pod.Name] = p.Result
+			}
+			if !reflect.DeepEqual(got, tt.wantResults) {
+				t.Errorf("results = %v, want %v", got, tt.wantResults)
+			}
+			if got := bound(); !reflect.DeepEqual(got, append([]string{}, tt.wantBound...)) {
+				t.Errorf("bound pods = %v, want %v", got, tt.wantBound)
+			}
+		})
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_PodGroupTimeout(t *testing.T) {
+	// 2 つしか入らないノードに、3 つ揃うまで待つ Pod グループ
+	pods := []*v1.Pod{
+		newPodGroupPod("worker-0", "training", "3", 0),
+		newPodGroupPod("worker-1", "training", "3", 1),
+		newPodGroupPod("worker-2", "training", "3", 2),
+	}
+	clientset, bound := newPodGroupClientset(newPodGroupNode("1500m"), pods[0], pods[1], pods[2])
+	k := newPodGroupClient(t, clientset, 100*time.Millisecond)
+
+	result := k.ProcessOneLoop()
+	if got := result.Count(ResultWaiting); got != 2 {
+		t.Fatalf("waiting pods = %d, want 2 (results: %+v)", got, result.Pods)
+	}
+	// 待っている間は、メンバーの分の空きを使わせない
+	if nodeInfo := k.schedulerCache.NodeInfos()[0]; len(nodeInfo.Pods) != 2 {
+		t.Errorf("pods on node1 = %d, want 2 while waiting", len(nodeInfo.Pods))
+	}
+
+	// 期限が過ぎたら仮置きを取り消し、すべてのメンバーを再試行する
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return len(k.schedulerCache.NodeInfos()[0].Pods) == 0 && k.queue.Depths().Active == 3, nil
+	}); err != nil {
+		t.Fatalf("pod group was not released: %v (queue: %+v)", err, k.queue.Depths())
+	}
+	if got := bound(); len(got) != 0 {
+		t.Errorf("bound pods = %v, want none", got)
+	}
+	got, err := clientset.CoreV1().Pods("default").Get(context.Background(), "worker-0", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get pod: %v", err)
+	}
+	wantMessage := "pod group default/training did not reach min member 3 in time"
+	var condition *v1.PodCondition
+	for i := range got.Status.Conditions {
+		if got.Status.Conditions[i].Type == v1.PodScheduled {
+			condition = &got.Status.Conditions[i]
+		}
+	}
+	if condition == nil || condition.Reason != v1.PodReasonUnschedulable || condition.Message != wantMessage {
+		t.Errorf("PodScheduled condition = %+v, want %s with message %q", condition, v1.PodReasonUnschedulable, wantMessage)
+	}
+}
+
+func TestK8sClient_ProcessOneLoop_PodGroupBindFailure(t *testing.T) {
+	pods := []*v1.Pod{
+		newPodGroupPod("worker-0", "training", "3", 0),
+		newPodGroupPod("worker-1", "training", "3", 1),
+		newPodGroupPod("worker-2", "training", "3", 2),
+	}
+	clientset, bound := newPodGroupClientset(newPodGroupNode("4"), pods[0], pods[1], pods[2])
+	// worker-0 の最初の bind だけ失敗させる
+	var failOnce sync.Once
+	clientset.PrependReactor("create", "pods", func(action coretesting.Action) (handled bool, ret runtime.Object, err error) {
+		binding, ok := action.(coretesting.CreateAction).GetObject().(*v1.Binding)
+		if !ok || binding.Name != "worker-0" {
+			return false, nil, nil
+		}
+		failed := false
+		failOnce.Do(func() { failed = true })
+		if failed {
+			return true, nil, errors.New("bind failed")
+		}
+		return false, nil, nil
+	})
+	scheduleLogic, err := logic.NewScheduleLogic(logic.NewInTreeRegistry(), logic.PluginSet{Filter: []string{logic.NodeResourcesFitName}})
+	if err != nil {
+		t.Fatalf("NewScheduleLogic() error = %v", err)
+	}
+	k := newStartedClient(t, &K8sClient{
+		Clientset:         clientset,
+		Profiles:          map[string]ScheduleLogic{testSchedulerName: scheduleLogic},
+		PodInitialBackoff: time.Millisecond,
+		PodMaxBackoff:     time.Millisecond,
+		PodGroupTimeout:   time.Minute,
+		// 待っていたメンバーから 1 つずつ bind し、worker-0 の失敗を後のメンバーの bind より先に確かめる
+		BindParallelism: 1,
+	})
+
+	// 待っていたメンバーの bind の失敗も、そのメンバーの結果として返す
+	result := k.ProcessOneLoop()
+	if got := result.Get("default", "worker-0"); got == nil || got.Result != metrics.ResultError {
+		t.Errorf("result of worker-0 = %+v, want %s", got, metrics.ResultError)
+	}
+	// 他のメンバーは bind せず、仮置きを取り消して再試行させる
+	wantReason := "pod group default/training: member worker-0 failed to bind"
+	for _, name := range []string{"worker-1", "worker-2"} {
+		got := result.Get("default", name)
+		if got == nil {
+			t.Errorf("no result for %s", name)
+			continue
+		}
+		if got.Result != metrics.ResultUnschedulable || got.Reason != wantReason {
+			t.Errorf("result of %s = %s %q, want %s %q", name, got.Result, got.Reason, metrics.ResultUnschedulable, wantReason)
+		}
+	}
+	if got := bound(); len(got) != 0 {
+		t.Errorf("bound pods = %v, want none", got)
+	}
+	if nodeInfo := k.schedulerCache.NodeInfos()[0]; len(nodeInfo.Pods) != 0 {
+		t.Errorf("pods on node1 = %d, want 0 after the group failed to bind", len(nodeInfo.Pods))
+	}
+
+	// バックオフの後で、グループとしてまとめて bind する
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return k.queue.Depths().Active == 3, nil
+	}); err != nil {
+		t.Fatalf("pod group was not requeued: %v (queue: %+v)", err, k.queue.Depths())
+	}
+	if got := k.ProcessOneLoop().Count(metrics.ResultScheduled); got != 3 {
+		t.Errorf("scheduled pods = %d, want 3", got)
+	}
+	if got, want := bound(), []string{"worker-0", "worker-1", "worker-2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bound pods = %v, want %v", got, want)
+	}
+}
+
+func TestK8sClient_removeWaitingPod(t *testing.T) {
+	pods := []*v1.Pod{
+		newPodGroupPod("worker-0", "training", "3", 0),
+		newPodGroupPod("worker-1", "training", "3", 1),
+	}
+	clientset, bound := newPodGroupClientset(newPodGroupNode("4"), pods[0], pods[1])
+	k := newPodGroupClient(t, clientset, time.Minute)
+	if got := k.ProcessOneLoop().Count(ResultWaiting); got != 2 {
+		t.Fatalf("waiting pods = %d, want 2", got)
+	}
+
+	// 削除された Pod の仮置きだけを取り消し、他のメンバーは待ち続ける
+	if err := clientset.CoreV1().Pods("default").Delete(context.Background(), "worker-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("failed to delete pod: %v", err)
+	}
+	if err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, wait.ForeverTestTimeout, true, func(context.Context) (bool, error) {
+		return len(k.schedulerCache.NodeInfos()[0].Pods) == 1, nil
+	}); err != nil {
+		t.Fatalf("assumed pod was not forgotten: %v", err)
+	}
+	k.podGroups.lock.Lock()
+	wg := k.podGroups.groups["default/training"]
+	k.podGroups.lock.Unlock()
+	if wg == nil || len(wg.pods) != 1 || wg.pods[0].pod.Name != "worker-1" {
+		t.Errorf("waiting group = %+v, want only worker-1", wg)
+	}
+	if got := bound(); len(got) != 0 {
+		t.Errorf("bound pods = %v, want none", got)
+	}
+}
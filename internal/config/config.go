@@ -0,0 +1,297 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"kube-scheduler-practice/internal/logic"
+	"net"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultSchedulerName は --scheduler-name も --config も指定されなかったときに担当する schedulerName
+	DefaultSchedulerName = "my-custom-scheduler"
+
+	DefaultPodInitialBackoffSeconds = 1
+	DefaultPodMaxBackoffSeconds     = 10
+
+	// DefaultBindParallelism は同時に行う bind の数のデフォルトの上限
+	DefaultBindParallelism = 16
+
+	// DefaultParallelism は Filter と Score をノードごとに並行に実行するワーカー数のデフォルト
+	DefaultParallelism = logic.DefaultParallelism
+
+	// DefaultPodGroupTimeoutSeconds は Pod グループのメンバーが揃うのを待つ時間のデフォルト
+	DefaultPodGroupTimeoutSeconds = 60
+
+	// DefaultMetricsBindAddress は Prometheus のメトリクスを返す HTTP サーバのデフォルトのアドレス
+	DefaultMetricsBindAddress = ":10251"
+
+	// リーダー選出に使う Lease のデフォルト。期間は kube-scheduler と同じ
+	DefaultLeaderElectionResourceNamespace = "kube-system"
+	DefaultLeaderElectionResourceName      = "kube-scheduler-practice"
+	DefaultLeaseDurationSeconds            = 15
+	DefaultRenewDeadlineSeconds            = 10
+	DefaultRetryPeriodSeconds              = 2
+)
+
+// Config は --config で渡すスケジューラの設定ファイル
+type Config struct {
+	// スケジュールに失敗した Pod を再試行するまでの待ち時間 (秒)
+	// 失敗するたびに倍になり、PodMaxBackoffSeconds で頭打ちになる
+	PodInitialBackoffSeconds int64 `json:"podInitialBackoffSeconds,omitempty"`
+	PodMaxBackoffSeconds     int64 `json:"podMaxBackoffSeconds,omitempty"`
+
+	// 同時に行う bind の数の上限。bind は次の Pod のスケジュールと並行して行う
+	BindParallelism int `json:"bindParallelism,omitempty"`
+
+	// Filter と Score をノードごとに並行に実行するワーカー数
+	Parallelism int `json:"parallelism,omitempty"`
+	// Filter を通ったノードが全ノードのこの割合 (%) だけ見つかったら、残りのノードは調べずに Score に進む
+	// 0 ならノード数から決め、100 ならすべてのノードを調べる。100 ノード未満のクラスタでは常にすべて調べる
+	PercentageOfNodesToScore int32 `json:"percentageOfNodesToScore,omitempty"`
+
+	// Pod グループのメンバーが minMember 個揃うまで、配置を決めたメンバーの bind を待つ時間 (秒)
+	// 過ぎたら待っているメンバーの仮置きを取り消し、バックオフの後で再試行する
+	PodGroupTimeoutSeconds int64 `json:"podGroupTimeoutSeconds,omitempty"`
+
+	Preemption Preemption `json:"preemption,omitempty"`
+
+	// /metrics で Prometheus のメトリクスを返す HTTP サーバのアドレス
+	MetricsBindAddress string `json:"metricsBindAddress,omitempty"`
+
+	LeaderElection LeaderElection `json:"leaderElection,omitempty"`
+
+	Profiles []Profile `json:"profiles"`
+}
+
+// Preemption はどのノードにも配置できない Pod のために、優先度の低い Pod を退避させる機能の設定
+type Preemption struct {
+	// true ならプリエンプションを行わない
+	Disabled bool `json:"disabled,omitempty"`
+	// true なら退避させる Pod をログに出すだけで、nominatedNodeName の設定や Pod の削除は行わない
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// LeaderElection は複数のレプリカを動かすときに、Lease を取得した 1 つだけがスケジュールするための設定
+type LeaderElection struct {
+	// true ならリーダーに選ばれたレプリカだけがスケジュールする
+	LeaderElect bool `json:"leaderElect,omitempty"`
+	// リーダー選出に使う Lease の namespace と名前
+	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+	ResourceName      string `json:"resourceName,omitempty"`
+	// リーダー以外のレプリカが、更新の止まった Lease を奪うまでに待つ時間 (秒)
+	LeaseDurationSeconds int64 `json:"leaseDurationSeconds,omitempty"`
+	// リーダーが Lease の更新をこの時間 (秒) 続けて失敗したら、スケジュールをやめる
+	RenewDeadlineSeconds int64 `json:"renewDeadlineSeconds,omitempty"`
+	// Lease の取得や更新を試みる間隔 (秒)
+	RetryPeriodSeconds int64 `json:"retryPeriodSeconds,omitempty"`
+}
+
+// Profile は schedulerName ごとのスケジューリングの方針
+// Pod の spec.schedulerName と一致する Profile が使われる
+type Profile struct {
+	SchedulerName string `json:"schedulerName"`
+	// 省略した場合はデフォルトのプラグインが有効になる
+	Plugins      *Plugins       `json:"plugins,omitempty"`
+	PluginConfig []PluginConfig `json:"pluginConfig,omitempty"`
+}
+
+// Plugins は有効にするプラグインの一覧
+type Plugins struct {
+	Filter []Plugin `json:"filter,omitempty"`
+	Score  []Plugin `json:"score,omitempty"`
+}
+
+type Plugin struct {
+	Name string `json:"name"`
+	// Score プラグインの重み。省略した場合は 1
+	Weight int64 `json:"weight,omitempty"`
+}
+
+// PluginConfig はプラグインに渡す引数
+type PluginConfig struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// Default は schedulerName を担当するデフォルトの Profile を 1 つだけ持つ設定を返す
+func Default(schedulerName string) *Config {
+	c := &Config{Profiles: []Profile{{SchedulerName: schedulerName}}}
+	c.setDefaults()
+	return c
+}
+
+// Load は YAML の設定ファイルを読み込み、デフォルト値を埋めて検証する
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	c.setDefaults()
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) setDefaults() {
+	if c.PodInitialBackoffSeconds == 0 {
+		c.PodInitialBackoffSeconds = DefaultPodInitialBackoffSeconds
+	}
+	if c.PodMaxBackoffSeconds == 0 {
+		c.PodMaxBackoffSeconds = DefaultPodMaxBackoffSeconds
+	}
+	if c.BindParallelism == 0 {
+		c.BindParallelism = DefaultBindParallelism
+	}
+	if c.Parallelism == 0 {
+		c.Parallelism = DefaultParallelism
+	}
+	if c.PodGroupTimeoutSeconds == 0 {
+		c.PodGroupTimeoutSeconds = DefaultPodGroupTimeoutSeconds
+	}
+	if c.MetricsBindAddress == "" {
+		c.MetricsBindAddress = DefaultMetricsBindAddress
+	}
+	c.LeaderElection.setDefaults()
+	for i := range c.Profiles {
+		if c.Profiles[i].Plugins == nil {
+			continue
+		}
+		for j := range c.Profiles[i].Plugins.Score {
+			if c.Profiles[i].Plugins.Score[j].Weight == 0 {
+				c.Profiles[i].Plugins.Score[j].Weight = 1
+			}
+		}
+	}
+}
+
+// Validate は設定の誤りをすべて集めて返す
+// プラグイン名が存在するか、引数が正しいかはプラグインの生成時に検証する
+func (c *Config) Validate() error {
+	var errs []error
+	if c.PodInitialBackoffSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("podInitialBackoffSeconds: must be greater than 0, got %d", c.PodInitialBackoffSeconds))
+	}
+	if c.PodMaxBackoffSeconds < c.PodInitialBackoffSeconds {
+		errs = append(errs, fmt.Errorf("podMaxBackoffSeconds: must be greater than or equal to podInitialBackoffSeconds, got %d", c.PodMaxBackoffSeconds))
+	}
+	if c.BindParallelism <= 0 {
+		errs = append(errs, fmt.Errorf("bindParallelism: must be greater than 0, got %d", c.BindParallelism))
+	}
+	if c.Parallelism <= 0 {
+		errs = append(errs, fmt.Errorf("parallelism: must be greater than 0, got %d", c.Parallelism))
+	}
+	if c.PercentageOfNodesToScore < 0 || c.PercentageOfNodesToScore > 100 {
+		errs = append(errs, fmt.Errorf("percentageOfNodesToScore: must be between 0 and 100, got %d", c.PercentageOfNodesToScore))
+	}
+	if c.PodGroupTimeoutSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("podGroupTimeoutSeconds: must be greater than 0, got %d", c.PodGroupTimeoutSeconds))
+	}
+	if _, _, err := net.SplitHostPort(c.MetricsBindAddress); err != nil {
+		errs = append(errs, fmt.Errorf("metricsBindAddress: %w", err))
+	}
+	if c.LeaderElection.LeaderElect {
+		errs = append(errs, c.LeaderElection.validate()...)
+	}
+
+	if len(c.Profiles) == 0 {
+		errs = append(errs, errors.New("profiles: at least one profile is required"))
+	}
+	schedulerNames := map[string]bool{}
+	for i, profile := range c.Profiles {
+		path := fmt.Sprintf("profiles[%d]", i)
+		if profile.SchedulerName == "" {
+			errs = append(errs, fmt.Errorf("%s.schedulerName: required", path))
+		} else if schedulerNames[profile.SchedulerName] {
+			errs = append(errs, fmt.Errorf("%s.schedulerName: duplicated scheduler name %q", path, profile.SchedulerName))
+		}
+		schedulerNames[profile.SchedulerName] = true
+
+		if profile.Plugins != nil {
+			errs = append(errs, validatePlugins(path+".plugins.filter", profile.Plugins.Filter)...)
+			errs = append(errs, validatePlugins(path+".plugins.score", profile.Plugins.Score)...)
+		}
+
+		pluginConfigNames := map[string]bool{}
+		for j, pc := range profile.PluginConfig {
+			pcPath := fmt.Sprintf("%s.pluginConfig[%d]", path, j)
+			if pc.Name == "" {
+				errs = append(errs, fmt.Errorf("%s.name: required", pcPath))
+			} else if pluginConfigNames[pc.Name] {
+				errs = append(errs, fmt.Errorf("%s.name: duplicated plugin config %q", pcPath, pc.Name))
+			}
+			pluginConfigNames[pc.Name] = true
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l *LeaderElection) setDefaults() {
+	if l.ResourceNamespace == "" {
+		l.ResourceNamespace = DefaultLeaderElectionResourceNamespace
+	}
+	if l.ResourceName == "" {
+		l.ResourceName = DefaultLeaderElectionResourceName
+	}
+	if l.LeaseDurationSeconds == 0 {
+		l.LeaseDurationSeconds = DefaultLeaseDurationSeconds
+	}
+	if l.RenewDeadlineSeconds == 0 {
+		l.RenewDeadlineSeconds = DefaultRenewDeadlineSeconds
+	}
+	if l.RetryPeriodSeconds == 0 {
+		l.RetryPeriodSeconds = DefaultRetryPeriodSeconds
+	}
+}
+
+// validate は client-go の leaderelection と同じ条件で期間を検証する
+// 更新の再試行がリーダーでいられる間に収まらないと、リーダーが頻繁に入れ替わる
+func (l *LeaderElection) validate() []error {
+	var errs []error
+	if l.RetryPeriodSeconds <= 0 {
+		errs = append(errs, fmt.Errorf("leaderElection.retryPeriodSeconds: must be greater than 0, got %d", l.RetryPeriodSeconds))
+	}
+	if float64(l.RenewDeadlineSeconds) <= 1.2*float64(l.RetryPeriodSeconds) {
+		errs = append(errs, fmt.Errorf("leaderElection.renewDeadlineSeconds: must be greater than 1.2 * retryPeriodSeconds, got %d", l.RenewDeadlineSeconds))
+	}
+	if l.LeaseDurationSeconds <= l.RenewDeadlineSeconds {
+		errs = append(errs, fmt.Errorf("leaderElection.leaseDurationSeconds: must be greater than renewDeadlineSeconds, got %d", l.LeaseDurationSeconds))
+	}
+	return errs
+}
+
+func validatePlugins(path string, plugins []Plugin) []error {
+	var errs []error
+	names := map[string]bool{}
+	for i, p := range plugins {
+		if p.Name == "" {
+			errs = append(errs, fmt.Errorf("%s[%d].name: required", path, i))
+		} else if names[p.Name] {
+			errs = append(errs, fmt.Errorf("%s[%d].name: duplicated plugin %q", path, i, p.Name))
+		}
+		names[p.Name] = true
+		if p.Weight < 0 {
+			errs = append(errs, fmt.Errorf("%s[%d].weight: must not be negative, got %d", path, i, p.Weight))
+		}
+	}
+	return errs
+}
+
+// Args はプラグイン名をキーにした引数を返す
+func (p *Profile) Args() map[string]json.RawMessage {
+	args := make(map[string]json.RawMessage, len(p.PluginConfig))
+	for _, pc := range p.PluginConfig {
+		args[pc.Name] = pc.Args
+	}
+	return args
+}
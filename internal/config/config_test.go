@@ -0,0 +1,283 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+podMaxBackoffSeconds: 30
+percentageOfNodesToScore: 30
+preemption:
+  dryRun: true
+leaderElection:
+  leaderElect: true
+  resourceName: my-scheduler
+profiles:
+- schedulerName: my-custom-scheduler
+- schedulerName: batch-scheduler
+  plugins:
+    filter:
+    - name: TierIsolation
+    - name: NodeResourcesFit
+    score:
+    - name: Random
+  pluginConfig:
+  - name: TierIsolation
+    args:
+      labelKey: pool
+      excludedValues: [system]
+      dedicatedValues: [batch]
+`)
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got.PodInitialBackoffSeconds != DefaultPodInitialBackoffSeconds {
+		t.Errorf("PodInitialBackoffSeconds = %d, want default %d", got.PodInitialBackoffSeconds, DefaultPodInitialBackoffSeconds)
+	}
+	if got.PodMaxBackoffSeconds != 30 {
+		t.Errorf("PodMaxBackoffSeconds = %d, want 30", got.PodMaxBackoffSeconds)
+	}
+	if got.BindParallelism != DefaultBindParallelism {
+		t.Errorf("BindParallelism = %d, want default %d", got.BindParallelism, DefaultBindParallelism)
+	}
+	if got.Parallelism != DefaultParallelism {
+		t.Errorf("Parallelism = %d, want default %d", got.Parallelism, DefaultParallelism)
+	}
+	if got.PercentageOfNodesToScore != 30 {
+		t.Errorf("PercentageOfNodesToScore = %d, want 30", got.PercentageOfNodesToScore)
+	}
+	if got.PodGroupTimeoutSeconds != DefaultPodGroupTimeoutSeconds {
+		t.Errorf("PodGroupTimeoutSeconds = %d, want default %d", got.PodGroupTimeoutSeconds, DefaultPodGroupTimeoutSeconds)
+	}
+	if got.MetricsBindAddress != DefaultMetricsBindAddress {
+		t.Errorf("MetricsBindAddress = %q, want default %q", got.MetricsBindAddress, DefaultMetricsBindAddress)
+	}
+	// 省略した Lease の設定はデフォルト値になる
+	wantLeaderElection := LeaderElection{
+		LeaderElect:          true,
+		ResourceNamespace:    DefaultLeaderElectionResourceNamespace,
+		ResourceName:         "my-scheduler",
+		LeaseDurationSeconds: DefaultLeaseDurationSeconds,
+		RenewDeadlineSeconds: DefaultRenewDeadlineSeconds,
+		RetryPeriodSeconds:   DefaultRetryPeriodSeconds,
+	}
+	if got.LeaderElection != wantLeaderElection {
+		t.Errorf("LeaderElection = %+v, want %+v", got.LeaderElection, wantLeaderElection)
+	}
+	if got.Preemption.Disabled || !got.Preemption.DryRun {
+		t.Errorf("Preemption = %+v, want dry-run enabled", got.Preemption)
+	}
+	if len(got.Profiles) != 2 {
+		t.Fatalf("len(Profiles) = %d, want 2", len(got.Profiles))
+	}
+	if got.Profiles[0].Plugins != nil {
+		t.Errorf("Profiles[0].Plugins = %+v, want nil for default plugins", got.Profiles[0].Plugins)
+	}
+	batch := got.Profiles[1]
+	if len(batch.Plugins.Filter) != 2 || batch.Plugins.Filter[0].Name != "TierIsolation" {
+		t.Errorf("Profiles[1].Plugins.Filter = %+v", batch.Plugins.Filter)
+	}
+	// weight を省略した Score プラグインは 1 になる
+	if len(batch.Plugins.Score) != 1 || batch.Plugins.Score[0].Weight != 1 {
+		t.Errorf("Profiles[1].Plugins.Score = %+v, want weight 1", batch.Plugins.Score)
+	}
+	args := batch.Args()["TierIsolation"]
+	if !strings.Contains(string(args), `"labelKey":"pool"`) {
+		t.Errorf("Profiles[1] TierIsolation args = %s", args)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr []string
+	}{
+		{
+			name:    "no profiles",
+			content: `profiles: []`,
+			wantErr: []string{"profiles: at least one profile is required"},
+		},
+		{
+			name: "missing and duplicated scheduler names",
+			content: `
+profiles:
+- schedulerName: a
+- schedulerName: a
+- plugins:
+    filter:
+    - name: TierIsolation
+`,
+			wantErr: []string{
+				`profiles[1].schedulerName: duplicated scheduler name "a"`,
+				"profiles[2].schedulerName: required",
+			},
+		},
+		{
+			name: "invalid plugins",
+			content: `
+profiles:
+- schedulerName: a
+  plugins:
+    filter:
+    - name: TierIsolation
+    - name: TierIsolation
+    score:
+    - name: ""
+    - name: Random
+      weight: -1
+  pluginConfig:
+  - name: TierIsolation
+  - name: TierIsolation
+`,
+			wantErr: []string{
+				`profiles[0].plugins.filter[1].name: duplicated plugin "TierIsolation"`,
+				"profiles[0].plugins.score[0].name: required",
+				"profiles[0].plugins.score[1].weight: must not be negative, got -1",
+				`profiles[0].pluginConfig[1].name: duplicated plugin config "TierIsolation"`,
+			},
+		},
+		{
+			name: "invalid backoff",
+			content: `
+podInitialBackoffSeconds: 10
+podMaxBackoffSeconds: 5
+profiles:
+- schedulerName: a
+`,
+			wantErr: []string{"podMaxBackoffSeconds: must be greater than or equal to podInitialBackoffSeconds, got 5"},
+		},
+		{
+			name: "invalid bind parallelism",
+			content: `
+bindParallelism: -1
+profiles:
+- schedulerName: a
+`,
+			wantErr: []string{"bindParallelism: must be greater than 0, got -1"},
+		},
+		{
+			name: "invalid parallelism",
+			content: `
+parallelism: -1
+percentageOfNodesToScore: 101
+profiles:
+- schedulerName: a
+`,
+			wantErr: []string{
+				"parallelism: must be greater than 0, got -1",
+				"percentageOfNodesToScore: must be between 0 and 100, got 101",
+			},
+		},
+		{
+			name: "invalid pod group timeout",
+			content: `
+podGroupTimeoutSeconds: -1
+profiles:
+- schedulerName: a
+`,
+			wantErr: []string{"podGroupTimeoutSeconds: must be greater than 0, got -1"},
+		},
+		{
+			name: "invalid metrics bind address",
+			content: `
+metricsBindAddress: "10251"
+profiles:
+- schedulerName: a
+`,
+			wantErr: []string{"metricsBindAddress: address 10251: missing port in address"},
+		},
+		{
+			name: "invalid leader election durations",
+			content: `
+leaderElection:
+  leaderElect: true
+  leaseDurationSeconds: 5
+  renewDeadlineSeconds: 6
+  retryPeriodSeconds: 5
+profiles:
+- schedulerName: a
+`,
+			wantErr: []string{
+				"leaderElection.renewDeadlineSeconds: must be greater than 1.2 * retryPeriodSeconds, got 6",
+				"leaderElection.leaseDurationSeconds: must be greater than renewDeadlineSeconds, got 5",
+			},
+		},
+		{
+			name: "unknown field",
+			content: `
+profiles:
+- schedulerName: a
+  plugin: {}
+`,
+			wantErr: []string{`unknown field "plugin"`},
+		},
+		{
+			name:    "broken yaml",
+			content: `profiles: [`,
+			wantErr: []string{"error parsing config file"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.content))
+			if err == nil {
+				t.Fatal("Load() error = nil, want error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() error = %q, want to contain %q", err.Error(), want)
+				}
+			}
+		})
+	}
+}
+
+// weight に 0 を書いた Score プラグインは、省略した場合と同じく 1 になる
+func TestLoad_ZeroWeight(t *testing.T) {
+	got, err := Load(writeConfig(t, `
+profiles:
+- schedulerName: a
+  plugins:
+    score:
+    - name: Random
+      weight: 0
+`))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if score := got.Profiles[0].Plugins.Score; len(score) != 1 || score[0].Weight != 1 {
+		t.Errorf("Profiles[0].Plugins.Score = %+v, want weight 1", score)
+	}
+}
+
+func TestLoad_FileNotFound(t *testing.T) {
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("Load() error = nil, want error")
+	}
+}
+
+func TestDefault(t *testing.T) {
+	got := Default("my-custom-scheduler")
+	if err := got.Validate(); err != nil {
+		t.Fatalf("Default().Validate() error = %v", err)
+	}
+	if len(got.Profiles) != 1 || got.Profiles[0].SchedulerName != "my-custom-scheduler" {
+		t.Errorf("Default().Profiles = %+v", got.Profiles)
+	}
+}
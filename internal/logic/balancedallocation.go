@@ -0,0 +1,106 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const NodeResourcesBalancedAllocationName = "NodeResourcesBalancedAllocation"
+
+// NodeResourcesBalancedAllocationArgs は NodeResourcesBalancedAllocation の引数
+type NodeResourcesBalancedAllocationArgs struct {
+	// 使用率を揃えたいリソース。upstream と同じく weight は使わないので 1 だけを許す
+	Resources []ResourceSpec `json:"resources"`
+}
+
+// DefaultNodeResourcesBalancedAllocationArgs は CPU とメモリの使用率を揃える設定を返す
+func DefaultNodeResourcesBalancedAllocationArgs() NodeResourcesBalancedAllocationArgs {
+	return NodeResourcesBalancedAllocationArgs{
+		Resources: []ResourceSpec{
+			{Name: string(v1.ResourceCPU), Weight: 1},
+			{Name: string(v1.ResourceMemory), Weight: 1},
+		},
+	}
+}
+
+// NodeResourcesBalancedAllocation は Pod を配置した後の各リソースの使用率が揃っているノードほど高いスコアにする
+// CPU だけ使い切ってメモリが余る、といった偏りを避けるためのもので、NodeResourcesFit の Score と組み合わせて使う
+type NodeResourcesBalancedAllocation struct {
+	scorer *resourceAllocationScorer
+}
+
+var _ ScorePlugin = &NodeResourcesBalancedAllocation{}
+
+func NewNodeResourcesBalancedAllocation(rawArgs json.RawMessage) (Plugin, error) {
+	args := DefaultNodeResourcesBalancedAllocationArgs()
+	if err := decodeArgs(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	if err := validateNodeResourcesBalancedAllocationArgs(args); err != nil {
+		return nil, err
+	}
+	s := &resourceAllocationScorer{
+		resources: args.Resources,
+		// upstream と同じく、要求量を書いていない Pod をデフォルト値で数えない
+		useRequested: true,
+	}
+	s.scorer = s.balancedResourceScore
+	return &NodeResourcesBalancedAllocation{scorer: s}, nil
+}
+
+func validateNodeResourcesBalancedAllocationArgs(args NodeResourcesBalancedAllocationArgs) error {
+	if len(args.Resources) == 0 {
+		return errors.New("resources: at least one resource is required")
+	}
+	errs := validateResources("resources", args.Resources)
+	for i, r := range args.Resources {
+		if r.Weight != 1 {
+			errs = append(errs, fmt.Errorf("resources[%d].weight: must be 1, got %d", i, r.Weight))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (b *NodeResourcesBalancedAllocation) Name() string {
+	return NodeResourcesBalancedAllocationName
+}
+
+func (b *NodeResourcesBalancedAllocation) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	return b.scorer.score(pod, nodeInfo), nil
+}
+
+// balancedResourceScore は各リソースの使用率の標準偏差 std から (1 - std) * MaxNodeScore を返す
+// 使用率は 1 で頭打ちにし、割り当て可能量が 0 のリソースは計算から外す
+func (s *resourceAllocationScorer) balancedResourceScore(requested, allocatable []int64) int64 {
+	var fractions []float64
+	var total float64
+	for i := range requested {
+		if allocatable[i] == 0 {
+			continue
+		}
+		fraction := min(float64(requested[i])/float64(allocatable[i]), 1)
+		fractions = append(fractions, fraction)
+		total += fraction
+	}
+
+	var std float64
+	switch len(fractions) {
+	case 0, 1:
+		// 比べる相手がないので偏りはない
+	case 2:
+		// 2 つの場合は |a - b| / 2 と同じになるので、平方根を取らずに済ませる
+		std = math.Abs((fractions[0] - fractions[1]) / 2)
+	default:
+		mean := total / float64(len(fractions))
+		var variance float64
+		for _, fraction := range fractions {
+			variance += (fraction - mean) * (fraction - mean)
+		}
+		std = math.Sqrt(variance / float64(len(fractions)))
+	}
+	return int64((1 - std) * float64(MaxNodeScore))
+}
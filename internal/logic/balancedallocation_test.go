@@ -0,0 +1,129 @@
+package logic
+
+import (
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestNewNodeResourcesBalancedAllocation(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr bool
+	}{
+		{name: "success: default args", args: ""},
+		{name: "success: custom resources", args: `{"resources":[{"name":"cpu","weight":1},{"name":"memory","weight":1},{"name":"nvidia.com/gpu","weight":1}]}`},
+		{name: "failure: unknown field", args: `{"resource":[]}`, wantErr: true},
+		{name: "failure: empty resources", args: `{"resources":[]}`, wantErr: true},
+		{name: "failure: weight other than 1", args: `{"resources":[{"name":"cpu","weight":2}]}`, wantErr: true},
+		{name: "failure: duplicated resource", args: `{"resources":[{"name":"cpu","weight":1},{"name":"cpu","weight":1}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNodeResourcesBalancedAllocation(json.RawMessage(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNodeResourcesBalancedAllocation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNodeResourcesBalancedAllocation_Score(t *testing.T) {
+	node := newResourceNode("node", v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("4"),
+		v1.ResourceMemory: resource.MustParse("4Gi"),
+		"nvidia.com/gpu":  resource.MustParse("4"),
+	})
+	existing := newRequestPod("existing", v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("1"),
+		v1.ResourceMemory: resource.MustParse("1Gi"),
+	})
+	withGPU := `{"resources":[{"name":"cpu","weight":1},{"name":"memory","weight":1},{"name":"nvidia.com/gpu","weight":1}]}`
+
+	tests := []struct {
+		name     string
+		args     string
+		pod      *v1.Pod
+		nodeInfo *NodeInfo
+		want     int64
+	}{
+		{
+			name: "unbalanced cpu and memory",
+			pod: newRequestPod("pod", v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				v1.ResourceMemory: resource.MustParse("2Gi"),
+			}),
+			nodeInfo: NewNodeInfo(node, existing),
+			// CPU: 2/4 = 0.5, メモリ: 3/4 = 0.75, std = |0.5 - 0.75| / 2 = 0.125, (1 - 0.125) * 100 = 87
+			want: 87,
+		},
+		{
+			name: "balanced cpu and memory",
+			pod: newRequestPod("pod", v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				v1.ResourceMemory: resource.MustParse("1Gi"),
+			}),
+			nodeInfo: NewNodeInfo(node, existing),
+			// CPU, メモリともに 0.5
+			want: MaxNodeScore,
+		},
+		{
+			name: "three resources",
+			args: withGPU,
+			pod: newRequestPod("pod", v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				v1.ResourceMemory: resource.MustParse("2Gi"),
+				"nvidia.com/gpu":  resource.MustParse("2"),
+			}),
+			nodeInfo: NewNodeInfo(node, existing),
+			// 0.5, 0.75, 0.5 の平均は 7/12
+			// 分散 = ((1/12)^2 + (2/12)^2 + (1/12)^2) / 3 = 1/72, std = 0.1178..., (1 - std) * 100 = 88
+			want: 88,
+		},
+		{
+			name: "resource the node does not have is ignored",
+			args: withGPU,
+			pod: newRequestPod("pod", v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("1"),
+				v1.ResourceMemory: resource.MustParse("2Gi"),
+			}),
+			nodeInfo: NewNodeInfo(newResourceNode("node", v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("4"),
+				v1.ResourceMemory: resource.MustParse("4Gi"),
+			}), existing),
+			want: 87,
+		},
+		{
+			name:     "fraction is capped at 1",
+			pod:      newRequestPod("pod", v1.ResourceList{v1.ResourceCPU: resource.MustParse("8")}),
+			nodeInfo: NewNodeInfo(node),
+			// CPU: min(8/4, 1) = 1, メモリ: 0, std = 0.5
+			want: 50,
+		},
+		{
+			// 要求量を書いていない Pod はデフォルト値で数えないので、偏りはない
+			name:     "pod without requests",
+			pod:      newRequestPod("pod", nil),
+			nodeInfo: NewNodeInfo(node),
+			want:     MaxNodeScore,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewNodeResourcesBalancedAllocation(json.RawMessage(tt.args))
+			if err != nil {
+				t.Fatalf("NewNodeResourcesBalancedAllocation() error = %v", err)
+			}
+			got, status := p.(ScorePlugin).Score(NewCycleState(), tt.pod, tt.nodeInfo)
+			if !status.IsSuccess() {
+				t.Fatalf("Score() status = %v", status)
+			}
+			if got != tt.want {
+				t.Errorf("Score() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
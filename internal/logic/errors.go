@@ -0,0 +1,38 @@
+package logic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// FitError は Pod を配置できるノードが 1 つもなかったことを表す
+type FitError struct {
+	Pod         *v1.Pod
+	NumAllNodes int
+	// ノード名 → そのノードに配置できなかった理由
+	NodeToReasons map[string][]string
+}
+
+func (f *FitError) Error() string {
+	// 理由ごとに該当したノード数を数える
+	reasonCounts := map[string]int{}
+	for _, reasons := range f.NodeToReasons {
+		for _, reason := range reasons {
+			reasonCounts[reason]++
+		}
+	}
+	reasonStrings := make([]string, 0, len(reasonCounts))
+	for reason, count := range reasonCounts {
+		reasonStrings = append(reasonStrings, fmt.Sprintf("%d %s", count, reason))
+	}
+	sort.Strings(reasonStrings)
+
+	msg := fmt.Sprintf("0/%d nodes are available", f.NumAllNodes)
+	if len(reasonStrings) > 0 {
+		msg += ": " + strings.Join(reasonStrings, ", ")
+	}
+	return msg + "."
+}
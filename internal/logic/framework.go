@@ -0,0 +1,244 @@
+package logic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ScorePlugin が返すスコアの範囲
+	MinNodeScore int64 = 0
+	MaxNodeScore int64 = 100
+)
+
+// Plugin はスケジューリングのルール 1 つを表す
+type Plugin interface {
+	Name() string
+}
+
+// PreFilterPlugin は Filter の前に 1 度だけ呼ばれ、クラスタ全体から
+// Filter で使う情報を集めて CycleState に書き込む
+type PreFilterPlugin interface {
+	Plugin
+	PreFilter(state *CycleState, pod *v1.Pod, snapshot *Snapshot) *Status
+}
+
+// PreFilterExtensions を実装した PreFilterPlugin は、PreFilter の後でノードの Pod が増減したときに
+// CycleState に書き込んだ状態を更新できる。プリエンプションで、Pod を退避させた後に配置できるかを確かめるのに使う
+// 配置済み Pod を数えるプラグインは実装しないと、退避させても状態が変わらないものとして判定される
+type PreFilterExtensions interface {
+	AddPod(state *CycleState, pod *v1.Pod, podToAdd *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) *Status
+	RemovePod(state *CycleState, pod *v1.Pod, podToRemove *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) *Status
+}
+
+// FilterPlugin は Pod をノードに配置して良いかを判定する
+// 配置できない場合は Unschedulable の Status に理由を入れて返す
+// ノードの Pod を退避させても解消しない理由なら UnschedulableAndUnresolvable を返す
+type FilterPlugin interface {
+	Plugin
+	Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status
+}
+
+// PreScorePlugin は Score の前に 1 度だけ呼ばれ、Filter を通ったノードの一覧から
+// Score で使う情報を集めて CycleState に書き込む
+type PreScorePlugin interface {
+	Plugin
+	PreScore(state *CycleState, pod *v1.Pod, snapshot *Snapshot, nodes []*NodeInfo) *Status
+}
+
+// ScorePlugin は Pod を配置するノードの良さを MinNodeScore 〜 MaxNodeScore で返す
+type ScorePlugin interface {
+	Plugin
+	Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status)
+}
+
+// ScoreNormalizer を実装した ScorePlugin は、全ノードのスコアが出揃った後に
+// スコアを MinNodeScore 〜 MaxNodeScore の範囲に正規化できる
+type ScoreNormalizer interface {
+	NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) *Status
+}
+
+// StateKey は CycleState にデータを書き込むときのキー。プラグイン名を含めて衝突しないようにする
+type StateKey string
+
+// StateData は CycleState に書き込むデータ
+type StateData interface{}
+
+// StateCloner を実装した StateData は、CycleState.Clone で複製される
+// PreFilterExtensions で書き換えるデータは実装する。実装しないデータは複製元と共有する
+type StateCloner interface {
+	Clone() StateData
+}
+
+// CycleState は 1 つの Pod をスケジュールする間だけ有効な、プラグイン間で共有する状態
+// PreFilter / PreScore で計算した結果を Filter / Score に渡すために使う
+type CycleState struct {
+	mu      sync.RWMutex
+	storage map[StateKey]StateData
+}
+
+func NewCycleState() *CycleState {
+	return &CycleState{storage: map[StateKey]StateData{}}
+}
+
+// Read は key に対応するデータを返す。書き込まれていなければエラーを返す
+func (c *CycleState) Read(key StateKey) (StateData, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if v, ok := c.storage[key]; ok {
+		return v, nil
+	}
+	return nil, fmt.Errorf("%s not found in cycle state", key)
+}
+
+func (c *CycleState) Write(key StateKey, val StateData) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.storage[key] = val
+}
+
+// Clone は CycleState を複製する。複製した方にだけ PreFilterExtensions を適用するために使う
+func (c *CycleState) Clone() *CycleState {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	clone := &CycleState{storage: make(map[StateKey]StateData, len(c.storage))}
+	for key, val := range c.storage {
+		if cloner, ok := val.(StateCloner); ok {
+			val = cloner.Clone()
+		}
+		clone.storage[key] = val
+	}
+	return clone
+}
+
+// NodeScore はノード 1 つ分のスコア
+type NodeScore struct {
+	Name  string
+	Score int64
+}
+
+type NodeScoreList []NodeScore
+
+// Code はプラグインの判定結果の種類
+type Code int
+
+const (
+	// Success は問題がなかったことを表す。nil の *Status も Success として扱う
+	Success Code = iota
+	// Unschedulable は Pod をそのノードに配置できないことを表す
+	Unschedulable
+	// UnschedulableAndUnresolvable は Pod をそのノードに配置できず、
+	// ノードの Pod を退避させても配置できるようにならないことを表す。プリエンプションではそのノードを調べない
+	UnschedulableAndUnresolvable
+	// Error はプラグインの内部エラーを表す
+	Error
+)
+
+func (c Code) String() string {
+	switch c {
+	case Success:
+		return "Success"
+	case Unschedulable:
+		return "Unschedulable"
+	case UnschedulableAndUnresolvable:
+		return "UnschedulableAndUnresolvable"
+	case Error:
+		return "Error"
+	}
+	return fmt.Sprintf("Code(%d)", int(c))
+}
+
+// 拡張点の名前。MetricsRecorder に渡す
+const (
+	ExtensionPointPreFilter      = "PreFilter"
+	ExtensionPointFilter         = "Filter"
+	ExtensionPointPreScore       = "PreScore"
+	ExtensionPointScore          = "Score"
+	ExtensionPointNormalizeScore = "NormalizeScore"
+)
+
+// MetricsRecorder はプラグインの実行時間を記録する
+// Filter と Score はノードごとに並行に実行するので、複数の goroutine から同時に呼ばれる
+type MetricsRecorder interface {
+	ObservePluginDuration(extensionPoint, plugin string, code Code, duration time.Duration)
+}
+
+// Status はプラグインの判定結果
+type Status struct {
+	code    Code
+	reasons []string
+	err     error
+}
+
+func NewStatus(code Code, reasons ...string) *Status {
+	return &Status{code: code, reasons: reasons}
+}
+
+// AsStatus はエラーを Error の Status に変換する
+func AsStatus(err error) *Status {
+	if err == nil {
+		return nil
+	}
+	return &Status{code: Error, reasons: []string{err.Error()}, err: err}
+}
+
+func (s *Status) Code() Code {
+	if s == nil {
+		return Success
+	}
+	return s.code
+}
+
+func (s *Status) IsSuccess() bool {
+	return s.Code() == Success
+}
+
+func (s *Status) Reasons() []string {
+	if s == nil {
+		return nil
+	}
+	return s.reasons
+}
+
+// AsError は Success 以外の Status をエラーに変換する。Success の場合は nil を返す
+func (s *Status) AsError() error {
+	if s.IsSuccess() {
+		return nil
+	}
+	if s.err != nil {
+		return s.err
+	}
+	return errors.New(strings.Join(s.reasons, ", "))
+}
+
+// DefaultNormalizeScore はスコアを最大値が maxPriority になるよう比例で拡大・縮小する
+// reverse が true の場合は、元のスコアが小さいほど高いスコアになるよう反転する
+func DefaultNormalizeScore(maxPriority int64, reverse bool, scores NodeScoreList) *Status {
+	var maxCount int64
+	for i := range scores {
+		maxCount = max(maxCount, scores[i].Score)
+	}
+
+	if maxCount == 0 {
+		if reverse {
+			for i := range scores {
+				scores[i].Score = maxPriority
+			}
+		}
+		return nil
+	}
+
+	for i := range scores {
+		score := maxPriority * scores[i].Score / maxCount
+		if reverse {
+			score = maxPriority - score
+		}
+		scores[i].Score = score
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package logic
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// DefaultBindAllHostIP は hostIP を省略したときに使われる、すべてのアドレスを表す IP
+const DefaultBindAllHostIP = "0.0.0.0"
+
+// ProtocolPort はプロトコルとポート番号の組
+type ProtocolPort struct {
+	Protocol string
+	Port     int32
+}
+
+// HostPortInfo はノードで使われている hostPort を IP ごとにまとめたもの
+type HostPortInfo map[string]map[ProtocolPort]struct{}
+
+// Add は使われている hostPort を追加する
+func (h HostPortInfo) Add(ip, protocol string, port int32) {
+	if port <= 0 {
+		return
+	}
+	ip, protocol = sanitizeHostPort(ip, protocol)
+	if _, ok := h[ip]; !ok {
+		h[ip] = map[ProtocolPort]struct{}{}
+	}
+	h[ip][ProtocolPort{Protocol: protocol, Port: port}] = struct{}{}
+}
+
+// CheckConflict は ip / protocol / port がすでに使われているかを返す
+// 0.0.0.0 はすべての IP と衝突する
+func (h HostPortInfo) CheckConflict(ip, protocol string, port int32) bool {
+	if port <= 0 {
+		return false
+	}
+	ip, protocol = sanitizeHostPort(ip, protocol)
+	pp := ProtocolPort{Protocol: protocol, Port: port}
+
+	if ip == DefaultBindAllHostIP {
+		for _, ports := range h {
+			if _, ok := ports[pp]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	for _, key := range []string{ip, DefaultBindAllHostIP} {
+		if _, ok := h[key][pp]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hostIP と protocol を省略した場合は 0.0.0.0 と TCP として扱う
+func sanitizeHostPort(ip, protocol string) (string, string) {
+	if ip == "" {
+		ip = DefaultBindAllHostIP
+	}
+	if protocol == "" {
+		protocol = string(v1.ProtocolTCP)
+	}
+	return ip, protocol
+}
+
+// getContainerPorts は Pod のコンテナが宣言しているポートを返す
+// restartPolicy: Always の init コンテナ (サイドカー) は通常のコンテナと同時に動くので含める
+func getContainerPorts(pod *v1.Pod) []v1.ContainerPort {
+	var ports []v1.ContainerPort
+	for _, c := range pod.Spec.InitContainers {
+		if c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			ports = append(ports, c.Ports...)
+		}
+	}
+	for _, c := range pod.Spec.Containers {
+		ports = append(ports, c.Ports...)
+	}
+	return ports
+}
@@ -0,0 +1,534 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const InterPodAffinityName = "InterPodAffinity"
+
+const (
+	affinityPreFilterStateKey StateKey = "PreFilter" + InterPodAffinityName
+	affinityPreScoreStateKey  StateKey = "PreScore" + InterPodAffinityName
+)
+
+// InterPodAffinity の Filter が返す理由
+const (
+	errReasonExistingAntiAffinityRulesNotMatch = "node(s) didn't satisfy existing pods anti-affinity rules"
+	errReasonAffinityRulesNotMatch             = "node(s) didn't match pod affinity rules"
+	errReasonAntiAffinityRulesNotMatch         = "node(s) didn't match pod anti-affinity rules"
+)
+
+// InterPodAffinityArgs は InterPodAffinity の引数
+type InterPodAffinityArgs struct {
+	// 配置済み Pod が持つ required な pod affinity を、スコアで何点として扱うか (0 〜 100)
+	HardPodAffinityWeight int32 `json:"hardPodAffinityWeight"`
+}
+
+func DefaultInterPodAffinityArgs() InterPodAffinityArgs {
+	return InterPodAffinityArgs{HardPodAffinityWeight: 1}
+}
+
+// InterPodAffinity は配置済み Pod との pod affinity / anti-affinity を扱う
+//   - Filter: Pod の required な affinity / anti-affinity と、
+//     配置済み Pod の required な anti-affinity (対称性) を満たすノードだけを通す
+//   - Score: Pod の preferred な term と、配置済み Pod の affinity / preferred な term を
+//     トポロジー (topologyKey のラベル値) ごとに集計する
+type InterPodAffinity struct {
+	args InterPodAffinityArgs
+}
+
+var _ PreFilterPlugin = &InterPodAffinity{}
+var _ PreFilterExtensions = &InterPodAffinity{}
+var _ FilterPlugin = &InterPodAffinity{}
+var _ PreScorePlugin = &InterPodAffinity{}
+var _ ScorePlugin = &InterPodAffinity{}
+var _ ScoreNormalizer = &InterPodAffinity{}
+
+func NewInterPodAffinity(rawArgs json.RawMessage) (Plugin, error) {
+	args := DefaultInterPodAffinityArgs()
+	if err := decodeArgs(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	if args.HardPodAffinityWeight < 0 || args.HardPodAffinityWeight > 100 {
+		return nil, fmt.Errorf("hardPodAffinityWeight: must be in the range 0-100, got %d", args.HardPodAffinityWeight)
+	}
+	return &InterPodAffinity{args: args}, nil
+}
+
+func (pl *InterPodAffinity) Name() string {
+	return InterPodAffinityName
+}
+
+// affinityTerm は v1.PodAffinityTerm を評価しやすい形に変換したもの
+type affinityTerm struct {
+	namespaces        sets.Set[string]
+	namespaceSelector labels.Selector
+	selector          labels.Selector
+	topologyKey       string
+}
+
+type weightedAffinityTerm struct {
+	affinityTerm
+	weight int32
+}
+
+// term を変換する。namespaces と namespaceSelector のどちらも指定がなければ、
+// term を持つ Pod 自身の Namespace だけが対象になる
+func newAffinityTerm(pod *v1.Pod, term *v1.PodAffinityTerm) (affinityTerm, error) {
+	// nil の labelSelector はどの Pod にも一致しない
+	selector, err := metav1.LabelSelectorAsSelector(term.LabelSelector)
+	if err != nil {
+		return affinityTerm{}, err
+	}
+	namespaces := sets.New(term.Namespaces...)
+	if len(term.Namespaces) == 0 && term.NamespaceSelector == nil {
+		namespaces.Insert(pod.Namespace)
+	}
+	// 空の namespaceSelector はすべての Namespace に一致する
+	namespaceSelector, err := metav1.LabelSelectorAsSelector(term.NamespaceSelector)
+	if err != nil {
+		return affinityTerm{}, err
+	}
+	return affinityTerm{
+		namespaces:        namespaces,
+		namespaceSelector: namespaceSelector,
+		selector:          selector,
+		topologyKey:       term.TopologyKey,
+	}, nil
+}
+
+func newAffinityTerms(pod *v1.Pod, terms []v1.PodAffinityTerm) ([]affinityTerm, error) {
+	result := make([]affinityTerm, 0, len(terms))
+	for i := range terms {
+		t, err := newAffinityTerm(pod, &terms[i])
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, nil
+}
+
+func newWeightedAffinityTerms(pod *v1.Pod, terms []v1.WeightedPodAffinityTerm) ([]weightedAffinityTerm, error) {
+	result := make([]weightedAffinityTerm, 0, len(terms))
+	for i := range terms {
+		// weight が 0 の term は何の意味も持たない
+		if terms[i].Weight == 0 {
+			continue
+		}
+		t, err := newAffinityTerm(pod, &terms[i].PodAffinityTerm)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, weightedAffinityTerm{affinityTerm: t, weight: terms[i].Weight})
+	}
+	return result, nil
+}
+
+// Pod が term の Namespace と labelSelector に一致するかを返す
+// nsLabels は pod の Namespace のラベル
+func (t *affinityTerm) matches(pod *v1.Pod, nsLabels labels.Set) bool {
+	if !t.namespaces.Has(pod.Namespace) && !t.namespaceSelector.Matches(nsLabels) {
+		return false
+	}
+	return t.selector.Matches(labels.Set(pod.Labels))
+}
+
+// podAffinityTerms は Pod が持つ pod affinity / anti-affinity の term をまとめたもの
+type podAffinityTerms struct {
+	requiredAffinity      []affinityTerm
+	requiredAntiAffinity  []affinityTerm
+	preferredAffinity     []weightedAffinityTerm
+	preferredAntiAffinity []weightedAffinityTerm
+}
+
+func getPodAffinityTerms(pod *v1.Pod) (*podAffinityTerms, error) {
+	terms := &podAffinityTerms{}
+	affinity := pod.Spec.Affinity
+	if affinity == nil {
+		return terms, nil
+	}
+	var err error
+	if a := affinity.PodAffinity; a != nil {
+		if terms.requiredAffinity, err = newAffinityTerms(pod, a.RequiredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, fmt.Errorf("parsing pod affinity of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		if terms.preferredAffinity, err = newWeightedAffinityTerms(pod, a.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, fmt.Errorf("parsing pod affinity of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	if a := affinity.PodAntiAffinity; a != nil {
+		if terms.requiredAntiAffinity, err = newAffinityTerms(pod, a.RequiredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, fmt.Errorf("parsing pod anti-affinity of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+		if terms.preferredAntiAffinity, err = newWeightedAffinityTerms(pod, a.PreferredDuringSchedulingIgnoredDuringExecution); err != nil {
+			return nil, fmt.Errorf("parsing pod anti-affinity of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+		}
+	}
+	return terms, nil
+}
+
+// pod が terms のすべてに一致するかを返す。terms が空の場合は false を返す
+func podMatchesAllAffinityTerms(terms []affinityTerm, pod *v1.Pod, nsLabels labels.Set) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for i := range terms {
+		if !terms[i].matches(pod, nsLabels) {
+			return false
+		}
+	}
+	return true
+}
+
+// topologyPair はトポロジーのドメイン 1 つ (例: topology.kubernetes.io/zone=zone-a)
+type topologyPair struct {
+	key   string
+	value string
+}
+
+type topologyToMatchedTermCount map[topologyPair]int64
+
+// node が topologyKey のラベルを持っていれば、そのドメインのカウントを増やす
+// 0 になったドメインは消し、一致する Pod が 1 つもないことを len で判定できるようにする
+func (m topologyToMatchedTermCount) add(node *v1.Node, topologyKey string, value int64) {
+	v, ok := node.Labels[topologyKey]
+	if !ok {
+		return
+	}
+	pair := topologyPair{key: topologyKey, value: v}
+	if m[pair] += value; m[pair] == 0 {
+		delete(m, pair)
+	}
+}
+
+func (m topologyToMatchedTermCount) clone() topologyToMatchedTermCount {
+	c := make(topologyToMatchedTermCount, len(m))
+	for pair, count := range m {
+		c[pair] = count
+	}
+	return c
+}
+
+type affinityPreFilterState struct {
+	// incoming Pod が、配置済み Pod の required な anti-affinity に一致するドメインごとの数
+	existingAntiAffinityCounts topologyToMatchedTermCount
+	// incoming Pod の required な affinity のすべてに一致する配置済み Pod のドメインごとの数
+	affinityCounts topologyToMatchedTermCount
+	// incoming Pod の required な anti-affinity に一致する配置済み Pod のドメインごとの数
+	antiAffinityCounts topologyToMatchedTermCount
+	terms              *podAffinityTerms
+	// incoming Pod の Namespace のラベル
+	namespaceLabels labels.Set
+}
+
+// Clone は PreFilterExtensions で書き換えるカウントだけを複製する
+func (s *affinityPreFilterState) Clone() StateData {
+	c := *s
+	c.existingAntiAffinityCounts = s.existingAntiAffinityCounts.clone()
+	c.affinityCounts = s.affinityCounts.clone()
+	c.antiAffinityCounts = s.antiAffinityCounts.clone()
+	return &c
+}
+
+// updateWithPod は node の配置済み Pod existingPod の分だけ、ドメインごとの一致数を multiplier (1 か -1) 増やす
+// PreFilter で配置済み Pod を数えるのと同じ条件で数える
+func (s *affinityPreFilterState) updateWithPod(pod, existingPod *v1.Pod, node *v1.Node, existingNSLabels labels.Set, multiplier int64) error {
+	if podHasRequiredAntiAffinity(existingPod) {
+		existingTerms, err := getPodAffinityTerms(existingPod)
+		if err != nil {
+			return err
+		}
+		for i := range existingTerms.requiredAntiAffinity {
+			t := &existingTerms.requiredAntiAffinity[i]
+			if t.matches(pod, s.namespaceLabels) {
+				s.existingAntiAffinityCounts.add(node, t.topologyKey, multiplier)
+			}
+		}
+	}
+	if podMatchesAllAffinityTerms(s.terms.requiredAffinity, existingPod, existingNSLabels) {
+		for i := range s.terms.requiredAffinity {
+			s.affinityCounts.add(node, s.terms.requiredAffinity[i].topologyKey, multiplier)
+		}
+	}
+	for i := range s.terms.requiredAntiAffinity {
+		t := &s.terms.requiredAntiAffinity[i]
+		if t.matches(existingPod, existingNSLabels) {
+			s.antiAffinityCounts.add(node, t.topologyKey, multiplier)
+		}
+	}
+	return nil
+}
+
+// PreFilter はクラスタ全体の配置済み Pod から、トポロジーのドメインごとの一致数を数える
+func (pl *InterPodAffinity) PreFilter(state *CycleState, pod *v1.Pod, snapshot *Snapshot) *Status {
+	terms, err := getPodAffinityTerms(pod)
+	if err != nil {
+		return AsStatus(err)
+	}
+	s := &affinityPreFilterState{
+		existingAntiAffinityCounts: topologyToMatchedTermCount{},
+		affinityCounts:             topologyToMatchedTermCount{},
+		antiAffinityCounts:         topologyToMatchedTermCount{},
+		terms:                      terms,
+		namespaceLabels:            snapshot.NamespaceLabels(pod.Namespace),
+	}
+
+	// 対称性: 配置済み Pod の anti-affinity に incoming Pod が一致すれば、そのドメインには置けない
+	for _, nodeInfo := range snapshot.HavePodsWithRequiredAntiAffinityList() {
+		for _, existingPod := range nodeInfo.PodsWithRequiredAntiAffinity {
+			existingTerms, err := getPodAffinityTerms(existingPod)
+			if err != nil {
+				return AsStatus(err)
+			}
+			for i := range existingTerms.requiredAntiAffinity {
+				t := &existingTerms.requiredAntiAffinity[i]
+				if t.matches(pod, s.namespaceLabels) {
+					s.existingAntiAffinityCounts.add(nodeInfo.Node, t.topologyKey, 1)
+				}
+			}
+		}
+	}
+
+	if len(terms.requiredAffinity) == 0 && len(terms.requiredAntiAffinity) == 0 {
+		state.Write(affinityPreFilterStateKey, s)
+		return nil
+	}
+	for _, nodeInfo := range snapshot.NodeInfos() {
+		for _, existingPod := range nodeInfo.Pods {
+			nsLabels := snapshot.NamespaceLabels(existingPod.Namespace)
+			if podMatchesAllAffinityTerms(terms.requiredAffinity, existingPod, nsLabels) {
+				for i := range terms.requiredAffinity {
+					s.affinityCounts.add(nodeInfo.Node, terms.requiredAffinity[i].topologyKey, 1)
+				}
+			}
+			for i := range terms.requiredAntiAffinity {
+				t := &terms.requiredAntiAffinity[i]
+				if t.matches(existingPod, nsLabels) {
+					s.antiAffinityCounts.add(nodeInfo.Node, t.topologyKey, 1)
+				}
+			}
+		}
+	}
+	state.Write(affinityPreFilterStateKey, s)
+	return nil
+}
+
+func getAffinityPreFilterState(state *CycleState) (*affinityPreFilterState, error) {
+	c, err := state.Read(affinityPreFilterStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(*affinityPreFilterState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to affinityPreFilterState error", c)
+	}
+	return s, nil
+}
+
+// AddPod は nodeInfo に podToAdd が配置されたものとして、PreFilter で数えた一致数を更新する
+func (pl *InterPodAffinity) AddPod(state *CycleState, pod *v1.Pod, podToAdd *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) *Status {
+	s, err := getAffinityPreFilterState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	return AsStatus(s.updateWithPod(pod, podToAdd, nodeInfo.Node, snapshot.NamespaceLabels(podToAdd.Namespace), 1))
+}
+
+// RemovePod は nodeInfo から podToRemove が退避したものとして、PreFilter で数えた一致数を更新する
+func (pl *InterPodAffinity) RemovePod(state *CycleState, pod *v1.Pod, podToRemove *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) *Status {
+	s, err := getAffinityPreFilterState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	return AsStatus(s.updateWithPod(pod, podToRemove, nodeInfo.Node, snapshot.NamespaceLabels(podToRemove.Namespace), -1))
+}
+
+func (pl *InterPodAffinity) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	s, err := getAffinityPreFilterState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	node := nodeInfo.Node
+
+	for pair, count := range s.existingAntiAffinityCounts {
+		if v, ok := node.Labels[pair.key]; ok && v == pair.value && count > 0 {
+			return NewStatus(Unschedulable, errReasonExistingAntiAffinityRulesNotMatch)
+		}
+	}
+
+	for _, t := range s.terms.requiredAntiAffinity {
+		if v, ok := node.Labels[t.topologyKey]; ok && s.antiAffinityCounts[topologyPair{key: t.topologyKey, value: v}] > 0 {
+			return NewStatus(Unschedulable, errReasonAntiAffinityRulesNotMatch)
+		}
+	}
+
+	if !satisfyPodAffinity(s, pod, node) {
+		return NewStatus(UnschedulableAndUnresolvable, errReasonAffinityRulesNotMatch)
+	}
+	return nil
+}
+
+// ノードが incoming Pod の required な affinity をすべて満たすかを返す
+func satisfyPodAffinity(s *affinityPreFilterState, pod *v1.Pod, node *v1.Node) bool {
+	podsExist := true
+	for _, t := range s.terms.requiredAffinity {
+		v, ok := node.Labels[t.topologyKey]
+		if !ok {
+			// topologyKey のラベルを持たないノードは、どのドメインにも属さない
+			return false
+		}
+		if s.affinityCounts[topologyPair{key: t.topologyKey, value: v}] <= 0 {
+			podsExist = false
+		}
+	}
+	if podsExist {
+		return true
+	}
+	// 一致する Pod がクラスタに 1 つもなく、incoming Pod 自身が term に一致する場合は、
+	// 最初の 1 つとして配置を許す (例: 同じ Deployment のレプリカ同士の affinity)
+	return len(s.affinityCounts) == 0 && podMatchesAllAffinityTerms(s.terms.requiredAffinity, pod, s.namespaceLabels)
+}
+
+// affinityPreScoreState はトポロジーのキー → ラベル値 → スコア
+type affinityPreScoreState struct {
+	topologyScore map[string]map[string]int64
+}
+
+func (s *affinityPreScoreState) add(node *v1.Node, topologyKey string, weight int64) {
+	v, ok := node.Labels[topologyKey]
+	if !ok || weight == 0 {
+		return
+	}
+	if s.topologyScore[topologyKey] == nil {
+		s.topologyScore[topologyKey] = map[string]int64{}
+	}
+	s.topologyScore[topologyKey][v] += weight
+}
+
+// PreScore は配置済み Pod ごとに、incoming Pod との affinity / anti-affinity を
+// 配置済み Pod のいるドメインのスコアとして集計する
+func (pl *InterPodAffinity) PreScore(state *CycleState, pod *v1.Pod, snapshot *Snapshot, nodes []*NodeInfo) *Status {
+	terms, err := getPodAffinityTerms(pod)
+	if err != nil {
+		return AsStatus(err)
+	}
+	s := &affinityPreScoreState{topologyScore: map[string]map[string]int64{}}
+	nsLabels := snapshot.NamespaceLabels(pod.Namespace)
+
+	// incoming Pod が preferred な term を持たなければ、affinity を持つ配置済み Pod だけを見れば良い
+	hasConstraints := len(terms.preferredAffinity) > 0 || len(terms.preferredAntiAffinity) > 0
+	nodeInfos := snapshot.HavePodsWithAffinityList()
+	if hasConstraints {
+		nodeInfos = snapshot.NodeInfos()
+	}
+	for _, nodeInfo := range nodeInfos {
+		podsToProcess := nodeInfo.PodsWithAffinity
+		if hasConstraints {
+			podsToProcess = nodeInfo.Pods
+		}
+		for _, existingPod := range podsToProcess {
+			if err := pl.processExistingPod(s, pod, nsLabels, terms, existingPod, snapshot.NamespaceLabels(existingPod.Namespace), nodeInfo.Node); err != nil {
+				return AsStatus(err)
+			}
+		}
+	}
+	state.Write(affinityPreScoreStateKey, s)
+	return nil
+}
+
+func (pl *InterPodAffinity) processExistingPod(s *affinityPreScoreState, pod *v1.Pod, nsLabels labels.Set, terms *podAffinityTerms, existingPod *v1.Pod, existingNSLabels labels.Set, existingNode *v1.Node) error {
+	// incoming Pod の preferred な term に配置済み Pod が一致するか
+	for _, t := range terms.preferredAffinity {
+		if t.matches(existingPod, existingNSLabels) {
+			s.add(existingNode, t.topologyKey, int64(t.weight))
+		}
+	}
+	for _, t := range terms.preferredAntiAffinity {
+		if t.matches(existingPod, existingNSLabels) {
+			s.add(existingNode, t.topologyKey, -int64(t.weight))
+		}
+	}
+
+	if !podHasAffinityConstraints(existingPod) {
+		return nil
+	}
+	existingTerms, err := getPodAffinityTerms(existingPod)
+	if err != nil {
+		return err
+	}
+	// 配置済み Pod の required な affinity は hardPodAffinityWeight として扱う
+	// required な anti-affinity は Filter で弾いているのでスコアには含めない
+	if pl.args.HardPodAffinityWeight > 0 {
+		for _, t := range existingTerms.requiredAffinity {
+			if t.matches(pod, nsLabels) {
+				s.add(existingNode, t.topologyKey, int64(pl.args.HardPodAffinityWeight))
+			}
+		}
+	}
+	for _, t := range existingTerms.preferredAffinity {
+		if t.matches(pod, nsLabels) {
+			s.add(existingNode, t.topologyKey, int64(t.weight))
+		}
+	}
+	for _, t := range existingTerms.preferredAntiAffinity {
+		if t.matches(pod, nsLabels) {
+			s.add(existingNode, t.topologyKey, -int64(t.weight))
+		}
+	}
+	return nil
+}
+
+func getAffinityPreScoreState(state *CycleState) (*affinityPreScoreState, error) {
+	c, err := state.Read(affinityPreScoreStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(*affinityPreScoreState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to affinityPreScoreState error", c)
+	}
+	return s, nil
+}
+
+// Score はノードが属するドメインのスコアを合計する。負の値になることもあるので NormalizeScore で正規化する
+func (pl *InterPodAffinity) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	s, err := getAffinityPreScoreState(state)
+	if err != nil {
+		return 0, AsStatus(err)
+	}
+	var score int64
+	for topologyKey, values := range s.topologyScore {
+		if v, ok := nodeInfo.Node.Labels[topologyKey]; ok {
+			score += values[v]
+		}
+	}
+	return score, nil
+}
+
+// 最小のスコアが MinNodeScore、最大のスコアが MaxNodeScore になるよう線形に変換する
+func (pl *InterPodAffinity) NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) *Status {
+	if len(scores) == 0 {
+		return nil
+	}
+	minCount, maxCount := scores[0].Score, scores[0].Score
+	for i := range scores {
+		minCount = min(minCount, scores[i].Score)
+		maxCount = max(maxCount, scores[i].Score)
+	}
+	diff := maxCount - minCount
+	for i := range scores {
+		if diff > 0 {
+			scores[i].Score = MaxNodeScore * (scores[i].Score - minCount) / diff
+		} else {
+			scores[i].Score = 0
+		}
+	}
+	return nil
+}
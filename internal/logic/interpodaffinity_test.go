@@ -0,0 +1,308 @@
+package logic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testHostnameKey = "kubernetes.io/hostname"
+	testZoneKey     = "topology.kubernetes.io/zone"
+)
+
+func newZoneNode(name, zone string) v1.Node {
+	return v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{testHostnameKey: name, testZoneKey: zone}}}
+}
+
+func newLabeledPod(name, namespace, nodeName string, podLabels map[string]string, affinity *v1.Affinity) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: podLabels},
+		Spec:       v1.PodSpec{NodeName: nodeName, Affinity: affinity},
+	}
+}
+
+func podAffinityTerm(topologyKey string, matchLabels map[string]string) v1.PodAffinityTerm {
+	return v1.PodAffinityTerm{
+		LabelSelector: &metav1.LabelSelector{MatchLabels: matchLabels},
+		TopologyKey:   topologyKey,
+	}
+}
+
+func TestNewInterPodAffinity(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr bool
+	}{
+		{name: "success: default args", args: ""},
+		{name: "success: custom args", args: `{"hardPodAffinityWeight":100}`},
+		{name: "failure: unknown field", args: `{"weight":1}`, wantErr: true},
+		{name: "failure: negative weight", args: `{"hardPodAffinityWeight":-1}`, wantErr: true},
+		{name: "failure: too large weight", args: `{"hardPodAffinityWeight":101}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewInterPodAffinity(json.RawMessage(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewInterPodAffinity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInterPodAffinity_Filter(t *testing.T) {
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newZoneNode("node-a1", "zone-a"),
+		newZoneNode("node-a2", "zone-a"),
+		newZoneNode("node-b1", "zone-b"),
+		// topologyKey のラベルを持たないノード
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-none"}},
+	}}
+	web := map[string]string{"app": "web"}
+	db := map[string]string{"app": "db"}
+	namespaces := []*v1.Namespace{
+		{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}},
+	}
+
+	tests := []struct {
+		name         string
+		pod          *v1.Pod
+		assignedPods []*v1.Pod
+		// ノード名 → Filter の理由。含まれないノードは通る
+		want map[string]string
+	}{
+		{
+			name: "success: no affinity",
+			pod:  newLabeledPod("web", "default", "", web, nil),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("web-0", "default", "node-a1", web, nil),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "anti-affinity by hostname keeps replicas apart",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testHostnameKey, web)},
+			}}),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("web-0", "default", "node-a1", web, nil),
+				newLabeledPod("db-0", "default", "node-a2", db, nil),
+			},
+			want: map[string]string{"node-a1": errReasonAntiAffinityRulesNotMatch},
+		},
+		{
+			name: "anti-affinity by zone rejects the whole zone",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testZoneKey, web)},
+			}}),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("web-0", "default", "node-a1", web, nil),
+			},
+			want: map[string]string{
+				"node-a1": errReasonAntiAffinityRulesNotMatch,
+				"node-a2": errReasonAntiAffinityRulesNotMatch,
+			},
+		},
+		{
+			name: "anti-affinity ignores pods in other namespaces",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testHostnameKey, web)},
+			}}),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("web-0", "team-a", "node-a1", web, nil),
+			},
+			want: map[string]string{},
+		},
+		{
+			name: "anti-affinity with namespaceSelector",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+					LabelSelector:     &metav1.LabelSelector{MatchLabels: web},
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+					TopologyKey:       testHostnameKey,
+				}},
+			}}),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("web-0", "team-a", "node-a1", web, nil),
+				// namespaceSelector を指定すると、自分の Namespace は自動では含まれない
+				newLabeledPod("web-1", "default", "node-a2", web, nil),
+			},
+			want: map[string]string{"node-a1": errReasonAntiAffinityRulesNotMatch},
+		},
+		{
+			name: "existing pod's anti-affinity is symmetric",
+			pod:  newLabeledPod("web", "default", "", web, nil),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("db-0", "default", "node-a1", db, &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testZoneKey, web)},
+				}}),
+			},
+			want: map[string]string{
+				"node-a1": errReasonExistingAntiAffinityRulesNotMatch,
+				"node-a2": errReasonExistingAntiAffinityRulesNotMatch,
+			},
+		},
+		{
+			name: "affinity requires a matching pod in the same zone",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{PodAffinity: &v1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testZoneKey, db)},
+			}}),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("db-0", "default", "node-b1", db, nil),
+			},
+			want: map[string]string{
+				"node-a1":   errReasonAffinityRulesNotMatch,
+				"node-a2":   errReasonAffinityRulesNotMatch,
+				"node-none": errReasonAffinityRulesNotMatch,
+			},
+		},
+		{
+			name: "affinity without matching pods rejects every node",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{PodAffinity: &v1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testZoneKey, db)},
+			}}),
+			want: map[string]string{
+				"node-a1":   errReasonAffinityRulesNotMatch,
+				"node-a2":   errReasonAffinityRulesNotMatch,
+				"node-b1":   errReasonAffinityRulesNotMatch,
+				"node-none": errReasonAffinityRulesNotMatch,
+			},
+		},
+		{
+			name: "first pod matching its own affinity is allowed",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{PodAffinity: &v1.PodAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testZoneKey, web)},
+			}}),
+			// topologyKey のラベルを持たないノードには配置できない
+			want: map[string]string{"node-none": errReasonAffinityRulesNotMatch},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewInterPodAffinity(nil)
+			if err != nil {
+				t.Fatalf("NewInterPodAffinity() error = %v", err)
+			}
+			pl := p.(*InterPodAffinity)
+			snapshot := NewSnapshot(nodes, tt.assignedPods, namespaces)
+			state := NewCycleState()
+			if status := pl.PreFilter(state, tt.pod, snapshot); !status.IsSuccess() {
+				t.Fatalf("InterPodAffinity.PreFilter() error = %v", status.AsError())
+			}
+			got := map[string]string{}
+			for _, nodeInfo := range snapshot.NodeInfos() {
+				status := pl.Filter(state, tt.pod, nodeInfo)
+				if status.Code() == Error {
+					t.Fatalf("InterPodAffinity.Filter() error = %v", status.AsError())
+				}
+				if !status.IsSuccess() {
+					got[nodeInfo.Node.Name] = status.Reasons()[0]
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InterPodAffinity.Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterPodAffinity_Score(t *testing.T) {
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newZoneNode("node-a1", "zone-a"),
+		newZoneNode("node-b1", "zone-b"),
+		newZoneNode("node-c1", "zone-c"),
+	}}
+	web := map[string]string{"app": "web"}
+	db := map[string]string{"app": "db"}
+
+	tests := []struct {
+		name         string
+		args         string
+		pod          *v1.Pod
+		assignedPods []*v1.Pod
+		want         map[string]int64
+	}{
+		{
+			name: "preferred affinity and anti-affinity",
+			pod: newLabeledPod("web", "default", "", web, &v1.Affinity{
+				PodAffinity: &v1.PodAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+					{Weight: 10, PodAffinityTerm: podAffinityTerm(testZoneKey, db)},
+				}},
+				PodAntiAffinity: &v1.PodAntiAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+					{Weight: 10, PodAffinityTerm: podAffinityTerm(testZoneKey, web)},
+				}},
+			}),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("db-0", "default", "node-a1", db, nil),
+				newLabeledPod("web-0", "default", "node-b1", web, nil),
+			},
+			// zone-a: +10, zone-b: -10, zone-c: 0 を 0 〜 100 に変換する
+			want: map[string]int64{"node-a1": 100, "node-b1": 0, "node-c1": 50},
+		},
+		{
+			name: "existing pod's required affinity counts as hardPodAffinityWeight",
+			args: `{"hardPodAffinityWeight":5}`,
+			pod:  newLabeledPod("web", "default", "", web, nil),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("db-0", "default", "node-a1", db, &v1.Affinity{PodAffinity: &v1.PodAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testZoneKey, web)},
+				}}),
+				newLabeledPod("db-1", "default", "node-b1", db, &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{
+						{Weight: 5, PodAffinityTerm: podAffinityTerm(testZoneKey, web)},
+					},
+				}}),
+			},
+			// zone-a: +5, zone-b: -5, zone-c: 0
+			want: map[string]int64{"node-a1": 100, "node-b1": 0, "node-c1": 50},
+		},
+		{
+			name: "hardPodAffinityWeight 0 ignores existing pod's required affinity",
+			args: `{"hardPodAffinityWeight":0}`,
+			pod:  newLabeledPod("web", "default", "", web, nil),
+			assignedPods: []*v1.Pod{
+				newLabeledPod("db-0", "default", "node-a1", db, &v1.Affinity{PodAffinity: &v1.PodAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{podAffinityTerm(testZoneKey, web)},
+				}}),
+			},
+			want: map[string]int64{"node-a1": 0, "node-b1": 0, "node-c1": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewInterPodAffinity(json.RawMessage(tt.args))
+			if err != nil {
+				t.Fatalf("NewInterPodAffinity() error = %v", err)
+			}
+			pl := p.(*InterPodAffinity)
+			snapshot := NewSnapshot(nodes, tt.assignedPods, nil)
+			state := NewCycleState()
+			if status := pl.PreScore(state, tt.pod, snapshot, snapshot.NodeInfos()); !status.IsSuccess() {
+				t.Fatalf("InterPodAffinity.PreScore() error = %v", status.AsError())
+			}
+			var scores NodeScoreList
+			for _, nodeInfo := range snapshot.NodeInfos() {
+				score, status := pl.Score(state, tt.pod, nodeInfo)
+				if !status.IsSuccess() {
+					t.Fatalf("InterPodAffinity.Score() error = %v", status.AsError())
+				}
+				scores = append(scores, NodeScore{Name: nodeInfo.Node.Name, Score: score})
+			}
+			if status := pl.NormalizeScore(state, tt.pod, scores); !status.IsSuccess() {
+				t.Fatalf("InterPodAffinity.NormalizeScore() error = %v", status.AsError())
+			}
+			got := map[string]int64{}
+			for _, s := range scores {
+				got[s.Name] = s.Score
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InterPodAffinity scores = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,44 +1,438 @@
 package logic
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
 )
 
-type ScheduleLogic struct{}
+// WeightedPlugin はスコアに掛ける重み付きのプラグイン名
+type WeightedPlugin struct {
+	Name   string
+	Weight int64
+}
+
+// PluginSet は有効にするプラグインの一覧と、プラグインごとの引数
+// Filter はすべて通ったノードだけが候補になり、Score は重み付きで合計される
+type PluginSet struct {
+	Filter []string
+	Score  []WeightedPlugin
+	// プラグイン名 → プラグインに渡す引数
+	Args map[string]json.RawMessage
+}
+
+// DefaultPlugins はデフォルトで有効にするプラグインを返す
+func DefaultPlugins() PluginSet {
+	return PluginSet{
+		Filter: []string{NodeConditionName, TierIsolationName, TaintTolerationName, NodeAffinityName, NodePortsName, NodeResourcesFitName, VolumeBindingName, NodeVolumeLimitsName, PodTopologySpreadName, InterPodAffinityName},
+		Score: []WeightedPlugin{
+			{Name: TaintTolerationName, Weight: 3},
+			{Name: NodeAffinityName, Weight: 2},
+			{Name: PodTopologySpreadName, Weight: 2},
+			{Name: InterPodAffinityName, Weight: 2},
+			{Name: NodeResourcesFitName, Weight: 1},
+			{Name: NodeResourcesBalancedAllocationName, Weight: 1},
+		},
+	}
+}
+
+const (
+	// ノード数がこれ未満なら、すべてのノードを調べる
+	minFeasibleNodesToFind = 100
+	// percentageOfNodesToScore をノード数から決めるときの下限 (%)
+	minFeasibleNodesPercentageToFind = 5
+	// percentageOfNodesToScore をノード数から決めるときの基準 (%)。125 ノード増えるごとに 1 % 減らす
+	basePercentageOfNodesToScore = 50
+)
+
+type weightedScorePlugin struct {
+	ScorePlugin
+	weight int64
+}
+
+type ScheduleLogic struct {
+	preFilterPlugins []PreFilterPlugin
+	filterPlugins    []FilterPlugin
+	preScorePlugins  []PreScorePlugin
+	scorePlugins     []weightedScorePlugin
+	metricsRecorder  MetricsRecorder
+
+	// Filter と Score をノードごとに並行に実行するワーカー数
+	parallelism int
+	// Filter を通ったノードが全ノードのこの割合 (%) だけ見つかったら、残りのノードは調べない。0 ならノード数から決める
+	percentageOfNodesToScore int32
+	// 次の Pod で Filter を始めるノードの添字
+	// 調べたノードの数だけずらし、途中で打ち切っても先頭のノードばかりが候補にならないようにする
+	nextStartNodeIndex atomic.Int64
+}
+
+// NewScheduleLogic は registry から plugins に書かれたプラグインを生成して ScheduleLogic を作る
+func NewScheduleLogic(registry Registry, plugins PluginSet) (*ScheduleLogic, error) {
+	s := &ScheduleLogic{parallelism: DefaultParallelism}
+	// 同じプラグインを Filter と Score の両方で使う場合も、インスタンスは 1 つにする
+	instances := map[string]Plugin{}
+	getPlugin := func(name string) (Plugin, error) {
+		if p, ok := instances[name]; ok {
+			return p, nil
+		}
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("plugin %s is not registered", name)
+		}
+		p, err := factory(plugins.Args[name])
+		if err != nil {
+			return nil, fmt.Errorf("error initializing plugin %s: %w", name, err)
+		}
+		instances[name] = p
+		return p, nil
+	}
+
+	for _, name := range plugins.Filter {
+		p, err := getPlugin(name)
+		if err != nil {
+			return nil, err
+		}
+		filterPlugin, ok := p.(FilterPlugin)
+		if !ok {
+			return nil, fmt.Errorf("plugin %s does not extend filter", name)
+		}
+		s.filterPlugins = append(s.filterPlugins, filterPlugin)
+		// PreFilter は Filter として有効にしたプラグインだけ呼ぶ
+		if preFilterPlugin, ok := p.(PreFilterPlugin); ok {
+			s.preFilterPlugins = append(s.preFilterPlugins, preFilterPlugin)
+		}
+	}
+	for _, wp := range plugins.Score {
+		p, err := getPlugin(wp.Name)
+		if err != nil {
+			return nil, err
+		}
+		scorePlugin, ok := p.(ScorePlugin)
+		if !ok {
+			return nil, fmt.Errorf("plugin %s does not extend score", wp.Name)
+		}
+		if wp.Weight <= 0 {
+			return nil, fmt.Errorf("score plugin %s has non-positive weight %d", wp.Name, wp.Weight)
+		}
+		s.scorePlugins = append(s.scorePlugins, weightedScorePlugin{ScorePlugin: scorePlugin, weight: wp.Weight})
+		if preScorePlugin, ok := p.(PreScorePlugin); ok {
+			s.preScorePlugins = append(s.preScorePlugins, preScorePlugin)
+		}
+	}
+	return s, nil
+}
+
+// SetMetricsRecorder はプラグインの実行時間を記録する先を設定する。nil なら記録しない
+func (s *ScheduleLogic) SetMetricsRecorder(recorder MetricsRecorder) {
+	s.metricsRecorder = recorder
+}
+
+// SetParallelism は Filter と Score をノードごとに並行に実行するワーカー数を設定する。0 以下ならデフォルトに戻す
+func (s *ScheduleLogic) SetParallelism(parallelism int) {
+	if parallelism <= 0 {
+		parallelism = DefaultParallelism
+	}
+	s.parallelism = parallelism
+}
+
+// SetPercentageOfNodesToScore は Filter を打ち切るまでに見つけるノードの割合 (%) を設定する
+// 0 ならノード数から決め、100 ならすべてのノードを調べる
+func (s *ScheduleLogic) SetPercentageOfNodesToScore(percentage int32) {
+	s.percentageOfNodesToScore = percentage
+}
+
+// numFeasibleNodesToFind は Filter を通ったノードをいくつ見つけたら打ち切るかを返す
+func (s *ScheduleLogic) numFeasibleNodesToFind(numAllNodes int) int {
+	if numAllNodes < minFeasibleNodesToFind || s.percentageOfNodesToScore >= 100 {
+		return numAllNodes
+	}
+	percentage := s.percentageOfNodesToScore
+	if percentage <= 0 {
+		percentage = max(basePercentageOfNodesToScore-int32(numAllNodes/125), minFeasibleNodesPercentageToFind)
+	}
+	return max(numAllNodes*int(percentage)/100, minFeasibleNodesToFind)
+}
+
+// プラグインの実行時間を metricsRecorder に記録する
+func (s *ScheduleLogic) observePluginDuration(extensionPoint string, p Plugin, status *Status, start time.Time) {
+	if s.metricsRecorder == nil {
+		return
+	}
+	s.metricsRecorder.ObservePluginDuration(extensionPoint, p.Name(), status.Code(), time.Since(start))
+}
+
+func (s *ScheduleLogic) runPreFilterPlugin(p PreFilterPlugin, state *CycleState, pod *v1.Pod, snapshot *Snapshot) *Status {
+	start := time.Now()
+	status := p.PreFilter(state, pod, snapshot)
+	s.observePluginDuration(ExtensionPointPreFilter, p, status, start)
+	return status
+}
 
 // unscheduled pod が、配置して良いnodesを返す
-func (s *ScheduleLogic) ChooseAvailableNodes(unschedulePod *v1.Pod, vs *v1.NodeList) (*v1.NodeList, error) {
-	retv := v1.NodeList{
-		TypeMeta: vs.TypeMeta,
-		ListMeta: vs.ListMeta,
-		Items:    []v1.Node{},
-	}
-	for _, vi := range vs.Items {
-		if vi.Labels["tier"] == "control" {
-			continue
+// 1 つも見つからなかった場合は、ノードごとの理由を持つ *FitError を返す
+func (s *ScheduleLogic) ChooseAvailableNodes(unschedulePod *v1.Pod, snapshot *Snapshot) (*v1.NodeList, error) {
+	retv := v1.NodeList{Items: []v1.Node{}}
+	nodeInfos := snapshot.NodeInfos()
+
+	state := NewCycleState()
+	for _, p := range s.preFilterPlugins {
+		status := s.runPreFilterPlugin(p, state, unschedulePod, snapshot)
+		if status.Code() == Error {
+			return nil, fmt.Errorf("running prefilter plugin %s: %w", p.Name(), status.AsError())
+		}
+		if !status.IsSuccess() {
+			// PreFilter で弾かれた場合は、すべてのノードが同じ理由で配置できない
+			nodeToReasons := make(map[string][]string, len(nodeInfos))
+			for _, nodeInfo := range nodeInfos {
+				nodeToReasons[nodeInfo.Node.Name] = status.Reasons()
+			}
+			return &retv, &FitError{Pod: unschedulePod, NumAllNodes: len(nodeInfos), NodeToReasons: nodeToReasons}
 		}
+	}
 
-		if unschedulePod.Spec.NodeSelector["tier"] != "cronjob" && vi.Labels["tier"] == "cronjob" {
-			continue
-		} else if unschedulePod.Spec.NodeSelector["tier"] == "cronjob" && vi.Labels["tier"] != "cronjob" {
+	feasibleNodes, nodeToReasons, err := s.findNodesThatPassFilters(state, unschedulePod, snapshot)
+	if err != nil {
+		return nil, err
+	}
+	for _, nodeInfo := range feasibleNodes {
+		retv.Items = append(retv.Items, *nodeInfo.Node)
+	}
+
+	if len(retv.Items) == 0 {
+		return &retv, &FitError{Pod: unschedulePod, NumAllNodes: len(nodeInfos), NodeToReasons: nodeToReasons}
+	}
+	return &retv, nil
+}
+
+// findNodesThatPassFilters はノードごとの Filter を並行に実行し、すべて通ったノードを nodeInfos の順に返す
+// 通ったノードが numFeasibleNodesToFind 個見つかったら残りのノードは調べない
+// どのノードまで調べるかは nextStartNodeIndex から数えた順で決めるので、並行に実行しても結果は変わらない
+// 通らなかったノードは、ノード名 → 理由 で返す
+func (s *ScheduleLogic) findNodesThatPassFilters(state *CycleState, pod *v1.Pod, snapshot *Snapshot) ([]*NodeInfo, map[string][]string, error) {
+	nodeInfos := snapshot.NodeInfos()
+	numAllNodes := len(nodeInfos)
+	if numAllNodes == 0 {
+		return nil, map[string][]string{}, nil
+	}
+	numNodesToFind := s.numFeasibleNodesToFind(numAllNodes)
+	start := int(s.nextStartNodeIndex.Load()) % numAllNodes
+
+	// 添字は start から数えた順。打ち切った後に始まるはずだったノードは checked が false のまま
+	statuses := make([]*Status, numAllNodes)
+	checked := make([]bool, numAllNodes)
+	var numFeasible atomic.Int64
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.parallelize(ctx, numAllNodes, func(i int) {
+		status := s.runFilterPluginsWithNominatedPods(state, pod, nodeInfos[(start+i)%numAllNodes], snapshot)
+		statuses[i] = status
+		checked[i] = true
+		if status.Code() == Error || (status.IsSuccess() && numFeasible.Add(1) >= int64(numNodesToFind)) {
+			cancel()
+		}
+	})
+
+	// start から順に結果を見て、numNodesToFind 個目が見つかったところで打ち切る
+	// 他のワーカーが打ち切ったために調べ残したノードは、ここで調べる
+	feasibleIndexes := make([]int, 0, numNodesToFind)
+	nodeToReasons := map[string][]string{}
+	processed := 0
+	for i := 0; i < numAllNodes && len(feasibleIndexes) < numNodesToFind; i++ {
+		index := (start + i) % numAllNodes
+		status := statuses[i]
+		if !checked[i] {
+			status = s.runFilterPluginsWithNominatedPods(state, pod, nodeInfos[index], snapshot)
+		}
+		processed++
+		if status.Code() == Error {
+			return nil, nil, status.AsError()
+		}
+		if !status.IsSuccess() {
+			nodeToReasons[nodeInfos[index].Node.Name] = status.Reasons()
 			continue
 		}
+		feasibleIndexes = append(feasibleIndexes, index)
+	}
+	s.nextStartNodeIndex.Store(int64((start + processed) % numAllNodes))
 
-		// ここまで問題なければ配置してOK
-		retv.Items = append(retv.Items, vi)
+	sort.Ints(feasibleIndexes)
+	feasibleNodes := make([]*NodeInfo, 0, len(feasibleIndexes))
+	for _, index := range feasibleIndexes {
+		feasibleNodes = append(feasibleNodes, nodeInfos[index])
 	}
-	return &retv, nil
+	return feasibleNodes, nodeToReasons, nil
+}
+
+// Filter を順に実行し、最初に通らなかったものの Status を返す
+func (s *ScheduleLogic) runFilterPlugins(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	for _, p := range s.filterPlugins {
+		start := time.Now()
+		status := p.Filter(state, pod, nodeInfo)
+		s.observePluginDuration(ExtensionPointFilter, p, status, start)
+		if status.Code() == Error {
+			return AsStatus(fmt.Errorf("running filter plugin %s: %w", p.Name(), status.AsError()))
+		}
+		if !status.IsSuccess() {
+			return status
+		}
+	}
+	return nil
+}
+
+// runFilterPluginsWithNominatedPods は、ノードを指名された優先度が同じか高い Pod が配置されているものとして Filter を実行する
+// 指名された Pod が結局配置されなかった場合にも通るよう、指名された Pod がいない状態でも Filter を実行する
+// (pod の affinity が指名された Pod にだけ一致する場合など)
+func (s *ScheduleLogic) runFilterPluginsWithNominatedPods(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) *Status {
+	nominatedState, nominatedNodeInfo, podsAdded, err := s.addNominatedPods(state, pod, nodeInfo, snapshot)
+	if err != nil {
+		return AsStatus(err)
+	}
+	if !podsAdded {
+		return s.runFilterPlugins(state, pod, nodeInfo)
+	}
+	if status := s.runFilterPlugins(nominatedState, pod, nominatedNodeInfo); !status.IsSuccess() {
+		return status
+	}
+	return s.runFilterPlugins(state, pod, nodeInfo)
+}
+
+// addNominatedPods は、ノードを指名された優先度が同じか高い Pod を加えた CycleState と NodeInfo を返す
+// 加える Pod がなければ state と nodeInfo をそのまま返し、podsAdded は false になる
+func (s *ScheduleLogic) addNominatedPods(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) (*CycleState, *NodeInfo, bool, error) {
+	nominatedPods := snapshot.NominatedPodsForNode(nodeInfo.Node.Name)
+	if len(nominatedPods) == 0 {
+		return state, nodeInfo, false, nil
+	}
+	stateOut := state.Clone()
+	nodeInfoOut := nodeInfo.withoutPods(nil)
+	podsAdded := false
+	for _, p := range nominatedPods {
+		if PodPriority(p) < PodPriority(pod) || (p.Namespace == pod.Namespace && p.Name == pod.Name) {
+			continue
+		}
+		nodeInfoOut.AddPod(p)
+		if err := s.runPreFilterExtensionAddPod(stateOut, pod, p, nodeInfoOut, snapshot); err != nil {
+			return nil, nil, false, err
+		}
+		podsAdded = true
+	}
+	if !podsAdded {
+		return state, nodeInfo, false, nil
+	}
+	return stateOut, nodeInfoOut, true, nil
 }
 
 // unscheduled podと配置していいnodesを与えると、配置するのに最適なnodeを返す
-func (s *ScheduleLogic) ChooseSuitableNode(unschedulePod *v1.Pod, vs *v1.NodeList) (v1.Node, error) {
+// 配置済み Pod は snapshot から参照する
+func (s *ScheduleLogic) ChooseSuitableNode(unschedulePod *v1.Pod, vs *v1.NodeList, snapshot *Snapshot) (v1.Node, error) {
 	if len(vs.Items) == 0 {
 		return v1.Node{}, nil
 	}
 
-	// vs.Items の要素からランダムで選択する
-	idx := rand.Intn(len(vs.Items))
-	return vs.Items[idx], nil
+	nodeInfos := make([]*NodeInfo, len(vs.Items))
+	for i := range vs.Items {
+		nodeInfos[i] = snapshot.Get(vs.Items[i].Name)
+		if nodeInfos[i] == nil {
+			// snapshot に無いノードは配置済み Pod が無いものとして扱う
+			nodeInfos[i] = NewNodeInfo(&vs.Items[i])
+		}
+	}
+
+	scores, err := s.runScorePlugins(unschedulePod, nodeInfos, snapshot)
+	if err != nil {
+		return v1.Node{}, err
+	}
+
+	return vs.Items[selectHost(scores)], nil
+}
+
+// 合計スコアが最も高いノードの添字を返す
+// 最高スコアのノードが複数ある場合は、その中から一様にランダムで選ぶ
+func selectHost(scores NodeScoreList) int {
+	selected := 0
+	ties := 1
+	for i := 1; i < len(scores); i++ {
+		switch {
+		case scores[i].Score > scores[selected].Score:
+			selected = i
+			ties = 1
+		case scores[i].Score == scores[selected].Score:
+			// reservoir sampling: ties 個目の候補は 1/ties の確率で入れ替える
+			ties++
+			if rand.Intn(ties) == 0 {
+				selected = i
+			}
+		}
+	}
+	return selected
+}
+
+// PreScore と Score を実行し、重みを掛けて合計したノードごとのスコアを返す
+// 戻り値の並びは nodeInfos と同じ
+func (s *ScheduleLogic) runScorePlugins(pod *v1.Pod, nodeInfos []*NodeInfo, snapshot *Snapshot) (NodeScoreList, error) {
+	state := NewCycleState()
+	for _, p := range s.preScorePlugins {
+		start := time.Now()
+		status := p.PreScore(state, pod, snapshot, nodeInfos)
+		s.observePluginDuration(ExtensionPointPreScore, p, status, start)
+		if !status.IsSuccess() {
+			return nil, fmt.Errorf("running prescore plugin %s: %w", p.Name(), status.AsError())
+		}
+	}
+
+	total := make(NodeScoreList, len(nodeInfos))
+	for i := range nodeInfos {
+		total[i].Name = nodeInfos[i].Node.Name
+	}
+
+	// プラグインの添字 → ノードごとのスコア。ノードごとに並行に計算し、それぞれの添字に書き込む
+	pluginScores := make([]NodeScoreList, len(s.scorePlugins))
+	for j := range s.scorePlugins {
+		pluginScores[j] = make(NodeScoreList, len(nodeInfos))
+	}
+	errs := make([]error, len(nodeInfos))
+	s.parallelize(context.Background(), len(nodeInfos), func(i int) {
+		nodeInfo := nodeInfos[i]
+		for j, p := range s.scorePlugins {
+			start := time.Now()
+			score, status := p.Score(state, pod, nodeInfo)
+			s.observePluginDuration(ExtensionPointScore, p, status, start)
+			if !status.IsSuccess() {
+				errs[i] = fmt.Errorf("running score plugin %s: %w", p.Name(), status.AsError())
+				return
+			}
+			pluginScores[j][i] = NodeScore{Name: nodeInfo.Node.Name, Score: score}
+		}
+	})
+	// どのノードで失敗しても、nodeInfos の順で最初のエラーを返す
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for j, p := range s.scorePlugins {
+		scores := pluginScores[j]
+		if normalizer, ok := p.ScorePlugin.(ScoreNormalizer); ok {
+			start := time.Now()
+			status := normalizer.NormalizeScore(state, pod, scores)
+			s.observePluginDuration(ExtensionPointNormalizeScore, p, status, start)
+			if !status.IsSuccess() {
+				return nil, fmt.Errorf("normalizing score of plugin %s: %w", p.Name(), status.AsError())
+			}
+		}
+		for i := range scores {
+			if scores[i].Score < MinNodeScore || scores[i].Score > MaxNodeScore {
+				return nil, fmt.Errorf("score plugin %s returned an invalid score %d for node %s", p.Name(), scores[i].Score, scores[i].Name)
+			}
+			total[i].Score += scores[i].Score * p.weight
+		}
+	}
+	return total, nil
 }
@@ -1,13 +1,30 @@
 package logic
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// デフォルトのプラグインを有効にした ScheduleLogic を返す
+func newDefaultScheduleLogic(t *testing.T) *ScheduleLogic {
+	t.Helper()
+	s, err := NewScheduleLogic(NewInTreeRegistry(), DefaultPlugins())
+	if err != nil {
+		t.Fatalf("NewScheduleLogic() error = %v", err)
+	}
+	return s
+}
+
 func TestScheduleLogic_ChooseAvailableNodes(t *testing.T) {
 	type args struct {
 		unschedulePod *v1.Pod
@@ -60,6 +77,9 @@ func TestScheduleLogic_ChooseAvailableNodes(t *testing.T) {
 			args: args{
 				unschedulePod: &v1.Pod{
 					TypeMeta: metav1.TypeMeta{Kind: "CronJob"},
+					Spec: v1.PodSpec{
+						NodeSelector: map[string]string{"tier": "cronjob"},
+					},
 				},
 				nodes: &v1.NodeList{
 					Items: []v1.Node{
@@ -89,7 +109,7 @@ func TestScheduleLogic_ChooseAvailableNodes(t *testing.T) {
 			want: &v1.NodeList{
 				Items: []v1.Node{},
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 		{
 			name: "success: multiple available nodes",
@@ -123,13 +143,13 @@ func TestScheduleLogic_ChooseAvailableNodes(t *testing.T) {
 			want: &v1.NodeList{
 				Items: []v1.Node{},
 			},
-			wantErr: false,
+			wantErr: true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &ScheduleLogic{}
-			got, err := s.ChooseAvailableNodes(tt.args.unschedulePod, tt.args.nodes)
+			s := newDefaultScheduleLogic(t)
+			got, err := s.ChooseAvailableNodes(tt.args.unschedulePod, NewSnapshot(tt.args.nodes, nil, nil))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ScheduleLogic.ChooseAvailableNodes() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -140,3 +160,1115 @@ func TestScheduleLogic_ChooseAvailableNodes(t *testing.T) {
 		})
 	}
 }
+
+func TestScheduleLogic_ChooseAvailableNodes_Resources(t *testing.T) {
+	newNode := func(name, cpu, memory, pods string) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"tier": "normal"}},
+			Status: v1.NodeStatus{
+				Allocatable: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse(cpu),
+					v1.ResourceMemory: resource.MustParse(memory),
+					v1.ResourcePods:   resource.MustParse(pods),
+				},
+			},
+		}
+	}
+	newPod := func(name, nodeName, cpu, memory string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: v1.PodSpec{
+				NodeName: nodeName,
+				Containers: []v1.Container{{
+					Name: "c",
+					Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+						v1.ResourceCPU:    resource.MustParse(cpu),
+						v1.ResourceMemory: resource.MustParse(memory),
+					}},
+				}},
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		pod          *v1.Pod
+		nodes        []v1.Node
+		assignedPods []*v1.Pod
+		want         []string
+		wantErr      string
+	}{
+		{
+			name:  "success: node with enough free resources",
+			pod:   newPod("pod", "", "2", "1Gi"),
+			nodes: []v1.Node{newNode("node1", "4", "4Gi", "110")},
+			want:  []string{"node1"},
+		},
+		{
+			name:         "success: requests of assigned pods are subtracted",
+			pod:          newPod("pod", "", "2", "1Gi"),
+			nodes:        []v1.Node{newNode("node1", "4", "4Gi", "110"), newNode("node2", "4", "4Gi", "110")},
+			assignedPods: []*v1.Pod{newPod("running", "node1", "3", "1Gi")},
+			want:         []string{"node2"},
+		},
+		{
+			name:         "failure: insufficient cpu and memory",
+			pod:          newPod("pod", "", "2", "2Gi"),
+			nodes:        []v1.Node{newNode("node1", "4", "4Gi", "110"), newNode("node2", "1", "8Gi", "110")},
+			assignedPods: []*v1.Pod{newPod("running", "node1", "3", "3Gi")},
+			want:         []string{},
+			wantErr:      "0/2 nodes are available: 1 Insufficient memory, 2 Insufficient cpu.",
+		},
+		{
+			name:         "failure: too many pods",
+			pod:          newPod("pod", "", "0", "0"),
+			nodes:        []v1.Node{newNode("node1", "4", "4Gi", "1")},
+			assignedPods: []*v1.Pod{newPod("running", "node1", "0", "0")},
+			want:         []string{},
+			wantErr:      "0/1 nodes are available: 1 Too many pods.",
+		},
+		{
+			// pods の allocatable を報告しないノードは、Pod の数を制限しない (upstream では 0 として扱う)
+			name: "success: node without pods allocatable",
+			pod:  newPod("pod", "", "0", "0"),
+			nodes: func() []v1.Node {
+				node := newNode("node1", "4", "4Gi", "1")
+				delete(node.Status.Allocatable, v1.ResourcePods)
+				return []v1.Node{node}
+			}(),
+			assignedPods: []*v1.Pod{newPod("running", "node1", "0", "0")},
+			want:         []string{"node1"},
+		},
+		{
+			name: "failure: insufficient extended resource",
+			pod: func() *v1.Pod {
+				pod := newPod("pod", "", "1", "1Gi")
+				pod.Spec.Containers[0].Resources.Requests["example.com/gpu"] = resource.MustParse("1")
+				return pod
+			}(),
+			nodes:   []v1.Node{newNode("node1", "4", "4Gi", "110")},
+			want:    []string{},
+			wantErr: "0/1 nodes are available: 1 Insufficient example.com/gpu.",
+		},
+		{
+			name: "failure: tier and resources are reported together",
+			pod:  newPod("pod", "", "8", "1Gi"),
+			nodes: []v1.Node{
+				func() v1.Node {
+					node := newNode("control", "16", "16Gi", "110")
+					node.Labels["tier"] = "control"
+					return node
+				}(),
+				newNode("node1", "4", "4Gi", "110"),
+			},
+			want:    []string{},
+			wantErr: "0/2 nodes are available: 1 Insufficient cpu, 1 node(s) had tier=control.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDefaultScheduleLogic(t)
+			got, err := s.ChooseAvailableNodes(tt.pod, NewSnapshot(&v1.NodeList{Items: tt.nodes}, tt.assignedPods, nil))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+			}
+			if tt.wantErr != "" {
+				var fitErr *FitError
+				if !errors.As(err, &fitErr) {
+					t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v, want *FitError", err)
+				}
+				if fitErr.Error() != tt.wantErr {
+					t.Errorf("ScheduleLogic.ChooseAvailableNodes() error = %q, want %q", fitErr.Error(), tt.wantErr)
+				}
+			}
+
+			gotNames := []string{}
+			for _, node := range got.Items {
+				gotNames = append(gotNames, node.Name)
+			}
+			if !reflect.DeepEqual(gotNames, tt.want) {
+				t.Errorf("ScheduleLogic.ChooseAvailableNodes() = %v, want %v", gotNames, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_ChooseAvailableNodes_NominatedPods(t *testing.T) {
+	node := *newResourceNode("node1", v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+	node.Labels = map[string]string{v1.LabelHostname: "node1"}
+	nodes := &v1.NodeList{Items: []v1.Node{node}}
+	nominate := func(pod *v1.Pod, nodeName string) *v1.Pod {
+		pod.Status.NominatedNodeName = nodeName
+		return pod
+	}
+
+	tests := []struct {
+		name          string
+		pod           *v1.Pod
+		nominatedPods []*v1.Pod
+		want          []string
+		wantErr       string
+	}{
+		{
+			// 退避させた Pod の空きは、指名された Pod のために取っておく
+			name:          "failure: lower priority pod cannot use space reserved by nominated pod",
+			pod:           newPriorityPod("pod", "", 0, "2"),
+			nominatedPods: []*v1.Pod{nominate(newPriorityPod("nominated", "", 100, "3"), "node1")},
+			want:          []string{},
+			wantErr:       "0/1 nodes are available: 1 Insufficient cpu.",
+		},
+		{
+			name:          "failure: equal priority pod also respects nomination",
+			pod:           newPriorityPod("pod", "", 100, "2"),
+			nominatedPods: []*v1.Pod{nominate(newPriorityPod("nominated", "", 100, "3"), "node1")},
+			want:          []string{},
+			wantErr:       "0/1 nodes are available: 1 Insufficient cpu.",
+		},
+		{
+			name:          "success: higher priority pod ignores nomination",
+			pod:           newPriorityPod("pod", "", 200, "2"),
+			nominatedPods: []*v1.Pod{nominate(newPriorityPod("nominated", "", 100, "3"), "node1")},
+			want:          []string{"node1"},
+		},
+		{
+			name:          "success: pod does not count its own nomination",
+			pod:           nominate(newPriorityPod("nominated", "", 100, "3"), "node1"),
+			nominatedPods: []*v1.Pod{nominate(newPriorityPod("nominated", "", 100, "3"), "node1")},
+			want:          []string{"node1"},
+		},
+		{
+			name:          "success: nomination to other node",
+			pod:           newPriorityPod("pod", "", 0, "2"),
+			nominatedPods: []*v1.Pod{nominate(newPriorityPod("nominated", "", 100, "3"), "node2")},
+			want:          []string{"node1"},
+		},
+		{
+			// 指名された Pod が配置されなくても affinity を満たす必要がある
+			name: "failure: affinity satisfied only by nominated pod",
+			pod: func() *v1.Pod {
+				pod := newPriorityPod("pod", "", 0, "1")
+				pod.Spec.Affinity = &v1.Affinity{PodAffinity: &v1.PodAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+						LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nominated"}},
+						TopologyKey:   v1.LabelHostname,
+					}},
+				}}
+				return pod
+			}(),
+			nominatedPods: []*v1.Pod{nominate(newPriorityPod("nominated", "", 100, "1"), "node1")},
+			want:          []string{},
+			wantErr:       "0/1 nodes are available: 1 node(s) didn't match pod affinity rules.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDefaultScheduleLogic(t)
+			snapshot := NewSnapshot(nodes, nil, nil)
+			snapshot.SetNominatedPods(tt.nominatedPods)
+			got, err := s.ChooseAvailableNodes(tt.pod, snapshot)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("ScheduleLogic.ChooseAvailableNodes() error = %v, want %q", err, tt.wantErr)
+			}
+
+			gotNames := []string{}
+			for _, node := range got.Items {
+				gotNames = append(gotNames, node.Name)
+			}
+			if !reflect.DeepEqual(gotNames, tt.want) {
+				t.Errorf("ScheduleLogic.ChooseAvailableNodes() = %v, want %v", gotNames, tt.want)
+			}
+		})
+	}
+}
+
+// テスト用に固定のスコアを返す ScorePlugin
+type fixedScorePlugin struct {
+	name   string
+	scores map[string]int64
+}
+
+func (f *fixedScorePlugin) Name() string { return f.name }
+
+func (f *fixedScorePlugin) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	return f.scores[nodeInfo.Node.Name], nil
+}
+
+// テスト用に名前が一致するノードだけを通す FilterPlugin
+type allowNodesFilterPlugin struct {
+	allowed map[string]bool
+}
+
+func (a *allowNodesFilterPlugin) Name() string { return "AllowNodes" }
+
+func (a *allowNodesFilterPlugin) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	if !a.allowed[nodeInfo.Node.Name] {
+		return NewStatus(Unschedulable, "node(s) were not allowed")
+	}
+	return nil
+}
+
+func TestNewScheduleLogic(t *testing.T) {
+	tests := []struct {
+		name    string
+		plugins PluginSet
+		wantErr bool
+	}{
+		{
+			name:    "success: default plugins",
+			plugins: DefaultPlugins(),
+		},
+		{
+			name:    "failure: unknown plugin",
+			plugins: PluginSet{Filter: []string{"Unknown"}},
+			wantErr: true,
+		},
+		{
+			name:    "failure: score plugin used as filter",
+			plugins: PluginSet{Filter: []string{RandomName}},
+			wantErr: true,
+		},
+		{
+			name:    "failure: filter plugin used as score",
+			plugins: PluginSet{Score: []WeightedPlugin{{Name: TierIsolationName, Weight: 1}}},
+			wantErr: true,
+		},
+		{
+			name:    "failure: zero weight",
+			plugins: PluginSet{Score: []WeightedPlugin{{Name: RandomName, Weight: 0}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewScheduleLogic(NewInTreeRegistry(), tt.plugins)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewScheduleLogic() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewInTreeRegistry()
+	if err := r.Register(RandomName, NewRandom); err == nil {
+		t.Errorf("Registry.Register() of a duplicated name should fail")
+	}
+	if err := r.Register("AllowNodes", func(_ json.RawMessage) (Plugin, error) { return &allowNodesFilterPlugin{}, nil }); err != nil {
+		t.Errorf("Registry.Register() error = %v", err)
+	}
+	if _, ok := r["AllowNodes"]; !ok {
+		t.Errorf("plugin AllowNodes is not registered")
+	}
+}
+
+func TestScheduleLogic_ChooseSuitableNode(t *testing.T) {
+	nodes := &v1.NodeList{Items: []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3"}},
+	}}
+	registry := Registry{
+		"A": func(_ json.RawMessage) (Plugin, error) {
+			return &fixedScorePlugin{name: "A", scores: map[string]int64{"node1": 100, "node2": 0, "node3": 40}}, nil
+		},
+		"B": func(_ json.RawMessage) (Plugin, error) {
+			return &fixedScorePlugin{name: "B", scores: map[string]int64{"node1": 0, "node2": 60, "node3": 30}}, nil
+		},
+		"Invalid": func(_ json.RawMessage) (Plugin, error) {
+			return &fixedScorePlugin{name: "Invalid", scores: map[string]int64{"node1": MaxNodeScore + 1}}, nil
+		},
+	}
+
+	tests := []struct {
+		name    string
+		plugins PluginSet
+		nodes   *v1.NodeList
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "success: single plugin",
+			plugins: PluginSet{Score: []WeightedPlugin{{Name: "A", Weight: 1}}},
+			nodes:   nodes,
+			want:    "node1",
+		},
+		{
+			// A*1 + B*2 = node1: 100, node2: 120, node3: 100
+			name:    "success: weights are applied",
+			plugins: PluginSet{Score: []WeightedPlugin{{Name: "A", Weight: 1}, {Name: "B", Weight: 2}}},
+			nodes:   nodes,
+			want:    "node2",
+		},
+		{
+			// A*1 + B*3 = node1: 100, node2: 180, node3: 130
+			name:    "success: heavier weight wins",
+			plugins: PluginSet{Score: []WeightedPlugin{{Name: "A", Weight: 1}, {Name: "B", Weight: 3}}},
+			nodes:   nodes,
+			want:    "node2",
+		},
+		{
+			name:    "success: no nodes",
+			plugins: PluginSet{Score: []WeightedPlugin{{Name: "A", Weight: 1}}},
+			nodes:   &v1.NodeList{},
+			want:    "",
+		},
+		{
+			name:    "failure: score out of range",
+			plugins: PluginSet{Score: []WeightedPlugin{{Name: "Invalid", Weight: 1}}},
+			nodes:   nodes,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScheduleLogic(registry, tt.plugins)
+			if err != nil {
+				t.Fatalf("NewScheduleLogic() error = %v", err)
+			}
+			got, err := s.ChooseSuitableNode(&v1.Pod{}, tt.nodes, NewSnapshot(tt.nodes, nil, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Name != tt.want {
+				t.Errorf("ScheduleLogic.ChooseSuitableNode() = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_ChooseAvailableNodes_Plugins(t *testing.T) {
+	registry := NewInTreeRegistry()
+	if err := registry.Register("AllowNodes", func(_ json.RawMessage) (Plugin, error) {
+		return &allowNodesFilterPlugin{allowed: map[string]bool{"node2": true, "node3": true}}, nil
+	}); err != nil {
+		t.Fatalf("Registry.Register() error = %v", err)
+	}
+	s, err := NewScheduleLogic(registry, PluginSet{Filter: []string{TierIsolationName, "AllowNodes"}})
+	if err != nil {
+		t.Fatalf("NewScheduleLogic() error = %v", err)
+	}
+
+	nodes := &v1.NodeList{Items: []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"tier": "normal"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"tier": "control"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3", Labels: map[string]string{"tier": "normal"}}},
+	}}
+	got, err := s.ChooseAvailableNodes(&v1.Pod{}, NewSnapshot(nodes, nil, nil))
+	if err != nil {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+	}
+	// すべての Filter を通ったノードだけが残る
+	if len(got.Items) != 1 || got.Items[0].Name != "node3" {
+		t.Errorf("ScheduleLogic.ChooseAvailableNodes() = %v, want [node3]", got.Items)
+	}
+}
+
+func TestScheduleLogic_ChooseAvailableNodes_Taints(t *testing.T) {
+	newNode := func(name string, taints ...v1.Taint) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"tier": "normal"}},
+			Spec:       v1.NodeSpec{Taints: taints},
+		}
+	}
+	controlPlaneTaint := v1.Taint{Key: "node-role.kubernetes.io/control-plane", Effect: v1.TaintEffectNoSchedule}
+	gpuTaint := v1.Taint{Key: "nvidia.com/gpu", Value: "present", Effect: v1.TaintEffectNoSchedule}
+	drainingTaint := v1.Taint{Key: "example.com/draining", Value: "true", Effect: v1.TaintEffectNoExecute}
+	preferTaint := v1.Taint{Key: "example.com/spot", Value: "true", Effect: v1.TaintEffectPreferNoSchedule}
+
+	tests := []struct {
+		name        string
+		tolerations []v1.Toleration
+		nodes       []v1.Node
+		want        []string
+		wantErr     string
+	}{
+		{
+			name:    "NoSchedule taint without toleration",
+			nodes:   []v1.Node{newNode("control-plane", controlPlaneTaint), newNode("worker")},
+			want:    []string{"worker"},
+			wantErr: "",
+		},
+		{
+			name:    "NoExecute taint without toleration",
+			nodes:   []v1.Node{newNode("draining", drainingTaint)},
+			want:    []string{},
+			wantErr: "0/1 nodes are available: 1 node(s) had untolerated taint {example.com/draining: true}.",
+		},
+		{
+			name:  "PreferNoSchedule taint does not filter",
+			nodes: []v1.Node{newNode("spot", preferTaint)},
+			want:  []string{"spot"},
+		},
+		{
+			name:        "Exists operator tolerates any value",
+			tolerations: []v1.Toleration{{Key: "nvidia.com/gpu", Operator: v1.TolerationOpExists}},
+			nodes:       []v1.Node{newNode("gpu", gpuTaint)},
+			want:        []string{"gpu"},
+		},
+		{
+			name:        "Equal operator with matching value",
+			tolerations: []v1.Toleration{{Key: "nvidia.com/gpu", Operator: v1.TolerationOpEqual, Value: "present", Effect: v1.TaintEffectNoSchedule}},
+			nodes:       []v1.Node{newNode("gpu", gpuTaint)},
+			want:        []string{"gpu"},
+		},
+		{
+			name:        "Equal operator with different value",
+			tolerations: []v1.Toleration{{Key: "nvidia.com/gpu", Operator: v1.TolerationOpEqual, Value: "absent"}},
+			nodes:       []v1.Node{newNode("gpu", gpuTaint)},
+			want:        []string{},
+			wantErr:     "0/1 nodes are available: 1 node(s) had untolerated taint {nvidia.com/gpu: present}.",
+		},
+		{
+			name:        "empty operator means Equal",
+			tolerations: []v1.Toleration{{Key: "nvidia.com/gpu", Value: "present"}},
+			nodes:       []v1.Node{newNode("gpu", gpuTaint)},
+			want:        []string{"gpu"},
+		},
+		{
+			name:        "empty key with Exists tolerates every taint",
+			tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+			nodes:       []v1.Node{newNode("gpu", gpuTaint, drainingTaint), newNode("control-plane", controlPlaneTaint)},
+			want:        []string{"gpu", "control-plane"},
+		},
+		{
+			name:        "empty effect matches every effect",
+			tolerations: []v1.Toleration{{Key: "example.com/draining", Operator: v1.TolerationOpExists}},
+			nodes:       []v1.Node{newNode("draining", drainingTaint)},
+			want:        []string{"draining"},
+		},
+		{
+			name:        "different effect does not match",
+			tolerations: []v1.Toleration{{Key: "example.com/draining", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+			nodes:       []v1.Node{newNode("draining", drainingTaint)},
+			want:        []string{},
+			wantErr:     "0/1 nodes are available: 1 node(s) had untolerated taint {example.com/draining: true}.",
+		},
+		{
+			name:        "all taints must be tolerated",
+			tolerations: []v1.Toleration{{Key: "nvidia.com/gpu", Operator: v1.TolerationOpExists}},
+			nodes:       []v1.Node{newNode("gpu", gpuTaint, drainingTaint)},
+			want:        []string{},
+			wantErr:     "0/1 nodes are available: 1 node(s) had untolerated taint {example.com/draining: true}.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDefaultScheduleLogic(t)
+			pod := &v1.Pod{Spec: v1.PodSpec{Tolerations: tt.tolerations}}
+			got, err := s.ChooseAvailableNodes(pod, NewSnapshot(&v1.NodeList{Items: tt.nodes}, nil, nil))
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("ScheduleLogic.ChooseAvailableNodes() error = %v, want %q", err, tt.wantErr)
+			}
+
+			gotNames := []string{}
+			for _, node := range got.Items {
+				gotNames = append(gotNames, node.Name)
+			}
+			if !reflect.DeepEqual(gotNames, tt.want) {
+				t.Errorf("ScheduleLogic.ChooseAvailableNodes() = %v, want %v", gotNames, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_ChooseSuitableNode_PreferNoSchedule(t *testing.T) {
+	spotTaint := v1.Taint{Key: "example.com/spot", Value: "true", Effect: v1.TaintEffectPreferNoSchedule}
+	preemptibleTaint := v1.Taint{Key: "example.com/spot", Value: "preemptible", Effect: v1.TaintEffectPreferNoSchedule}
+	oldTaint := v1.Taint{Key: "example.com/old-kernel", Value: "true", Effect: v1.TaintEffectPreferNoSchedule}
+	nodes := &v1.NodeList{Items: []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "spot"}, Spec: v1.NodeSpec{Taints: []v1.Taint{spotTaint, preemptibleTaint}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "old-kernel"}, Spec: v1.NodeSpec{Taints: []v1.Taint{oldTaint}}},
+		// NoSchedule の taint はスコアに影響しないので、PreferNoSchedule は 2 つとして数える
+		{ObjectMeta: metav1.ObjectMeta{Name: "mixed"}, Spec: v1.NodeSpec{Taints: []v1.Taint{{Key: "a", Effect: v1.TaintEffectNoSchedule}, oldTaint, spotTaint}}},
+	}}
+	s, err := NewScheduleLogic(NewInTreeRegistry(), PluginSet{Score: []WeightedPlugin{{Name: TaintTolerationName, Weight: 1}}})
+	if err != nil {
+		t.Fatalf("NewScheduleLogic() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		tolerations []v1.Toleration
+		want        string
+	}{
+		{
+			name: "fewest untolerated PreferNoSchedule taints",
+			want: "old-kernel",
+		},
+		{
+			name:        "tolerated taints are not counted",
+			tolerations: []v1.Toleration{{Key: "example.com/spot", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectPreferNoSchedule}},
+			want:        "spot",
+		},
+		{
+			name:        "NoSchedule tolerations do not affect the score",
+			tolerations: []v1.Toleration{{Key: "example.com/spot", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+			want:        "old-kernel",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ChooseSuitableNode(&v1.Pod{Spec: v1.PodSpec{Tolerations: tt.tolerations}}, nodes, NewSnapshot(nodes, nil, nil))
+			if err != nil {
+				t.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v", err)
+			}
+			if got.Name != tt.want {
+				t.Errorf("ScheduleLogic.ChooseSuitableNode() = %s, want %s", got.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_ChooseSuitableNode_PreferredNodeAffinity(t *testing.T) {
+	newNode := func(name, zone, disktype string) v1.Node {
+		return v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{
+			"topology.kubernetes.io/zone": zone,
+			"disktype":                    disktype,
+		}}}
+	}
+	preferred := func(weight int32, key string, values ...string) v1.PreferredSchedulingTerm {
+		return v1.PreferredSchedulingTerm{
+			Weight: weight,
+			Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: key, Operator: v1.NodeSelectorOpIn, Values: values},
+			}},
+		}
+	}
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newNode("zone-b-hdd", "zone-b", "hdd"),
+		newNode("zone-a-hdd", "zone-a", "hdd"),
+		newNode("zone-b-ssd", "zone-b", "ssd"),
+	}}
+
+	tests := []struct {
+		name      string
+		preferred []v1.PreferredSchedulingTerm
+		want      []string
+	}{
+		{
+			name:      "prefer zone-a",
+			preferred: []v1.PreferredSchedulingTerm{preferred(10, "topology.kubernetes.io/zone", "zone-a")},
+			want:      []string{"zone-a-hdd"},
+		},
+		{
+			name: "weights of satisfied terms are summed",
+			preferred: []v1.PreferredSchedulingTerm{
+				preferred(10, "topology.kubernetes.io/zone", "zone-a"),
+				preferred(6, "disktype", "ssd"),
+				preferred(6, "topology.kubernetes.io/zone", "zone-b"),
+			},
+			want: []string{"zone-b-ssd"},
+		},
+		{
+			name: "ties are broken among top-scoring nodes only",
+			preferred: []v1.PreferredSchedulingTerm{
+				preferred(10, "topology.kubernetes.io/zone", "zone-a"),
+				preferred(10, "disktype", "ssd"),
+			},
+			want: []string{"zone-a-hdd", "zone-b-ssd"},
+		},
+		{
+			name: "no preference picks any node",
+			want: []string{"zone-b-hdd", "zone-a-hdd", "zone-b-ssd"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newDefaultScheduleLogic(t)
+			pod := &v1.Pod{Spec: v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: tt.preferred,
+			}}}}
+
+			// 同点のノードはランダムに選ばれるので、何度か選ばせて候補がすべて選ばれることを確かめる
+			seen := map[string]bool{}
+			for i := 0; i < 200; i++ {
+				got, err := s.ChooseSuitableNode(pod, nodes, NewSnapshot(nodes, nil, nil))
+				if err != nil {
+					t.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v", err)
+				}
+				seen[got.Name] = true
+			}
+			want := map[string]bool{}
+			for _, name := range tt.want {
+				want[name] = true
+			}
+			if !reflect.DeepEqual(seen, want) {
+				t.Errorf("ScheduleLogic.ChooseSuitableNode() chose %v, want %v", seen, want)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_PodAntiAffinity(t *testing.T) {
+	// kind/nginx.yaml と同じく、レプリカ同士をホスト単位で離す
+	web := map[string]string{"app": "web-nginx"}
+	antiAffinity := func(required bool) *v1.Affinity {
+		term := podAffinityTerm(testHostnameKey, web)
+		if required {
+			return &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{term},
+			}}
+		}
+		return &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{Weight: 100, PodAffinityTerm: term}},
+		}}
+	}
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newZoneNode("node1", "zone-a"),
+		newZoneNode("node2", "zone-a"),
+		newZoneNode("node3", "zone-b"),
+	}}
+	assignedPods := []*v1.Pod{
+		newLabeledPod("web-0", "default", "node1", web, nil),
+		newLabeledPod("web-1", "default", "node2", web, nil),
+	}
+	snapshot := NewSnapshot(nodes, assignedPods, nil)
+	s := newDefaultScheduleLogic(t)
+
+	// required: レプリカのいないノードだけが候補になる
+	got, err := s.ChooseAvailableNodes(newLabeledPod("web-2", "default", "", web, antiAffinity(true)), snapshot)
+	if err != nil {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+	}
+	if len(got.Items) != 1 || got.Items[0].Name != "node3" {
+		t.Errorf("ScheduleLogic.ChooseAvailableNodes() = %v, want [node3]", got.Items)
+	}
+
+	// preferred: すべてのノードが候補になり、レプリカのいないノードが選ばれる
+	pod := newLabeledPod("web-2", "default", "", web, antiAffinity(false))
+	got, err = s.ChooseAvailableNodes(pod, snapshot)
+	if err != nil {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+	}
+	if len(got.Items) != 3 {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() = %v, want all nodes", got.Items)
+	}
+	for i := 0; i < 20; i++ {
+		node, err := s.ChooseSuitableNode(pod, got, snapshot)
+		if err != nil {
+			t.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v", err)
+		}
+		if node.Name != "node3" {
+			t.Fatalf("ScheduleLogic.ChooseSuitableNode() = %s, want node3", node.Name)
+		}
+	}
+
+	// 4 つ目のレプリカは required なら配置できない
+	snapshot = NewSnapshot(nodes, append(assignedPods, newLabeledPod("web-2", "default", "node3", web, nil)), nil)
+	_, err = s.ChooseAvailableNodes(newLabeledPod("web-3", "default", "", web, antiAffinity(true)), snapshot)
+	var fitErr *FitError
+	if !errors.As(err, &fitErr) {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v, want *FitError", err)
+	}
+	if want := "0/3 nodes are available: 3 " + errReasonAntiAffinityRulesNotMatch + "."; fitErr.Error() != want {
+		t.Errorf("ScheduleLogic.ChooseAvailableNodes() error = %q, want %q", fitErr.Error(), want)
+	}
+}
+
+func TestScheduleLogic_PodTopologySpread(t *testing.T) {
+	// kind/multi-node.yaml のワーカーのように、ホスト名のラベルだけを持つノード
+	nodes := &v1.NodeList{}
+	for _, name := range []string{"worker1", "worker2", "worker3"} {
+		nodes.Items = append(nodes.Items, v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{testHostnameKey: name}}})
+	}
+	web := map[string]string{"app": "web-nginx"}
+	s := newDefaultScheduleLogic(t)
+
+	// 制約を持たない Pod も、デフォルトの制約で同じワークロードの Pod がいないノードに配置される
+	var assignedPods []*v1.Pod
+	for i := 0; i < 3; i++ {
+		pod := newLabeledPod(fmt.Sprintf("web-%d", i), "default", "", web, nil)
+		snapshot := NewSnapshot(nodes, assignedPods, nil)
+		available, err := s.ChooseAvailableNodes(pod, snapshot)
+		if err != nil {
+			t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+		}
+		node, err := s.ChooseSuitableNode(pod, available, snapshot)
+		if err != nil {
+			t.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v", err)
+		}
+		pod.Spec.NodeName = node.Name
+		assignedPods = append(assignedPods, pod)
+	}
+	got := map[string]bool{}
+	for _, pod := range assignedPods {
+		got[pod.Spec.NodeName] = true
+	}
+	if len(got) != 3 {
+		t.Errorf("replicas were placed on %v, want 3 different nodes", got)
+	}
+
+	// DoNotSchedule の制約を満たせない場合は FitError になる
+	pod := newLabeledPod("web-3", "default", "", web, nil)
+	pod.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{
+		spreadConstraint(1, testZoneKey, v1.DoNotSchedule, web),
+	}
+	_, err := s.ChooseAvailableNodes(pod, NewSnapshot(nodes, assignedPods, nil))
+	var fitErr *FitError
+	if !errors.As(err, &fitErr) {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v, want *FitError", err)
+	}
+	if want := "0/3 nodes are available: 3 " + errReasonNodeLabelNotMatch + "."; fitErr.Error() != want {
+		t.Errorf("ScheduleLogic.ChooseAvailableNodes() error = %q, want %q", fitErr.Error(), want)
+	}
+}
+
+func TestScheduleLogic_NodePorts(t *testing.T) {
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newZoneNode("node1", "zone-a"),
+		newZoneNode("node2", "zone-a"),
+	}}
+	assignedPods := []*v1.Pod{
+		newHostPortPod("ingress-0", "node1", v1.ContainerPort{HostPort: 443}),
+	}
+	s := newDefaultScheduleLogic(t)
+
+	got, err := s.ChooseAvailableNodes(newHostPortPod("ingress-1", "", v1.ContainerPort{HostPort: 443}), NewSnapshot(nodes, assignedPods, nil))
+	if err != nil {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+	}
+	if len(got.Items) != 1 || got.Items[0].Name != "node2" {
+		t.Errorf("ScheduleLogic.ChooseAvailableNodes() = %v, want [node2]", got.Items)
+	}
+
+	// すべてのノードで衝突する場合は、理由が FitError に含まれる
+	assignedPods = append(assignedPods, newHostPortPod("ingress-1", "node2", v1.ContainerPort{HostIP: "10.0.0.2", HostPort: 443}))
+	_, err = s.ChooseAvailableNodes(newHostPortPod("ingress-2", "", v1.ContainerPort{HostPort: 443}), NewSnapshot(nodes, assignedPods, nil))
+	var fitErr *FitError
+	if !errors.As(err, &fitErr) {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v, want *FitError", err)
+	}
+	if want := "0/2 nodes are available: 2 " + errReasonNodePorts + "."; fitErr.Error() != want {
+		t.Errorf("ScheduleLogic.ChooseAvailableNodes() error = %q, want %q", fitErr.Error(), want)
+	}
+}
+
+func TestScheduleLogic_VolumeBinding(t *testing.T) {
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newZoneNode("node-a", "zone-a"),
+		newZoneNode("node-b", "zone-b"),
+	}}
+	classes := []*storagev1.StorageClass{
+		newStorageClass(testImmediateClass, "ebs.csi.aws.com", storagev1.VolumeBindingImmediate),
+		newStorageClass(testLocalClass, noProvisioner, storagev1.VolumeBindingWaitForFirstConsumer),
+	}
+	boundPVC := newPVC("bound", testImmediateClass, "pv-bound", "1Gi")
+	localPVC := newPVC("local", testLocalClass, "", "1Gi")
+	immediatePVC := newPVC("immediate", testImmediateClass, "", "1Gi")
+	snapshot := NewSnapshot(nodes, nil, nil)
+	snapshot.SetStorageInfo(NewStorageInfo(
+		[]*v1.PersistentVolumeClaim{boundPVC, localPVC, immediatePVC},
+		[]*v1.PersistentVolume{
+			withClaimRef(newPV("pv-bound", testImmediateClass, "1Gi", "zone-b"), boundPVC),
+			newPV("pv-local", testLocalClass, "1Gi", "zone-a"),
+		},
+		classes, nil,
+	))
+	s := newDefaultScheduleLogic(t)
+
+	tests := []struct {
+		name      string
+		pod       *v1.Pod
+		wantNodes []string
+		wantErr   string
+	}{
+		{name: "bound volume", pod: newPVCPod("pod", "bound"), wantNodes: []string{"node-b"}},
+		{name: "static local volume", pod: newPVCPod("pod", "local"), wantNodes: []string{"node-a"}},
+		{
+			name:    "volumes in different zones",
+			pod:     newPVCPod("pod", "bound", "local"),
+			wantErr: "0/2 nodes are available: 1 " + errReasonVolumeBindConflict + ", 1 " + errReasonVolumeNodeAffinityConflict + ".",
+		},
+		{
+			name:    "unbound immediate claim",
+			pod:     newPVCPod("pod", "immediate"),
+			wantErr: "0/2 nodes are available: 2 " + errReasonUnboundImmediatePVC + ".",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.ChooseAvailableNodes(tt.pod, snapshot)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+			}
+			var names []string
+			for _, node := range got.Items {
+				names = append(names, node.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNodes) {
+				t.Errorf("ScheduleLogic.ChooseAvailableNodes() = %v, want %v", names, tt.wantNodes)
+			}
+		})
+	}
+}
+
+// テスト用に呼ばれた拡張点・プラグイン・結果ごとの回数を数える MetricsRecorder
+type countingMetricsRecorder struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (r *countingMetricsRecorder) ObservePluginDuration(extensionPoint, plugin string, code Code, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[extensionPoint+"/"+plugin+"/"+code.String()]++
+}
+
+func TestScheduleLogic_SetMetricsRecorder(t *testing.T) {
+	registry := NewInTreeRegistry()
+	if err := registry.Register("AllowNodes", func(_ json.RawMessage) (Plugin, error) {
+		return &allowNodesFilterPlugin{allowed: map[string]bool{"node1": true, "node3": true}}, nil
+	}); err != nil {
+		t.Fatalf("Registry.Register() error = %v", err)
+	}
+	if err := registry.Register("Fixed", func(_ json.RawMessage) (Plugin, error) {
+		return &fixedScorePlugin{name: "Fixed", scores: map[string]int64{"node1": 10, "node3": 20}}, nil
+	}); err != nil {
+		t.Fatalf("Registry.Register() error = %v", err)
+	}
+	s, err := NewScheduleLogic(registry, PluginSet{
+		Filter: []string{NodePortsName, "AllowNodes"},
+		Score:  []WeightedPlugin{{Name: "Fixed", Weight: 1}},
+	})
+	if err != nil {
+		t.Fatalf("NewScheduleLogic() error = %v", err)
+	}
+	recorder := &countingMetricsRecorder{counts: map[string]int{}}
+	s.SetMetricsRecorder(recorder)
+
+	nodes := &v1.NodeList{Items: []v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node2"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node3"}},
+	}}
+	snapshot := NewSnapshot(nodes, nil, nil)
+	available, err := s.ChooseAvailableNodes(&v1.Pod{}, snapshot)
+	if err != nil {
+		t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+	}
+	if _, err := s.ChooseSuitableNode(&v1.Pod{}, available, snapshot); err != nil {
+		t.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v", err)
+	}
+
+	want := map[string]int{
+		"PreFilter/NodePorts/Success":     1,
+		"Filter/NodePorts/Success":        3,
+		"Filter/AllowNodes/Success":       2,
+		"Filter/AllowNodes/Unschedulable": 1,
+		"Score/Fixed/Success":             2,
+	}
+	if !reflect.DeepEqual(recorder.counts, want) {
+		t.Errorf("observed plugin durations = %v, want %v", recorder.counts, want)
+	}
+}
+
+// 名前がノードの添字の順に並ぶ n 個のノードを返す
+func newNumberedNodes(n int) *v1.NodeList {
+	nodes := &v1.NodeList{Items: make([]v1.Node, n)}
+	for i := range nodes.Items {
+		nodes.Items[i] = v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%04d", i)}}
+	}
+	return nodes
+}
+
+func nodeNames(nodes *v1.NodeList) []string {
+	names := make([]string, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		names = append(names, node.Name)
+	}
+	return names
+}
+
+func TestScheduleLogic_numFeasibleNodesToFind(t *testing.T) {
+	tests := []struct {
+		name        string
+		percentage  int32
+		numAllNodes int
+		want        int
+	}{
+		{name: "small cluster is fully evaluated", percentage: 10, numAllNodes: 99, want: 99},
+		{name: "percentage", percentage: 30, numAllNodes: 1000, want: 300},
+		{name: "at least 100 nodes", percentage: 10, numAllNodes: 500, want: 100},
+		{name: "all nodes", percentage: 100, numAllNodes: 5000, want: 5000},
+		// 0 ならノード数が多いほど割合を小さくする
+		{name: "adaptive", numAllNodes: 1000, want: 420},
+		{name: "adaptive with 5000 nodes", numAllNodes: 5000, want: 500},
+		{name: "adaptive lower bound", numAllNodes: 10000, want: 500},
+		{name: "adaptive at least 100 nodes", numAllNodes: 100, want: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &ScheduleLogic{}
+			s.SetPercentageOfNodesToScore(tt.percentage)
+			if got := s.numFeasibleNodesToFind(tt.numAllNodes); got != tt.want {
+				t.Errorf("ScheduleLogic.numFeasibleNodesToFind() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_Parallelism(t *testing.T) {
+	nodes := newNumberedNodes(1000)
+	// 3 つに 1 つのノードだけが Filter を通り、そのうち 1 つだけスコアが高い
+	allowed := map[string]bool{}
+	scores := map[string]int64{}
+	var wantNodes []string
+	for i, node := range nodes.Items {
+		if i%3 == 0 {
+			allowed[node.Name] = true
+			scores[node.Name] = MaxNodeScore / 2
+			wantNodes = append(wantNodes, node.Name)
+		}
+	}
+	wantSelected := wantNodes[len(wantNodes)/2]
+	scores[wantSelected] = MaxNodeScore
+	registry := Registry{
+		"AllowNodes": func(_ json.RawMessage) (Plugin, error) { return &allowNodesFilterPlugin{allowed: allowed}, nil },
+		"Fixed":      func(_ json.RawMessage) (Plugin, error) { return &fixedScorePlugin{name: "Fixed", scores: scores}, nil },
+	}
+	snapshot := NewSnapshot(nodes, nil, nil)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+
+	for _, parallelism := range []int{1, 4, 16} {
+		t.Run(fmt.Sprintf("parallelism %d", parallelism), func(t *testing.T) {
+			s, err := NewScheduleLogic(registry, PluginSet{Filter: []string{"AllowNodes"}, Score: []WeightedPlugin{{Name: "Fixed", Weight: 1}}})
+			if err != nil {
+				t.Fatalf("NewScheduleLogic() error = %v", err)
+			}
+			s.SetParallelism(parallelism)
+			s.SetPercentageOfNodesToScore(100)
+
+			// 並行に実行しても、結果はノードの順に並ぶ
+			got, err := s.ChooseAvailableNodes(pod, snapshot)
+			if err != nil {
+				t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+			}
+			if !reflect.DeepEqual(nodeNames(got), wantNodes) {
+				t.Errorf("ScheduleLogic.ChooseAvailableNodes() returned %d nodes, want %d in node order", len(got.Items), len(wantNodes))
+			}
+			selected, err := s.ChooseSuitableNode(pod, got, snapshot)
+			if err != nil {
+				t.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v", err)
+			}
+			if selected.Name != wantSelected {
+				t.Errorf("ScheduleLogic.ChooseSuitableNode() = %s, want %s", selected.Name, wantSelected)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_PercentageOfNodesToScore(t *testing.T) {
+	nodes := newNumberedNodes(1000)
+	// 偶数番目のノードだけが Filter を通る
+	allowed := map[string]bool{}
+	for i, node := range nodes.Items {
+		allowed[node.Name] = i%2 == 0
+	}
+	registry := Registry{
+		"AllowNodes": func(_ json.RawMessage) (Plugin, error) { return &allowNodesFilterPlugin{allowed: allowed}, nil },
+	}
+	snapshot := NewSnapshot(nodes, nil, nil)
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"}}
+
+	for _, parallelism := range []int{1, 16} {
+		t.Run(fmt.Sprintf("parallelism %d", parallelism), func(t *testing.T) {
+			s, err := NewScheduleLogic(registry, PluginSet{Filter: []string{"AllowNodes"}})
+			if err != nil {
+				t.Fatalf("NewScheduleLogic() error = %v", err)
+			}
+			s.SetParallelism(parallelism)
+			// 1000 ノードの 10 % = 100 ノード見つかったら打ち切る
+			s.SetPercentageOfNodesToScore(10)
+
+			// 打ち切るまでにおよそ 200 ノードを調べるので、次の Pod はその続きから調べる
+			// 末尾まで調べたら先頭に戻る
+			wantFirst := []string{"node-0000", "node-0200", "node-0400", "node-0600", "node-0800", "node-0000"}
+			for i, want := range wantFirst {
+				got, err := s.ChooseAvailableNodes(pod, snapshot)
+				if err != nil {
+					t.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+				}
+				if len(got.Items) != 100 || got.Items[0].Name != want {
+					t.Errorf("call %d: ScheduleLogic.ChooseAvailableNodes() returned %d nodes from %s, want 100 from %s", i, len(got.Items), got.Items[0].Name, want)
+				}
+			}
+		})
+	}
+}
+
+// 5,000 ノードのクラスタで、デフォルトのプラグインで 1 つの Pod を配置するノードを選ぶ
+//
+//	go test ./internal/logic -run '^$' -bench ScheduleLogic_5000Nodes
+func BenchmarkScheduleLogic_5000Nodes(b *testing.B) {
+	nodes := newNumberedNodes(5000)
+	for i := range nodes.Items {
+		nodes.Items[i].Labels = map[string]string{v1.LabelTopologyZone: fmt.Sprintf("zone-%d", i%3), v1.LabelHostname: nodes.Items[i].Name}
+		nodes.Items[i].Status.Allocatable = v1.ResourceList{
+			v1.ResourceCPU:    resource.MustParse("8"),
+			v1.ResourceMemory: resource.MustParse("32Gi"),
+			v1.ResourcePods:   resource.MustParse("110"),
+		}
+		nodes.Items[i].Status.Conditions = []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+	}
+	snapshot := NewSnapshot(nodes, nil, nil)
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Spec: v1.PodSpec{
+			Containers: []v1.Container{{
+				Name: "c",
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+					v1.ResourceCPU:    resource.MustParse("500m"),
+					v1.ResourceMemory: resource.MustParse("1Gi"),
+				}},
+			}},
+			TopologySpreadConstraints: []v1.TopologySpreadConstraint{{
+				MaxSkew:           1,
+				TopologyKey:       v1.LabelTopologyZone,
+				WhenUnsatisfiable: v1.ScheduleAnyway,
+				LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			}},
+		},
+	}
+
+	benchmarks := []struct {
+		name        string
+		parallelism int
+		percentage  int32
+	}{
+		{name: "serial", parallelism: 1, percentage: 100},
+		{name: "parallel", parallelism: DefaultParallelism, percentage: 100},
+		{name: "parallel with adaptive percentage", parallelism: DefaultParallelism},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			s, err := NewScheduleLogic(NewInTreeRegistry(), DefaultPlugins())
+			if err != nil {
+				b.Fatalf("NewScheduleLogic() error = %v", err)
+			}
+			s.SetParallelism(bm.parallelism)
+			s.SetPercentageOfNodesToScore(bm.percentage)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				available, err := s.ChooseAvailableNodes(pod, snapshot)
+				if err != nil {
+					b.Fatalf("ScheduleLogic.ChooseAvailableNodes() error = %v", err)
+				}
+				if _, err := s.ChooseSuitableNode(pod, available, snapshot); err != nil {
+					b.Fatalf("ScheduleLogic.ChooseSuitableNode() error = %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+const NodeAffinityName = "NodeAffinity"
+
+// Pod の nodeSelector / node affinity を満たさないノードの理由
+const errReasonNodeAffinity = "node(s) didn't match Pod's node affinity/selector"
+
+// NodeAffinity は Pod の node affinity を扱う
+//   - Filter: nodeSelector と requiredDuringSchedulingIgnoredDuringExecution を満たすノードだけを通す
+//   - Score: preferredDuringSchedulingIgnoredDuringExecution のうち、ノードが満たす term の weight を合計する
+type NodeAffinity struct{}
+
+var _ FilterPlugin = &NodeAffinity{}
+var _ ScorePlugin = &NodeAffinity{}
+var _ ScoreNormalizer = &NodeAffinity{}
+
+func NewNodeAffinity(_ json.RawMessage) (Plugin, error) {
+	return &NodeAffinity{}, nil
+}
+
+func (n *NodeAffinity) Name() string {
+	return NodeAffinityName
+}
+
+func (n *NodeAffinity) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	if !podMatchesNodeSelectorAndAffinityTerms(pod, nodeInfo.Node) {
+		return NewStatus(UnschedulableAndUnresolvable, errReasonNodeAffinity)
+	}
+	return nil
+}
+
+func (n *NodeAffinity) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil {
+		return 0, nil
+	}
+
+	var count int64
+	for _, term := range affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		// weight が 0 の term は何の意味も持たない
+		if term.Weight == 0 {
+			continue
+		}
+		if nodeMatchesNodeSelectorTerm(nodeInfo.Node, term.Preference) {
+			count += int64(term.Weight)
+		}
+	}
+	return count, nil
+}
+
+// 最も多くの weight を満たしたノードが MaxNodeScore になるよう正規化する
+func (n *NodeAffinity) NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) *Status {
+	return DefaultNormalizeScore(MaxNodeScore, false, scores)
+}
+
+// ノードが Pod の nodeSelector のすべてのキーと、required な node affinity を満たすかを返す
+func podMatchesNodeSelectorAndAffinityTerms(pod *v1.Pod, node *v1.Node) bool {
+	if len(pod.Spec.NodeSelector) > 0 {
+		if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+			return false
+		}
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+	return nodeMatchesNodeSelectorTerms(node, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms)
+}
+
+// terms のいずれか 1 つでも満たせば true を返す (term 同士は OR)
+// term が 1 つもない場合は、どのノードにも一致しない
+func nodeMatchesNodeSelectorTerms(node *v1.Node, terms []v1.NodeSelectorTerm) bool {
+	for _, term := range terms {
+		if nodeMatchesNodeSelectorTerm(node, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// term の matchExpressions と matchFields をすべて満たせば true を返す (term 内は AND)
+// 空の term や、解釈できない式を含む term はどのノードにも一致しない
+func nodeMatchesNodeSelectorTerm(node *v1.Node, term v1.NodeSelectorTerm) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	if len(term.MatchExpressions) > 0 {
+		selector, err := nodeSelectorRequirementsAsSelector(term.MatchExpressions)
+		if err != nil || !selector.Matches(labels.Set(node.Labels)) {
+			return false
+		}
+	}
+
+	if len(term.MatchFields) > 0 {
+		for _, req := range term.MatchFields {
+			// upstream と同様に、フィールドは metadata.name の In / NotIn だけをサポートする
+			if req.Key != "metadata.name" || (req.Operator != v1.NodeSelectorOpIn && req.Operator != v1.NodeSelectorOpNotIn) {
+				return false
+			}
+		}
+		selector, err := nodeSelectorRequirementsAsSelector(term.MatchFields)
+		if err != nil || !selector.Matches(labels.Set{"metadata.name": node.Name}) {
+			return false
+		}
+	}
+	return true
+}
+
+// NodeSelectorRequirement の一覧を labels.Selector に変換する
+func nodeSelectorRequirementsAsSelector(reqs []v1.NodeSelectorRequirement) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, req := range reqs {
+		var op selection.Operator
+		switch req.Operator {
+		case v1.NodeSelectorOpIn:
+			op = selection.In
+		case v1.NodeSelectorOpNotIn:
+			op = selection.NotIn
+		case v1.NodeSelectorOpExists:
+			op = selection.Exists
+		case v1.NodeSelectorOpDoesNotExist:
+			op = selection.DoesNotExist
+		case v1.NodeSelectorOpGt:
+			op = selection.GreaterThan
+		case v1.NodeSelectorOpLt:
+			op = selection.LessThan
+		default:
+			return nil, fmt.Errorf("%q is not a valid node selector operator", req.Operator)
+		}
+		r, err := labels.NewRequirement(req.Key, op, req.Values)
+		if err != nil {
+			return nil, err
+		}
+		selector = selector.Add(*r)
+	}
+	return selector, nil
+}
@@ -0,0 +1,159 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNodeAffinity_Filter(t *testing.T) {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name: "node1",
+		Labels: map[string]string{
+			"disktype":                    "ssd",
+			"topology.kubernetes.io/zone": "zone-a",
+			"example.com/cores":           "16",
+		},
+	}}
+	required := func(terms ...v1.NodeSelectorTerm) *v1.Affinity {
+		return &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: terms},
+		}}
+	}
+	expr := func(key string, op v1.NodeSelectorOperator, values ...string) v1.NodeSelectorTerm {
+		return v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: key, Operator: op, Values: values}}}
+	}
+
+	tests := []struct {
+		name         string
+		nodeSelector map[string]string
+		affinity     *v1.Affinity
+		want         bool
+	}{
+		{name: "no selector and no affinity", want: true},
+		{name: "nodeSelector matches", nodeSelector: map[string]string{"disktype": "ssd"}, want: true},
+		{name: "nodeSelector with a key other than tier", nodeSelector: map[string]string{"disktype": "hdd"}, want: false},
+		{name: "every nodeSelector key must match", nodeSelector: map[string]string{"disktype": "ssd", "gpu": "true"}, want: false},
+		{name: "In", affinity: required(expr("disktype", v1.NodeSelectorOpIn, "ssd", "nvme")), want: true},
+		{name: "In without the value", affinity: required(expr("disktype", v1.NodeSelectorOpIn, "hdd")), want: false},
+		{name: "NotIn", affinity: required(expr("disktype", v1.NodeSelectorOpNotIn, "hdd")), want: true},
+		{name: "NotIn with the value", affinity: required(expr("disktype", v1.NodeSelectorOpNotIn, "ssd")), want: false},
+		{name: "NotIn with a missing label", affinity: required(expr("gpu", v1.NodeSelectorOpNotIn, "true")), want: true},
+		{name: "Exists", affinity: required(expr("disktype", v1.NodeSelectorOpExists)), want: true},
+		{name: "Exists with a missing label", affinity: required(expr("gpu", v1.NodeSelectorOpExists)), want: false},
+		{name: "DoesNotExist", affinity: required(expr("gpu", v1.NodeSelectorOpDoesNotExist)), want: true},
+		{name: "DoesNotExist with the label", affinity: required(expr("disktype", v1.NodeSelectorOpDoesNotExist)), want: false},
+		{name: "Gt", affinity: required(expr("example.com/cores", v1.NodeSelectorOpGt, "8")), want: true},
+		{name: "Gt with a smaller label", affinity: required(expr("example.com/cores", v1.NodeSelectorOpGt, "32")), want: false},
+		{name: "Gt with a non-integer value", affinity: required(expr("example.com/cores", v1.NodeSelectorOpGt, "many")), want: false},
+		{name: "Lt", affinity: required(expr("example.com/cores", v1.NodeSelectorOpLt, "32")), want: true},
+		{name: "Lt with a larger label", affinity: required(expr("example.com/cores", v1.NodeSelectorOpLt, "8")), want: false},
+		{
+			name: "expressions in a term are ANDed",
+			affinity: required(v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: "disktype", Operator: v1.NodeSelectorOpIn, Values: []string{"ssd"}},
+				{Key: "topology.kubernetes.io/zone", Operator: v1.NodeSelectorOpIn, Values: []string{"zone-b"}},
+			}}),
+			want: false,
+		},
+		{
+			name: "terms are ORed",
+			affinity: required(
+				expr("topology.kubernetes.io/zone", v1.NodeSelectorOpIn, "zone-b"),
+				expr("disktype", v1.NodeSelectorOpIn, "ssd"),
+			),
+			want: true,
+		},
+		{name: "no terms match nothing", affinity: required(), want: false},
+		{name: "empty term matches nothing", affinity: required(v1.NodeSelectorTerm{}), want: false},
+		{
+			name: "matchFields on metadata.name",
+			affinity: required(v1.NodeSelectorTerm{MatchFields: []v1.NodeSelectorRequirement{
+				{Key: "metadata.name", Operator: v1.NodeSelectorOpIn, Values: []string{"node1"}},
+			}}),
+			want: true,
+		},
+		{
+			name: "matchFields NotIn metadata.name",
+			affinity: required(v1.NodeSelectorTerm{MatchFields: []v1.NodeSelectorRequirement{
+				{Key: "metadata.name", Operator: v1.NodeSelectorOpNotIn, Values: []string{"node1"}},
+			}}),
+			want: false,
+		},
+		{
+			name: "matchFields on an unsupported field",
+			affinity: required(v1.NodeSelectorTerm{MatchFields: []v1.NodeSelectorRequirement{
+				{Key: "metadata.uid", Operator: v1.NodeSelectorOpIn, Values: []string{"node1"}},
+			}}),
+			want: false,
+		},
+		{
+			name: "matchExpressions and matchFields in a term are ANDed",
+			affinity: required(v1.NodeSelectorTerm{
+				MatchExpressions: []v1.NodeSelectorRequirement{{Key: "disktype", Operator: v1.NodeSelectorOpIn, Values: []string{"hdd"}}},
+				MatchFields:      []v1.NodeSelectorRequirement{{Key: "metadata.name", Operator: v1.NodeSelectorOpIn, Values: []string{"node1"}}},
+			}),
+			want: false,
+		},
+		{
+			name:         "nodeSelector and affinity must both match",
+			nodeSelector: map[string]string{"disktype": "hdd"},
+			affinity:     required(expr("disktype", v1.NodeSelectorOpExists)),
+			want:         false,
+		},
+		{
+			name:     "preferred terms are not required",
+			affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{{Weight: 1, Preference: expr("gpu", v1.NodeSelectorOpExists)}}}},
+			want:     true,
+		},
+	}
+	p := &NodeAffinity{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{Spec: v1.PodSpec{NodeSelector: tt.nodeSelector, Affinity: tt.affinity}}
+			status := p.Filter(NewCycleState(), pod, NewNodeInfo(node))
+			if status.IsSuccess() != tt.want {
+				t.Errorf("NodeAffinity.Filter() = %v, want success %v", status.Reasons(), tt.want)
+			}
+			if !tt.want && (len(status.Reasons()) != 1 || status.Reasons()[0] != errReasonNodeAffinity) {
+				t.Errorf("NodeAffinity.Filter() reasons = %v, want %q", status.Reasons(), errReasonNodeAffinity)
+			}
+		})
+	}
+}
+
+func TestNodeAffinity_Score(t *testing.T) {
+	pod := &v1.Pod{Spec: v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+		PreferredDuringSchedulingIgnoredDuringExecution: []v1.PreferredSchedulingTerm{
+			{Weight: 4, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "zone", Operator: v1.NodeSelectorOpIn, Values: []string{"a"}}}}},
+			{Weight: 1, Preference: v1.NodeSelectorTerm{MatchExpressions: []v1.NodeSelectorRequirement{{Key: "ssd", Operator: v1.NodeSelectorOpExists}}}},
+		},
+	}}}}
+	nodes := []*v1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "a-ssd", Labels: map[string]string{"zone": "a", "ssd": ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "a", Labels: map[string]string{"zone": "a"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "ssd", Labels: map[string]string{"ssd": ""}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "none"}},
+	}
+
+	p := &NodeAffinity{}
+	scores := NodeScoreList{}
+	for _, node := range nodes {
+		score, status := p.Score(NewCycleState(), pod, NewNodeInfo(node))
+		if !status.IsSuccess() {
+			t.Fatalf("NodeAffinity.Score() error = %v", status.AsError())
+		}
+		scores = append(scores, NodeScore{Name: node.Name, Score: score})
+	}
+	if status := p.NormalizeScore(NewCycleState(), pod, scores); !status.IsSuccess() {
+		t.Fatalf("NodeAffinity.NormalizeScore() error = %v", status.AsError())
+	}
+
+	// 合計 weight 5, 4, 1, 0 を最大 100 に正規化する
+	want := NodeScoreList{{Name: "a-ssd", Score: 100}, {Name: "a", Score: 80}, {Name: "ssd", Score: 20}, {Name: "none", Score: 0}}
+	if !reflect.DeepEqual(scores, want) {
+		t.Errorf("NodeAffinity scores = %v, want %v", scores, want)
+	}
+}
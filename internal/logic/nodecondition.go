@@ -0,0 +1,83 @@
+package logic
+
+import (
+	"encoding/json"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	NodeConditionName = "NodeCondition"
+
+	errReasonUnschedulable      = "node(s) were unschedulable"
+	errReasonNotReady           = "node(s) were not ready"
+	errReasonUnreachable        = "node(s) were unreachable"
+	errReasonMemoryPressure     = "node(s) had memory pressure"
+	errReasonDiskPressure       = "node(s) had disk pressure"
+	errReasonPIDPressure        = "node(s) had pid pressure"
+	errReasonNetworkUnavailable = "node(s) had network unavailable"
+)
+
+// NodeCondition は cordon されたノードや、Node.Status.Conditions が異常なノードを除外する
+// コントローラがノードに node.kubernetes.io/* の taint を付ける前でも除外できるよう、condition を直接見る
+// Pod が対応する NoSchedule の taint を許容している場合は除外しない
+type NodeCondition struct{}
+
+var _ FilterPlugin = &NodeCondition{}
+
+func NewNodeCondition(_ json.RawMessage) (Plugin, error) {
+	return &NodeCondition{}, nil
+}
+
+func (pl *NodeCondition) Name() string {
+	return NodeConditionName
+}
+
+// nodeConditionCheck は condition が status のときに付くべき taint と、除外する理由
+type nodeConditionCheck struct {
+	status   v1.ConditionStatus
+	taintKey string
+	reason   string
+}
+
+// condition の種類ごとの確認内容
+// Ready は False なら not-ready、Unknown なら unreachable として扱う
+var nodeConditionChecks = map[v1.NodeConditionType][]nodeConditionCheck{
+	v1.NodeReady: {
+		{status: v1.ConditionFalse, taintKey: v1.TaintNodeNotReady, reason: errReasonNotReady},
+		{status: v1.ConditionUnknown, taintKey: v1.TaintNodeUnreachable, reason: errReasonUnreachable},
+	},
+	v1.NodeMemoryPressure:     {{status: v1.ConditionTrue, taintKey: v1.TaintNodeMemoryPressure, reason: errReasonMemoryPressure}},
+	v1.NodeDiskPressure:       {{status: v1.ConditionTrue, taintKey: v1.TaintNodeDiskPressure, reason: errReasonDiskPressure}},
+	v1.NodePIDPressure:        {{status: v1.ConditionTrue, taintKey: v1.TaintNodePIDPressure, reason: errReasonPIDPressure}},
+	v1.NodeNetworkUnavailable: {{status: v1.ConditionTrue, taintKey: v1.TaintNodeNetworkUnavailable, reason: errReasonNetworkUnavailable}},
+}
+
+// Filter はノードが cordon されているか、condition が異常で、Pod がそれを許容しない場合に除外する
+// Ready の condition を報告していないノードは、登録直後のノードやテスト用のノードを考えて Ready として扱う
+func (pl *NodeCondition) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	node := nodeInfo.Node
+	var reasons []string
+	if node.Spec.Unschedulable && !podToleratesNoScheduleTaint(pod, v1.TaintNodeUnschedulable) {
+		reasons = append(reasons, errReasonUnschedulable)
+	}
+	for _, condition := range node.Status.Conditions {
+		for _, check := range nodeConditionChecks[condition.Type] {
+			if condition.Status != check.status {
+				continue
+			}
+			if !podToleratesNoScheduleTaint(pod, check.taintKey) {
+				reasons = append(reasons, check.reason)
+			}
+		}
+	}
+	if len(reasons) > 0 {
+		return NewStatus(UnschedulableAndUnresolvable, reasons...)
+	}
+	return nil
+}
+
+// Pod が key の NoSchedule の taint を許容するかを返す
+func podToleratesNoScheduleTaint(pod *v1.Pod, key string) bool {
+	return tolerationsTolerateTaint(pod.Spec.Tolerations, &v1.Taint{Key: key, Effect: v1.TaintEffectNoSchedule})
+}
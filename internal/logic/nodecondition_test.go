@@ -0,0 +1,114 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newConditionNode(unschedulable bool, conditions map[v1.NodeConditionType]v1.ConditionStatus) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node"},
+		Spec:       v1.NodeSpec{Unschedulable: unschedulable},
+	}
+	for _, t := range []v1.NodeConditionType{v1.NodeReady, v1.NodeMemoryPressure, v1.NodeDiskPressure, v1.NodePIDPressure, v1.NodeNetworkUnavailable} {
+		if status, ok := conditions[t]; ok {
+			node.Status.Conditions = append(node.Status.Conditions, v1.NodeCondition{Type: t, Status: status})
+		}
+	}
+	return node
+}
+
+func TestNodeCondition_Filter(t *testing.T) {
+	healthy := map[v1.NodeConditionType]v1.ConditionStatus{
+		v1.NodeReady:              v1.ConditionTrue,
+		v1.NodeMemoryPressure:     v1.ConditionFalse,
+		v1.NodeDiskPressure:       v1.ConditionFalse,
+		v1.NodePIDPressure:        v1.ConditionFalse,
+		v1.NodeNetworkUnavailable: v1.ConditionFalse,
+	}
+	with := func(t v1.NodeConditionType, status v1.ConditionStatus) map[v1.NodeConditionType]v1.ConditionStatus {
+		conditions := map[v1.NodeConditionType]v1.ConditionStatus{}
+		for k, v := range healthy {
+			conditions[k] = v
+		}
+		conditions[t] = status
+		return conditions
+	}
+	tolerate := func(key string, effect v1.TaintEffect) []v1.Toleration {
+		return []v1.Toleration{{Key: key, Operator: v1.TolerationOpExists, Effect: effect}}
+	}
+
+	tests := []struct {
+		name          string
+		unschedulable bool
+		conditions    map[v1.NodeConditionType]v1.ConditionStatus
+		tolerations   []v1.Toleration
+		wantReasons   []string
+	}{
+		{name: "healthy node", conditions: healthy},
+		{name: "no conditions reported"},
+		{name: "cordoned", unschedulable: true, conditions: healthy, wantReasons: []string{errReasonUnschedulable}},
+		{name: "cordoned but tolerated", unschedulable: true, conditions: healthy, tolerations: tolerate(v1.TaintNodeUnschedulable, v1.TaintEffectNoSchedule)},
+		{name: "not ready", conditions: with(v1.NodeReady, v1.ConditionFalse), wantReasons: []string{errReasonNotReady}},
+		{name: "ready unknown", conditions: with(v1.NodeReady, v1.ConditionUnknown), wantReasons: []string{errReasonUnreachable}},
+		{name: "not ready but tolerated", conditions: with(v1.NodeReady, v1.ConditionFalse), tolerations: tolerate(v1.TaintNodeNotReady, "")},
+		{
+			// デフォルトで付く NoExecute の toleration では NoSchedule を許容しない
+			name:        "not ready with only NoExecute toleration",
+			conditions:  with(v1.NodeReady, v1.ConditionFalse),
+			tolerations: tolerate(v1.TaintNodeNotReady, v1.TaintEffectNoExecute),
+			wantReasons: []string{errReasonNotReady},
+		},
+		{name: "memory pressure", conditions: with(v1.NodeMemoryPressure, v1.ConditionTrue), wantReasons: []string{errReasonMemoryPressure}},
+		{name: "memory pressure tolerated", conditions: with(v1.NodeMemoryPressure, v1.ConditionTrue), tolerations: tolerate(v1.TaintNodeMemoryPressure, v1.TaintEffectNoSchedule)},
+		{name: "disk pressure", conditions: with(v1.NodeDiskPressure, v1.ConditionTrue), wantReasons: []string{errReasonDiskPressure}},
+		{name: "pid pressure", conditions: with(v1.NodePIDPressure, v1.ConditionTrue), wantReasons: []string{errReasonPIDPressure}},
+		{name: "network unavailable", conditions: with(v1.NodeNetworkUnavailable, v1.ConditionTrue), wantReasons: []string{errReasonNetworkUnavailable}},
+		{
+			// 別の condition の toleration は効かない
+			name:        "disk pressure with memory pressure toleration",
+			conditions:  with(v1.NodeDiskPressure, v1.ConditionTrue),
+			tolerations: tolerate(v1.TaintNodeMemoryPressure, v1.TaintEffectNoSchedule),
+			wantReasons: []string{errReasonDiskPressure},
+		},
+		{
+			name:          "multiple problems",
+			unschedulable: true,
+			conditions: map[v1.NodeConditionType]v1.ConditionStatus{
+				v1.NodeReady:          v1.ConditionFalse,
+				v1.NodeMemoryPressure: v1.ConditionTrue,
+				v1.NodeDiskPressure:   v1.ConditionTrue,
+			},
+			wantReasons: []string{errReasonUnschedulable, errReasonNotReady, errReasonMemoryPressure, errReasonDiskPressure},
+		},
+		{
+			// key を省略した Exists はすべての taint を許容する
+			name:          "tolerate everything",
+			unschedulable: true,
+			conditions: map[v1.NodeConditionType]v1.ConditionStatus{
+				v1.NodeReady:       v1.ConditionUnknown,
+				v1.NodePIDPressure: v1.ConditionTrue,
+			},
+			tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{Spec: v1.PodSpec{Tolerations: tt.tolerations}}
+			nodeInfo := NewNodeInfo(newConditionNode(tt.unschedulable, tt.conditions))
+			status := (&NodeCondition{}).Filter(NewCycleState(), pod, nodeInfo)
+			if len(tt.wantReasons) == 0 {
+				if !status.IsSuccess() {
+					t.Errorf("NodeCondition.Filter() = %v, want success", status.Reasons())
+				}
+				return
+			}
+			if status.Code() != UnschedulableAndUnresolvable || !reflect.DeepEqual(status.Reasons(), tt.wantReasons) {
+				t.Errorf("NodeCondition.Filter() = %v %v, want %v %v", status.Code(), status.Reasons(), UnschedulableAndUnresolvable, tt.wantReasons)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package logic
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// NodeInfo はノードと、そのノードに配置済みの Pod をまとめたもの
+type NodeInfo struct {
+	Node *v1.Node
+	Pods []*v1.Pod
+	// Pods のうち pod affinity / anti-affinity を持つもの
+	PodsWithAffinity []*v1.Pod
+	// Pods のうち required な pod anti-affinity を持つもの
+	PodsWithRequiredAntiAffinity []*v1.Pod
+	// 配置済み Pod の要求量の合計
+	Requested *Resource
+	// PodNonZeroRequests で数えた配置済み Pod の要求量の合計。スコアの計算に使う
+	NonZeroRequested *Resource
+	// ノードの割り当て可能量 (Node.Status.Allocatable)
+	Allocatable *Resource
+	// 配置済み Pod が使っている hostPort
+	UsedPorts HostPortInfo
+}
+
+func NewNodeInfo(node *v1.Node, pods ...*v1.Pod) *NodeInfo {
+	n := &NodeInfo{
+		Node:             node,
+		Requested:        &Resource{},
+		NonZeroRequested: &Resource{},
+		Allocatable:      NewResource(node.Status.Allocatable),
+		UsedPorts:        HostPortInfo{},
+	}
+	for _, pod := range pods {
+		n.AddPod(pod)
+	}
+	return n
+}
+
+func (n *NodeInfo) AddPod(pod *v1.Pod) {
+	n.Pods = append(n.Pods, pod)
+	n.Requested.AddResource(PodRequests(pod))
+	n.NonZeroRequested.AddResource(PodNonZeroRequests(pod))
+	for _, port := range getContainerPorts(pod) {
+		n.UsedPorts.Add(port.HostIP, string(port.Protocol), port.HostPort)
+	}
+	if podHasAffinityConstraints(pod) {
+		n.PodsWithAffinity = append(n.PodsWithAffinity, pod)
+	}
+	if podHasRequiredAntiAffinity(pod) {
+		n.PodsWithRequiredAntiAffinity = append(n.PodsWithRequiredAntiAffinity, pod)
+	}
+}
+
+// withoutPods は removed に含まれる Pod を除いた NodeInfo を新しく作って返す。n は書き換えない
+// removed が nil なら n の複製になる。プリエンプションで Pod を退避させた後の状態を調べるのに使う
+func (n *NodeInfo) withoutPods(removed map[*v1.Pod]bool) *NodeInfo {
+	c := NewNodeInfo(n.Node)
+	for _, pod := range n.Pods {
+		if !removed[pod] {
+			c.AddPod(pod)
+		}
+	}
+	return c
+}
+
+func podHasAffinityConstraints(pod *v1.Pod) bool {
+	affinity := pod.Spec.Affinity
+	return affinity != nil && (affinity.PodAffinity != nil || affinity.PodAntiAffinity != nil)
+}
+
+func podHasRequiredAntiAffinity(pod *v1.Pod) bool {
+	affinity := pod.Spec.Affinity
+	return affinity != nil && affinity.PodAntiAffinity != nil &&
+		len(affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) > 0
+}
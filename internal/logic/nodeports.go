@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	NodePortsName = "NodePorts"
+
+	nodePortsPreFilterStateKey StateKey = "PreFilter" + NodePortsName
+
+	// errReasonNodePorts は hostPort が衝突したノードの理由
+	errReasonNodePorts = "node(s) didn't have free ports for the requested pod ports"
+)
+
+// NodePorts は Pod が要求する hostPort が、ノードに配置済みの Pod の hostPort と衝突するノードを除外する
+// 同じノードに衝突する Pod を置くと kubelet の admission で失敗するため
+type NodePorts struct{}
+
+var _ PreFilterPlugin = &NodePorts{}
+var _ FilterPlugin = &NodePorts{}
+
+func NewNodePorts(_ json.RawMessage) (Plugin, error) {
+	return &NodePorts{}, nil
+}
+
+func (pl *NodePorts) Name() string {
+	return NodePortsName
+}
+
+// Pod が要求する hostPort の一覧
+type nodePortsPreFilterState []v1.ContainerPort
+
+// PreFilter は Pod が要求する hostPort を集めて CycleState に書き込む
+func (pl *NodePorts) PreFilter(state *CycleState, pod *v1.Pod, snapshot *Snapshot) *Status {
+	var wantPorts nodePortsPreFilterState
+	for _, port := range getContainerPorts(pod) {
+		if port.HostPort > 0 {
+			wantPorts = append(wantPorts, port)
+		}
+	}
+	state.Write(nodePortsPreFilterStateKey, wantPorts)
+	return nil
+}
+
+func getNodePortsPreFilterState(state *CycleState) (nodePortsPreFilterState, error) {
+	c, err := state.Read(nodePortsPreFilterStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(nodePortsPreFilterState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to nodePortsPreFilterState error", c)
+	}
+	return s, nil
+}
+
+func (pl *NodePorts) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	wantPorts, err := getNodePortsPreFilterState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	for _, port := range wantPorts {
+		if nodeInfo.UsedPorts.CheckConflict(port.HostIP, string(port.Protocol), port.HostPort) {
+			return NewStatus(Unschedulable, errReasonNodePorts)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package logic
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func newHostPortPod(name, nodeName string, ports ...v1.ContainerPort) *v1.Pod {
+	pod := newLabeledPod(name, "default", nodeName, nil, nil)
+	pod.Spec.Containers = []v1.Container{{Name: "app", Ports: ports}}
+	return pod
+}
+
+func TestHostPortInfo_CheckConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		used     []v1.ContainerPort
+		port     v1.ContainerPort
+		conflict bool
+	}{
+		{
+			name: "no ports used",
+			port: v1.ContainerPort{HostPort: 8080},
+		},
+		{
+			name:     "same port with defaults",
+			used:     []v1.ContainerPort{{HostPort: 8080}},
+			port:     v1.ContainerPort{HostPort: 8080, Protocol: v1.ProtocolTCP},
+			conflict: true,
+		},
+		{
+			name: "different protocol",
+			used: []v1.ContainerPort{{HostPort: 53, Protocol: v1.ProtocolUDP}},
+			port: v1.ContainerPort{HostPort: 53, Protocol: v1.ProtocolTCP},
+		},
+		{
+			name: "different host ip",
+			used: []v1.ContainerPort{{HostIP: "10.0.0.1", HostPort: 8080}},
+			port: v1.ContainerPort{HostIP: "10.0.0.2", HostPort: 8080},
+		},
+		{
+			// 使われている 0.0.0.0 はすべての IP と衝突する
+			name:     "used wildcard conflicts with specific ip",
+			used:     []v1.ContainerPort{{HostPort: 8080}},
+			port:     v1.ContainerPort{HostIP: "10.0.0.1", HostPort: 8080},
+			conflict: true,
+		},
+		{
+			// 要求する 0.0.0.0 もすべての IP と衝突する
+			name:     "wanted wildcard conflicts with specific ip",
+			used:     []v1.ContainerPort{{HostIP: "10.0.0.1", HostPort: 8080}},
+			port:     v1.ContainerPort{HostIP: DefaultBindAllHostIP, HostPort: 8080},
+			conflict: true,
+		},
+		{
+			// hostPort を持たない containerPort は数えない
+			name: "container port only",
+			used: []v1.ContainerPort{{ContainerPort: 8080}},
+			port: v1.ContainerPort{ContainerPort: 8080},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := HostPortInfo{}
+			for _, p := range tt.used {
+				h.Add(p.HostIP, string(p.Protocol), p.HostPort)
+			}
+			if got := h.CheckConflict(tt.port.HostIP, string(tt.port.Protocol), tt.port.HostPort); got != tt.conflict {
+				t.Errorf("HostPortInfo.CheckConflict() = %v, want %v", got, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestNodePorts_Filter(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+	node := newZoneNode("node", "zone-a")
+	sidecarPod := newHostPortPod("sidecar", "node")
+	sidecarPod.Spec.InitContainers = []v1.Container{{Name: "proxy", RestartPolicy: &always, Ports: []v1.ContainerPort{{HostPort: 15001}}}}
+	initPod := newHostPortPod("init", "node")
+	initPod.Spec.InitContainers = []v1.Container{{Name: "setup", Ports: []v1.ContainerPort{{HostPort: 15001}}}}
+
+	tests := []struct {
+		name       string
+		pod        *v1.Pod
+		nodeInfo   *NodeInfo
+		wantReason string
+	}{
+		{
+			name:     "pod without host ports",
+			pod:      newHostPortPod("pod", "", v1.ContainerPort{ContainerPort: 80}),
+			nodeInfo: NewNodeInfo(&node, newHostPortPod("existing", "node", v1.ContainerPort{HostPort: 80})),
+		},
+		{
+			name:       "host port already used",
+			pod:        newHostPortPod("pod", "", v1.ContainerPort{HostPort: 80}),
+			nodeInfo:   NewNodeInfo(&node, newHostPortPod("existing", "node", v1.ContainerPort{HostPort: 80})),
+			wantReason: errReasonNodePorts,
+		},
+		{
+			name:     "different host port",
+			pod:      newHostPortPod("pod", "", v1.ContainerPort{HostPort: 80}),
+			nodeInfo: NewNodeInfo(&node, newHostPortPod("existing", "node", v1.ContainerPort{HostPort: 443})),
+		},
+		{
+			name:       "sidecar host port is used",
+			pod:        newHostPortPod("pod", "", v1.ContainerPort{HostPort: 15001}),
+			nodeInfo:   NewNodeInfo(&node, sidecarPod),
+			wantReason: errReasonNodePorts,
+		},
+		{
+			// 終了済みの init コンテナのポートは使われていない
+			name:     "init container host port is released",
+			pod:      newHostPortPod("pod", "", v1.ContainerPort{HostPort: 15001}),
+			nodeInfo: NewNodeInfo(&node, initPod),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pl := &NodePorts{}
+			state := NewCycleState()
+			if status := pl.PreFilter(state, tt.pod, NewSnapshot(&v1.NodeList{Items: []v1.Node{node}}, nil, nil)); !status.IsSuccess() {
+				t.Fatalf("NodePorts.PreFilter() status = %v", status)
+			}
+			status := pl.Filter(state, tt.pod, tt.nodeInfo)
+			if tt.wantReason == "" {
+				if !status.IsSuccess() {
+					t.Errorf("NodePorts.Filter() = %v, want success", status.Reasons())
+				}
+				return
+			}
+			if status.Code() != Unschedulable || len(status.Reasons()) != 1 || status.Reasons()[0] != tt.wantReason {
+				t.Errorf("NodePorts.Filter() = %v, want %q", status.Reasons(), tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const NodeResourcesFitName = "NodeResourcesFit"
+
+// ScoringStrategyType はノードの空き容量からスコアを付ける方法
+type ScoringStrategyType string
+
+const (
+	// LeastAllocated は空きが多いノードほど高いスコアにする (分散)
+	LeastAllocated ScoringStrategyType = "LeastAllocated"
+	// MostAllocated は使用率が高いノードほど高いスコアにする (ビンパッキング)
+	MostAllocated ScoringStrategyType = "MostAllocated"
+	// RequestedToCapacityRatio は使用率をユーザが定義した関数でスコアに変換する
+	RequestedToCapacityRatio ScoringStrategyType = "RequestedToCapacityRatio"
+)
+
+// NodeResourcesFitArgs は NodeResourcesFit の引数
+type NodeResourcesFitArgs struct {
+	ScoringStrategy *ScoringStrategy `json:"scoringStrategy"`
+}
+
+// ScoringStrategy は NodeResourcesFit の Score の計算方法
+type ScoringStrategy struct {
+	Type ScoringStrategyType `json:"type"`
+	// スコアの計算に使うリソースと重み。リソースごとのスコアを重み付きで平均する
+	Resources []ResourceSpec `json:"resources"`
+	// type が RequestedToCapacityRatio のときだけ指定する
+	RequestedToCapacityRatio *RequestedToCapacityRatioParam `json:"requestedToCapacityRatio"`
+}
+
+// ResourceSpec はスコアの計算に使うリソース 1 つ
+type ResourceSpec struct {
+	Name   string `json:"name"`
+	Weight int64  `json:"weight"`
+}
+
+// RequestedToCapacityRatioParam は使用率からスコアへの変換関数
+type RequestedToCapacityRatioParam struct {
+	// 使用率 (0 〜 100) とスコア (0 〜 10) の点の並び。点の間は線形に補間する
+	Shape []UtilizationShapePoint `json:"shape"`
+}
+
+type UtilizationShapePoint struct {
+	Utilization int32 `json:"utilization"`
+	Score       int32 `json:"score"`
+}
+
+const (
+	// UtilizationShapePoint の score の最大値。MaxNodeScore に拡大して使う
+	maxCustomPriorityScore int32 = 10
+	maxResourceWeight      int64 = 100
+)
+
+// DefaultNodeResourcesFitArgs は CPU とメモリを同じ重みで見る LeastAllocated を返す
+func DefaultNodeResourcesFitArgs() NodeResourcesFitArgs {
+	return NodeResourcesFitArgs{
+		ScoringStrategy: &ScoringStrategy{
+			Type: LeastAllocated,
+			Resources: []ResourceSpec{
+				{Name: string(v1.ResourceCPU), Weight: 1},
+				{Name: string(v1.ResourceMemory), Weight: 1},
+			},
+		},
+	}
+}
+
+// NodeResourcesFit はノードのリソースを扱う
+//   - Filter: 配置済み Pod の要求量を差し引いたノードの空き容量に、Pod の要求量が収まるかどうかを判定する
+//   - Score: Pod を配置した後の使用率から、scoringStrategy に従ってスコアを付ける
+type NodeResourcesFit struct {
+	scorer *resourceAllocationScorer
+}
+
+var _ FilterPlugin = &NodeResourcesFit{}
+var _ ScorePlugin = &NodeResourcesFit{}
+
+func NewNodeResourcesFit(rawArgs json.RawMessage) (Plugin, error) {
+	args := DefaultNodeResourcesFitArgs()
+	if err := decodeArgs(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	if args.ScoringStrategy == nil {
+		args.ScoringStrategy = DefaultNodeResourcesFitArgs().ScoringStrategy
+	}
+	if len(args.ScoringStrategy.Resources) == 0 {
+		args.ScoringStrategy.Resources = DefaultNodeResourcesFitArgs().ScoringStrategy.Resources
+	}
+	if err := validateScoringStrategy(args.ScoringStrategy); err != nil {
+		return nil, err
+	}
+	return &NodeResourcesFit{scorer: newResourceAllocationScorer(args.ScoringStrategy)}, nil
+}
+
+func validateScoringStrategy(strategy *ScoringStrategy) error {
+	var errs []error
+	switch strategy.Type {
+	case LeastAllocated, MostAllocated:
+		if strategy.RequestedToCapacityRatio != nil {
+			errs = append(errs, fmt.Errorf("scoringStrategy.requestedToCapacityRatio: must be empty for type %s", strategy.Type))
+		}
+	case RequestedToCapacityRatio:
+		if strategy.RequestedToCapacityRatio == nil {
+			errs = append(errs, errors.New("scoringStrategy.requestedToCapacityRatio: required for type RequestedToCapacityRatio"))
+		} else {
+			errs = append(errs, validateUtilizationShape(strategy.RequestedToCapacityRatio.Shape))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("scoringStrategy.type: must be one of %s, %s, %s, got %q", LeastAllocated, MostAllocated, RequestedToCapacityRatio, strategy.Type))
+	}
+
+	errs = append(errs, validateResources("scoringStrategy.resources", strategy.Resources)...)
+	return errors.Join(errs...)
+}
+
+// validateResources はスコアの計算に使うリソースの一覧を検証する
+func validateResources(path string, resources []ResourceSpec) []error {
+	var errs []error
+	seen := map[string]bool{}
+	for i, r := range resources {
+		path := fmt.Sprintf("%s[%d]", path, i)
+		if r.Name == "" {
+			errs = append(errs, fmt.Errorf("%s.name: required", path))
+		}
+		if seen[r.Name] {
+			errs = append(errs, fmt.Errorf("%s.name: duplicated resource %q", path, r.Name))
+		}
+		seen[r.Name] = true
+		if r.Weight <= 0 || r.Weight > maxResourceWeight {
+			errs = append(errs, fmt.Errorf("%s.weight: must be in the range 1-%d, got %d", path, maxResourceWeight, r.Weight))
+		}
+	}
+	return errs
+}
+
+func validateUtilizationShape(shape []UtilizationShapePoint) error {
+	if len(shape) == 0 {
+		return errors.New("scoringStrategy.requestedToCapacityRatio.shape: at least one point is required")
+	}
+	var errs []error
+	for i, point := range shape {
+		path := fmt.Sprintf("scoringStrategy.requestedToCapacityRatio.shape[%d]", i)
+		if point.Utilization < 0 || point.Utilization > 100 {
+			errs = append(errs, fmt.Errorf("%s.utilization: must be in the range 0-100, got %d", path, point.Utilization))
+		}
+		if i > 0 && point.Utilization <= shape[i-1].Utilization {
+			errs = append(errs, fmt.Errorf("%s.utilization: must be greater than the previous point, got %d", path, point.Utilization))
+		}
+		if point.Score < 0 || point.Score > maxCustomPriorityScore {
+			errs = append(errs, fmt.Errorf("%s.score: must be in the range 0-%d, got %d", path, maxCustomPriorityScore, point.Score))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (f *NodeResourcesFit) Name() string {
+	return NodeResourcesFitName
+}
+
+func (f *NodeResourcesFit) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	if reasons := fitsRequest(PodRequests(pod), nodeInfo); len(reasons) > 0 {
+		return NewStatus(Unschedulable, reasons...)
+	}
+	return nil
+}
+
+// Score は Pod を配置した後のノードの使用率から、scoringStrategy に従ったスコアを返す
+func (f *NodeResourcesFit) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	return f.scorer.score(pod, nodeInfo), nil
+}
+
+// Pod の要求量がノードの空き容量に収まるかを調べ、収まらないリソースごとの理由を返す
+// 収まる場合は空のスライスを返す
+func fitsRequest(podRequest *Resource, nodeInfo *NodeInfo) []string {
+	var reasons []string
+
+	// ノードが pods の allocatable を報告していない場合は上限なしとして扱う
+	// upstream は 0 として扱うが、pods を省略したノード (テストや手で作ったノード) に配置できなくなるので、
+	// Pod の数を数え始める前からの動作を変えない
+	if _, ok := nodeInfo.Node.Status.Allocatable[v1.ResourcePods]; ok {
+		if int64(len(nodeInfo.Pods))+1 > nodeInfo.Allocatable.AllowedPodNumber {
+			reasons = append(reasons, "Too many pods")
+		}
+	}
+
+	allocatable := nodeInfo.Allocatable
+	requested := nodeInfo.Requested
+	if podRequest.MilliCPU > 0 && podRequest.MilliCPU > allocatable.MilliCPU-requested.MilliCPU {
+		reasons = append(reasons, insufficientReason(v1.ResourceCPU))
+	}
+	if podRequest.Memory > 0 && podRequest.Memory > allocatable.Memory-requested.Memory {
+		reasons = append(reasons, insufficientReason(v1.ResourceMemory))
+	}
+	if podRequest.EphemeralStorage > 0 && podRequest.EphemeralStorage > allocatable.EphemeralStorage-requested.EphemeralStorage {
+		reasons = append(reasons, insufficientReason(v1.ResourceEphemeralStorage))
+	}
+	for name, quantity := range podRequest.ScalarResources {
+		if quantity > 0 && quantity > allocatable.ScalarResources[name]-requested.ScalarResources[name] {
+			reasons = append(reasons, insufficientReason(name))
+		}
+	}
+	return reasons
+}
+
+func insufficientReason(name v1.ResourceName) string {
+	return fmt.Sprintf("Insufficient %s", name)
+}
@@ -0,0 +1,153 @@
+package logic
+
+import (
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newResourceNode(name string, allocatable v1.ResourceList) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NodeStatus{Allocatable: allocatable},
+	}
+}
+
+func newRequestPod(name string, requests v1.ResourceList) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       v1.PodSpec{Containers: []v1.Container{{Resources: v1.ResourceRequirements{Requests: requests}}}},
+	}
+}
+
+func TestNewNodeResourcesFit(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr bool
+	}{
+		{name: "success: default args", args: ""},
+		{name: "success: most allocated", args: `{"scoringStrategy":{"type":"MostAllocated","resources":[{"name":"cpu","weight":3},{"name":"nvidia.com/gpu","weight":1}]}}`},
+		// resources を省略すると cpu と memory を使う
+		{name: "success: resources omitted", args: `{"scoringStrategy":{"type":"MostAllocated"}}`},
+		{name: "success: requested to capacity ratio", args: `{"scoringStrategy":{"type":"RequestedToCapacityRatio","requestedToCapacityRatio":{"shape":[{"utilization":0,"score":10},{"utilization":100,"score":0}]}}}`},
+		{name: "failure: unknown field", args: `{"strategy":{}}`, wantErr: true},
+		{name: "failure: unknown type", args: `{"scoringStrategy":{"type":"Balanced"}}`, wantErr: true},
+		{name: "failure: weight out of range", args: `{"scoringStrategy":{"type":"LeastAllocated","resources":[{"name":"cpu","weight":0}]}}`, wantErr: true},
+		{name: "failure: duplicated resource", args: `{"scoringStrategy":{"type":"LeastAllocated","resources":[{"name":"cpu","weight":1},{"name":"cpu","weight":2}]}}`, wantErr: true},
+		{name: "failure: shape missing", args: `{"scoringStrategy":{"type":"RequestedToCapacityRatio"}}`, wantErr: true},
+		{name: "failure: shape with other type", args: `{"scoringStrategy":{"type":"LeastAllocated","requestedToCapacityRatio":{"shape":[{"utilization":0,"score":0}]}}}`, wantErr: true},
+		{name: "failure: utilization not increasing", args: `{"scoringStrategy":{"type":"RequestedToCapacityRatio","requestedToCapacityRatio":{"shape":[{"utilization":50,"score":0},{"utilization":50,"score":10}]}}}`, wantErr: true},
+		{name: "failure: score out of range", args: `{"scoringStrategy":{"type":"RequestedToCapacityRatio","requestedToCapacityRatio":{"shape":[{"utilization":0,"score":11}]}}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewNodeResourcesFit(json.RawMessage(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewNodeResourcesFit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNodeResourcesFit_Score(t *testing.T) {
+	// 4 CPU / 4Gi のノードに 1 CPU / 1Gi の Pod が配置済み
+	node := newResourceNode("node", v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("4"),
+		v1.ResourceMemory: resource.MustParse("4Gi"),
+	})
+	existing := newRequestPod("existing", v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("1"),
+		v1.ResourceMemory: resource.MustParse("1Gi"),
+	})
+	// 配置後は CPU 50%, メモリ 75%
+	pod := newRequestPod("pod", v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse("1"),
+		v1.ResourceMemory: resource.MustParse("2Gi"),
+	})
+
+	tests := []struct {
+		name     string
+		args     string
+		pod      *v1.Pod
+		nodeInfo *NodeInfo
+		want     int64
+	}{
+		{
+			name: "least allocated by default",
+			pod:  pod, nodeInfo: NewNodeInfo(node, existing),
+			// CPU: (4000-2000)*100/4000 = 50, メモリ: (4-3)*100/4 = 25, 平均 (50+25)/2 = 37
+			want: 37,
+		},
+		{
+			name: "least allocated with weights",
+			args: `{"scoringStrategy":{"type":"LeastAllocated","resources":[{"name":"cpu","weight":3},{"name":"memory","weight":1}]}}`,
+			pod:  pod, nodeInfo: NewNodeInfo(node, existing),
+			// (50*3 + 25*1) / 4 = 43
+			want: 43,
+		},
+		{
+			name: "most allocated",
+			args: `{"scoringStrategy":{"type":"MostAllocated"}}`,
+			pod:  pod, nodeInfo: NewNodeInfo(node, existing),
+			// CPU: 50, メモリ: 75, 平均 62
+			want: 62,
+		},
+		{
+			name: "requested to capacity ratio",
+			args: `{"scoringStrategy":{"type":"RequestedToCapacityRatio","requestedToCapacityRatio":{"shape":[{"utilization":50,"score":0},{"utilization":100,"score":10}]}}}`,
+			pod:  pod, nodeInfo: NewNodeInfo(node, existing),
+			// CPU: 50% → 0, メモリ: 75% → 0 + (100-0)*(75-50)/(100-50) = 50, 平均 25
+			want: 25,
+		},
+		{
+			name: "requested to capacity ratio below the first point",
+			args: `{"scoringStrategy":{"type":"RequestedToCapacityRatio","requestedToCapacityRatio":{"shape":[{"utilization":80,"score":4},{"utilization":100,"score":10}]}}}`,
+			pod:  pod, nodeInfo: NewNodeInfo(node, existing),
+			// 最初の点より左なので、どちらも 4 * 10 = 40
+			want: 40,
+		},
+		{
+			name: "pod without requests counts as default requests",
+			pod:  newRequestPod("pod", nil), nodeInfo: NewNodeInfo(node),
+			// CPU: (4000-100)*100/4000 = 97, メモリ: (4096-200)*100/4096 = 95, 平均 96
+			want: 96,
+		},
+		{
+			name: "resource the node does not have is ignored",
+			args: `{"scoringStrategy":{"type":"LeastAllocated","resources":[{"name":"cpu","weight":1},{"name":"nvidia.com/gpu","weight":5}]}}`,
+			pod:  pod, nodeInfo: NewNodeInfo(node, existing),
+			want: 50,
+		},
+		{
+			name: "least allocated over capacity",
+			args: `{"scoringStrategy":{"type":"LeastAllocated","resources":[{"name":"cpu","weight":1}]}}`,
+			pod:  newRequestPod("pod", v1.ResourceList{v1.ResourceCPU: resource.MustParse("5")}), nodeInfo: NewNodeInfo(node),
+			want: 0,
+		},
+		{
+			name: "most allocated over capacity",
+			args: `{"scoringStrategy":{"type":"MostAllocated","resources":[{"name":"cpu","weight":1}]}}`,
+			pod:  newRequestPod("pod", v1.ResourceList{v1.ResourceCPU: resource.MustParse("5")}), nodeInfo: NewNodeInfo(node),
+			want: MaxNodeScore,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewNodeResourcesFit(json.RawMessage(tt.args))
+			if err != nil {
+				t.Fatalf("NewNodeResourcesFit() error = %v", err)
+			}
+			got, status := p.(ScorePlugin).Score(NewCycleState(), tt.pod, tt.nodeInfo)
+			if !status.IsSuccess() {
+				t.Fatalf("Score() status = %v", status)
+			}
+			if got != tt.want {
+				t.Errorf("Score() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
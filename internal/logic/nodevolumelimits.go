@@ -0,0 +1,140 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	NodeVolumeLimitsName = "NodeVolumeLimits"
+
+	nodeVolumeLimitsStateKey StateKey = "PreFilter" + NodeVolumeLimitsName
+
+	errReasonMaxVolumeCountExceeded = "node(s) exceed max volume count"
+)
+
+// NodeVolumeLimits は CSINode に書かれたドライバごとのアタッチ数の上限を超えるノードを除外する
+// CSINode がないノードや、上限が書かれていないドライバは上限なしとして扱う
+type NodeVolumeLimits struct{}
+
+var _ PreFilterPlugin = &NodeVolumeLimits{}
+var _ FilterPlugin = &NodeVolumeLimits{}
+
+func NewNodeVolumeLimits(_ json.RawMessage) (Plugin, error) {
+	return &NodeVolumeLimits{}, nil
+}
+
+func (pl *NodeVolumeLimits) Name() string {
+	return NodeVolumeLimitsName
+}
+
+// ドライバ名 → ボリュームを一意に表すハンドルの集合
+type csiVolumes map[string]map[string]struct{}
+
+func (c csiVolumes) add(driver, handle string) {
+	if _, ok := c[driver]; !ok {
+		c[driver] = map[string]struct{}{}
+	}
+	c[driver][handle] = struct{}{}
+}
+
+type nodeVolumeLimitsState struct {
+	// Pod が新たに使う CSI のボリューム
+	volumes     csiVolumes
+	storageInfo *StorageInfo
+}
+
+// PreFilter は Pod が使う CSI のボリュームを集めて CycleState に書き込む
+func (pl *NodeVolumeLimits) PreFilter(state *CycleState, pod *v1.Pod, snapshot *Snapshot) *Status {
+	state.Write(nodeVolumeLimitsStateKey, &nodeVolumeLimitsState{
+		volumes:     podCSIVolumes(pod, snapshot.StorageInfo()),
+		storageInfo: snapshot.StorageInfo(),
+	})
+	return nil
+}
+
+func getNodeVolumeLimitsState(state *CycleState) (*nodeVolumeLimitsState, error) {
+	c, err := state.Read(nodeVolumeLimitsStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(*nodeVolumeLimitsState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to nodeVolumeLimitsState error", c)
+	}
+	return s, nil
+}
+
+// Filter はノードにアタッチ済みのボリュームに、Pod のボリュームのうちまだアタッチされていないものを足した数が
+// ドライバごとの上限を超えないかを見る。配置済み Pod と同じ PVC を使う場合は数を増やさない
+func (pl *NodeVolumeLimits) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	s, err := getNodeVolumeLimitsState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	if len(s.volumes) == 0 {
+		return nil
+	}
+	csiNode := s.storageInfo.CSINode(nodeInfo.Node.Name)
+	if csiNode == nil {
+		return nil
+	}
+
+	attached := csiVolumes{}
+	for _, p := range nodeInfo.Pods {
+		for driver, handles := range podCSIVolumes(p, s.storageInfo) {
+			for handle := range handles {
+				attached.add(driver, handle)
+			}
+		}
+	}
+
+	for _, driver := range csiNode.Spec.Drivers {
+		if driver.Allocatable == nil || driver.Allocatable.Count == nil {
+			continue
+		}
+		newCount := 0
+		for handle := range s.volumes[driver.Name] {
+			if _, ok := attached[driver.Name][handle]; !ok {
+				newCount++
+			}
+		}
+		if newCount > 0 && len(attached[driver.Name])+newCount > int(*driver.Allocatable.Count) {
+			return NewStatus(Unschedulable, errReasonMaxVolumeCountExceeded)
+		}
+	}
+	return nil
+}
+
+// podCSIVolumes は Pod が使う CSI のボリュームをドライバごとに返す
+//   - 束縛済みの PVC: PV の CSI ドライバとボリュームハンドル
+//   - 未束縛の PVC: これからプロビジョニングされるので、StorageClass の provisioner と PVC の名前
+//   - CSI のインラインボリューム: ドライバと Pod 内のボリューム名
+//
+// PVC や PV が見つからないボリュームは数えない (VolumeBinding が除外する)
+func podCSIVolumes(pod *v1.Pod, storageInfo *StorageInfo) csiVolumes {
+	volumes := csiVolumes{}
+	for _, vol := range pod.Spec.Volumes {
+		if vol.CSI != nil {
+			volumes.add(vol.CSI.Driver, pod.Namespace+"/"+pod.Name+"/"+vol.Name)
+		}
+	}
+	for _, name := range podVolumeClaimNames(pod) {
+		pvc := storageInfo.PVC(pod.Namespace, name)
+		if pvc == nil {
+			continue
+		}
+		if pvc.Spec.VolumeName == "" {
+			if class := storageInfo.StorageClass(storageClassName(pvc)); class != nil && class.Provisioner != noProvisioner {
+				volumes.add(class.Provisioner, "pvc:"+pvc.Namespace+"/"+pvc.Name)
+			}
+			continue
+		}
+		if pv := storageInfo.PV(pvc.Spec.VolumeName); pv != nil && pv.Spec.CSI != nil {
+			volumes.add(pv.Spec.CSI.Driver, pv.Spec.CSI.VolumeHandle)
+		}
+	}
+	return volumes
+}
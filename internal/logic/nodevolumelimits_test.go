@@ -0,0 +1,134 @@
+package logic
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+const testCSIDriver = "ebs.csi.aws.com"
+
+func newCSINode(name string, limit *int32) *storagev1.CSINode {
+	return &storagev1.CSINode{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: storagev1.CSINodeSpec{Drivers: []storagev1.CSINodeDriver{{
+			Name:        testCSIDriver,
+			NodeID:      name,
+			Allocatable: &storagev1.VolumeNodeResources{Count: limit},
+		}}},
+	}
+}
+
+func withCSISource(pv *v1.PersistentVolume, driver string) *v1.PersistentVolume {
+	pv.Spec.CSI = &v1.CSIPersistentVolumeSource{Driver: driver, VolumeHandle: "handle-" + pv.Name}
+	return pv
+}
+
+func TestNodeVolumeLimits_Filter(t *testing.T) {
+	node := newZoneNode("node", "zone-a")
+	classes := []*storagev1.StorageClass{
+		newStorageClass(testImmediateClass, testCSIDriver, storagev1.VolumeBindingImmediate),
+		newStorageClass(testDelayedClass, testCSIDriver, storagev1.VolumeBindingWaitForFirstConsumer),
+	}
+	var pvcs []*v1.PersistentVolumeClaim
+	var pvs []*v1.PersistentVolume
+	for _, name := range []string{"vol-1", "vol-2", "vol-3"} {
+		pvc := newPVC(name, testImmediateClass, "pv-"+name, "1Gi")
+		pvcs = append(pvcs, pvc)
+		pvs = append(pvs, withCSISource(withClaimRef(newPV("pv-"+name, testImmediateClass, "1Gi", ""), pvc), testCSIDriver))
+	}
+	pvcs = append(pvcs, newPVC("unbound", testDelayedClass, "", "1Gi"))
+	// 別のドライバのボリュームは数えない
+	otherPVC := newPVC("other", "other", "pv-other", "1Gi")
+	pvcs = append(pvcs, otherPVC)
+	pvs = append(pvs, withCSISource(withClaimRef(newPV("pv-other", "other", "1Gi", ""), otherPVC), "other.csi.example.com"))
+
+	assigned := func(name string, claimNames ...string) *v1.Pod {
+		pod := newPVCPod(name, claimNames...)
+		pod.Spec.NodeName = "node"
+		return pod
+	}
+
+	tests := []struct {
+		name     string
+		pod      *v1.Pod
+		existing []*v1.Pod
+		csiNode  *storagev1.CSINode
+		wantFail bool
+	}{
+		{
+			name:     "under the limit",
+			pod:      newPVCPod("pod", "vol-2"),
+			existing: []*v1.Pod{assigned("existing", "vol-1")},
+			csiNode:  newCSINode("node", ptr.To[int32](2)),
+		},
+		{
+			name:     "exceeds the limit",
+			pod:      newPVCPod("pod", "vol-3"),
+			existing: []*v1.Pod{assigned("existing-1", "vol-1"), assigned("existing-2", "vol-2")},
+			csiNode:  newCSINode("node", ptr.To[int32](2)),
+			wantFail: true,
+		},
+		{
+			// 配置済みの Pod と同じボリュームは新たにアタッチされない
+			name:     "shared volume is counted once",
+			pod:      newPVCPod("pod", "vol-1"),
+			existing: []*v1.Pod{assigned("existing-1", "vol-1"), assigned("existing-2", "vol-2")},
+			csiNode:  newCSINode("node", ptr.To[int32](2)),
+		},
+		{
+			// 未束縛の PVC は StorageClass の provisioner のボリュームとして数える
+			name:     "unbound claim counts for provisioner",
+			pod:      newPVCPod("pod", "unbound"),
+			existing: []*v1.Pod{assigned("existing", "vol-1")},
+			csiNode:  newCSINode("node", ptr.To[int32](1)),
+			wantFail: true,
+		},
+		{
+			name:     "other driver is not counted",
+			pod:      newPVCPod("pod", "vol-2"),
+			existing: []*v1.Pod{assigned("existing", "vol-1", "other")},
+			csiNode:  newCSINode("node", ptr.To[int32](2)),
+		},
+		{
+			name:     "no csi node",
+			pod:      newPVCPod("pod", "vol-3"),
+			existing: []*v1.Pod{assigned("existing-1", "vol-1"), assigned("existing-2", "vol-2")},
+		},
+		{
+			name:     "no limit for driver",
+			pod:      newPVCPod("pod", "vol-3"),
+			existing: []*v1.Pod{assigned("existing-1", "vol-1"), assigned("existing-2", "vol-2")},
+			csiNode:  newCSINode("node", nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var csiNodes []*storagev1.CSINode
+			if tt.csiNode != nil {
+				csiNodes = append(csiNodes, tt.csiNode)
+			}
+			snapshot := NewSnapshot(&v1.NodeList{Items: []v1.Node{node}}, tt.existing, nil)
+			snapshot.SetStorageInfo(NewStorageInfo(pvcs, pvs, classes, csiNodes))
+
+			pl := &NodeVolumeLimits{}
+			state := NewCycleState()
+			if status := pl.PreFilter(state, tt.pod, snapshot); !status.IsSuccess() {
+				t.Fatalf("NodeVolumeLimits.PreFilter() status = %v", status)
+			}
+			status := pl.Filter(state, tt.pod, snapshot.Get("node"))
+			if tt.wantFail {
+				if status.Code() != Unschedulable || status.Reasons()[0] != errReasonMaxVolumeCountExceeded {
+					t.Errorf("NodeVolumeLimits.Filter() = %v, want %q", status.Reasons(), errReasonMaxVolumeCountExceeded)
+				}
+				return
+			}
+			if !status.IsSuccess() {
+				t.Errorf("NodeVolumeLimits.Filter() = %v, want success", status.Reasons())
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package logic
+
+import (
+	"context"
+	"math"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// DefaultParallelism は Filter と Score をノードごとに並行に実行するワーカー数のデフォルト
+const DefaultParallelism = 16
+
+// parallelize は doWorkPiece(0) 〜 doWorkPiece(pieces-1) を s.parallelism 個のワーカーで並行に実行する
+// ctx がキャンセルされたら、まだ始めていない piece は実行しない
+// 結果は piece ごとに別の添字に書き込み、実行の順序に依存しないようにする
+func (s *ScheduleLogic) parallelize(ctx context.Context, pieces int, doWorkPiece func(piece int)) {
+	workqueue.ParallelizeUntil(ctx, s.parallelism, pieces, doWorkPiece, workqueue.WithChunkSize(chunkSizeFor(pieces, s.parallelism)))
+}
+
+// ワーカーが 1 度に受け取る piece の数
+// 小さいと受け渡しの負荷が大きくなり、大きいとワーカー間の偏りや、キャンセル後に無駄に実行する piece が増える
+func chunkSizeFor(pieces, parallelism int) int {
+	size := int(math.Sqrt(float64(pieces)))
+	if r := pieces/parallelism + 1; size > r {
+		size = r
+	}
+	return max(size, 1)
+}
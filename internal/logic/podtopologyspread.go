@@ -0,0 +1,548 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const PodTopologySpreadName = "PodTopologySpread"
+
+const (
+	spreadPreFilterStateKey StateKey = "PreFilter" + PodTopologySpreadName
+	spreadPreScoreStateKey  StateKey = "PreScore" + PodTopologySpreadName
+)
+
+// PodTopologySpread の Filter が返す理由
+const (
+	errReasonConstraintsNotMatch = "node(s) didn't match pod topology spread constraints"
+	errReasonNodeLabelNotMatch   = errReasonConstraintsNotMatch + " (missing required label)"
+)
+
+const (
+	// SystemDefaulting は Pod が制約を持たないとき、systemDefaultConstraints を使う
+	SystemDefaulting = "System"
+	// ListDefaulting は Pod が制約を持たないとき、引数の defaultConstraints を使う
+	ListDefaulting = "List"
+)
+
+// systemDefaultConstraints はホストとゾーンにゆるく分散させる、upstream と同じデフォルトの制約
+var systemDefaultConstraints = []v1.TopologySpreadConstraint{
+	{TopologyKey: v1.LabelHostname, WhenUnsatisfiable: v1.ScheduleAnyway, MaxSkew: 3},
+	{TopologyKey: v1.LabelTopologyZone, WhenUnsatisfiable: v1.ScheduleAnyway, MaxSkew: 5},
+}
+
+// Pod ごとに値が異なるため、デフォルトの制約で同じワークロードの Pod を数えるときに使わないラベル
+var podUniqueLabelKeys = []string{
+	"statefulset.kubernetes.io/pod-name",
+	"apps.kubernetes.io/pod-index",
+}
+
+// PodTopologySpreadArgs は PodTopologySpread の引数
+type PodTopologySpreadArgs struct {
+	// topologySpreadConstraints を持たない Pod に適用する制約。defaultingType が List のときだけ使う
+	// labelSelector は指定できず、Pod 自身のラベルで同じワークロードの Pod を数える
+	DefaultConstraints []v1.TopologySpreadConstraint `json:"defaultConstraints"`
+	// System または List
+	DefaultingType string `json:"defaultingType"`
+}
+
+func DefaultPodTopologySpreadArgs() PodTopologySpreadArgs {
+	return PodTopologySpreadArgs{DefaultingType: SystemDefaulting}
+}
+
+// PodTopologySpread は Pod の topologySpreadConstraints を扱う
+//   - Filter: DoNotSchedule の制約について、配置後の skew が maxSkew を超えるノードを除外する
+//   - Score: ScheduleAnyway の制約について、一致する Pod が少ないドメインのノードほど高いスコアにする
+type PodTopologySpread struct {
+	defaultConstraints []v1.TopologySpreadConstraint
+	// System のデフォルトの制約は、topologyKey のラベルを持たないノードも候補に含める
+	systemDefaulted bool
+}
+
+var _ PreFilterPlugin = &PodTopologySpread{}
+var _ PreFilterExtensions = &PodTopologySpread{}
+var _ FilterPlugin = &PodTopologySpread{}
+var _ PreScorePlugin = &PodTopologySpread{}
+var _ ScorePlugin = &PodTopologySpread{}
+var _ ScoreNormalizer = &PodTopologySpread{}
+
+func NewPodTopologySpread(rawArgs json.RawMessage) (Plugin, error) {
+	args := DefaultPodTopologySpreadArgs()
+	if err := decodeArgs(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	if err := validatePodTopologySpreadArgs(&args); err != nil {
+		return nil, err
+	}
+	pl := &PodTopologySpread{defaultConstraints: args.DefaultConstraints}
+	if args.DefaultingType == SystemDefaulting {
+		pl.defaultConstraints = systemDefaultConstraints
+		pl.systemDefaulted = true
+	}
+	return pl, nil
+}
+
+func validatePodTopologySpreadArgs(args *PodTopologySpreadArgs) error {
+	var errs []error
+	switch args.DefaultingType {
+	case SystemDefaulting:
+		if len(args.DefaultConstraints) > 0 {
+			errs = append(errs, errors.New("defaultConstraints: must be empty when defaultingType is System"))
+		}
+	case ListDefaulting:
+	default:
+		errs = append(errs, fmt.Errorf("defaultingType: must be %s or %s, got %q", SystemDefaulting, ListDefaulting, args.DefaultingType))
+	}
+
+	type keyAction struct {
+		key    string
+		action v1.UnsatisfiableConstraintAction
+	}
+	seen := sets.New[keyAction]()
+	for i, c := range args.DefaultConstraints {
+		path := fmt.Sprintf("defaultConstraints[%d]", i)
+		if c.MaxSkew <= 0 {
+			errs = append(errs, fmt.Errorf("%s.maxSkew: must be greater than 0, got %d", path, c.MaxSkew))
+		}
+		if c.TopologyKey == "" {
+			errs = append(errs, fmt.Errorf("%s.topologyKey: required", path))
+		}
+		if c.WhenUnsatisfiable != v1.DoNotSchedule && c.WhenUnsatisfiable != v1.ScheduleAnyway {
+			errs = append(errs, fmt.Errorf("%s.whenUnsatisfiable: must be %s or %s, got %q", path, v1.DoNotSchedule, v1.ScheduleAnyway, c.WhenUnsatisfiable))
+		}
+		if c.LabelSelector != nil {
+			errs = append(errs, fmt.Errorf("%s.labelSelector: must be empty for default constraints", path))
+		}
+		ka := keyAction{key: c.TopologyKey, action: c.WhenUnsatisfiable}
+		if seen.Has(ka) {
+			errs = append(errs, fmt.Errorf("%s: duplicated constraint for topologyKey %q and whenUnsatisfiable %q", path, c.TopologyKey, c.WhenUnsatisfiable))
+		}
+		seen.Insert(ka)
+	}
+	return errors.Join(errs...)
+}
+
+func (pl *PodTopologySpread) Name() string {
+	return PodTopologySpreadName
+}
+
+// topologySpreadConstraint は v1.TopologySpreadConstraint を評価しやすい形に変換したもの
+type topologySpreadConstraint struct {
+	maxSkew            int32
+	topologyKey        string
+	selector           labels.Selector
+	minDomains         int32
+	nodeAffinityPolicy v1.NodeInclusionPolicy
+	nodeTaintsPolicy   v1.NodeInclusionPolicy
+}
+
+// Pod に適用する制約のうち、whenUnsatisfiable が action のものを返す
+// Pod が制約を 1 つも持たない場合はデフォルトの制約を使う
+func (pl *PodTopologySpread) getConstraints(pod *v1.Pod, action v1.UnsatisfiableConstraintAction) ([]topologySpreadConstraint, error) {
+	if len(pod.Spec.TopologySpreadConstraints) > 0 {
+		return filterTopologySpreadConstraints(pod.Spec.TopologySpreadConstraints, pod.Labels, action)
+	}
+	selector := podWorkloadLabels(pod)
+	if len(selector) == 0 {
+		// 同じワークロードの Pod を見分けられないので、デフォルトの制約は適用しない
+		return nil, nil
+	}
+	constraints := make([]v1.TopologySpreadConstraint, len(pl.defaultConstraints))
+	for i, c := range pl.defaultConstraints {
+		c.LabelSelector = &metav1.LabelSelector{MatchLabels: selector}
+		constraints[i] = c
+	}
+	return filterTopologySpreadConstraints(constraints, pod.Labels, action)
+}
+
+// Pod のラベルから、Pod ごとに異なる値を持つものを除いたものを返す
+func podWorkloadLabels(pod *v1.Pod) map[string]string {
+	result := make(map[string]string, len(pod.Labels))
+	for k, v := range pod.Labels {
+		result[k] = v
+	}
+	for _, k := range podUniqueLabelKeys {
+		delete(result, k)
+	}
+	return result
+}
+
+func filterTopologySpreadConstraints(constraints []v1.TopologySpreadConstraint, podLabels map[string]string, action v1.UnsatisfiableConstraintAction) ([]topologySpreadConstraint, error) {
+	var result []topologySpreadConstraint
+	for i := range constraints {
+		c := &constraints[i]
+		if c.WhenUnsatisfiable != action {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(c.LabelSelector)
+		if err != nil {
+			return nil, err
+		}
+		// matchLabelKeys のキーは、Pod 自身のラベル値と一致する Pod だけを数える
+		for _, key := range c.MatchLabelKeys {
+			value, ok := podLabels[key]
+			if !ok {
+				continue
+			}
+			r, err := labels.NewRequirement(key, selection.Equals, []string{value})
+			if err != nil {
+				return nil, err
+			}
+			selector = selector.Add(*r)
+		}
+		tsc := topologySpreadConstraint{
+			maxSkew:            c.MaxSkew,
+			topologyKey:        c.TopologyKey,
+			selector:           selector,
+			minDomains:         1,
+			nodeAffinityPolicy: v1.NodeInclusionPolicyHonor,
+			nodeTaintsPolicy:   v1.NodeInclusionPolicyIgnore,
+		}
+		if c.MinDomains != nil {
+			tsc.minDomains = *c.MinDomains
+		}
+		if c.NodeAffinityPolicy != nil {
+			tsc.nodeAffinityPolicy = *c.NodeAffinityPolicy
+		}
+		if c.NodeTaintsPolicy != nil {
+			tsc.nodeTaintsPolicy = *c.NodeTaintsPolicy
+		}
+		result = append(result, tsc)
+	}
+	return result, nil
+}
+
+// ノードを skew の計算に含めるかを nodeAffinityPolicy / nodeTaintsPolicy に従って返す
+func (c *topologySpreadConstraint) matchNodeInclusionPolicies(pod *v1.Pod, node *v1.Node) bool {
+	if c.nodeAffinityPolicy == v1.NodeInclusionPolicyHonor && !podMatchesNodeSelectorAndAffinityTerms(pod, node) {
+		return false
+	}
+	if c.nodeTaintsPolicy == v1.NodeInclusionPolicyHonor {
+		if _, untolerated := findMatchingUntoleratedTaint(node.Spec.Taints, pod.Spec.Tolerations, doNotScheduleTaintsFilter); untolerated {
+			return false
+		}
+	}
+	return true
+}
+
+// ノードがすべての制約の topologyKey のラベルを持つかを返す
+func nodeLabelsMatchSpreadConstraints(nodeLabels map[string]string, constraints []topologySpreadConstraint) bool {
+	for _, c := range constraints {
+		if _, ok := nodeLabels[c.topologyKey]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// pods のうち、namespace にあり selector に一致する Pod の数を返す。削除中の Pod は数えない
+func countPodsMatchSelector(pods []*v1.Pod, selector labels.Selector, namespace string) int64 {
+	var count int64
+	for _, p := range pods {
+		if p.DeletionTimestamp != nil || p.Namespace != namespace {
+			continue
+		}
+		if selector.Matches(labels.Set(p.Labels)) {
+			count++
+		}
+	}
+	return count
+}
+
+type spreadPreFilterState struct {
+	constraints []topologySpreadConstraint
+	// ドメインごとの、制約の selector に一致する Pod の数
+	tpPairToMatchNum map[topologyPair]int64
+	// topologyKey → ドメインの数
+	tpKeyToDomainsNum map[string]int
+}
+
+// 制約の topologyKey のドメインのうち、一致する Pod の最小数を返す
+// ドメインの数が minDomains に満たない場合は、まだ Pod のないドメインがあるものとして 0 を返す
+func (s *spreadPreFilterState) minMatchNum(c *topologySpreadConstraint) int64 {
+	if s.tpKeyToDomainsNum[c.topologyKey] < int(c.minDomains) {
+		return 0
+	}
+	minMatch := int64(math.MaxInt64)
+	for pair, num := range s.tpPairToMatchNum {
+		if pair.key == c.topologyKey {
+			minMatch = min(minMatch, num)
+		}
+	}
+	if minMatch == math.MaxInt64 {
+		return 0
+	}
+	return minMatch
+}
+
+// Clone は PreFilterExtensions で書き換える一致数だけを複製する
+func (s *spreadPreFilterState) Clone() StateData {
+	c := *s
+	c.tpPairToMatchNum = make(map[topologyPair]int64, len(s.tpPairToMatchNum))
+	for pair, num := range s.tpPairToMatchNum {
+		c.tpPairToMatchNum[pair] = num
+	}
+	return &c
+}
+
+// updateWithPod は node の配置済み Pod existingPod の分だけ、ドメインごとの一致数を delta (1 か -1) 増やす
+// PreFilter で配置済み Pod を数えるのと同じ条件で数える
+func (s *spreadPreFilterState) updateWithPod(pod, existingPod *v1.Pod, node *v1.Node, delta int64) {
+	if !nodeLabelsMatchSpreadConstraints(node.Labels, s.constraints) {
+		return
+	}
+	for i := range s.constraints {
+		c := &s.constraints[i]
+		if !c.matchNodeInclusionPolicies(pod, node) {
+			continue
+		}
+		if countPodsMatchSelector([]*v1.Pod{existingPod}, c.selector, pod.Namespace) == 0 {
+			continue
+		}
+		s.tpPairToMatchNum[topologyPair{key: c.topologyKey, value: node.Labels[c.topologyKey]}] += delta
+	}
+}
+
+// PreFilter は DoNotSchedule の制約について、ドメインごとに一致する Pod の数を数える
+func (pl *PodTopologySpread) PreFilter(state *CycleState, pod *v1.Pod, snapshot *Snapshot) *Status {
+	constraints, err := pl.getConstraints(pod, v1.DoNotSchedule)
+	if err != nil {
+		return AsStatus(fmt.Errorf("getting topology spread constraints of pod %s/%s: %w", pod.Namespace, pod.Name, err))
+	}
+	s := &spreadPreFilterState{
+		constraints:       constraints,
+		tpPairToMatchNum:  map[topologyPair]int64{},
+		tpKeyToDomainsNum: map[string]int{},
+	}
+	for _, nodeInfo := range snapshot.NodeInfos() {
+		node := nodeInfo.Node
+		// topologyKey のラベルが 1 つでも欠けているノードはどのドメインにも数えない
+		if !nodeLabelsMatchSpreadConstraints(node.Labels, constraints) {
+			continue
+		}
+		for i := range constraints {
+			c := &constraints[i]
+			if !c.matchNodeInclusionPolicies(pod, node) {
+				continue
+			}
+			pair := topologyPair{key: c.topologyKey, value: node.Labels[c.topologyKey]}
+			if _, ok := s.tpPairToMatchNum[pair]; !ok {
+				s.tpKeyToDomainsNum[c.topologyKey]++
+			}
+			s.tpPairToMatchNum[pair] += countPodsMatchSelector(nodeInfo.Pods, c.selector, pod.Namespace)
+		}
+	}
+	state.Write(spreadPreFilterStateKey, s)
+	return nil
+}
+
+func getSpreadPreFilterState(state *CycleState) (*spreadPreFilterState, error) {
+	c, err := state.Read(spreadPreFilterStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(*spreadPreFilterState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to spreadPreFilterState error", c)
+	}
+	return s, nil
+}
+
+// AddPod は nodeInfo に podToAdd が配置されたものとして、PreFilter で数えた一致数を更新する
+func (pl *PodTopologySpread) AddPod(state *CycleState, pod *v1.Pod, podToAdd *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) *Status {
+	s, err := getSpreadPreFilterState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	s.updateWithPod(pod, podToAdd, nodeInfo.Node, 1)
+	return nil
+}
+
+// RemovePod は nodeInfo から podToRemove が退避したものとして、PreFilter で数えた一致数を更新する
+func (pl *PodTopologySpread) RemovePod(state *CycleState, pod *v1.Pod, podToRemove *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) *Status {
+	s, err := getSpreadPreFilterState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	s.updateWithPod(pod, podToRemove, nodeInfo.Node, -1)
+	return nil
+}
+
+// Filter は Pod をノードに配置したときの skew (ドメインの Pod 数 - 最小のドメインの Pod 数) が
+// maxSkew 以下になるかを見る
+func (pl *PodTopologySpread) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	s, err := getSpreadPreFilterState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	node := nodeInfo.Node
+	for i := range s.constraints {
+		c := &s.constraints[i]
+		value, ok := node.Labels[c.topologyKey]
+		if !ok {
+			return NewStatus(UnschedulableAndUnresolvable, errReasonNodeLabelNotMatch)
+		}
+		var selfMatchNum int64
+		if c.selector.Matches(labels.Set(pod.Labels)) {
+			selfMatchNum = 1
+		}
+		matchNum := s.tpPairToMatchNum[topologyPair{key: c.topologyKey, value: value}]
+		if skew := matchNum + selfMatchNum - s.minMatchNum(c); skew > int64(c.maxSkew) {
+			return NewStatus(Unschedulable, errReasonConstraintsNotMatch)
+		}
+	}
+	return nil
+}
+
+type spreadPreScoreState struct {
+	constraints []topologySpreadConstraint
+	// スコアを付けないノード。topologyKey のラベルが欠けている
+	ignoredNodes sets.Set[string]
+	// 候補ノードのドメインごとの、制約の selector に一致する Pod の数
+	tpPairToPodCounts map[topologyPair]int64
+	// 制約ごとの重み。ドメインが多いトポロジーほど重くする
+	tpNormalizingWeight []float64
+}
+
+// PreScore は ScheduleAnyway の制約について、候補ノードのドメインごとに一致する Pod の数を数える
+func (pl *PodTopologySpread) PreScore(state *CycleState, pod *v1.Pod, snapshot *Snapshot, nodes []*NodeInfo) *Status {
+	constraints, err := pl.getConstraints(pod, v1.ScheduleAnyway)
+	if err != nil {
+		return AsStatus(fmt.Errorf("getting topology spread constraints of pod %s/%s: %w", pod.Namespace, pod.Name, err))
+	}
+	s := &spreadPreScoreState{
+		constraints:         constraints,
+		ignoredNodes:        sets.New[string](),
+		tpPairToPodCounts:   map[topologyPair]int64{},
+		tpNormalizingWeight: make([]float64, len(constraints)),
+	}
+	state.Write(spreadPreScoreStateKey, s)
+	if len(constraints) == 0 {
+		return nil
+	}
+
+	// Pod 自身が制約を持つ場合は、すべての topologyKey のラベルを持つノードだけにスコアを付ける
+	requireAllTopologies := len(pod.Spec.TopologySpreadConstraints) > 0 || !pl.systemDefaulted
+	topologySize := make([]int, len(constraints))
+	for _, nodeInfo := range nodes {
+		node := nodeInfo.Node
+		if requireAllTopologies && !nodeLabelsMatchSpreadConstraints(node.Labels, constraints) {
+			s.ignoredNodes.Insert(node.Name)
+			continue
+		}
+		for i := range constraints {
+			value, ok := node.Labels[constraints[i].topologyKey]
+			if !ok {
+				continue
+			}
+			pair := topologyPair{key: constraints[i].topologyKey, value: value}
+			if _, ok := s.tpPairToPodCounts[pair]; !ok {
+				s.tpPairToPodCounts[pair] = 0
+				topologySize[i]++
+			}
+		}
+	}
+	for i := range constraints {
+		// ドメインが 1 つでも log(0) にならないよう 2 を足す
+		s.tpNormalizingWeight[i] = math.Log(float64(topologySize[i] + 2))
+	}
+
+	// 一致する Pod は候補ノードに限らずクラスタ全体から数える
+	for _, nodeInfo := range snapshot.NodeInfos() {
+		node := nodeInfo.Node
+		if requireAllTopologies && !nodeLabelsMatchSpreadConstraints(node.Labels, constraints) {
+			continue
+		}
+		for i := range constraints {
+			c := &constraints[i]
+			if !c.matchNodeInclusionPolicies(pod, node) {
+				continue
+			}
+			value, ok := node.Labels[c.topologyKey]
+			if !ok {
+				continue
+			}
+			pair := topologyPair{key: c.topologyKey, value: value}
+			if _, ok := s.tpPairToPodCounts[pair]; !ok {
+				continue
+			}
+			s.tpPairToPodCounts[pair] += countPodsMatchSelector(nodeInfo.Pods, c.selector, pod.Namespace)
+		}
+	}
+	return nil
+}
+
+func getSpreadPreScoreState(state *CycleState) (*spreadPreScoreState, error) {
+	c, err := state.Read(spreadPreScoreStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(*spreadPreScoreState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to spreadPreScoreState error", c)
+	}
+	return s, nil
+}
+
+// Score はノードが属するドメインの Pod 数に重みを掛けて合計する
+// 値が小さいほど良いので、NormalizeScore で反転する
+func (pl *PodTopologySpread) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	s, err := getSpreadPreScoreState(state)
+	if err != nil {
+		return 0, AsStatus(err)
+	}
+	node := nodeInfo.Node
+	if s.ignoredNodes.Has(node.Name) {
+		return 0, nil
+	}
+	var score float64
+	for i := range s.constraints {
+		c := &s.constraints[i]
+		value, ok := node.Labels[c.topologyKey]
+		if !ok {
+			continue
+		}
+		count := s.tpPairToPodCounts[topologyPair{key: c.topologyKey, value: value}]
+		// maxSkew が大きい制約ほど、Pod 数の差が効きにくくなる
+		score += float64(count)*s.tpNormalizingWeight[i] + float64(c.maxSkew-1)
+	}
+	return int64(math.Round(score)), nil
+}
+
+// Pod 数が最も少ないノードが MaxNodeScore になるよう反転する。ignoredNodes は 0 にする
+func (pl *PodTopologySpread) NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) *Status {
+	s, err := getSpreadPreScoreState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+
+	var minScore int64 = math.MaxInt64
+	var maxScore int64
+	for i := range scores {
+		if s.ignoredNodes.Has(scores[i].Name) {
+			continue
+		}
+		minScore = min(minScore, scores[i].Score)
+		maxScore = max(maxScore, scores[i].Score)
+	}
+	for i := range scores {
+		if s.ignoredNodes.Has(scores[i].Name) {
+			scores[i].Score = 0
+			continue
+		}
+		if maxScore == 0 {
+			scores[i].Score = MaxNodeScore
+			continue
+		}
+		scores[i].Score = MaxNodeScore * (maxScore + minScore - scores[i].Score) / maxScore
+	}
+	return nil
+}
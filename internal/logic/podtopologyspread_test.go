@@ -0,0 +1,367 @@
+package logic
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestNewPodTopologySpread(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr bool
+	}{
+		{name: "success: default args", args: ""},
+		{name: "success: list defaulting", args: `{"defaultingType":"List","defaultConstraints":[{"maxSkew":1,"topologyKey":"kubernetes.io/hostname","whenUnsatisfiable":"ScheduleAnyway"}]}`},
+		{name: "success: list defaulting without constraints", args: `{"defaultingType":"List"}`},
+		{name: "failure: unknown field", args: `{"defaulting":"List"}`, wantErr: true},
+		{name: "failure: unknown defaulting type", args: `{"defaultingType":"Cluster"}`, wantErr: true},
+		{name: "failure: constraints with system defaulting", args: `{"defaultingType":"System","defaultConstraints":[{"maxSkew":1,"topologyKey":"zone","whenUnsatisfiable":"ScheduleAnyway"}]}`, wantErr: true},
+		{name: "failure: non-positive maxSkew", args: `{"defaultingType":"List","defaultConstraints":[{"maxSkew":0,"topologyKey":"zone","whenUnsatisfiable":"ScheduleAnyway"}]}`, wantErr: true},
+		{name: "failure: empty topologyKey", args: `{"defaultingType":"List","defaultConstraints":[{"maxSkew":1,"whenUnsatisfiable":"ScheduleAnyway"}]}`, wantErr: true},
+		{name: "failure: invalid whenUnsatisfiable", args: `{"defaultingType":"List","defaultConstraints":[{"maxSkew":1,"topologyKey":"zone","whenUnsatisfiable":"Never"}]}`, wantErr: true},
+		{name: "failure: labelSelector", args: `{"defaultingType":"List","defaultConstraints":[{"maxSkew":1,"topologyKey":"zone","whenUnsatisfiable":"ScheduleAnyway","labelSelector":{}}]}`, wantErr: true},
+		{name: "failure: duplicated constraint", args: `{"defaultingType":"List","defaultConstraints":[{"maxSkew":1,"topologyKey":"zone","whenUnsatisfiable":"ScheduleAnyway"},{"maxSkew":2,"topologyKey":"zone","whenUnsatisfiable":"ScheduleAnyway"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPodTopologySpread(json.RawMessage(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewPodTopologySpread() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func spreadConstraint(maxSkew int32, topologyKey string, action v1.UnsatisfiableConstraintAction, matchLabels map[string]string) v1.TopologySpreadConstraint {
+	return v1.TopologySpreadConstraint{
+		MaxSkew:           maxSkew,
+		TopologyKey:       topologyKey,
+		WhenUnsatisfiable: action,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: matchLabels},
+	}
+}
+
+func newSpreadPod(name, nodeName string, podLabels map[string]string, constraints ...v1.TopologySpreadConstraint) *v1.Pod {
+	pod := newLabeledPod(name, "default", nodeName, podLabels, nil)
+	pod.Spec.TopologySpreadConstraints = constraints
+	return pod
+}
+
+func TestPodTopologySpread_Filter(t *testing.T) {
+	web := map[string]string{"app": "web"}
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newZoneNode("node-a1", "zone-a"),
+		newZoneNode("node-a2", "zone-a"),
+		newZoneNode("node-b1", "zone-b"),
+		newZoneNode("node-c1", "zone-c"),
+		// topologyKey のラベルを持たないノード
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-none"}},
+	}}
+	// zone-a に 2 つ、zone-b に 1 つ、zone-c に 0
+	assignedPods := []*v1.Pod{
+		newSpreadPod("web-0", "node-a1", web),
+		newSpreadPod("web-1", "node-a2", web),
+		newSpreadPod("web-2", "node-b1", web),
+		// 他のワークロードの Pod は数えない
+		newSpreadPod("db-0", "node-c1", map[string]string{"app": "db"}),
+	}
+
+	tests := []struct {
+		name         string
+		pod          *v1.Pod
+		nodes        *v1.NodeList
+		assignedPods []*v1.Pod
+		// ノード名 → Filter の理由。含まれないノードは通る
+		want map[string]string
+	}{
+		{
+			name:         "success: no constraints",
+			pod:          newSpreadPod("web", "", web),
+			nodes:        nodes,
+			assignedPods: assignedPods,
+			want:         map[string]string{},
+		},
+		{
+			name:         "ScheduleAnyway is not a filter",
+			pod:          newSpreadPod("web", "", web, spreadConstraint(1, testZoneKey, v1.ScheduleAnyway, web)),
+			nodes:        nodes,
+			assignedPods: assignedPods,
+			want:         map[string]string{},
+		},
+		{
+			name:         "maxSkew 1 by zone",
+			pod:          newSpreadPod("web", "", web, spreadConstraint(1, testZoneKey, v1.DoNotSchedule, web)),
+			nodes:        nodes,
+			assignedPods: assignedPods,
+			want: map[string]string{
+				"node-a1":   errReasonConstraintsNotMatch,
+				"node-a2":   errReasonConstraintsNotMatch,
+				"node-b1":   errReasonConstraintsNotMatch,
+				"node-none": errReasonNodeLabelNotMatch,
+			},
+		},
+		{
+			name:         "maxSkew 2 by zone",
+			pod:          newSpreadPod("web", "", web, spreadConstraint(2, testZoneKey, v1.DoNotSchedule, web)),
+			nodes:        nodes,
+			assignedPods: assignedPods,
+			want: map[string]string{
+				"node-a1":   errReasonConstraintsNotMatch,
+				"node-a2":   errReasonConstraintsNotMatch,
+				"node-none": errReasonNodeLabelNotMatch,
+			},
+		},
+		{
+			name: "pod not matching its own selector does not add to skew",
+			pod: newSpreadPod("db", "", map[string]string{"app": "db"},
+				spreadConstraint(2, testZoneKey, v1.DoNotSchedule, web)),
+			nodes:        nodes,
+			assignedPods: assignedPods,
+			want:         map[string]string{"node-none": errReasonNodeLabelNotMatch},
+		},
+		{
+			name: "minDomains larger than the number of domains treats global minimum as 0",
+			pod: func() *v1.Pod {
+				c := spreadConstraint(2, testZoneKey, v1.DoNotSchedule, web)
+				c.MinDomains = ptr.To[int32](4)
+				return newSpreadPod("web", "", web, c)
+			}(),
+			nodes: nodes,
+			// zone-a に 1 つ、zone-b に 1 つ、zone-c に 1 つ
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+				newSpreadPod("web-1", "node-b1", web),
+				newSpreadPod("web-2", "node-c1", web),
+			},
+			// ドメインが 3 つしかないので最小値を 0 とみなし、どのゾーンも skew 2 になる
+			want: map[string]string{
+				"node-none": errReasonNodeLabelNotMatch,
+			},
+		},
+		{
+			name: "minDomains blocks domains that already reached maxSkew",
+			pod: func() *v1.Pod {
+				c := spreadConstraint(1, testZoneKey, v1.DoNotSchedule, web)
+				c.MinDomains = ptr.To[int32](4)
+				return newSpreadPod("web", "", web, c)
+			}(),
+			nodes: nodes,
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+			},
+			// zone-a は 1 + 1 - 0 = 2 > 1 になる
+			want: map[string]string{
+				"node-a1":   errReasonConstraintsNotMatch,
+				"node-a2":   errReasonConstraintsNotMatch,
+				"node-none": errReasonNodeLabelNotMatch,
+			},
+		},
+		{
+			name: "matchLabelKeys counts only pods with the same label value",
+			pod: func() *v1.Pod {
+				c := spreadConstraint(1, testZoneKey, v1.DoNotSchedule, web)
+				c.MatchLabelKeys = []string{"pod-template-hash"}
+				return newSpreadPod("web", "", map[string]string{"app": "web", "pod-template-hash": "v2"}, c)
+			}(),
+			nodes: nodes,
+			assignedPods: append([]*v1.Pod{
+				newSpreadPod("web-v2-0", "node-c1", map[string]string{"app": "web", "pod-template-hash": "v2"}),
+			}, assignedPods...),
+			// v2 の Pod は zone-c に 1 つだけなので zone-a と zone-b が最小になる
+			want: map[string]string{
+				"node-c1":   errReasonConstraintsNotMatch,
+				"node-none": errReasonNodeLabelNotMatch,
+			},
+		},
+		{
+			name: "nodeAffinityPolicy Honor ignores domains the pod cannot use",
+			pod: func() *v1.Pod {
+				pod := newSpreadPod("web", "", web, spreadConstraint(1, testZoneKey, v1.DoNotSchedule, web))
+				pod.Spec.NodeSelector = map[string]string{"disktype": "ssd"}
+				return pod
+			}(),
+			nodes: &v1.NodeList{Items: []v1.Node{
+				withLabel(newZoneNode("node-a1", "zone-a"), "disktype", "ssd"),
+				withLabel(newZoneNode("node-b1", "zone-b"), "disktype", "ssd"),
+				newZoneNode("node-c1", "zone-c"),
+			}},
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+			},
+			// zone-c は数えないので最小は zone-b の 0
+			want: map[string]string{"node-a1": errReasonConstraintsNotMatch},
+		},
+		{
+			name: "nodeAffinityPolicy Ignore counts every domain",
+			pod: func() *v1.Pod {
+				c := spreadConstraint(1, testZoneKey, v1.DoNotSchedule, web)
+				c.NodeAffinityPolicy = ptr.To(v1.NodeInclusionPolicyIgnore)
+				pod := newSpreadPod("web", "", web, c)
+				pod.Spec.NodeSelector = map[string]string{"disktype": "ssd"}
+				return pod
+			}(),
+			nodes: &v1.NodeList{Items: []v1.Node{
+				withLabel(newZoneNode("node-a1", "zone-a"), "disktype", "ssd"),
+				withLabel(newZoneNode("node-b1", "zone-b"), "disktype", "ssd"),
+				newZoneNode("node-c1", "zone-c"),
+			}},
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+				newSpreadPod("web-1", "node-b1", web),
+			},
+			// zone-c の 0 が最小になるので、ssd のゾーンにはもう置けない
+			want: map[string]string{
+				"node-a1": errReasonConstraintsNotMatch,
+				"node-b1": errReasonConstraintsNotMatch,
+			},
+		},
+		{
+			name: "nodeTaintsPolicy Honor ignores tainted domains",
+			pod: func() *v1.Pod {
+				c := spreadConstraint(1, testZoneKey, v1.DoNotSchedule, web)
+				c.NodeTaintsPolicy = ptr.To(v1.NodeInclusionPolicyHonor)
+				return newSpreadPod("web", "", web, c)
+			}(),
+			nodes: &v1.NodeList{Items: []v1.Node{
+				newZoneNode("node-a1", "zone-a"),
+				newZoneNode("node-b1", "zone-b"),
+				withTaint(newZoneNode("node-c1", "zone-c"), v1.Taint{Key: "dedicated", Value: "gpu", Effect: v1.TaintEffectNoSchedule}),
+			}},
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+				newSpreadPod("web-1", "node-b1", web),
+			},
+			// zone-c を数えないので最小は 1 になり、zone-a と zone-b に置ける
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPodTopologySpread(nil)
+			if err != nil {
+				t.Fatalf("NewPodTopologySpread() error = %v", err)
+			}
+			pl := p.(*PodTopologySpread)
+			snapshot := NewSnapshot(tt.nodes, tt.assignedPods, nil)
+			state := NewCycleState()
+			if status := pl.PreFilter(state, tt.pod, snapshot); !status.IsSuccess() {
+				t.Fatalf("PodTopologySpread.PreFilter() error = %v", status.AsError())
+			}
+			got := map[string]string{}
+			for _, nodeInfo := range snapshot.NodeInfos() {
+				status := pl.Filter(state, tt.pod, nodeInfo)
+				if status.Code() == Error {
+					t.Fatalf("PodTopologySpread.Filter() error = %v", status.AsError())
+				}
+				if !status.IsSuccess() {
+					got[nodeInfo.Node.Name] = status.Reasons()[0]
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PodTopologySpread.Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func withLabel(node v1.Node, key, value string) v1.Node {
+	node.Labels[key] = value
+	return node
+}
+
+func withTaint(node v1.Node, taint v1.Taint) v1.Node {
+	node.Spec.Taints = append(node.Spec.Taints, taint)
+	return node
+}
+
+func TestPodTopologySpread_Score(t *testing.T) {
+	web := map[string]string{"app": "web"}
+	nodes := &v1.NodeList{Items: []v1.Node{
+		newZoneNode("node-a1", "zone-a"),
+		newZoneNode("node-a2", "zone-a"),
+		newZoneNode("node-b1", "zone-b"),
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-none", Labels: map[string]string{testHostnameKey: "node-none"}}},
+	}}
+
+	tests := []struct {
+		name         string
+		args         string
+		pod          *v1.Pod
+		assignedPods []*v1.Pod
+		want         map[string]int64
+	}{
+		{
+			name: "fewer matching pods in the zone scores higher",
+			pod:  newSpreadPod("web", "", web, spreadConstraint(1, testZoneKey, v1.ScheduleAnyway, web)),
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+				newSpreadPod("web-1", "node-a2", web),
+			},
+			// zone-a: 2*log(4)=2.77→3, zone-b: 0。ラベルのないノードは 0
+			want: map[string]int64{"node-a1": 0, "node-a2": 0, "node-b1": 100, "node-none": 0},
+		},
+		{
+			name: "no matching pods scores every node evenly",
+			pod:  newSpreadPod("web", "", web, spreadConstraint(1, testZoneKey, v1.ScheduleAnyway, web)),
+			want: map[string]int64{"node-a1": 100, "node-a2": 100, "node-b1": 100, "node-none": 0},
+		},
+		{
+			name: "system default constraints spread pods of the same workload by hostname",
+			pod:  newSpreadPod("web", "", web),
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+			},
+			// hostname: node-a1 は 1*log(6)+2=3.79、他は 2
+			// zone: zone-a は 1*log(4)+4=5.39、zone-b は 4、zone のないノードは加算なし
+			// 合計: node-a1=9, node-a2=7, node-b1=6, node-none=2 → 100*(9+2-s)/9
+			want: map[string]int64{"node-a1": 22, "node-a2": 44, "node-b1": 55, "node-none": 100},
+		},
+		{
+			name: "list defaulting without constraints does nothing",
+			args: `{"defaultingType":"List"}`,
+			pod:  newSpreadPod("web", "", web),
+			assignedPods: []*v1.Pod{
+				newSpreadPod("web-0", "node-a1", web),
+			},
+			want: map[string]int64{"node-a1": 100, "node-a2": 100, "node-b1": 100, "node-none": 100},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPodTopologySpread(json.RawMessage(tt.args))
+			if err != nil {
+				t.Fatalf("NewPodTopologySpread() error = %v", err)
+			}
+			pl := p.(*PodTopologySpread)
+			snapshot := NewSnapshot(nodes, tt.assignedPods, nil)
+			state := NewCycleState()
+			if status := pl.PreScore(state, tt.pod, snapshot, snapshot.NodeInfos()); !status.IsSuccess() {
+				t.Fatalf("PodTopologySpread.PreScore() error = %v", status.AsError())
+			}
+			var scores NodeScoreList
+			for _, nodeInfo := range snapshot.NodeInfos() {
+				score, status := pl.Score(state, tt.pod, nodeInfo)
+				if !status.IsSuccess() {
+					t.Fatalf("PodTopologySpread.Score() error = %v", status.AsError())
+				}
+				scores = append(scores, NodeScore{Name: nodeInfo.Node.Name, Score: score})
+			}
+			if status := pl.NormalizeScore(state, tt.pod, scores); !status.IsSuccess() {
+				t.Fatalf("PodTopologySpread.NormalizeScore() error = %v", status.AsError())
+			}
+			got := map[string]int64{}
+			for _, s := range scores {
+				got[s.Name] = s.Score
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PodTopologySpread scores = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package logic
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// PreemptionResult は優先度の低い Pod を退避させれば Pod を配置できるノードと、退避させる Pod
+type PreemptionResult struct {
+	NodeName string
+	Victims  []*v1.Pod
+	// Victims のうち、PodDisruptionBudget に違反してしまう Pod の数
+	NumPDBViolations int
+}
+
+// PodPriority は Pod の優先度を返す。priority が設定されていなければ 0 として扱う
+func PodPriority(pod *v1.Pod) int32 {
+	if pod.Spec.Priority == nil {
+		return 0
+	}
+	return *pod.Spec.Priority
+}
+
+// Preempt は Pod より優先度の低い Pod を退避させれば Pod を配置できるノードを探し、
+// 退避させる Pod が最も少なく済むノードを返す。見つからなければ nil を返す
+//
+// ノードごとに、優先度の低い Pod をすべて退避させた状態で Filter を通るかを確かめた後、
+// 優先度の高い Pod から順に、戻しても Filter を通るなら退避させないことにする
+// PodDisruptionBudget に違反する Pod は先に戻すことで、なるべく違反しないようにする
+//
+// PreFilter はクラスタ全体について 1 度だけ実行し、退避させる Pod の分だけ PreFilterExtensions で状態を更新する
+// 退避させた状態は調べるノードの NodeInfo だけを作り直して表し、他のノードの NodeInfo は作らない
+// Filter と同じく、ノードを指名された優先度が同じか高い Pod の分は空けたままにする
+func (s *ScheduleLogic) Preempt(pod *v1.Pod, snapshot *Snapshot, pdbs []*policyv1.PodDisruptionBudget) (*PreemptionResult, error) {
+	if !podEligibleToPreemptOthers(pod, snapshot) {
+		return nil, nil
+	}
+
+	state := NewCycleState()
+	for _, p := range s.preFilterPlugins {
+		status := s.runPreFilterPlugin(p, state, pod, snapshot)
+		if status.Code() == Error {
+			return nil, status.AsError()
+		}
+		if !status.IsSuccess() {
+			// PreFilter で弾かれる Pod は、どのノードの Pod を退避させても配置できない
+			return nil, nil
+		}
+	}
+
+	var candidates []*PreemptionResult
+	for _, nodeInfo := range snapshot.NodeInfos() {
+		candidate, err := s.selectVictimsOnNode(state, pod, snapshot, nodeInfo, pdbs)
+		if err != nil {
+			return nil, err
+		}
+		if candidate != nil {
+			candidates = append(candidates, candidate)
+		}
+	}
+	return pickOneNodeForPreemption(candidates), nil
+}
+
+// podEligibleToPreemptOthers は Pod がプリエンプションを行って良いかを返す
+//   - PriorityClass を持たない Pod や、preemptionPolicy: Never の Pod は行わない
+//   - 前回のプリエンプションで選んだノードで、優先度の低い Pod がまだ終了中なら、終了を待つ
+func podEligibleToPreemptOthers(pod *v1.Pod, snapshot *Snapshot) bool {
+	if pod.Spec.PriorityClassName == "" {
+		return false
+	}
+	if pod.Spec.PreemptionPolicy != nil && *pod.Spec.PreemptionPolicy == v1.PreemptNever {
+		return false
+	}
+	if nodeName := pod.Status.NominatedNodeName; nodeName != "" {
+		if nodeInfo := snapshot.Get(nodeName); nodeInfo != nil {
+			for _, p := range nodeInfo.Pods {
+				if p.DeletionTimestamp != nil && PodPriority(p) < PodPriority(pod) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// selectVictimsOnNode はノードで退避させる Pod の最小の組を返す
+// 優先度の低い Pod をすべて退避させても配置できない場合は nil を返す
+// state は PreFilter を実行した状態で、書き換えずに複製して使う
+func (s *ScheduleLogic) selectVictimsOnNode(state *CycleState, pod *v1.Pod, snapshot *Snapshot, nodeInfo *NodeInfo, pdbs []*policyv1.PodDisruptionBudget) (*PreemptionResult, error) {
+	priority := PodPriority(pod)
+	removed := map[*v1.Pod]bool{}
+	var potentialVictims []*v1.Pod
+	for _, p := range nodeInfo.Pods {
+		if PodPriority(p) < priority {
+			potentialVictims = append(potentialVictims, p)
+			removed[p] = true
+		}
+	}
+	if len(potentialVictims) == 0 {
+		return nil, nil
+	}
+	// 退避させなくても配置できるノードや、退避させても解消しない理由で配置できないノードは調べない
+	status := s.runFilterPluginsWithNominatedPods(state, pod, nodeInfo, snapshot)
+	if status.Code() == Error {
+		return nil, status.AsError()
+	}
+	if status.IsSuccess() || status.Code() == UnschedulableAndUnresolvable {
+		return nil, nil
+	}
+
+	nodeState := state.Clone()
+	for _, p := range potentialVictims {
+		if err := s.runPreFilterExtensionRemovePod(nodeState, pod, p, nodeInfo, snapshot); err != nil {
+			return nil, err
+		}
+	}
+	// removed を退避させた状態で、Pod がノードの Filter を通るかを返す
+	fits := func() (bool, error) {
+		status := s.runFilterPluginsWithNominatedPods(nodeState, pod, nodeInfo.withoutPods(removed), snapshot)
+		if status.Code() == Error {
+			return false, status.AsError()
+		}
+		return status.IsSuccess(), nil
+	}
+	if ok, err := fits(); err != nil || !ok {
+		return nil, err
+	}
+
+	// 優先度の高い Pod から戻す
+	slices.SortStableFunc(potentialVictims, func(a, b *v1.Pod) int {
+		if pa, pb := PodPriority(a), PodPriority(b); pa != pb {
+			return int(pb) - int(pa)
+		}
+		return podStartTime(a).Compare(podStartTime(b).Time)
+	})
+	violatingVictims, nonViolatingVictims := filterPodsWithPDBViolation(potentialVictims, pdbs)
+
+	result := &PreemptionResult{NodeName: nodeInfo.Node.Name}
+	reprieve := func(p *v1.Pod) (bool, error) {
+		delete(removed, p)
+		if err := s.runPreFilterExtensionAddPod(nodeState, pod, p, nodeInfo, snapshot); err != nil {
+			return false, err
+		}
+		ok, err := fits()
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			removed[p] = true
+			if err := s.runPreFilterExtensionRemovePod(nodeState, pod, p, nodeInfo, snapshot); err != nil {
+				return false, err
+			}
+			result.Victims = append(result.Victims, p)
+		}
+		return ok, nil
+	}
+	for _, p := range violatingVictims {
+		fits, err := reprieve(p)
+		if err != nil {
+			return nil, err
+		}
+		if !fits {
+			result.NumPDBViolations++
+		}
+	}
+	for _, p := range nonViolatingVictims {
+		if _, err := reprieve(p); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// runPreFilterExtensionAddPod は PreFilterExtensions を実装したプラグインに、nodeInfo に podToAdd が配置されたことを伝える
+func (s *ScheduleLogic) runPreFilterExtensionAddPod(state *CycleState, pod, podToAdd *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) error {
+	for _, p := range s.preFilterPlugins {
+		if ext, ok := p.(PreFilterExtensions); ok {
+			if status := ext.AddPod(state, pod, podToAdd, nodeInfo, snapshot); !status.IsSuccess() {
+				return fmt.Errorf("running AddPod of plugin %s: %w", p.Name(), status.AsError())
+			}
+		}
+	}
+	return nil
+}
+
+// runPreFilterExtensionRemovePod は PreFilterExtensions を実装したプラグインに、nodeInfo から podToRemove が退避したことを伝える
+func (s *ScheduleLogic) runPreFilterExtensionRemovePod(state *CycleState, pod, podToRemove *v1.Pod, nodeInfo *NodeInfo, snapshot *Snapshot) error {
+	for _, p := range s.preFilterPlugins {
+		if ext, ok := p.(PreFilterExtensions); ok {
+			if status := ext.RemovePod(state, pod, podToRemove, nodeInfo, snapshot); !status.IsSuccess() {
+				return fmt.Errorf("running RemovePod of plugin %s: %w", p.Name(), status.AsError())
+			}
+		}
+	}
+	return nil
+}
+
+// filterPodsWithPDBViolation は pods を、退避させると PodDisruptionBudget に違反するものとしないものに分ける
+// pods の順に、一致する PDB の disruptionsAllowed を使い切ったら違反として数える
+func filterPodsWithPDBViolation(pods []*v1.Pod, pdbs []*policyv1.PodDisruptionBudget) (violating, nonViolating []*v1.Pod) {
+	allowed := make([]int32, len(pdbs))
+	for i, pdb := range pdbs {
+		allowed[i] = pdb.Status.DisruptionsAllowed
+	}
+	for _, pod := range pods {
+		violated := false
+		for i, pdb := range pdbs {
+			if pdb.Namespace != pod.Namespace {
+				continue
+			}
+			selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+			// upstream と同じく、空の selector はどの Pod にも一致しないものとする
+			if err != nil || selector.Empty() || !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			// すでに退避中として数えられている Pod は disruptionsAllowed に反映済み
+			if _, ok := pdb.Status.DisruptedPods[pod.Name]; ok {
+				continue
+			}
+			allowed[i]--
+			if allowed[i] < 0 {
+				violated = true
+			}
+		}
+		if violated {
+			violating = append(violating, pod)
+		} else {
+			nonViolating = append(nonViolating, pod)
+		}
+	}
+	return violating, nonViolating
+}
+
+// pickOneNodeForPreemption は候補の中から、upstream と同じ基準で 1 つを選ぶ
+//  1. PodDisruptionBudget の違反が最も少ない
+//  2. 退避させる Pod の最高の優先度が最も低い
+//  3. 退避させる Pod の優先度の合計が最も小さい
+//  4. 退避させる Pod の数が最も少ない
+//
+// それでも決まらなければ、先に見つかったノードを選ぶ
+func pickOneNodeForPreemption(candidates []*PreemptionResult) *PreemptionResult {
+	var best *PreemptionResult
+	for _, c := range candidates {
+		if best == nil || comparePreemptionResults(c, best) < 0 {
+			best = c
+		}
+	}
+	return best
+}
+
+// a の方が良い候補なら負の値を返す
+func comparePreemptionResults(a, b *PreemptionResult) int {
+	if a.NumPDBViolations != b.NumPDBViolations {
+		return a.NumPDBViolations - b.NumPDBViolations
+	}
+	if ha, hb := highestPriority(a.Victims), highestPriority(b.Victims); ha != hb {
+		if ha < hb {
+			return -1
+		}
+		return 1
+	}
+	if sa, sb := sumPriorities(a.Victims), sumPriorities(b.Victims); sa != sb {
+		if sa < sb {
+			return -1
+		}
+		return 1
+	}
+	return len(a.Victims) - len(b.Victims)
+}
+
+func highestPriority(pods []*v1.Pod) int64 {
+	highest := int64(math.MinInt32) - 1
+	for _, p := range pods {
+		highest = max(highest, int64(PodPriority(p)))
+	}
+	return highest
+}
+
+// 負の優先度の Pod を退避させる方が合計が小さくならないよう、MaxInt32 + 1 を足してから合計する
+func sumPriorities(pods []*v1.Pod) int64 {
+	var sum int64
+	for _, p := range pods {
+		sum += int64(PodPriority(p)) + int64(math.MaxInt32) + 1
+	}
+	return sum
+}
+
+// podStartTime は Pod が起動した時刻を返す。起動前なら作成時刻を返す
+func podStartTime(pod *v1.Pod) metav1.Time {
+	if pod.Status.StartTime != nil {
+		return *pod.Status.StartTime
+	}
+	return pod.CreationTimestamp
+}
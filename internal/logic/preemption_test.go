@@ -0,0 +1,314 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// cpu を要求する、priority の Pod を作る
+func newPriorityPod(name, nodeName string, priority int32, cpu string) *v1.Pod {
+	pod := newRequestPod(name, v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)})
+	pod.Labels = map[string]string{"app": name}
+	pod.Spec.NodeName = nodeName
+	pod.Spec.Priority = ptr.To(priority)
+	if priority > 0 {
+		pod.Spec.PriorityClassName = "high"
+	}
+	return pod
+}
+
+func newPDB(name string, matchLabels map[string]string, disruptionsAllowed int32) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       policyv1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: matchLabels}},
+		Status:     policyv1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+	}
+}
+
+func TestScheduleLogic_Preempt(t *testing.T) {
+	newCPUNode := func(name string) v1.Node {
+		return *newResourceNode(name, v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+	}
+	newHostNode := func(name string) v1.Node {
+		node := newCPUNode(name)
+		node.Labels = map[string]string{v1.LabelHostname: name}
+		return node
+	}
+	nodes := &v1.NodeList{Items: []v1.Node{newCPUNode("node1"), newCPUNode("node2")}}
+	hostNodes := &v1.NodeList{Items: []v1.Node{newHostNode("node1"), newHostNode("node2")}}
+	withLabel := func(pod *v1.Pod, app string) *v1.Pod {
+		pod.Labels = map[string]string{"app": app}
+		return pod
+	}
+	s := newDefaultScheduleLogic(t)
+
+	tests := []struct {
+		name         string
+		nodes        *v1.NodeList // 省略すると nodes を使う
+		pod          *v1.Pod
+		assignedPods []*v1.Pod
+		// Status.NominatedNodeName を設定した、まだ配置されていない Pod
+		nominatedPods []*v1.Pod
+		pdbs          []*policyv1.PodDisruptionBudget
+		wantNode      string
+		wantVictims   []string
+		wantPDB       int
+	}{
+		{
+			// 1 CPU 空けば良いので、2 つのうち 1 つだけを退避させる
+			name: "minimal victims",
+			pod:  newPriorityPod("high", "", 100, "2"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("low-1", "node1", 0, "1500m"),
+				newPriorityPod("low-2", "node1", 0, "1500m"),
+				newPriorityPod("mid", "node2", 50, "3"),
+			},
+			wantNode:    "node1",
+			wantVictims: []string{"low-2"},
+		},
+		{
+			// 退避させる Pod の優先度が低いノードを選ぶ
+			name: "prefer lower priority victims",
+			pod:  newPriorityPod("high", "", 100, "2"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("mid", "node1", 50, "3"),
+				newPriorityPod("low", "node2", 10, "3"),
+			},
+			wantNode:    "node2",
+			wantVictims: []string{"low"},
+		},
+		{
+			// 同じ優先度なら、退避させる Pod の優先度の合計が小さいノードを選ぶ
+			name: "prefer fewer victims",
+			pod:  newPriorityPod("high", "", 100, "3"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("low-1", "node1", 10, "2"),
+				newPriorityPod("low-2", "node1", 10, "2"),
+				newPriorityPod("low-3", "node2", 10, "3"),
+			},
+			wantNode:    "node2",
+			wantVictims: []string{"low-3"},
+		},
+		{
+			// PDB に違反しない方を退避させる
+			name: "avoid pdb violation",
+			pod:  newPriorityPod("high", "", 100, "2"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("protected", "node1", 0, "2"),
+				newPriorityPod("free", "node1", 0, "2"),
+				newPriorityPod("mid", "node2", 50, "4"),
+			},
+			pdbs:        []*policyv1.PodDisruptionBudget{newPDB("protected", map[string]string{"app": "protected"}, 0)},
+			wantNode:    "node1",
+			wantVictims: []string{"free"},
+		},
+		{
+			// 違反が避けられなければ、違反したまま退避させる
+			name: "unavoidable pdb violation",
+			pod:  newPriorityPod("high", "", 100, "4"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("protected", "node1", 0, "2"),
+				newPriorityPod("free", "node1", 0, "2"),
+				newPriorityPod("mid", "node2", 100, "4"),
+			},
+			pdbs:        []*policyv1.PodDisruptionBudget{newPDB("protected", map[string]string{"app": "protected"}, 0)},
+			wantNode:    "node1",
+			wantVictims: []string{"protected", "free"},
+			wantPDB:     1,
+		},
+		{
+			name: "no lower priority pods",
+			pod:  newPriorityPod("high", "", 100, "2"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("same-1", "node1", 100, "3"),
+				newPriorityPod("same-2", "node2", 100, "3"),
+			},
+		},
+		{
+			// 優先度の低い Pod をすべて退避させても入らない
+			name: "does not fit even after preemption",
+			pod:  newPriorityPod("high", "", 100, "5"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("low-1", "node1", 0, "3"),
+				newPriorityPod("low-2", "node2", 0, "3"),
+			},
+		},
+		{
+			name: "pod without priority class",
+			pod:  newPriorityPod("no-class", "", 0, "2"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("low", "node1", -10, "3"),
+			},
+		},
+		{
+			name: "preemption policy never",
+			pod: func() *v1.Pod {
+				pod := newPriorityPod("high", "", 100, "2")
+				pod.Spec.PreemptionPolicy = ptr.To(v1.PreemptNever)
+				return pod
+			}(),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("low", "node1", 0, "3"),
+			},
+		},
+		{
+			// 前回退避させた Pod の終了を待つ
+			name: "victims still terminating on nominated node",
+			pod: func() *v1.Pod {
+				pod := newPriorityPod("high", "", 100, "2")
+				pod.Status.NominatedNodeName = "node1"
+				return pod
+			}(),
+			assignedPods: []*v1.Pod{
+				func() *v1.Pod {
+					pod := newPriorityPod("terminating", "node1", 0, "3")
+					pod.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+					return pod
+				}(),
+				newPriorityPod("low", "node2", 0, "3"),
+			},
+		},
+		{
+			// 退避させる Pod の anti-affinity が無くなった状態で判定する
+			name:  "victim with anti-affinity to preemptor",
+			nodes: hostNodes,
+			pod:   newPriorityPod("high", "", 100, "1"),
+			assignedPods: []*v1.Pod{
+				func() *v1.Pod {
+					pod := newPriorityPod("low", "node1", 0, "1")
+					pod.Spec.Affinity = &v1.Affinity{PodAntiAffinity: &v1.PodAntiAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: []v1.PodAffinityTerm{{
+							LabelSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "high"}},
+							TopologyKey:   v1.LabelHostname,
+						}},
+					}}
+					return pod
+				}(),
+				newPriorityPod("mid", "node2", 100, "4"),
+			},
+			wantNode:    "node1",
+			wantVictims: []string{"low"},
+		},
+		{
+			// 戻した Pod も分散の偏りに数えるので、low-a は戻せない
+			name:  "reprieved pod counts for topology spread",
+			nodes: hostNodes,
+			pod: func() *v1.Pod {
+				pod := withLabel(newPriorityPod("high", "", 100, "2"), "web")
+				pod.Spec.TopologySpreadConstraints = []v1.TopologySpreadConstraint{{
+					MaxSkew:           1,
+					TopologyKey:       v1.LabelHostname,
+					WhenUnsatisfiable: v1.DoNotSchedule,
+					LabelSelector:     &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+				}}
+				return pod
+			}(),
+			assignedPods: []*v1.Pod{
+				withLabel(newPriorityPod("low-a", "node1", 0, "1"), "web"),
+				newPriorityPod("low-b", "node1", 0, "3"),
+				newPriorityPod("mid", "node2", 100, "4"),
+			},
+			wantNode:    "node1",
+			wantVictims: []string{"low-a", "low-b"},
+		},
+		{
+			// 優先度の高い Pod に指名されたノードでは、その Pod の分を空けたままにする
+			name: "space reserved by nominated pod",
+			pod:  newPriorityPod("high", "", 50, "2"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("low", "node1", 0, "1"),
+				newPriorityPod("mid", "node2", 100, "4"),
+			},
+			nominatedPods: []*v1.Pod{func() *v1.Pod {
+				pod := newPriorityPod("nominated", "", 100, "2")
+				pod.Status.NominatedNodeName = "node1"
+				return pod
+			}()},
+			wantNode:    "node1",
+			wantVictims: []string{"low"},
+		},
+		{
+			name: "nominated pod leaves no room even after preemption",
+			pod:  newPriorityPod("high", "", 50, "2"),
+			assignedPods: []*v1.Pod{
+				newPriorityPod("low", "node1", 0, "1"),
+				newPriorityPod("mid", "node2", 100, "4"),
+			},
+			nominatedPods: []*v1.Pod{func() *v1.Pod {
+				pod := newPriorityPod("nominated", "", 100, "3")
+				pod.Status.NominatedNodeName = "node1"
+				return pod
+			}()},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes := nodes
+			if tt.nodes != nil {
+				nodes = tt.nodes
+			}
+			snapshot := NewSnapshot(nodes, tt.assignedPods, nil)
+			snapshot.SetNominatedPods(tt.nominatedPods)
+			got, err := s.Preempt(tt.pod, snapshot, tt.pdbs)
+			if err != nil {
+				t.Fatalf("ScheduleLogic.Preempt() error = %v", err)
+			}
+			if tt.wantNode == "" {
+				if got != nil {
+					t.Errorf("ScheduleLogic.Preempt() = %+v, want nil", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatalf("ScheduleLogic.Preempt() = nil, want node %s", tt.wantNode)
+			}
+			var victims []string
+			for _, victim := range got.Victims {
+				victims = append(victims, victim.Name)
+			}
+			if got.NodeName != tt.wantNode || !reflect.DeepEqual(victims, tt.wantVictims) || got.NumPDBViolations != tt.wantPDB {
+				t.Errorf("ScheduleLogic.Preempt() = {node: %s, victims: %v, pdb: %d}, want {node: %s, victims: %v, pdb: %d}",
+					got.NodeName, victims, got.NumPDBViolations, tt.wantNode, tt.wantVictims, tt.wantPDB)
+			}
+		})
+	}
+}
+
+func TestScheduleLogic_Preempt_SkipUnresolvableNodes(t *testing.T) {
+	s, err := NewScheduleLogic(NewInTreeRegistry(), PluginSet{Filter: []string{TaintTolerationName, NodeResourcesFitName}})
+	if err != nil {
+		t.Fatalf("NewScheduleLogic() error = %v", err)
+	}
+	recorder := &countingMetricsRecorder{counts: map[string]int{}}
+	s.SetMetricsRecorder(recorder)
+
+	tainted := newResourceNode("tainted", v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})
+	tainted.Spec.Taints = []v1.Taint{{Key: "example.com/draining", Value: "true", Effect: v1.TaintEffectNoSchedule}}
+	nodes := &v1.NodeList{Items: []v1.Node{*tainted, *newResourceNode("node2", v1.ResourceList{v1.ResourceCPU: resource.MustParse("4")})}}
+	snapshot := NewSnapshot(nodes, []*v1.Pod{
+		newPriorityPod("low-1", "tainted", 0, "3"),
+		newPriorityPod("low-2", "node2", 0, "3"),
+	}, nil)
+
+	got, err := s.Preempt(newPriorityPod("high", "", 100, "2"), snapshot, nil)
+	if err != nil {
+		t.Fatalf("ScheduleLogic.Preempt() error = %v", err)
+	}
+	if got == nil || got.NodeName != "node2" {
+		t.Fatalf("ScheduleLogic.Preempt() = %+v, want node node2", got)
+	}
+	// taint のあるノードは最初の Filter だけで除き、Pod を退避させた状態では判定しない
+	if n := recorder.counts["Filter/TaintToleration/UnschedulableAndUnresolvable"]; n != 1 {
+		t.Errorf("Filter/TaintToleration/UnschedulableAndUnresolvable observed %d times, want 1", n)
+	}
+	if n := recorder.counts["Filter/TaintToleration/Success"]; n != 3 {
+		t.Errorf("Filter/TaintToleration/Success observed %d times, want 3", n)
+	}
+}
@@ -0,0 +1,29 @@
+package logic
+
+import (
+	"encoding/json"
+	"math/rand"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const RandomName = "Random"
+
+// Random はノードにランダムなスコアを付ける
+// 最高スコアのノード同士はもともとランダムに選ばれるので、デフォルトでは有効にしていない
+// 他のスコアの差をわざとぼかしたい Profile で使う
+type Random struct{}
+
+var _ ScorePlugin = &Random{}
+
+func NewRandom(_ json.RawMessage) (Plugin, error) {
+	return &Random{}, nil
+}
+
+func (r *Random) Name() string {
+	return RandomName
+}
+
+func (r *Random) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	return rand.Int63n(MaxNodeScore + 1), nil
+}
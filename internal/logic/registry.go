@@ -0,0 +1,55 @@
+package logic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// PluginFactory はプラグインを生成する
+// args は設定ファイルの pluginConfig に書かれた引数で、指定がなければ nil になる
+type PluginFactory func(args json.RawMessage) (Plugin, error)
+
+// Registry はプラグイン名と PluginFactory の対応
+type Registry map[string]PluginFactory
+
+// Register はプラグインを名前で登録する。同じ名前がすでに登録されていればエラーを返す
+func (r Registry) Register(name string, factory PluginFactory) error {
+	if _, ok := r[name]; ok {
+		return fmt.Errorf("a plugin named %s already exists", name)
+	}
+	r[name] = factory
+	return nil
+}
+
+// NewInTreeRegistry はこのリポジトリに含まれるプラグインを登録した Registry を返す
+func NewInTreeRegistry() Registry {
+	return Registry{
+		TierIsolationName:                   NewTierIsolation,
+		RandomName:                          NewRandom,
+		NodeResourcesFitName:                NewNodeResourcesFit,
+		NodeResourcesBalancedAllocationName: NewNodeResourcesBalancedAllocation,
+		TaintTolerationName:                 NewTaintToleration,
+		NodeAffinityName:                    NewNodeAffinity,
+		NodePortsName:                       NewNodePorts,
+		NodeConditionName:                   NewNodeCondition,
+		VolumeBindingName:                   NewVolumeBinding,
+		NodeVolumeLimitsName:                NewNodeVolumeLimits,
+		InterPodAffinityName:                NewInterPodAffinity,
+		PodTopologySpreadName:               NewPodTopologySpread,
+	}
+}
+
+// プラグインの引数を into にデコードする。args が空の場合は into をそのままにする
+// 知らないフィールドが含まれていればエラーにする
+func decodeArgs(args json.RawMessage, into any) error {
+	if len(args) == 0 || string(args) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(args))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(into); err != nil {
+		return fmt.Errorf("error decoding args: %w", err)
+	}
+	return nil
+}
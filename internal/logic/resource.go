@@ -0,0 +1,149 @@
+package logic
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+const (
+	// スコアの計算で、CPU を要求していない Pod が使うとみなす量 (upstream と同じ 0.1 core)
+	DefaultMilliCPURequest int64 = 100
+	// スコアの計算で、メモリを要求していない Pod が使うとみなす量 (upstream と同じ 200MB)
+	DefaultMemoryRequest int64 = 200 * 1024 * 1024
+)
+
+// Resource は Pod の要求量やノードの割り当て可能量を集計したもの
+type Resource struct {
+	MilliCPU         int64
+	Memory           int64
+	EphemeralStorage int64
+	AllowedPodNumber int64
+	// cpu, memory, ephemeral-storage, pods 以外の拡張リソース (nvidia.com/gpu など)
+	ScalarResources map[v1.ResourceName]int64
+}
+
+// ResourceList から Resource を作る
+func NewResource(rl v1.ResourceList) *Resource {
+	r := &Resource{}
+	r.Add(rl)
+	return r
+}
+
+// ResourceList の値を加算する
+func (r *Resource) Add(rl v1.ResourceList) {
+	for name, quantity := range rl {
+		switch name {
+		case v1.ResourceCPU:
+			r.MilliCPU += quantity.MilliValue()
+		case v1.ResourceMemory:
+			r.Memory += quantity.Value()
+		case v1.ResourceEphemeralStorage:
+			r.EphemeralStorage += quantity.Value()
+		case v1.ResourcePods:
+			r.AllowedPodNumber += quantity.Value()
+		default:
+			r.SetScalar(name, r.ScalarResources[name]+quantity.Value())
+		}
+	}
+}
+
+// 別の Resource の値を加算する
+func (r *Resource) AddResource(other *Resource) {
+	r.MilliCPU += other.MilliCPU
+	r.Memory += other.Memory
+	r.EphemeralStorage += other.EphemeralStorage
+	r.AllowedPodNumber += other.AllowedPodNumber
+	for name, value := range other.ScalarResources {
+		r.SetScalar(name, r.ScalarResources[name]+value)
+	}
+}
+
+// 各リソースについて、別の Resource の値との大きい方を取る
+func (r *Resource) SetMaxResource(other *Resource) {
+	r.MilliCPU = max(r.MilliCPU, other.MilliCPU)
+	r.Memory = max(r.Memory, other.Memory)
+	r.EphemeralStorage = max(r.EphemeralStorage, other.EphemeralStorage)
+	r.AllowedPodNumber = max(r.AllowedPodNumber, other.AllowedPodNumber)
+	for name, value := range other.ScalarResources {
+		r.SetScalar(name, max(r.ScalarResources[name], value))
+	}
+}
+
+// Get はリソース名に対応する値を返す
+func (r *Resource) Get(name v1.ResourceName) int64 {
+	switch name {
+	case v1.ResourceCPU:
+		return r.MilliCPU
+	case v1.ResourceMemory:
+		return r.Memory
+	case v1.ResourceEphemeralStorage:
+		return r.EphemeralStorage
+	case v1.ResourcePods:
+		return r.AllowedPodNumber
+	default:
+		return r.ScalarResources[name]
+	}
+}
+
+func (r *Resource) SetScalar(name v1.ResourceName, value int64) {
+	if r.ScalarResources == nil {
+		r.ScalarResources = map[v1.ResourceName]int64{}
+	}
+	r.ScalarResources[name] = value
+}
+
+func (r *Resource) Clone() *Resource {
+	c := *r
+	if r.ScalarResources != nil {
+		c.ScalarResources = make(map[v1.ResourceName]int64, len(r.ScalarResources))
+		for name, value := range r.ScalarResources {
+			c.ScalarResources[name] = value
+		}
+	}
+	return &c
+}
+
+// Pod が要求するリソース量を upstream の kube-scheduler と同じ方法で計算する
+//
+//   - 通常のコンテナは同時に動くので合計する
+//   - init コンテナは順番に動くので、通常のコンテナの合計との最大値を取る
+//   - restartPolicy: Always の init コンテナ (サイドカー) は起動後も動き続けるので、
+//     以降の init コンテナと通常のコンテナに加算する
+//   - 最後に pod overhead を加算する
+func PodRequests(pod *v1.Pod) *Resource {
+	reqs := &Resource{}
+	for _, c := range pod.Spec.Containers {
+		reqs.Add(c.Resources.Requests)
+	}
+
+	restartableInitContainerReqs := &Resource{}
+	initContainerReqs := &Resource{}
+	for _, c := range pod.Spec.InitContainers {
+		containerReqs := NewResource(c.Resources.Requests)
+		if c.RestartPolicy != nil && *c.RestartPolicy == v1.ContainerRestartPolicyAlways {
+			reqs.AddResource(containerReqs)
+			restartableInitContainerReqs.AddResource(containerReqs)
+			containerReqs = restartableInitContainerReqs.Clone()
+		} else {
+			containerReqs.AddResource(restartableInitContainerReqs)
+		}
+		initContainerReqs.SetMaxResource(containerReqs)
+	}
+	reqs.SetMaxResource(initContainerReqs)
+
+	reqs.Add(pod.Spec.Overhead)
+	return reqs
+}
+
+// PodNonZeroRequests は PodRequests と同じだが、CPU とメモリを要求していない Pod は
+// DefaultMilliCPURequest / DefaultMemoryRequest を要求しているものとして扱う
+// 要求量 0 の Pod ばかりが 1 つのノードに集まらないよう、スコアの計算だけで使う
+func PodNonZeroRequests(pod *v1.Pod) *Resource {
+	reqs := PodRequests(pod)
+	if reqs.MilliCPU == 0 {
+		reqs.MilliCPU = DefaultMilliCPURequest
+	}
+	if reqs.Memory == 0 {
+		reqs.Memory = DefaultMemoryRequest
+	}
+	return reqs
+}
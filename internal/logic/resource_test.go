@@ -0,0 +1,110 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestPodRequests(t *testing.T) {
+	always := v1.ContainerRestartPolicyAlways
+	container := func(cpu, memory string) v1.Container {
+		return v1.Container{
+			Resources: v1.ResourceRequirements{Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse(cpu),
+				v1.ResourceMemory: resource.MustParse(memory),
+			}},
+		}
+	}
+	sidecar := func(cpu, memory string) v1.Container {
+		c := container(cpu, memory)
+		c.RestartPolicy = &always
+		return c
+	}
+
+	tests := []struct {
+		name string
+		spec v1.PodSpec
+		want *Resource
+	}{
+		{
+			name: "containers are summed",
+			spec: v1.PodSpec{Containers: []v1.Container{container("500m", "1Gi"), container("250m", "512Mi")}},
+			want: &Resource{MilliCPU: 750, Memory: 1536 * 1024 * 1024},
+		},
+		{
+			name: "init containers take the max",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{container("2", "256Mi"), container("1", "128Mi")},
+				Containers:     []v1.Container{container("500m", "1Gi")},
+			},
+			want: &Resource{MilliCPU: 2000, Memory: 1024 * 1024 * 1024},
+		},
+		{
+			name: "sidecar init containers are added to later containers",
+			spec: v1.PodSpec{
+				InitContainers: []v1.Container{sidecar("500m", "128Mi"), container("1", "128Mi")},
+				Containers:     []v1.Container{container("500m", "256Mi")},
+			},
+			// init: max(500m+1, 500m) = 1500m, 通常: 500m + 500m = 1000m
+			want: &Resource{MilliCPU: 1500, Memory: 384 * 1024 * 1024},
+		},
+		{
+			name: "pod overhead is added",
+			spec: v1.PodSpec{
+				Containers: []v1.Container{container("500m", "1Gi")},
+				Overhead:   v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")},
+			},
+			want: &Resource{MilliCPU: 600, Memory: 1024 * 1024 * 1024},
+		},
+		{
+			name: "extended resources",
+			spec: v1.PodSpec{Containers: []v1.Container{{
+				Resources: v1.ResourceRequirements{Requests: v1.ResourceList{"example.com/gpu": resource.MustParse("2")}},
+			}}},
+			want: &Resource{ScalarResources: map[v1.ResourceName]int64{"example.com/gpu": 2}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PodRequests(&v1.Pod{Spec: tt.spec})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PodRequests() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodNonZeroRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests v1.ResourceList
+		want     *Resource
+	}{
+		{
+			name: "no requests",
+			want: &Resource{MilliCPU: DefaultMilliCPURequest, Memory: DefaultMemoryRequest},
+		},
+		{
+			name:     "only cpu requested",
+			requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("250m")},
+			want:     &Resource{MilliCPU: 250, Memory: DefaultMemoryRequest},
+		},
+		{
+			name:     "both requested",
+			requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("1"), v1.ResourceMemory: resource.MustParse("1Gi")},
+			want:     &Resource{MilliCPU: 1000, Memory: 1024 * 1024 * 1024},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &v1.Pod{Spec: v1.PodSpec{Containers: []v1.Container{{Resources: v1.ResourceRequirements{Requests: tt.requests}}}}}
+			got := PodNonZeroRequests(pod)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PodNonZeroRequests() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package logic
+
+import (
+	v1 "k8s.io/api/core/v1"
+)
+
+// resourceAllocationScorer はノードの割り当て可能量と、Pod を配置した後の要求量からスコアを計算する
+// NodeResourcesFit の scoringStrategy や NodeResourcesBalancedAllocation ごとに scorer を差し替える
+type resourceAllocationScorer struct {
+	resources []ResourceSpec
+	// true なら PodRequests で数えた実際の要求量を使う。false なら PodNonZeroRequests で数える
+	useRequested bool
+	// resources と同じ順番の要求量と割り当て可能量から 0 〜 MaxNodeScore のスコアを返す
+	scorer func(requested, allocatable []int64) int64
+}
+
+func newResourceAllocationScorer(strategy *ScoringStrategy) *resourceAllocationScorer {
+	s := &resourceAllocationScorer{resources: strategy.Resources}
+	switch strategy.Type {
+	case MostAllocated:
+		s.scorer = s.mostAllocatedScore
+	case RequestedToCapacityRatio:
+		s.scorer = s.requestedToCapacityRatioScore(strategy.RequestedToCapacityRatio.Shape)
+	default:
+		s.scorer = s.leastAllocatedScore
+	}
+	return s
+}
+
+// score は pod を nodeInfo に配置した後の各リソースの要求量を求めてスコアを計算する
+// useRequested が false なら要求量を PodNonZeroRequests で数えるので、要求量 0 の Pod でもノードごとに差が付く
+func (s *resourceAllocationScorer) score(pod *v1.Pod, nodeInfo *NodeInfo) int64 {
+	podRequest, nodeRequested := PodNonZeroRequests(pod), nodeInfo.NonZeroRequested
+	if s.useRequested {
+		podRequest, nodeRequested = PodRequests(pod), nodeInfo.Requested
+	}
+	requested := make([]int64, len(s.resources))
+	allocatable := make([]int64, len(s.resources))
+	for i, r := range s.resources {
+		name := v1.ResourceName(r.Name)
+		requested[i] = nodeRequested.Get(name) + podRequest.Get(name)
+		allocatable[i] = nodeInfo.Allocatable.Get(name)
+	}
+	return s.scorer(requested, allocatable)
+}
+
+// weightedAverage はリソースごとのスコアを重み付きで平均する
+// 割り当て可能量が 0 のリソース (そのノードが持たない拡張リソースなど) は計算から外す
+func (s *resourceAllocationScorer) weightedAverage(requested, allocatable []int64, resourceScore func(requested, allocatable int64) int64) int64 {
+	var score, weightSum int64
+	for i := range requested {
+		if allocatable[i] == 0 {
+			continue
+		}
+		score += resourceScore(requested[i], allocatable[i]) * s.resources[i].Weight
+		weightSum += s.resources[i].Weight
+	}
+	if weightSum == 0 {
+		return 0
+	}
+	return score / weightSum
+}
+
+// leastAllocatedScore は空き容量の割合をスコアにする
+// 要求量が割り当て可能量を超えている場合は 0 にする
+func (s *resourceAllocationScorer) leastAllocatedScore(requested, allocatable []int64) int64 {
+	return s.weightedAverage(requested, allocatable, func(requested, allocatable int64) int64 {
+		if requested > allocatable {
+			return 0
+		}
+		return (allocatable - requested) * MaxNodeScore / allocatable
+	})
+}
+
+// mostAllocatedScore は使用率をスコアにする
+// 要求量が割り当て可能量を超えている場合は割り当て可能量で頭打ちにする
+func (s *resourceAllocationScorer) mostAllocatedScore(requested, allocatable []int64) int64 {
+	return s.weightedAverage(requested, allocatable, func(requested, allocatable int64) int64 {
+		return min(requested, allocatable) * MaxNodeScore / allocatable
+	})
+}
+
+// requestedToCapacityRatioScore は使用率を shape の折れ線で変換したものをスコアにする
+// shape の score (0 〜 10) は MaxNodeScore の範囲に拡大する
+func (s *resourceAllocationScorer) requestedToCapacityRatioScore(shape []UtilizationShapePoint) func(requested, allocatable []int64) int64 {
+	scaled := make([]UtilizationShapePoint, len(shape))
+	for i, point := range shape {
+		scaled[i] = UtilizationShapePoint{
+			Utilization: point.Utilization,
+			Score:       point.Score * int32(MaxNodeScore) / maxCustomPriorityScore,
+		}
+	}
+	return func(requested, allocatable []int64) int64 {
+		return s.weightedAverage(requested, allocatable, func(requested, allocatable int64) int64 {
+			utilization := min(requested, allocatable) * 100 / allocatable
+			return brokenLinearFunction(scaled, utilization)
+		})
+	}
+}
+
+// brokenLinearFunction は shape の点を線形に補間した値を返す
+// 最初の点より左は最初の点の score、最後の点より右は最後の点の score になる
+func brokenLinearFunction(shape []UtilizationShapePoint, utilization int64) int64 {
+	for i, point := range shape {
+		if utilization > int64(point.Utilization) {
+			continue
+		}
+		if i == 0 {
+			return int64(point.Score)
+		}
+		prev := shape[i-1]
+		return int64(prev.Score) + int64(point.Score-prev.Score)*(utilization-int64(prev.Utilization))/int64(point.Utilization-prev.Utilization)
+	}
+	return int64(shape[len(shape)-1].Score)
+}
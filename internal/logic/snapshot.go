@@ -0,0 +1,123 @@
+package logic
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Snapshot は 1 つの Pod をスケジュールする間に参照するクラスタの状態
+// ノードごとの配置済み Pod と、Namespace のラベル、ボリュームの情報を持つ
+type Snapshot struct {
+	// NodeList と同じ順番で並べた NodeInfo
+	nodeInfoList []*NodeInfo
+	nodeInfoMap  map[string]*NodeInfo
+	// affinity / anti-affinity を持つ Pod が配置されているノード
+	havePodsWithAffinityList         []*NodeInfo
+	havePodsWithRequiredAntiAffinity []*NodeInfo
+	// Namespace 名 → ラベル。namespaceSelector の評価に使う
+	namespaceLabels map[string]labels.Set
+	storageInfo     *StorageInfo
+	// ノード名 → そのノードに nominatedNodeName で指名された、まだ配置されていない Pod
+	nominatedPods map[string][]*v1.Pod
+}
+
+// NewSnapshot はノード一覧と配置済み Pod、Namespace から Snapshot を作る
+// どのノードにも配置されていない Pod は無視する
+func NewSnapshot(vs *v1.NodeList, assignedPods []*v1.Pod, namespaces []*v1.Namespace) *Snapshot {
+	nodeInfos := make([]*NodeInfo, 0, len(vs.Items))
+	nodeInfoMap := make(map[string]*NodeInfo, len(vs.Items))
+	for i := range vs.Items {
+		nodeInfo := NewNodeInfo(&vs.Items[i])
+		nodeInfos = append(nodeInfos, nodeInfo)
+		nodeInfoMap[nodeInfo.Node.Name] = nodeInfo
+	}
+	for _, pod := range assignedPods {
+		if nodeInfo, ok := nodeInfoMap[pod.Spec.NodeName]; ok {
+			nodeInfo.AddPod(pod)
+		}
+	}
+	return NewSnapshotFromNodeInfos(nodeInfos, namespaces)
+}
+
+// NewSnapshotFromNodeInfos は組み立て済みの NodeInfo と Namespace から Snapshot を作る
+// NodeInfo はスケジュールの間に書き換えないので、スケジューラのキャッシュが持つものをそのまま共有できる
+func NewSnapshotFromNodeInfos(nodeInfos []*NodeInfo, namespaces []*v1.Namespace) *Snapshot {
+	s := &Snapshot{
+		nodeInfoList:    nodeInfos,
+		nodeInfoMap:     make(map[string]*NodeInfo, len(nodeInfos)),
+		namespaceLabels: make(map[string]labels.Set, len(namespaces)),
+		storageInfo:     NewStorageInfo(nil, nil, nil, nil),
+	}
+	for _, nodeInfo := range nodeInfos {
+		s.nodeInfoMap[nodeInfo.Node.Name] = nodeInfo
+	}
+	s.buildAffinityLists()
+	for _, ns := range namespaces {
+		s.namespaceLabels[ns.Name] = labels.Set(ns.Labels)
+	}
+	return s
+}
+
+// affinity / anti-affinity を持つ Pod が配置されているノードの一覧を作る
+func (s *Snapshot) buildAffinityLists() {
+	for _, nodeInfo := range s.nodeInfoList {
+		if len(nodeInfo.PodsWithAffinity) > 0 {
+			s.havePodsWithAffinityList = append(s.havePodsWithAffinityList, nodeInfo)
+		}
+		if len(nodeInfo.PodsWithRequiredAntiAffinity) > 0 {
+			s.havePodsWithRequiredAntiAffinity = append(s.havePodsWithRequiredAntiAffinity, nodeInfo)
+		}
+	}
+}
+
+// NodeInfos はすべてのノードの NodeInfo を返す
+func (s *Snapshot) NodeInfos() []*NodeInfo {
+	return s.nodeInfoList
+}
+
+// Get はノード名に対応する NodeInfo を返す。存在しなければ nil を返す
+func (s *Snapshot) Get(nodeName string) *NodeInfo {
+	return s.nodeInfoMap[nodeName]
+}
+
+// HavePodsWithAffinityList は affinity / anti-affinity を持つ Pod が配置されているノードを返す
+func (s *Snapshot) HavePodsWithAffinityList() []*NodeInfo {
+	return s.havePodsWithAffinityList
+}
+
+// HavePodsWithRequiredAntiAffinityList は required な anti-affinity を持つ Pod が配置されているノードを返す
+func (s *Snapshot) HavePodsWithRequiredAntiAffinityList() []*NodeInfo {
+	return s.havePodsWithRequiredAntiAffinity
+}
+
+// NamespaceLabels は Namespace のラベルを返す。知らない Namespace の場合は nil を返す
+func (s *Snapshot) NamespaceLabels(namespace string) labels.Set {
+	return s.namespaceLabels[namespace]
+}
+
+// SetStorageInfo はボリュームの情報を設定する。設定しなければ PVC / PV などが 1 つもないものとして扱う
+func (s *Snapshot) SetStorageInfo(storageInfo *StorageInfo) {
+	s.storageInfo = storageInfo
+}
+
+// StorageInfo はボリュームの情報を返す
+func (s *Snapshot) StorageInfo() *StorageInfo {
+	return s.storageInfo
+}
+
+// SetNominatedPods はプリエンプションでノードを指名された、まだ配置されていない Pod を設定する
+// Filter では優先度が同じか高い指名済みの Pod が配置されているものとして判定し、
+// 退避させた Pod の終了を待つ間に、優先度の低い Pod が空いた分を使わないようにする
+func (s *Snapshot) SetNominatedPods(pods []*v1.Pod) {
+	s.nominatedPods = map[string][]*v1.Pod{}
+	for _, pod := range pods {
+		if nodeName := pod.Status.NominatedNodeName; nodeName != "" {
+			s.nominatedPods[nodeName] = append(s.nominatedPods[nodeName], pod)
+		}
+	}
+}
+
+// NominatedPodsForNode はノードを指名された Pod を返す
+func (s *Snapshot) NominatedPodsForNode(nodeName string) []*v1.Pod {
+	return s.nominatedPods[nodeName]
+}
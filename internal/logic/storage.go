@@ -0,0 +1,72 @@
+package logic
+
+import (
+	"slices"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+)
+
+// StorageInfo は Pod のボリュームを扱うプラグインが参照する PVC / PV / StorageClass / CSINode
+type StorageInfo struct {
+	// "namespace/name" → PVC
+	pvcs map[string]*v1.PersistentVolumeClaim
+	// 名前順に並べた PV。空いている PV を探すときに順番が変わらないようにする
+	pvs     []*v1.PersistentVolume
+	pvMap   map[string]*v1.PersistentVolume
+	classes map[string]*storagev1.StorageClass
+	// ノード名 → CSINode
+	csiNodes map[string]*storagev1.CSINode
+}
+
+// NewStorageInfo は PVC / PV / StorageClass / CSINode の一覧から StorageInfo を作る
+func NewStorageInfo(pvcs []*v1.PersistentVolumeClaim, pvs []*v1.PersistentVolume, classes []*storagev1.StorageClass, csiNodes []*storagev1.CSINode) *StorageInfo {
+	s := &StorageInfo{
+		pvcs:     make(map[string]*v1.PersistentVolumeClaim, len(pvcs)),
+		pvMap:    make(map[string]*v1.PersistentVolume, len(pvs)),
+		classes:  make(map[string]*storagev1.StorageClass, len(classes)),
+		csiNodes: make(map[string]*storagev1.CSINode, len(csiNodes)),
+	}
+	for _, pvc := range pvcs {
+		s.pvcs[pvc.Namespace+"/"+pvc.Name] = pvc
+	}
+	for _, pv := range pvs {
+		s.pvMap[pv.Name] = pv
+	}
+	s.pvs = slices.SortedFunc(slices.Values(pvs), func(a, b *v1.PersistentVolume) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	for _, class := range classes {
+		s.classes[class.Name] = class
+	}
+	for _, csiNode := range csiNodes {
+		s.csiNodes[csiNode.Name] = csiNode
+	}
+	return s
+}
+
+// PVC は namespace と name に対応する PVC を返す。存在しなければ nil を返す
+func (s *StorageInfo) PVC(namespace, name string) *v1.PersistentVolumeClaim {
+	return s.pvcs[namespace+"/"+name]
+}
+
+// PV は name に対応する PV を返す。存在しなければ nil を返す
+func (s *StorageInfo) PV(name string) *v1.PersistentVolume {
+	return s.pvMap[name]
+}
+
+// PVs はすべての PV を名前順に返す
+func (s *StorageInfo) PVs() []*v1.PersistentVolume {
+	return s.pvs
+}
+
+// StorageClass は name に対応する StorageClass を返す。存在しなければ nil を返す
+func (s *StorageInfo) StorageClass(name string) *storagev1.StorageClass {
+	return s.classes[name]
+}
+
+// CSINode はノード名に対応する CSINode を返す。存在しなければ nil を返す
+func (s *StorageInfo) CSINode(nodeName string) *storagev1.CSINode {
+	return s.csiNodes[nodeName]
+}
@@ -0,0 +1,92 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const TaintTolerationName = "TaintToleration"
+
+// TaintToleration はノードの taint と Pod の toleration を比較する
+//   - Filter: Pod が許容しない NoSchedule / NoExecute の taint を持つノードを除外する
+//   - Score: Pod が許容しない PreferNoSchedule の taint が少ないノードほど高いスコアにする
+type TaintToleration struct{}
+
+var _ FilterPlugin = &TaintToleration{}
+var _ ScorePlugin = &TaintToleration{}
+var _ ScoreNormalizer = &TaintToleration{}
+
+func NewTaintToleration(_ json.RawMessage) (Plugin, error) {
+	return &TaintToleration{}, nil
+}
+
+func (t *TaintToleration) Name() string {
+	return TaintTolerationName
+}
+
+func (t *TaintToleration) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	taint, untolerated := findMatchingUntoleratedTaint(nodeInfo.Node.Spec.Taints, pod.Spec.Tolerations, doNotScheduleTaintsFilter)
+	if !untolerated {
+		return nil
+	}
+	return NewStatus(UnschedulableAndUnresolvable, fmt.Sprintf("node(s) had untolerated taint {%s: %s}", taint.Key, taint.Value))
+}
+
+// Score は許容されない PreferNoSchedule の taint の数を返す
+// NormalizeScore で数が少ないほど高いスコアになるよう反転する
+func (t *TaintToleration) Score(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) (int64, *Status) {
+	// PreferNoSchedule に効く toleration だけを見る
+	var tolerations []v1.Toleration
+	for _, toleration := range pod.Spec.Tolerations {
+		if toleration.Effect == "" || toleration.Effect == v1.TaintEffectPreferNoSchedule {
+			tolerations = append(tolerations, toleration)
+		}
+	}
+
+	var count int64
+	for i := range nodeInfo.Node.Spec.Taints {
+		taint := &nodeInfo.Node.Spec.Taints[i]
+		if taint.Effect != v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !tolerationsTolerateTaint(tolerations, taint) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (t *TaintToleration) NormalizeScore(state *CycleState, pod *v1.Pod, scores NodeScoreList) *Status {
+	return DefaultNormalizeScore(MaxNodeScore, true, scores)
+}
+
+// Pod の配置を禁止する effect (NoSchedule / NoExecute) の taint だけを選ぶ
+func doNotScheduleTaintsFilter(taint *v1.Taint) bool {
+	return taint.Effect == v1.TaintEffectNoSchedule || taint.Effect == v1.TaintEffectNoExecute
+}
+
+// tolerations のいずれかが taint を許容するかを返す
+// 演算子 (Exists / Equal)、空のキー、effect の一致は v1.Toleration.ToleratesTaint の規則に従う
+func tolerationsTolerateTaint(tolerations []v1.Toleration, taint *v1.Taint) bool {
+	for i := range tolerations {
+		if tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// inclusionFilter を満たす taint のうち、tolerations で許容されないものを 1 つ返す
+func findMatchingUntoleratedTaint(taints []v1.Taint, tolerations []v1.Toleration, inclusionFilter func(*v1.Taint) bool) (v1.Taint, bool) {
+	for i := range taints {
+		if inclusionFilter != nil && !inclusionFilter(&taints[i]) {
+			continue
+		}
+		if !tolerationsTolerateTaint(tolerations, &taints[i]) {
+			return taints[i], true
+		}
+	}
+	return v1.Taint{}, false
+}
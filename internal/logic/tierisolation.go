@@ -0,0 +1,98 @@
+package logic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+const TierIsolationName = "TierIsolation"
+
+// TierIsolationArgs は TierIsolation の引数
+type TierIsolationArgs struct {
+	// ノードの用途を表すラベルのキー
+	LabelKey string `json:"labelKey"`
+	// このラベル値を持つノードには Pod を配置しない
+	ExcludedValues []string `json:"excludedValues"`
+	// このラベル値を持つノードには、nodeSelector か required な node affinity で
+	// 同じ値を明示的に指定した Pod だけを配置する
+	DedicatedValues []string `json:"dedicatedValues"`
+}
+
+// DefaultTierIsolationArgs は kind/multi-node.yaml のラベルに合わせたデフォルトの引数を返す
+func DefaultTierIsolationArgs() TierIsolationArgs {
+	return TierIsolationArgs{
+		LabelKey:        "tier",
+		ExcludedValues:  []string{"control"},
+		DedicatedValues: []string{"cronjob"},
+	}
+}
+
+// TierIsolation はラベルでノードの用途を分ける
+//   - tier=control のノードには配置しない
+//   - tier=cronjob のノードには tier=cronjob を指定した Pod だけを配置する
+//
+// Pod が指定したラベルを持たないノードを除外するのは NodeAffinity の役割なので、ここでは見ない
+type TierIsolation struct {
+	args TierIsolationArgs
+}
+
+var _ FilterPlugin = &TierIsolation{}
+
+func NewTierIsolation(rawArgs json.RawMessage) (Plugin, error) {
+	args := DefaultTierIsolationArgs()
+	if err := decodeArgs(rawArgs, &args); err != nil {
+		return nil, err
+	}
+	if args.LabelKey == "" {
+		return nil, errors.New("labelKey: required")
+	}
+	for _, v := range args.DedicatedValues {
+		if slices.Contains(args.ExcludedValues, v) {
+			return nil, fmt.Errorf("dedicatedValues: %q is also listed in excludedValues", v)
+		}
+	}
+	return &TierIsolation{args: args}, nil
+}
+
+func (t *TierIsolation) Name() string {
+	return TierIsolationName
+}
+
+func (t *TierIsolation) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	key := t.args.LabelKey
+	nodeValue, ok := nodeInfo.Node.Labels[key]
+	if !ok {
+		return nil
+	}
+	if slices.Contains(t.args.ExcludedValues, nodeValue) {
+		return NewStatus(UnschedulableAndUnresolvable, fmt.Sprintf("node(s) had %s=%s", key, nodeValue))
+	}
+	if slices.Contains(t.args.DedicatedValues, nodeValue) && !podRequestsLabel(pod, key, nodeValue) {
+		return NewStatus(UnschedulableAndUnresolvable, fmt.Sprintf("node(s) had %s=%s", key, nodeValue))
+	}
+	return nil
+}
+
+// Pod が nodeSelector か required な node affinity の In で key=value を明示的に指定しているかを返す
+func podRequestsLabel(pod *v1.Pod, key, value string) bool {
+	if v, ok := pod.Spec.NodeSelector[key]; ok && v == value {
+		return true
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return false
+	}
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, req := range term.MatchExpressions {
+			if req.Key == key && req.Operator == v1.NodeSelectorOpIn && slices.Contains(req.Values, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
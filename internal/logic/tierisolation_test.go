@@ -0,0 +1,85 @@
+package logic
+
+import (
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewTierIsolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    string
+		wantErr bool
+	}{
+		{name: "success: default args", args: ""},
+		{name: "success: custom args", args: `{"labelKey":"pool","excludedValues":["system"],"dedicatedValues":["batch","gpu"]}`},
+		{name: "failure: unknown field", args: `{"label":"pool"}`, wantErr: true},
+		{name: "failure: empty label key", args: `{"labelKey":""}`, wantErr: true},
+		{name: "failure: value both excluded and dedicated", args: `{"excludedValues":["batch"],"dedicatedValues":["batch"]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewTierIsolation(json.RawMessage(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewTierIsolation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTierIsolation_Filter(t *testing.T) {
+	p, err := NewTierIsolation(json.RawMessage(`{"labelKey":"pool","excludedValues":["system"],"dedicatedValues":["batch"]}`))
+	if err != nil {
+		t.Fatalf("NewTierIsolation() error = %v", err)
+	}
+	filter := p.(FilterPlugin)
+
+	newNode := func(pool string) *NodeInfo {
+		return NewNodeInfo(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node", Labels: map[string]string{"pool": pool}}})
+	}
+	normalPod := &v1.Pod{}
+	batchPod := &v1.Pod{Spec: v1.PodSpec{NodeSelector: map[string]string{"pool": "batch"}}}
+
+	tests := []struct {
+		name       string
+		pod        *v1.Pod
+		nodeInfo   *NodeInfo
+		wantReason string
+	}{
+		{name: "excluded node", pod: normalPod, nodeInfo: newNode("system"), wantReason: "node(s) had pool=system"},
+		{name: "excluded node rejects dedicated pod too", pod: batchPod, nodeInfo: newNode("system"), wantReason: "node(s) had pool=system"},
+		{name: "dedicated node rejects normal pod", pod: normalPod, nodeInfo: newNode("batch"), wantReason: "node(s) had pool=batch"},
+		{name: "dedicated pod on dedicated node", pod: batchPod, nodeInfo: newNode("batch")},
+		// ラベルが一致しないことは NodeAffinity が判定する
+		{name: "dedicated pod on normal node", pod: batchPod, nodeInfo: newNode("web")},
+		{name: "dedicated node accepts pod with required node affinity", pod: &v1.Pod{Spec: v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{Key: "pool", Operator: v1.NodeSelectorOpIn, Values: []string{"batch", "web"}}},
+			}}},
+		}}}}, nodeInfo: newNode("batch")},
+		{name: "dedicated node rejects pod selecting it only with Exists", pod: &v1.Pod{Spec: v1.PodSpec{Affinity: &v1.Affinity{NodeAffinity: &v1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+				MatchExpressions: []v1.NodeSelectorRequirement{{Key: "pool", Operator: v1.NodeSelectorOpExists}},
+			}}},
+		}}}}, nodeInfo: newNode("batch"), wantReason: "node(s) had pool=batch"},
+		{name: "normal pod on normal node", pod: normalPod, nodeInfo: newNode("web")},
+		{name: "default tier label is not used", pod: normalPod, nodeInfo: NewNodeInfo(&v1.Node{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"tier": "control"}}})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := filter.Filter(NewCycleState(), tt.pod, tt.nodeInfo)
+			if tt.wantReason == "" {
+				if !status.IsSuccess() {
+					t.Errorf("TierIsolation.Filter() = %v, want success", status.Reasons())
+				}
+				return
+			}
+			if status.Code() != UnschedulableAndUnresolvable || len(status.Reasons()) != 1 || status.Reasons()[0] != tt.wantReason {
+				t.Errorf("TierIsolation.Filter() = %v %v, want %v %q", status.Code(), status.Reasons(), UnschedulableAndUnresolvable, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package logic
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	VolumeBindingName = "VolumeBinding"
+
+	volumeBindingStateKey StateKey = "PreFilter" + VolumeBindingName
+
+	// AnnSelectedNode は WaitForFirstConsumer の PVC に付けて、プロビジョナにボリュームを作るノードを伝えるアノテーション
+	AnnSelectedNode = "volume.kubernetes.io/selected-node"
+	// AnnBoundByController は PV の claimRef をスケジューラや PV コントローラが設定したことを表すアノテーション
+	AnnBoundByController = "pv.kubernetes.io/bound-by-controller"
+	// 動的プロビジョニングをしない StorageClass の provisioner
+	noProvisioner = "kubernetes.io/no-provisioner"
+
+	errReasonUnboundImmediatePVC        = "pod has unbound immediate PersistentVolumeClaims"
+	errReasonVolumeNodeAffinityConflict = "node(s) had volume node affinity conflict"
+	errReasonVolumeBindConflict         = "node(s) didn't find available persistent volumes to bind"
+	errReasonVolumeSelectedNodeConflict = "node(s) didn't match the node selected for volume provisioning"
+)
+
+// VolumeBinding は Pod の PVC を考慮してノードを除外する
+//   - 束縛済みの PVC: PV の node affinity を満たさないノードを除外する
+//   - WaitForFirstConsumer の未束縛の PVC: 束縛できる PV があるか、プロビジョニングできるノードだけを残す
+//   - それ以外の未束縛の PVC: PV コントローラが束縛するまでどのノードにも配置しない
+//
+// ノードを決めた後の PV の束縛や selected-node アノテーションの設定は、FindPodVolumes の結果を使って client が行う
+type VolumeBinding struct{}
+
+var _ PreFilterPlugin = &VolumeBinding{}
+var _ FilterPlugin = &VolumeBinding{}
+
+func NewVolumeBinding(_ json.RawMessage) (Plugin, error) {
+	return &VolumeBinding{}, nil
+}
+
+func (pl *VolumeBinding) Name() string {
+	return VolumeBindingName
+}
+
+// BindingInfo は未束縛の PVC と、それに束縛する PV の組
+type BindingInfo struct {
+	PV  *v1.PersistentVolume
+	PVC *v1.PersistentVolumeClaim
+}
+
+// PodVolumes は Pod をノードに配置するために必要なボリュームの操作
+type PodVolumes struct {
+	// 既存の PV に束縛する PVC
+	StaticBindings []*BindingInfo
+	// selected-node アノテーションを付けてプロビジョニングさせる PVC
+	DynamicProvisions []*v1.PersistentVolumeClaim
+}
+
+// Pod の PVC を状態ごとに分けたもの
+type podVolumeClaims struct {
+	bound            []*v1.PersistentVolumeClaim
+	unboundDelayed   []*v1.PersistentVolumeClaim
+	unboundImmediate []*v1.PersistentVolumeClaim
+}
+
+func (c *podVolumeClaims) isEmpty() bool {
+	return len(c.bound) == 0 && len(c.unboundDelayed) == 0 && len(c.unboundImmediate) == 0
+}
+
+type volumeBindingState struct {
+	claims      *podVolumeClaims
+	storageInfo *StorageInfo
+}
+
+// PreFilter は Pod の PVC を集めて、束縛済み・遅延束縛・即時束縛に分ける
+// PVC が存在しない場合や、未束縛の即時束縛の PVC がある場合はどのノードにも配置できない
+func (pl *VolumeBinding) PreFilter(state *CycleState, pod *v1.Pod, snapshot *Snapshot) *Status {
+	claims, reason := getPodVolumeClaims(pod, snapshot.StorageInfo())
+	if reason != "" {
+		return NewStatus(UnschedulableAndUnresolvable, reason)
+	}
+	if len(claims.unboundImmediate) > 0 {
+		return NewStatus(UnschedulableAndUnresolvable, errReasonUnboundImmediatePVC)
+	}
+	state.Write(volumeBindingStateKey, &volumeBindingState{claims: claims, storageInfo: snapshot.StorageInfo()})
+	return nil
+}
+
+func getVolumeBindingState(state *CycleState) (*volumeBindingState, error) {
+	c, err := state.Read(volumeBindingStateKey)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(*volumeBindingState)
+	if !ok {
+		return nil, fmt.Errorf("%+v convert to volumeBindingState error", c)
+	}
+	return s, nil
+}
+
+func (pl *VolumeBinding) Filter(state *CycleState, pod *v1.Pod, nodeInfo *NodeInfo) *Status {
+	s, err := getVolumeBindingState(state)
+	if err != nil {
+		return AsStatus(err)
+	}
+	if s.claims.isEmpty() {
+		return nil
+	}
+	if _, reasons := findPodVolumes(s.claims, nodeInfo.Node, s.storageInfo); len(reasons) > 0 {
+		return NewStatus(UnschedulableAndUnresolvable, reasons...)
+	}
+	return nil
+}
+
+// FindPodVolumes は Pod を node に配置するために必要なボリュームの操作を返す
+// 配置できない場合は、その理由を返す
+func FindPodVolumes(pod *v1.Pod, node *v1.Node, storageInfo *StorageInfo) (*PodVolumes, []string) {
+	claims, reason := getPodVolumeClaims(pod, storageInfo)
+	if reason != "" {
+		return nil, []string{reason}
+	}
+	if len(claims.unboundImmediate) > 0 {
+		return nil, []string{errReasonUnboundImmediatePVC}
+	}
+	return findPodVolumes(claims, node, storageInfo)
+}
+
+func findPodVolumes(claims *podVolumeClaims, node *v1.Node, storageInfo *StorageInfo) (*PodVolumes, []string) {
+	var reasons []string
+	for _, pvc := range claims.bound {
+		if !pvNodeAffinityMatches(storageInfo.PV(pvc.Spec.VolumeName), node) {
+			reasons = append(reasons, errReasonVolumeNodeAffinityConflict)
+			break
+		}
+	}
+
+	podVolumes := &PodVolumes{}
+	// 同じ Pod の別の PVC に同じ PV を選ばないようにする
+	chosen := map[string]bool{}
+	for _, pvc := range claims.unboundDelayed {
+		// プロビジョニング中の PVC は、選ばれたノードにしか配置できない
+		if selected, ok := pvc.Annotations[AnnSelectedNode]; ok && selected != node.Name {
+			reasons = append(reasons, errReasonVolumeSelectedNodeConflict)
+			break
+		}
+		if pv := findMatchingVolume(pvc, node, storageInfo.PVs(), chosen); pv != nil {
+			chosen[pv.Name] = true
+			podVolumes.StaticBindings = append(podVolumes.StaticBindings, &BindingInfo{PV: pv, PVC: pvc})
+			continue
+		}
+		if canProvision(pvc, storageInfo.StorageClass(storageClassName(pvc)), node) {
+			podVolumes.DynamicProvisions = append(podVolumes.DynamicProvisions, pvc)
+			continue
+		}
+		reasons = append(reasons, errReasonVolumeBindConflict)
+		break
+	}
+	if len(reasons) > 0 {
+		return nil, reasons
+	}
+	return podVolumes, nil
+}
+
+// getPodVolumeClaims は Pod が使う PVC を集める
+// PVC や、束縛済みの PV が見つからない場合はその理由を返す
+func getPodVolumeClaims(pod *v1.Pod, storageInfo *StorageInfo) (*podVolumeClaims, string) {
+	claims := &podVolumeClaims{}
+	for _, name := range podVolumeClaimNames(pod) {
+		pvc := storageInfo.PVC(pod.Namespace, name)
+		if pvc == nil {
+			return nil, fmt.Sprintf("persistentvolumeclaim %q not found", name)
+		}
+		if pvc.DeletionTimestamp != nil {
+			return nil, fmt.Sprintf("persistentvolumeclaim %q is being deleted", name)
+		}
+		switch {
+		case pvc.Spec.VolumeName != "":
+			if storageInfo.PV(pvc.Spec.VolumeName) == nil {
+				return nil, fmt.Sprintf("persistentvolume %q not found", pvc.Spec.VolumeName)
+			}
+			claims.bound = append(claims.bound, pvc)
+		case isDelayedBinding(pvc, storageInfo):
+			claims.unboundDelayed = append(claims.unboundDelayed, pvc)
+		default:
+			claims.unboundImmediate = append(claims.unboundImmediate, pvc)
+		}
+	}
+	return claims, ""
+}
+
+// podVolumeClaimNames は Pod が使う PVC の名前を返す
+// generic ephemeral volume は "<Pod 名>-<ボリューム名>" の PVC として作られる
+func podVolumeClaimNames(pod *v1.Pod) []string {
+	var names []string
+	for _, vol := range pod.Spec.Volumes {
+		switch {
+		case vol.PersistentVolumeClaim != nil:
+			names = append(names, vol.PersistentVolumeClaim.ClaimName)
+		case vol.Ephemeral != nil:
+			names = append(names, pod.Name+"-"+vol.Name)
+		}
+	}
+	return names
+}
+
+func storageClassName(pvc *v1.PersistentVolumeClaim) string {
+	if pvc.Spec.StorageClassName == nil {
+		return ""
+	}
+	return *pvc.Spec.StorageClassName
+}
+
+// PVC の StorageClass が WaitForFirstConsumer かどうかを返す
+func isDelayedBinding(pvc *v1.PersistentVolumeClaim, storageInfo *StorageInfo) bool {
+	class := storageInfo.StorageClass(storageClassName(pvc))
+	return class != nil && class.VolumeBindingMode != nil && *class.VolumeBindingMode == storagev1.VolumeBindingWaitForFirstConsumer
+}
+
+// PV の node affinity をノードが満たすかを返す。node affinity のない PV はどのノードからも使える
+func pvNodeAffinityMatches(pv *v1.PersistentVolume, node *v1.Node) bool {
+	if pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+		return true
+	}
+	return nodeMatchesNodeSelectorTerms(node, pv.Spec.NodeAffinity.Required.NodeSelectorTerms)
+}
+
+// findMatchingVolume は pvc に束縛でき、node から使える PV を返す
+// PVC を指す claimRef を持つ (事前に束縛された) PV を優先し、それ以外は容量が最も小さい PV を選ぶ
+func findMatchingVolume(pvc *v1.PersistentVolumeClaim, node *v1.Node, pvs []*v1.PersistentVolume, chosen map[string]bool) *v1.PersistentVolume {
+	var best *v1.PersistentVolume
+	for _, pv := range pvs {
+		if chosen[pv.Name] || !pvMatchesClaim(pv, pvc) || !pvNodeAffinityMatches(pv, node) {
+			continue
+		}
+		if pv.Spec.ClaimRef != nil {
+			return pv
+		}
+		if best == nil || pv.Spec.Capacity.Storage().Cmp(*best.Spec.Capacity.Storage()) < 0 {
+			best = pv
+		}
+	}
+	return best
+}
+
+// pvMatchesClaim は PV が空いていて、PVC の要求 (StorageClass、volumeMode、accessModes、容量、selector) を満たすかを返す
+func pvMatchesClaim(pv *v1.PersistentVolume, pvc *v1.PersistentVolumeClaim) bool {
+	if pv.DeletionTimestamp != nil {
+		return false
+	}
+	if ref := pv.Spec.ClaimRef; ref != nil {
+		if ref.Namespace != pvc.Namespace || ref.Name != pvc.Name || (ref.UID != "" && ref.UID != pvc.UID) {
+			return false
+		}
+	} else if pv.Status.Phase == v1.VolumeReleased || pv.Status.Phase == v1.VolumeFailed {
+		return false
+	}
+
+	if pv.Spec.StorageClassName != storageClassName(pvc) {
+		return false
+	}
+	if volumeModeOrDefault(pv.Spec.VolumeMode) != volumeModeOrDefault(pvc.Spec.VolumeMode) {
+		return false
+	}
+	for _, mode := range pvc.Spec.AccessModes {
+		if !slices.Contains(pv.Spec.AccessModes, mode) {
+			return false
+		}
+	}
+	if request, ok := pvc.Spec.Resources.Requests[v1.ResourceStorage]; ok && pv.Spec.Capacity.Storage().Cmp(request) < 0 {
+		return false
+	}
+	if pvc.Spec.Selector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(pvc.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(pv.Labels)) {
+			return false
+		}
+	}
+	return true
+}
+
+func volumeModeOrDefault(mode *v1.PersistentVolumeMode) v1.PersistentVolumeMode {
+	if mode == nil {
+		return v1.PersistentVolumeFilesystem
+	}
+	return *mode
+}
+
+// canProvision は pvc のボリュームを node 向けにプロビジョニングできるかを返す
+// selector 付きの PVC はプロビジョニングできない。allowedTopologies があれば node がそれを満たす必要がある
+func canProvision(pvc *v1.PersistentVolumeClaim, class *storagev1.StorageClass, node *v1.Node) bool {
+	if class == nil || class.Provisioner == noProvisioner || pvc.Spec.Selector != nil {
+		return false
+	}
+	if len(class.AllowedTopologies) == 0 {
+		return true
+	}
+	for _, term := range class.AllowedTopologies {
+		if nodeMatchesTopologySelectorTerm(node, term) {
+			return true
+		}
+	}
+	return false
+}
+
+// term のすべての式について、ノードのラベルの値が values に含まれれば true を返す
+func nodeMatchesTopologySelectorTerm(node *v1.Node, term v1.TopologySelectorTerm) bool {
+	for _, req := range term.MatchLabelExpressions {
+		value, ok := node.Labels[req.Key]
+		if !ok || !slices.Contains(req.Values, value) {
+			return false
+		}
+	}
+	return true
+}
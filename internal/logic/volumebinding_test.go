@@ -0,0 +1,210 @@
+package logic
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	testImmediateClass = "standard"
+	testDelayedClass   = "local-wffc"
+	testLocalClass     = "local-static"
+)
+
+func newStorageClass(name, provisioner string, mode storagev1.VolumeBindingMode, allowedZones ...string) *storagev1.StorageClass {
+	class := &storagev1.StorageClass{
+		ObjectMeta:        metav1.ObjectMeta{Name: name},
+		Provisioner:       provisioner,
+		VolumeBindingMode: &mode,
+	}
+	if len(allowedZones) > 0 {
+		class.AllowedTopologies = []v1.TopologySelectorTerm{{
+			MatchLabelExpressions: []v1.TopologySelectorLabelRequirement{{Key: testZoneKey, Values: allowedZones}},
+		}}
+	}
+	return class
+}
+
+func newPVC(name, class, volumeName, request string) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: v1.PersistentVolumeClaimSpec{
+			StorageClassName: ptr.To(class),
+			VolumeName:       volumeName,
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Resources:        v1.VolumeResourceRequirements{Requests: v1.ResourceList{v1.ResourceStorage: resource.MustParse(request)}},
+		},
+	}
+}
+
+// zone が空でなければ、その zone のノードからしか使えない PV を作る
+func newPV(name, class, capacity, zone string) *v1.PersistentVolume {
+	pv := &v1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1.PersistentVolumeSpec{
+			StorageClassName: class,
+			AccessModes:      []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			Capacity:         v1.ResourceList{v1.ResourceStorage: resource.MustParse(capacity)},
+		},
+		Status: v1.PersistentVolumeStatus{Phase: v1.VolumeAvailable},
+	}
+	if zone != "" {
+		pv.Spec.NodeAffinity = &v1.VolumeNodeAffinity{Required: &v1.NodeSelector{NodeSelectorTerms: []v1.NodeSelectorTerm{{
+			MatchExpressions: []v1.NodeSelectorRequirement{{Key: testZoneKey, Operator: v1.NodeSelectorOpIn, Values: []string{zone}}},
+		}}}}
+	}
+	return pv
+}
+
+func withClaimRef(pv *v1.PersistentVolume, pvc *v1.PersistentVolumeClaim) *v1.PersistentVolume {
+	pv.Spec.ClaimRef = &v1.ObjectReference{Namespace: pvc.Namespace, Name: pvc.Name, UID: pvc.UID}
+	pv.Status.Phase = v1.VolumeBound
+	return pv
+}
+
+func newPVCPod(name string, claimNames ...string) *v1.Pod {
+	pod := newLabeledPod(name, "default", "", nil, nil)
+	for _, claimName := range claimNames {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name:         claimName,
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{ClaimName: claimName}},
+		})
+	}
+	return pod
+}
+
+func TestFindPodVolumes(t *testing.T) {
+	classes := []*storagev1.StorageClass{
+		newStorageClass(testImmediateClass, "ebs.csi.aws.com", storagev1.VolumeBindingImmediate),
+		newStorageClass(testDelayedClass, "ebs.csi.aws.com", storagev1.VolumeBindingWaitForFirstConsumer, "zone-a"),
+		newStorageClass(testLocalClass, noProvisioner, storagev1.VolumeBindingWaitForFirstConsumer),
+	}
+	nodeA := newZoneNode("node-a", "zone-a")
+	nodeB := newZoneNode("node-b", "zone-b")
+
+	boundPVC := newPVC("bound", testImmediateClass, "pv-bound", "1Gi")
+	boundPV := withClaimRef(newPV("pv-bound", testImmediateClass, "1Gi", "zone-a"), boundPVC)
+	immediatePVC := newPVC("immediate", testImmediateClass, "", "1Gi")
+	delayedPVC := newPVC("delayed", testDelayedClass, "", "1Gi")
+	localPVC := newPVC("local", testLocalClass, "", "5Gi")
+	localPVC2 := newPVC("local2", testLocalClass, "", "5Gi")
+	preBoundPVC := newPVC("pre-bound", testLocalClass, "", "1Gi")
+	selectedPVC := newPVC("selected", testDelayedClass, "", "1Gi")
+	selectedPVC.Annotations = map[string]string{AnnSelectedNode: "node-b"}
+
+	smallLocal := newPV("local-small", testLocalClass, "5Gi", "zone-b")
+	largeLocal := newPV("local-large", testLocalClass, "10Gi", "zone-b")
+	tooSmallLocal := newPV("local-too-small", testLocalClass, "1Gi", "zone-b")
+	preBoundPV := withClaimRef(newPV("local-pre-bound", testLocalClass, "10Gi", "zone-b"), preBoundPVC)
+	preBoundPV.Status.Phase = v1.VolumeAvailable
+	releasedPV := newPV("local-released", testLocalClass, "5Gi", "zone-a")
+	releasedPV.Status.Phase = v1.VolumeReleased
+
+	storageInfo := NewStorageInfo(
+		[]*v1.PersistentVolumeClaim{boundPVC, immediatePVC, delayedPVC, localPVC, localPVC2, preBoundPVC, selectedPVC},
+		[]*v1.PersistentVolume{boundPV, smallLocal, largeLocal, tooSmallLocal, preBoundPV, releasedPV},
+		classes, nil,
+	)
+
+	tests := []struct {
+		name        string
+		pod         *v1.Pod
+		node        v1.Node
+		want        *PodVolumes
+		wantReasons []string
+	}{
+		{
+			name: "no volumes",
+			pod:  newPVCPod("pod"),
+			node: nodeA,
+			want: &PodVolumes{},
+		},
+		{
+			name:        "claim not found",
+			pod:         newPVCPod("pod", "missing"),
+			node:        nodeA,
+			wantReasons: []string{`persistentvolumeclaim "missing" not found`},
+		},
+		{
+			name: "bound volume in the same zone",
+			pod:  newPVCPod("pod", "bound"),
+			node: nodeA,
+			want: &PodVolumes{},
+		},
+		{
+			name:        "bound volume in another zone",
+			pod:         newPVCPod("pod", "bound"),
+			node:        nodeB,
+			wantReasons: []string{errReasonVolumeNodeAffinityConflict},
+		},
+		{
+			// 即時束縛の PVC は PV コントローラに任せる
+			name:        "unbound immediate claim",
+			pod:         newPVCPod("pod", "immediate"),
+			node:        nodeA,
+			wantReasons: []string{errReasonUnboundImmediatePVC},
+		},
+		{
+			name: "delayed claim provisioned in allowed zone",
+			pod:  newPVCPod("pod", "delayed"),
+			node: nodeA,
+			want: &PodVolumes{DynamicProvisions: []*v1.PersistentVolumeClaim{delayedPVC}},
+		},
+		{
+			name:        "delayed claim outside allowed topologies",
+			pod:         newPVCPod("pod", "delayed"),
+			node:        nodeB,
+			wantReasons: []string{errReasonVolumeBindConflict},
+		},
+		{
+			// 容量を満たす PV のうち最も小さいものを選ぶ
+			name: "static volume matched by smallest capacity",
+			pod:  newPVCPod("pod", "local"),
+			node: nodeB,
+			want: &PodVolumes{StaticBindings: []*BindingInfo{{PV: smallLocal, PVC: localPVC}}},
+		},
+		{
+			name: "two claims get different volumes",
+			pod:  newPVCPod("pod", "local", "local2"),
+			node: nodeB,
+			want: &PodVolumes{StaticBindings: []*BindingInfo{{PV: smallLocal, PVC: localPVC}, {PV: largeLocal, PVC: localPVC2}}},
+		},
+		{
+			// zone-a には Released の PV しかなく、no-provisioner なのでプロビジョニングもできない
+			name:        "no static volume on the node",
+			pod:         newPVCPod("pod", "local"),
+			node:        nodeA,
+			wantReasons: []string{errReasonVolumeBindConflict},
+		},
+		{
+			name: "pre-bound volume preferred",
+			pod:  newPVCPod("pod", "pre-bound"),
+			node: nodeB,
+			want: &PodVolumes{StaticBindings: []*BindingInfo{{PV: preBoundPV, PVC: preBoundPVC}}},
+		},
+		{
+			name:        "claim being provisioned for another node",
+			pod:         newPVCPod("pod", "selected"),
+			node:        nodeA,
+			wantReasons: []string{errReasonVolumeSelectedNodeConflict},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reasons := FindPodVolumes(tt.pod, &tt.node, storageInfo)
+			if !reflect.DeepEqual(reasons, tt.wantReasons) {
+				t.Fatalf("FindPodVolumes() reasons = %v, want %v", reasons, tt.wantReasons)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindPodVolumes() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"kube-scheduler-practice/internal/logic"
+	"kube-scheduler-practice/internal/queue"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "scheduler"
+
+// スケジュールの試行結果。scheduler_schedule_attempts_total などの result ラベルに使う
+const (
+	ResultScheduled     = "scheduled"
+	ResultUnschedulable = "unschedulable"
+	ResultError         = "error"
+)
+
+// Metrics はスケジューラのメトリクス
+// プロセス全体で共有するレジストリは使わず、Metrics ごとに登録するので、テストでは New で何個でも作れる
+// nil の *Metrics は何も記録しない
+type Metrics struct {
+	registry *prometheus.Registry
+
+	// Pod 1 つをスケジュールしようとした回数。result と profile (schedulerName) ごと
+	scheduleAttempts *prometheus.CounterVec
+	// Pod 1 つのスケジュールの試行にかかった時間。ノードの選択から bind まで
+	schedulingAttemptDuration *prometheus.HistogramVec
+	// Pod が最初にキューに積まれてから bind されるまでの時間
+	podSchedulingDuration *prometheus.HistogramVec
+	// プラグインの拡張点ごとの実行時間
+	pluginExecutionDuration *prometheus.HistogramVec
+	// bind の API 呼び出しにかかった時間
+	bindingDuration *prometheus.HistogramVec
+	// API 呼び出しのエラーの回数。operation ごと
+	apiErrors *prometheus.CounterVec
+
+	lock sync.Mutex
+	// サブキューごとの Pod の数を返す。キューができるまでは nil
+	queueDepths func() queue.Depths
+}
+
+var _ logic.MetricsRecorder = &Metrics{}
+
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		scheduleAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "schedule_attempts_total",
+			Help:      "Number of attempts to schedule pods, by the result.",
+		}, []string{"result", "profile"}),
+		schedulingAttemptDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "scheduling_attempt_duration_seconds",
+			Help:      "Scheduling attempt latency in seconds (scheduling algorithm + binding).",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"result", "profile"}),
+		podSchedulingDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "pod_scheduling_duration_seconds",
+			Help:      "E2e latency for a pod being scheduled, from the time the pod enters the scheduling queue until it is bound.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 20),
+		}, []string{"profile"}),
+		pluginExecutionDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "plugin_execution_duration_seconds",
+			Help:      "Duration for running a plugin at a specific extension point.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 1.5, 20),
+		}, []string{"plugin", "extension_point", "status"}),
+		bindingDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "binding_duration_seconds",
+			Help:      "Binding latency in seconds.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15),
+		}, []string{"result"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "api_errors_total",
+			Help:      "Number of failed requests to the API server, by the operation.",
+		}, []string{"operation"}),
+	}
+	m.registry.MustRegister(
+		m.scheduleAttempts,
+		m.schedulingAttemptDuration,
+		m.podSchedulingDuration,
+		m.pluginExecutionDuration,
+		m.bindingDuration,
+		m.apiErrors,
+		&pendingPodsCollector{metrics: m},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler はメトリクスを Prometheus のテキスト形式で返す http.Handler を返す
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ListenAndServe は addr の /metrics でメトリクスを返す。ctx がキャンセルされたら止める
+func (m *Metrics) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Shutdown(context.Background())
+	}()
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("error serving metrics on %s: %w", addr, err)
+	}
+	return nil
+}
+
+// SetQueueDepths は scheduler_pending_pods の値をどこから取るかを設定する
+func (m *Metrics) SetQueueDepths(depths func() queue.Depths) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.queueDepths = depths
+}
+
+// ObserveSchedulingAttempt は Pod 1 つのスケジュールの試行の結果とかかった時間を記録する
+func (m *Metrics) ObserveSchedulingAttempt(result, profile string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.scheduleAttempts.WithLabelValues(result, profile).Inc()
+	m.schedulingAttemptDuration.WithLabelValues(result, profile).Observe(duration.Seconds())
+}
+
+// ObservePodScheduled は Pod が最初にキューに積まれてから bind されるまでの時間を記録する
+func (m *Metrics) ObservePodScheduled(profile string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.podSchedulingDuration.WithLabelValues(profile).Observe(duration.Seconds())
+}
+
+// ObservePluginDuration はプラグインの拡張点 1 回分の実行時間を記録する
+func (m *Metrics) ObservePluginDuration(extensionPoint, plugin string, code logic.Code, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.pluginExecutionDuration.WithLabelValues(plugin, extensionPoint, code.String()).Observe(duration.Seconds())
+}
+
+// ObserveBinding は bind の API 呼び出しにかかった時間を記録する
+func (m *Metrics) ObserveBinding(err error, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = ResultError
+	}
+	m.bindingDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// IncAPIError は operation の API 呼び出しが失敗したことを記録する
+func (m *Metrics) IncAPIError(operation string) {
+	if m == nil {
+		return
+	}
+	m.apiErrors.WithLabelValues(operation).Inc()
+}
+
+// pendingPodsCollector は収集のたびにキューの長さを読み、scheduler_pending_pods として返す
+type pendingPodsCollector struct {
+	metrics *Metrics
+}
+
+var pendingPodsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "pending_pods"),
+	"Number of pending pods, by the queue type.",
+	[]string{"queue"}, nil,
+)
+
+func (c *pendingPodsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingPodsDesc
+}
+
+func (c *pendingPodsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.metrics.lock.Lock()
+	depths := c.metrics.queueDepths
+	c.metrics.lock.Unlock()
+
+	var d queue.Depths
+	if depths != nil {
+		d = depths()
+	}
+	ch <- prometheus.MustNewConstMetric(pendingPodsDesc, prometheus.GaugeValue, float64(d.Active), "active")
+	ch <- prometheus.MustNewConstMetric(pendingPodsDesc, prometheus.GaugeValue, float64(d.Backoff), "backoff")
+	ch <- prometheus.MustNewConstMetric(pendingPodsDesc, prometheus.GaugeValue, float64(d.Unschedulable), "unschedulable")
+}
@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"kube-scheduler-practice/internal/logic"
+	"kube-scheduler-practice/internal/queue"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Handler から返るメトリクスのテキストを取得する
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	server := httptest.NewServer(m.Handler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to get metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status code = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestMetrics_Handler(t *testing.T) {
+	m := New()
+	m.ObserveSchedulingAttempt(ResultScheduled, "my-custom-scheduler", 20*time.Millisecond)
+	m.ObserveSchedulingAttempt(ResultScheduled, "my-custom-scheduler", 30*time.Millisecond)
+	m.ObserveSchedulingAttempt(ResultUnschedulable, "my-batch-scheduler", 10*time.Millisecond)
+	m.ObservePodScheduled("my-custom-scheduler", time.Second)
+	m.ObservePluginDuration(logic.ExtensionPointFilter, "NodeResourcesFit", logic.Unschedulable, time.Microsecond)
+	m.ObserveBinding(nil, 5*time.Millisecond)
+	m.ObserveBinding(errors.New("conflict"), 5*time.Millisecond)
+	m.IncAPIError("bind")
+	m.SetQueueDepths(func() queue.Depths {
+		return queue.Depths{Active: 1, Backoff: 2, Unschedulable: 3}
+	})
+
+	got := scrape(t, m)
+	for _, want := range []string{
+		`scheduler_schedule_attempts_total{profile="my-custom-scheduler",result="scheduled"} 2`,
+		`scheduler_schedule_attempts_total{profile="my-batch-scheduler",result="unschedulable"} 1`,
+		`scheduler_scheduling_attempt_duration_seconds_count{profile="my-custom-scheduler",result="scheduled"} 2`,
+		`scheduler_scheduling_attempt_duration_seconds_sum{profile="my-custom-scheduler",result="scheduled"} 0.05`,
+		`scheduler_pod_scheduling_duration_seconds_count{profile="my-custom-scheduler"} 1`,
+		`scheduler_plugin_execution_duration_seconds_count{extension_point="Filter",plugin="NodeResourcesFit",status="Unschedulable"} 1`,
+		`scheduler_binding_duration_seconds_count{result="success"} 1`,
+		`scheduler_binding_duration_seconds_count{result="error"} 1`,
+		`scheduler_api_errors_total{operation="bind"} 1`,
+		`scheduler_pending_pods{queue="active"} 1`,
+		`scheduler_pending_pods{queue="backoff"} 2`,
+		`scheduler_pending_pods{queue="unschedulable"} 3`,
+		`go_goroutines`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
+
+func TestMetrics_Handler_NoQueue(t *testing.T) {
+	// キューができる前でも、pending_pods は 0 として返す
+	got := scrape(t, New())
+	if !strings.Contains(got, `scheduler_pending_pods{queue="active"} 0`) {
+		t.Errorf("metrics do not contain pending pods:\n%s", got)
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	// nil の *Metrics は何もしない
+	var m *Metrics
+	m.ObserveSchedulingAttempt(ResultError, "my-custom-scheduler", time.Second)
+	m.ObservePodScheduled("my-custom-scheduler", time.Second)
+	m.ObservePluginDuration(logic.ExtensionPointScore, "NodeAffinity", logic.Success, time.Second)
+	m.ObserveBinding(nil, time.Second)
+	m.IncAPIError("bind")
+	m.SetQueueDepths(nil)
+}
@@ -0,0 +1,82 @@
+package queue
+
+import "container/heap"
+
+// podHeap は key で要素を引ける QueuedPodInfo のヒープ
+// less で先に取り出す要素を決める
+type podHeap struct {
+	items []*QueuedPodInfo
+	// key → items の添字
+	index map[string]int
+	less  func(a, b *QueuedPodInfo) bool
+}
+
+func newPodHeap(less func(a, b *QueuedPodInfo) bool) *podHeap {
+	return &podHeap{index: map[string]int{}, less: less}
+}
+
+// heap.Interface の実装。直接呼ばずに AddOrUpdate / Delete / Pop を使う
+func (h *podHeap) Len() int           { return len(h.items) }
+func (h *podHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *podHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].key()] = i
+	h.index[h.items[j].key()] = j
+}
+
+func (h *podHeap) Push(x any) {
+	pInfo := x.(*QueuedPodInfo)
+	h.index[pInfo.key()] = len(h.items)
+	h.items = append(h.items, pInfo)
+}
+
+func (h *podHeap) Pop() any {
+	n := len(h.items) - 1
+	pInfo := h.items[n]
+	h.items[n] = nil
+	h.items = h.items[:n]
+	delete(h.index, pInfo.key())
+	return pInfo
+}
+
+// AddOrUpdate は pInfo を追加する。同じ key の要素があれば置き換える
+func (h *podHeap) AddOrUpdate(pInfo *QueuedPodInfo) {
+	if i, ok := h.index[pInfo.key()]; ok {
+		h.items[i] = pInfo
+		heap.Fix(h, i)
+		return
+	}
+	heap.Push(h, pInfo)
+}
+
+func (h *podHeap) Get(key string) (*QueuedPodInfo, bool) {
+	i, ok := h.index[key]
+	if !ok {
+		return nil, false
+	}
+	return h.items[i], true
+}
+
+func (h *podHeap) Delete(key string) (*QueuedPodInfo, bool) {
+	i, ok := h.index[key]
+	if !ok {
+		return nil, false
+	}
+	return heap.Remove(h, i).(*QueuedPodInfo), true
+}
+
+// Peek は次に取り出す要素を返す。空なら nil
+func (h *podHeap) Peek() *QueuedPodInfo {
+	if len(h.items) == 0 {
+		return nil
+	}
+	return h.items[0]
+}
+
+// PopFront は次の要素を取り出す。空なら nil
+func (h *podHeap) PopFront() *QueuedPodInfo {
+	if len(h.items) == 0 {
+		return nil
+	}
+	return heap.Pop(h).(*QueuedPodInfo)
+}
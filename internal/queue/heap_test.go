@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPodHeap(t *testing.T) {
+	h := newPodHeap(activeQLess)
+	for i, name := range []string{"a", "b", "c", "d"} {
+		h.AddOrUpdate(&QueuedPodInfo{Pod: newTestPod(name, int32(i), testStartTime)})
+	}
+	// 優先度を上げると先頭に来る
+	c, _ := h.Get("default/c")
+	c.Pod = newTestPod("c", 10, testStartTime)
+	h.AddOrUpdate(c)
+	if _, ok := h.Delete("default/b"); !ok {
+		t.Fatalf("podHeap.Delete() = false, want true")
+	}
+	if _, ok := h.Delete("default/b"); ok {
+		t.Errorf("podHeap.Delete() of deleted pod = true, want false")
+	}
+	h.AddOrUpdate(&QueuedPodInfo{Pod: newTestPod("e", 3, testStartTime.Add(time.Second))})
+
+	var got []string
+	for pInfo := h.PopFront(); pInfo != nil; pInfo = h.PopFront() {
+		got = append(got, pInfo.Pod.Name)
+	}
+	want := []string{"c", "d", "e", "a"}
+	if len(got) != len(want) {
+		t.Fatalf("pop order = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pop order = %v, want %v", got, want)
+		}
+	}
+	if h.Len() != 0 || len(h.index) != 0 {
+		t.Errorf("podHeap is not empty: len = %d, index = %v", h.Len(), h.index)
+	}
+}
@@ -0,0 +1,405 @@
+package queue
+
+import (
+	"kube-scheduler-practice/internal/logic"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// backoffQ から待ち時間の過ぎた Pod を activeQ に移す間隔
+	backoffFlushInterval = time.Second
+	// unschedulablePods に長く残っている Pod を確認する間隔
+	unschedulableFlushInterval = 30 * time.Second
+	// イベントが来なくても、unschedulablePods にこれより長く残った Pod は再試行する
+	// イベントの取りこぼしで Pod が永久に配置されないのを防ぐ
+	podMaxInUnschedulablePodsDuration = 5 * time.Minute
+)
+
+// QueuedPodInfo はキューに積まれた Pod と、スケジュールの試行状況
+type QueuedPodInfo struct {
+	Pod *v1.Pod
+	// スケジュールを試みた回数。バックオフの待ち時間はこの回数から決まる
+	Attempts int
+	// 最初にキューに積まれた時刻
+	InitialAttemptTimestamp time.Time
+	// 最後にキューに積まれた、またはスケジュールに失敗した時刻
+	Timestamp time.Time
+}
+
+func (p *QueuedPodInfo) key() string {
+	return podKey(p.Pod)
+}
+
+func podKey(pod *v1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+// Depths はサブキューごとの Pod の数
+type Depths struct {
+	Active        int
+	Backoff       int
+	Unschedulable int
+}
+
+// 処理中 (Pop されてから Done されるまで) の Pod
+type inFlightPod struct {
+	// Pop したときのスケジューリングサイクル
+	cycle int64
+	// 処理中に Update で届いた最新の Pod。届いていなければ nil
+	updated *v1.Pod
+}
+
+// PriorityQueue はスケジュールを待つ Pod のキュー
+//   - activeQ: すぐにスケジュールする Pod。優先度の高い順、同じなら作成の古い順に取り出す
+//   - backoffQ: スケジュールに失敗し、バックオフの待ち時間が過ぎるのを待つ Pod
+//   - unschedulablePods: どのノードにも配置できなかった Pod。
+//     ノードの追加や Pod の削除など、配置できるようになり得るイベントが来るまで再試行しない
+type PriorityQueue struct {
+	clock          clock.Clock
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	lock              sync.Mutex
+	cond              sync.Cond
+	activeQ           *podHeap
+	backoffQ          *podHeap
+	unschedulablePods map[string]*QueuedPodInfo
+	inFlightPods      map[string]*inFlightPod
+	// Pop のたびに増える
+	schedulingCycle int64
+	// 最後に unschedulablePods を移動させたときの schedulingCycle
+	// これ以前に Pop された Pod は、処理中にクラスタが変わった可能性がある
+	moveRequestCycle int64
+	closed           bool
+}
+
+// NewPriorityQueue はバックオフの待ち時間を initialBackoff から始めて失敗するたびに倍にし、
+// maxBackoff で頭打ちにするキューを作る
+func NewPriorityQueue(initialBackoff, maxBackoff time.Duration) *PriorityQueue {
+	return newPriorityQueue(clock.RealClock{}, initialBackoff, maxBackoff)
+}
+
+func newPriorityQueue(clock clock.Clock, initialBackoff, maxBackoff time.Duration) *PriorityQueue {
+	q := &PriorityQueue{
+		clock:             clock,
+		initialBackoff:    initialBackoff,
+		maxBackoff:        maxBackoff,
+		activeQ:           newPodHeap(activeQLess),
+		unschedulablePods: map[string]*QueuedPodInfo{},
+		inFlightPods:      map[string]*inFlightPod{},
+		moveRequestCycle:  -1,
+	}
+	q.cond.L = &q.lock
+	q.backoffQ = newPodHeap(func(a, b *QueuedPodInfo) bool {
+		return q.backoffExpiry(a).Before(q.backoffExpiry(b))
+	})
+	return q
+}
+
+// 優先度の高い順、同じなら作成の古い順、さらに同じならキューに積まれた順
+func activeQLess(a, b *QueuedPodInfo) bool {
+	pa, pb := logic.PodPriority(a.Pod), logic.PodPriority(b.Pod)
+	if pa != pb {
+		return pa > pb
+	}
+	ca, cb := a.Pod.CreationTimestamp.Time, b.Pod.CreationTimestamp.Time
+	if !ca.Equal(cb) {
+		return ca.Before(cb)
+	}
+	if !a.InitialAttemptTimestamp.Equal(b.InitialAttemptTimestamp) {
+		return a.InitialAttemptTimestamp.Before(b.InitialAttemptTimestamp)
+	}
+	return a.key() < b.key()
+}
+
+// Run は stopCh が閉じられるまで、待ち時間の過ぎた Pod を activeQ に移し続ける
+// stopCh が閉じられたらキューも閉じる
+func (q *PriorityQueue) Run(stopCh <-chan struct{}) {
+	go wait.Until(q.flushBackoffQCompleted, backoffFlushInterval, stopCh)
+	go wait.Until(q.flushUnschedulablePodsLeftover, unschedulableFlushInterval, stopCh)
+	go func() {
+		<-stopCh
+		q.Close()
+	}()
+}
+
+// Add は新しくスケジュールが必要になった Pod を activeQ に積む
+// すでに他のサブキューにあれば、試行回数を引き継いで activeQ に移す
+func (q *PriorityQueue) Add(pod *v1.Pod) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := podKey(pod)
+	if inFlight, ok := q.inFlightPods[key]; ok {
+		inFlight.updated = pod
+		return
+	}
+	pInfo := q.deleteFromSubQueues(key)
+	if pInfo == nil {
+		now := q.clock.Now()
+		pInfo = &QueuedPodInfo{InitialAttemptTimestamp: now, Timestamp: now}
+	}
+	pInfo.Pod = pod
+	q.activeQ.AddOrUpdate(pInfo)
+	q.cond.Broadcast()
+}
+
+// Update は Pod の変更をキューに反映する
+// unschedulablePods にある Pod は、スケジュールに関わる部分が変わっていれば再試行する
+func (q *PriorityQueue) Update(oldPod, newPod *v1.Pod) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := podKey(newPod)
+	if inFlight, ok := q.inFlightPods[key]; ok {
+		inFlight.updated = newPod
+		return
+	}
+	if pInfo, ok := q.activeQ.Get(key); ok {
+		pInfo.Pod = newPod
+		q.activeQ.AddOrUpdate(pInfo)
+		return
+	}
+	if pInfo, ok := q.backoffQ.Get(key); ok {
+		pInfo.Pod = newPod
+		q.backoffQ.AddOrUpdate(pInfo)
+		return
+	}
+	if pInfo, ok := q.unschedulablePods[key]; ok {
+		pInfo.Pod = newPod
+		if isPodUpdated(oldPod, newPod) {
+			delete(q.unschedulablePods, key)
+			q.requeue(pInfo)
+		}
+		return
+	}
+	// どこにも無ければ新しく積む
+	now := q.clock.Now()
+	q.activeQ.AddOrUpdate(&QueuedPodInfo{Pod: newPod, InitialAttemptTimestamp: now, Timestamp: now})
+	q.cond.Broadcast()
+}
+
+// Delete は Pod をキューから取り除く
+func (q *PriorityQueue) Delete(pod *v1.Pod) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := podKey(pod)
+	delete(q.inFlightPods, key)
+	q.deleteFromSubQueues(key)
+}
+
+func (q *PriorityQueue) deleteFromSubQueues(key string) *QueuedPodInfo {
+	if pInfo, ok := q.activeQ.Delete(key); ok {
+		return pInfo
+	}
+	if pInfo, ok := q.backoffQ.Delete(key); ok {
+		return pInfo
+	}
+	if pInfo, ok := q.unschedulablePods[key]; ok {
+		delete(q.unschedulablePods, key)
+		return pInfo
+	}
+	return nil
+}
+
+// Pop は activeQ から次にスケジュールする Pod を取り出す。activeQ が空の間は待つ
+// キューが閉じられたら false を返す。取り出した Pod は処理が終わったら Done を呼ぶ
+func (q *PriorityQueue) Pop() (*QueuedPodInfo, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for q.activeQ.Len() == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.cond.Wait()
+	}
+	return q.popLocked(), true
+}
+
+// TryPop は Pop と同じだが、activeQ が空なら待たずに false を返す
+// Depths で数えた後に削除などで activeQ から抜けた Pod があっても、待ち続けないようにする
+func (q *PriorityQueue) TryPop() (*QueuedPodInfo, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.closed || q.activeQ.Len() == 0 {
+		return nil, false
+	}
+	return q.popLocked(), true
+}
+
+// popLocked は activeQ の先頭の Pod を取り出し、処理中にする。q.lock を取ってから呼ぶ
+func (q *PriorityQueue) popLocked() *QueuedPodInfo {
+	pInfo := q.activeQ.PopFront()
+	pInfo.Attempts++
+	q.schedulingCycle++
+	q.inFlightPods[pInfo.key()] = &inFlightPod{cycle: q.schedulingCycle}
+	return pInfo
+}
+
+// Done は Pop した Pod の処理が終わったことを伝える
+func (q *PriorityQueue) Done(pod *v1.Pod) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.inFlightPods, podKey(pod))
+}
+
+// AddUnschedulableIfNotPresent はスケジュールに失敗した Pod をキューに戻す
+//   - unschedulable: どのノードにも配置できなかった。配置できるようになり得るイベントが来るまで unschedulablePods で待つ
+//   - それ以外 (API のエラーなど): バックオフの待ち時間の後に再試行する
+//
+// 処理中に Pod が更新された、またはクラスタのイベントが来た場合は、待たずにバックオフの後で再試行する
+// 処理中に Pod が削除された場合は何もしない
+func (q *PriorityQueue) AddUnschedulableIfNotPresent(pInfo *QueuedPodInfo, unschedulable bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	key := pInfo.key()
+	inFlight, ok := q.inFlightPods[key]
+	if !ok {
+		return
+	}
+	delete(q.inFlightPods, key)
+
+	pInfo.Timestamp = q.clock.Now()
+	if inFlight.updated != nil {
+		updated := isPodUpdated(pInfo.Pod, inFlight.updated)
+		pInfo.Pod = inFlight.updated
+		if updated {
+			q.backoffQ.AddOrUpdate(pInfo)
+			return
+		}
+	}
+	if !unschedulable || q.moveRequestCycle >= inFlight.cycle {
+		q.backoffQ.AddOrUpdate(pInfo)
+		return
+	}
+	q.unschedulablePods[key] = pInfo
+}
+
+// MoveAllToActiveOrBackoffQueue は unschedulablePods のうち preCheck を満たす Pod を再試行する
+// event はログに出すためのイベント名。preCheck が nil ならすべての Pod を対象にする
+func (q *PriorityQueue) MoveAllToActiveOrBackoffQueue(event string, preCheck func(pod *v1.Pod) bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	moved := 0
+	for key, pInfo := range q.unschedulablePods {
+		if preCheck != nil && !preCheck(pInfo.Pod) {
+			continue
+		}
+		delete(q.unschedulablePods, key)
+		q.requeue(pInfo)
+		moved++
+	}
+	if moved > 0 {
+		slog.Info("moving unschedulable pods to retry", "event", event, "pods", moved)
+	}
+	q.moveRequestCycle = q.schedulingCycle
+}
+
+// バックオフ中なら backoffQ に、そうでなければ activeQ に積む
+func (q *PriorityQueue) requeue(pInfo *QueuedPodInfo) {
+	if q.isPodBackingoff(pInfo) {
+		q.backoffQ.AddOrUpdate(pInfo)
+		return
+	}
+	q.activeQ.AddOrUpdate(pInfo)
+	q.cond.Broadcast()
+}
+
+// backoffQ のうち待ち時間の過ぎた Pod を activeQ に移す
+func (q *PriorityQueue) flushBackoffQCompleted() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	moved := false
+	for pInfo := q.backoffQ.Peek(); pInfo != nil && !q.isPodBackingoff(pInfo); pInfo = q.backoffQ.Peek() {
+		q.backoffQ.PopFront()
+		q.activeQ.AddOrUpdate(pInfo)
+		moved = true
+	}
+	if moved {
+		q.cond.Broadcast()
+	}
+}
+
+// unschedulablePods に podMaxInUnschedulablePodsDuration より長く残っている Pod を再試行する
+func (q *PriorityQueue) flushUnschedulablePodsLeftover() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := q.clock.Now()
+	for key, pInfo := range q.unschedulablePods {
+		if now.Sub(pInfo.Timestamp) > podMaxInUnschedulablePodsDuration {
+			delete(q.unschedulablePods, key)
+			q.requeue(pInfo)
+		}
+	}
+	if depths := q.depths(); depths != (Depths{}) {
+		slog.Info("scheduling queue", "active", depths.Active, "backoff", depths.Backoff, "unschedulable", depths.Unschedulable)
+	}
+}
+
+// 待ち時間は試行回数ごとに倍になり、maxBackoff で頭打ちになる
+func (q *PriorityQueue) backoffDuration(pInfo *QueuedPodInfo) time.Duration {
+	duration := q.initialBackoff
+	for i := 1; i < pInfo.Attempts; i++ {
+		if duration > q.maxBackoff-duration {
+			return q.maxBackoff
+		}
+		duration *= 2
+	}
+	return min(duration, q.maxBackoff)
+}
+
+func (q *PriorityQueue) backoffExpiry(pInfo *QueuedPodInfo) time.Time {
+	return pInfo.Timestamp.Add(q.backoffDuration(pInfo))
+}
+
+func (q *PriorityQueue) isPodBackingoff(pInfo *QueuedPodInfo) bool {
+	return q.backoffExpiry(pInfo).After(q.clock.Now())
+}
+
+// Depths はサブキューごとの Pod の数を返す
+func (q *PriorityQueue) Depths() Depths {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.depths()
+}
+
+func (q *PriorityQueue) depths() Depths {
+	return Depths{Active: q.activeQ.Len(), Backoff: q.backoffQ.Len(), Unschedulable: len(q.unschedulablePods)}
+}
+
+// Close はキューを閉じ、Pop で待っているものを起こす
+func (q *PriorityQueue) Close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// status や resourceVersion など、スケジュールに関わらない部分を除いて Pod が変わったかを返す
+// nominatedNodeName の設定などで再試行を繰り返さないようにする
+func isPodUpdated(oldPod, newPod *v1.Pod) bool {
+	strip := func(pod *v1.Pod) *v1.Pod {
+		p := pod.DeepCopy()
+		p.ResourceVersion = ""
+		p.Generation = 0
+		p.ManagedFields = nil
+		p.Status = v1.PodStatus{}
+		return p
+	}
+	return !reflect.DeepEqual(strip(oldPod), strip(newPod))
+}
@@ -0,0 +1,261 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	testInitialBackoff = time.Second
+	testMaxBackoff     = 10 * time.Second
+)
+
+var testStartTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestPod(name string, priority int32, created time.Time) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", CreationTimestamp: metav1.NewTime(created)},
+		Spec:       v1.PodSpec{Priority: ptr.To(priority)},
+	}
+}
+
+func newTestQueue() (*PriorityQueue, *testingclock.FakeClock) {
+	clock := testingclock.NewFakeClock(testStartTime)
+	return newPriorityQueue(clock, testInitialBackoff, testMaxBackoff), clock
+}
+
+// activeQ から n 個の Pod を取り出して、名前を返す
+func popNames(t *testing.T, q *PriorityQueue, n int) []string {
+	t.Helper()
+	var names []string
+	for range n {
+		pInfo, ok := q.Pop()
+		if !ok {
+			t.Fatalf("PriorityQueue.Pop() returned closed")
+		}
+		names = append(names, pInfo.Pod.Name)
+	}
+	return names
+}
+
+func TestPriorityQueue_PopOrder(t *testing.T) {
+	q, _ := newTestQueue()
+	q.Add(newTestPod("low-old", 0, testStartTime))
+	q.Add(newTestPod("high-new", 100, testStartTime.Add(2*time.Second)))
+	q.Add(newTestPod("high-old", 100, testStartTime.Add(time.Second)))
+	q.Add(newTestPod("low-new", 0, testStartTime.Add(3*time.Second)))
+
+	got := popNames(t, q, 4)
+	want := []string{"high-old", "high-new", "low-old", "low-new"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pop order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPriorityQueue_UnschedulableAndMove(t *testing.T) {
+	tests := []struct {
+		name string
+		// Pop してから失敗を伝えるまでの間に行う操作
+		duringCycle   func(q *PriorityQueue, pod *v1.Pod)
+		unschedulable bool
+		// 失敗を伝えた後に行う操作
+		afterFailure func(q *PriorityQueue, clock *testingclock.FakeClock, pod *v1.Pod)
+		want         Depths
+	}{
+		{
+			// イベントが来るまで再試行しない
+			name:          "unschedulable pod waits for an event",
+			unschedulable: true,
+			want:          Depths{Unschedulable: 1},
+		},
+		{
+			name:          "event moves pod to backoffQ while backing off",
+			unschedulable: true,
+			afterFailure: func(q *PriorityQueue, _ *testingclock.FakeClock, _ *v1.Pod) {
+				q.MoveAllToActiveOrBackoffQueue("NodeAdd", nil)
+			},
+			want: Depths{Backoff: 1},
+		},
+		{
+			name:          "event moves pod to activeQ after backoff",
+			unschedulable: true,
+			afterFailure: func(q *PriorityQueue, clock *testingclock.FakeClock, _ *v1.Pod) {
+				clock.Step(testInitialBackoff)
+				q.MoveAllToActiveOrBackoffQueue("NodeAdd", nil)
+			},
+			want: Depths{Active: 1},
+		},
+		{
+			name:          "event filtered by preCheck",
+			unschedulable: true,
+			afterFailure: func(q *PriorityQueue, _ *testingclock.FakeClock, _ *v1.Pod) {
+				q.MoveAllToActiveOrBackoffQueue("AssignedPodAdd", func(*v1.Pod) bool { return false })
+			},
+			want: Depths{Unschedulable: 1},
+		},
+		{
+			// 処理中にクラスタが変わったので、イベントを待たずに再試行する
+			name: "event during scheduling cycle",
+			duringCycle: func(q *PriorityQueue, _ *v1.Pod) {
+				q.MoveAllToActiveOrBackoffQueue("NodeAdd", nil)
+			},
+			unschedulable: true,
+			want:          Depths{Backoff: 1},
+		},
+		{
+			name: "pod spec updated during scheduling cycle",
+			duringCycle: func(q *PriorityQueue, pod *v1.Pod) {
+				updated := pod.DeepCopy()
+				updated.Spec.Tolerations = []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists}}
+				q.Update(pod, updated)
+			},
+			unschedulable: true,
+			want:          Depths{Backoff: 1},
+		},
+		{
+			// nominatedNodeName など status だけの変更では再試行しない
+			name: "pod status updated during scheduling cycle",
+			duringCycle: func(q *PriorityQueue, pod *v1.Pod) {
+				updated := pod.DeepCopy()
+				updated.Status.NominatedNodeName = "node1"
+				q.Update(pod, updated)
+			},
+			unschedulable: true,
+			want:          Depths{Unschedulable: 1},
+		},
+		{
+			name: "pod deleted during scheduling cycle",
+			duringCycle: func(q *PriorityQueue, pod *v1.Pod) {
+				q.Delete(pod)
+			},
+			unschedulable: true,
+			want:          Depths{},
+		},
+		{
+			name:          "pod spec updated in unschedulable pool",
+			unschedulable: true,
+			afterFailure: func(q *PriorityQueue, _ *testingclock.FakeClock, pod *v1.Pod) {
+				updated := pod.DeepCopy()
+				updated.Labels = map[string]string{"app": "web"}
+				q.Update(pod, updated)
+			},
+			want: Depths{Backoff: 1},
+		},
+		{
+			// API のエラーなどはイベントを待たずにバックオフの後で再試行する
+			name: "error goes to backoffQ",
+			want: Depths{Backoff: 1},
+		},
+		{
+			name: "backoffQ flushed after backoff",
+			afterFailure: func(q *PriorityQueue, clock *testingclock.FakeClock, _ *v1.Pod) {
+				clock.Step(testInitialBackoff)
+				q.flushBackoffQCompleted()
+			},
+			want: Depths{Active: 1},
+		},
+		{
+			name:          "leftover in unschedulable pool",
+			unschedulable: true,
+			afterFailure: func(q *PriorityQueue, clock *testingclock.FakeClock, _ *v1.Pod) {
+				clock.Step(podMaxInUnschedulablePodsDuration + time.Second)
+				q.flushUnschedulablePodsLeftover()
+			},
+			want: Depths{Active: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, clock := newTestQueue()
+			pod := newTestPod("pod", 0, testStartTime)
+			q.Add(pod)
+			pInfo, _ := q.Pop()
+			if tt.duringCycle != nil {
+				tt.duringCycle(q, pod)
+			}
+			q.AddUnschedulableIfNotPresent(pInfo, tt.unschedulable)
+			q.Done(pInfo.Pod)
+			if tt.afterFailure != nil {
+				tt.afterFailure(q, clock, pInfo.Pod)
+			}
+			if got := q.Depths(); got != tt.want {
+				t.Errorf("PriorityQueue.Depths() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriorityQueue_backoffDuration(t *testing.T) {
+	q, _ := newTestQueue()
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Second},
+		{attempts: 2, want: 2 * time.Second},
+		{attempts: 4, want: 8 * time.Second},
+		{attempts: 5, want: testMaxBackoff},
+		{attempts: 100, want: testMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := q.backoffDuration(&QueuedPodInfo{Attempts: tt.attempts}); got != tt.want {
+			t.Errorf("backoffDuration(attempts=%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestPriorityQueue_Close(t *testing.T) {
+	q, _ := newTestQueue()
+	done := make(chan bool)
+	go func() {
+		_, ok := q.Pop()
+		done <- ok
+	}()
+	q.Close()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Errorf("PriorityQueue.Pop() = true after Close, want false")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("PriorityQueue.Pop() was not woken up by Close")
+	}
+}
+
+func TestPriorityQueue_TryPop(t *testing.T) {
+	q, _ := newTestQueue()
+	pod := newTestPod("pod", 0, testStartTime)
+	q.Add(pod)
+	// 数えた後に削除された Pod は取り出せず、待たずに false を返す
+	if got := q.Depths().Active; got != 1 {
+		t.Fatalf("PriorityQueue.Depths().Active = %d, want 1", got)
+	}
+	q.Delete(pod)
+	if _, ok := q.TryPop(); ok {
+		t.Errorf("PriorityQueue.TryPop() = true on empty activeQ, want false")
+	}
+
+	q.Add(pod)
+	pInfo, ok := q.TryPop()
+	if !ok || pInfo.Pod.Name != "pod" || pInfo.Attempts != 1 {
+		t.Fatalf("PriorityQueue.TryPop() = %+v, %v, want pod with 1 attempt", pInfo, ok)
+	}
+	// Pop と同じく処理中になり、失敗したら unschedulablePods に戻せる
+	q.AddUnschedulableIfNotPresent(pInfo, true)
+	if got := q.Depths(); got != (Depths{Unschedulable: 1}) {
+		t.Errorf("PriorityQueue.Depths() = %+v, want 1 unschedulable", got)
+	}
+
+	q.Close()
+	q.Add(newTestPod("after-close", 0, testStartTime))
+	if _, ok := q.TryPop(); ok {
+		t.Errorf("PriorityQueue.TryPop() = true after Close, want false")
+	}
+}